@@ -5,11 +5,497 @@
 
 package ratelimiter
 
-import "time"
+import (
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+)
+
+const (
+	DefaultTimeout        = core.DefaultTimeout
+	DefaultPunishmentTime = core.DefaultPunishmentTime
+	DefaultMaxTimeout     = core.DefaultMaxTimeout
+	DefaultMessageCount   = core.DefaultMessageCount
+	DefaultProbationTime  = 24 * time.Hour
+	DefaultThreadLifetime = 48 * time.Hour
+	DefaultAlbumLifetime  = time.Minute
+	DefaultScoreThreshold = 0.8
+
+	// DefaultPublishQueueSize is the maximum amount of the events waiting
+	// to be published to the event bus of a limiter.
+	DefaultPublishQueueSize = 1024
+)
+
+const (
+	// TierNormal is the default tier of users; the limiter's default
+	// limits are applied to them.
+	TierNormal Tier = iota
+
+	// TierVIP is the tier of the trusted users, who usually get more
+	// generous limits.
+	TierVIP
+
+	// TierSuspicious is the tier of the flagged users, who usually get
+	// stricter limits.
+	TierSuspicious
+)
+
+const (
+	TypeText MessageType = 1 << iota
+	TypePhoto
+	TypeVideo
+	TypeAnimation
+	TypeAudio
+	TypeVoice
+	TypeVideoNote
+	TypeDocument
+	TypeSticker
+	TypeDice
+	TypePoll
+	TypeLocation
+	TypeContact
+
+	// TypeOther is the type of the messages which don't fit in any of
+	// the other types, such as the service messages.
+	TypeOther
+
+	// TypeMedia is the mask of all of the media types.
+	TypeMedia = TypePhoto | TypeVideo | TypeAnimation | TypeAudio |
+		TypeVoice | TypeVideoNote | TypeDocument | TypeSticker
+
+	// TypeAll is the mask of all of the message types.
+	TypeAll = TypeOther<<1 - 1
+)
+
+const (
+	// ScopePrivate is the scope of the private chats with the bot.
+	ScopePrivate ChatScope = 1 << iota
+
+	// ScopeGroups is the scope of the groups and the supergroups.
+	ScopeGroups
+
+	// ScopeChannels is the scope of the channels.
+	ScopeChannels
+
+	// ScopeAll is the mask of all of the chat scopes.
+	ScopeAll = ScopeChannels<<1 - 1
+)
+
+const (
+	// TriggerGroupsOnly makes the trigger run only for the updates of
+	// the groups and the supergroups.
+	TriggerGroupsOnly TriggerFilter = 1 << iota
+
+	// TriggerStrictExtension makes the trigger run only when the
+	// punishment of an already limited user is extended by their new
+	// update in the strict mode (see `Limiter.IsStrict`), instead of
+	// when the user gets limited.
+	TriggerStrictExtension
+
+	// TriggerFirstOffense makes the trigger run only for the first
+	// offense of the user in the offense window (see
+	// `DefaultOffenseWindow`).
+	TriggerFirstOffense
+
+	// TriggerRelease makes the trigger run when the punishment of the
+	// user ends (after the delay of the trigger), instead of when the
+	// user gets limited; the trigger gets the update which has made the
+	// user limited.
+	TriggerRelease
+)
+
+const (
+	// DefaultOffenseWindow is how long the offenses of the users are
+	// remembered for the `TriggerFirstOffense` filter.
+	DefaultOffenseWindow = 24 * time.Hour
+)
+
+const (
+	// ServiceCount counts the service messages as normal messages.
+	ServiceCount ServicePolicy = iota
+
+	// ServiceIgnore ignores the service messages; they are not checked
+	// by the limiter at all.
+	ServiceIgnore
+
+	// ServiceDetect doesn't count the service messages as normal messages;
+	// instead the join and leave messages are passed to a dedicated spam
+	// detector per chat, which has its own threshold (see
+	// `Limiter.SetJoinFloodProfile`) and triggers. other service messages
+	// are ignored.
+	ServiceDetect
+)
+
+const (
+	DefaultJoinFloodCount   = 10
+	DefaultJoinFloodTimeout = time.Minute
+	DefaultJoinFloodTime    = 5 * time.Minute
+)
+
+const (
+	DefaultPaymentCount   = 3
+	DefaultPaymentTimeout = time.Minute
+	DefaultPaymentTime    = 5 * time.Minute
+
+	// DefaultPaymentLimitedText is the error message shown to the users
+	// whose payment queries are rejected by the limiter.
+	DefaultPaymentLimitedText = "Too many payment attempts, please try again later."
+)
+
+const (
+	DefaultVoiceCount   = 3
+	DefaultVoiceTimeout = time.Minute
+	DefaultVoiceTime    = 10 * time.Minute
+)
+
+const (
+	DefaultReactionCountThrottle = 5 * time.Second
+	DefaultBoostThrottle         = time.Second
+)
+
+const (
+	DefaultAdaptiveBaseRate  = 30
+	DefaultAdaptiveMinFactor = 0.5
+	DefaultAdaptiveMaxFactor = 2
+)
+
+// DefaultRiskNewAccountID is the default user id above which the
+// accounts are considered new by the account heuristics.
+const DefaultRiskNewAccountID = 8_000_000_000
+
+const (
+	RoleCreator       Role = "creator"
+	RoleAdministrator Role = "administrator"
+	RoleMember        Role = "member"
+	RoleRestricted    Role = "restricted"
+)
+
+const (
+	DefaultRoleCacheTime       = 5 * time.Minute
+	DefaultReputationCacheTime = time.Hour
+)
+
+const (
+	UpdateMessage  UpdateType = "message"
+	UpdateEdit     UpdateType = "edit"
+	UpdateCallback UpdateType = "callback"
+
+	// UpdateInline and UpdateReaction are the inline queries and the
+	// message reactions; they are only checked by the limiter if they
+	// have a limit profile (see `Limiter.SetUpdateProfile`).
+	UpdateInline   UpdateType = "inline"
+	UpdateReaction UpdateType = "reaction"
+
+	// UpdateReactionCount and UpdateBoost are the anonymous reaction
+	// counts of the messages and the (removed) chat boosts; they are only
+	// checked if they have a limit profile as well (see
+	// `DefaultReactionCountProfile` and `DefaultBoostProfile`).
+	// the reaction counts have no sender, so they are always keyed by
+	// their chat.
+	UpdateReactionCount UpdateType = "reaction_count"
+	UpdateBoost         UpdateType = "boost"
+)
+
+const (
+	// PropagationEndGroups ends the iteration of all of the handler
+	// groups for the limited updates, so no other handler receives them.
+	PropagationEndGroups Propagation = iota
+
+	// PropagationEndGroup only ends the handler group the limiter is
+	// registered in; the limited updates are still passed to the handlers
+	// of the later groups.
+	PropagationEndGroup
+
+	// PropagationAnnotate doesn't stop the limited updates at all; it only
+	// marks them as limited in the context's data (see `ContextLimitedKey`),
+	// so the other handlers can decide what to do with them.
+	// the limiter has to be registered in a group before the other
+	// handlers for this to work.
+	PropagationAnnotate
+)
+
+const (
+	// StopClear clears the statuses of the users when the limiter is
+	// stopped, so it starts over on the next start.
+	StopClear StopPolicy = iota
+
+	// StopRetain keeps the statuses of the users when the limiter is
+	// stopped, and flushes them to the storage backend (if any); so the
+	// limiter continues from where it was on the next start, even if
+	// the process is restarted meanwhile; use `Limiter.Shutdown` to
+	// check whether they have been flushed.
+	StopRetain
+)
+
+const (
+	// PunishmentNone only ignores the updates of the limited users.
+	PunishmentNone Punishment = iota
+
+	// PunishmentMute restricts the limited users from sending messages
+	// to the group until their punishment is over.
+	PunishmentMute
+
+	// PunishmentBan bans the limited users from the group until their
+	// punishment is over; they can join the group again after that.
+	PunishmentBan
+)
+
+const (
+	// ContextLimitedKey is the key of the limited marker stored in
+	// `ext.Context.Data` when the propagation is `PropagationAnnotate`.
+	ContextLimitedKey = "ratelimiter.limited"
+
+	// ContextLimitInfoKey is the key of the `*LimitInfo` stored in
+	// `ext.Context.Data` next to the limited marker.
+	ContextLimitInfoKey = "ratelimiter.limit_info"
+
+	// ContextDelayedKey is the key of the time an update has been queued
+	// at, stored in `ext.Context.Data` of the updates re-injected by the
+	// delay mode.
+	ContextDelayedKey = "ratelimiter.delayed_at"
+
+	// ContextWarnedKey is the key of the warning marker stored in
+	// `ext.Context.Data` of the update which has made the user reach the
+	// warning threshold of its quota.
+	ContextWarnedKey = "ratelimiter.warned"
+
+	// ContextSlowedKey is the key of the marker stored in
+	// `ext.Context.Data` of the messages ignored by the slow mode of
+	// their chat.
+	ContextSlowedKey = "ratelimiter.slowed"
+
+	// ContextDispatcherKey is the key of the `*ext.Dispatcher` processing
+	// the update, stored in `ext.Context.Data` by the handlers of the
+	// limiter; the delay mode re-injects the update into it.
+	ContextDispatcherKey = "ratelimiter.dispatcher"
+)
+
+const (
+	// ChallengeCallbackPrefix is the prefix of the callback data of the
+	// challenge buttons sent by the limiter.
+	ChallengeCallbackPrefix = "rlc:"
+
+	DefaultChallengeText        = "You have been limited for flooding. Press {choice} to prove you're human."
+	DefaultChallengeSuccessText = "Thank you! You can send messages again."
+	DefaultChallengeFailureText = "Wrong button, please try again."
+)
+
+const (
+	// AlertCallbackPrefix is the prefix of the callback data of the
+	// buttons of the repeat-offender alerts.
+	AlertCallbackPrefix = "rla:"
+
+	DefaultAlertThreshold  = 3
+	DefaultAlertWindow     = 24 * time.Hour
+	DefaultAlertBanText    = "Ban"
+	DefaultAlertPardonText = "Pardon"
+)
+
+const (
+	DefaultLockdownWindow   = 10 * time.Second
+	DefaultLockdownCooldown = 5 * time.Minute
+)
+
+const (
+	DefaultEscalationWindow = 24 * time.Hour
+	DefaultEscalationFactor = 2
+)
+
+const (
+	DefaultRaidJoins  = 20
+	DefaultRaidWindow = time.Minute
+)
+
+const (
+	// StateLockdown is the state of the chats which are locked down; see
+	// `Limiter.SetLockdown`.
+	StateLockdown CooldownState = "lockdown"
+
+	// StateSlowMode is the state of the chats whose slow mode is raised
+	// temporarily; see `Limiter.RaiseSlowMode`.
+	StateSlowMode CooldownState = "slow_mode"
+
+	// StateRaid is the raid alert of the chats; it's over once no join
+	// has been counted in the window of the raid detection since the
+	// chat has been raided (see `Limiter.SetRaid`).
+	StateRaid CooldownState = "raid"
+)
+
+const (
+	DefaultFlappingChanges = 6
+	DefaultFlappingWindow  = 10 * time.Minute
+)
+
+const (
+	DefaultReplyText       = "{mention}, you are sending messages too fast; please wait {remaining}."
+	DefaultReplyTimeFormat = "15:04:05 MST"
+)
+
+const (
+	EventLimited   = "limited"
+	EventUnlimited = "unlimited"
+
+	// EventWarned is sent when a user reaches the warning threshold of
+	// its quota; see `Limiter.SetWarnThreshold`.
+	EventWarned = "warned"
+
+	// EventJoinFlood is sent when the join/leave spam detector finds
+	// a flood of joins and leaves in a chat; its key is the chat id.
+	EventJoinFlood = "join_flood"
+
+	// EventLockdown and EventLockdownEnd are sent when a chat is locked
+	// down because of an aggregate flood, and when its lockdown is over;
+	// their key is the chat id.
+	EventLockdown    = "lockdown"
+	EventLockdownEnd = "lockdown_end"
+
+	// EventBlockedSource is sent when a message forwarded from a blocked
+	// source is received; its key is the key of the sender.
+	EventBlockedSource = "blocked_source"
+
+	// EventReputation is sent when an update of a known spammer (according
+	// to the reputation provider) is received; its key is the key of the
+	// sender.
+	EventReputation = "reputation"
+
+	// EventGlobalLimit is sent when a user exceeds the global profile
+	// of the limiter across all of the chats; its key is the user id.
+	EventGlobalLimit = "global_limit"
+
+	// EventMentionLimit is sent when a user exceeds the mention profile
+	// of the limiter; its key is the key of the sender.
+	EventMentionLimit = "mention_limit"
+
+	// EventVoiceFlood is sent when a user exceeds the voice profile of
+	// the limiter; its key is the key of the sender.
+	EventVoiceFlood = "voice_flood"
+
+	// EventRaid is sent when a burst of joins is detected in a chat;
+	// its key is the chat id.
+	EventRaid = "raid"
+
+	// EventRaidEnd is sent when the raid alert of a chat is over; its
+	// key is the chat id.
+	EventRaidEnd = "raid_end"
+
+	// EventFlapping is sent when a user joins and leaves a chat too many
+	// times; its key is the key of the user.
+	EventFlapping = "flapping"
+
+	// EventFederationError is sent when syncing the federation of the
+	// limiter in the background fails; its error field holds the error.
+	EventFederationError = "federation_error"
+)
+
+const (
+	// ActionIgnore means the updates of the user are being ignored.
+	ActionIgnore = "ignore"
+
+	// ActionChallenge means a verification challenge has been sent to
+	// the user.
+	ActionChallenge = "challenge"
+
+	// ActionExpire means the punishment of the user has been expired.
+	ActionExpire = "expire"
+
+	// ActionManual means the action has been taken manually by calling
+	// the limiter's methods.
+	ActionManual = "manual"
+
+	// ActionWarn means the user has been warned to slow down.
+	ActionWarn = "warn"
+)
+
+const (
+	AuditLimit   = "limit"
+	AuditUnlimit = "unlimit"
+	AuditDelete  = "delete"
+	AuditMute    = "mute"
+	AuditUnmute  = "unmute"
+	AuditBan     = "ban"
+	AuditKick    = "kick"
+	AuditUnban   = "unban"
+	AuditWarn    = "warn"
+
+	// AuditIgnore and AuditUnignore are the custom ignores added and
+	// removed by `Limiter.AddCustomIgnore` and `Limiter.RemoveCustomIgnore`.
+	AuditIgnore   = "custom_ignore"
+	AuditUnignore = "remove_custom_ignore"
+
+	// AuditExempt and AuditUnexempt are the changes of the exception
+	// list of the limiter.
+	AuditExempt   = "exempt"
+	AuditUnexempt = "unexempt"
+)
+
+const (
+	// ActorLimiter is the actor of the actions taken by the limiter
+	// itself.
+	ActorLimiter = "limiter"
+
+	// ActorManual is the actor of the actions taken by calling the
+	// limiter's methods.
+	ActorManual = "manual"
+)
+
+const (
+	DefaultWebhookRetries   = 3
+	DefaultWebhookBackoff   = time.Second
+	DefaultWebhookTimeout   = 10 * time.Second
+	DefaultWebhookQueueSize = 256
+)
+
+const (
+	// DefaultFederationSyncTimeout is the default max time of a single
+	// background sync of the federation.
+	DefaultFederationSyncTimeout = 10 * time.Second
+)
+
+const (
+	chatSettingsPrefix = "settings:"
+	customIgnorePrefix = "ignore:"
+	actionPrefix       = "action:"
+	statusesKey        = "statuses"
+	federationPrefix   = "federation:"
+	healthKey          = "health"
+	statsPrefix        = "stats:"
+)
+
+const (
+	DefaultConfigWatchInterval = 5 * time.Second
+)
+
+const (
+	// TemplateLimit, TemplateWarn and TemplateUnlimit are the names of
+	// the templates of the built-in replies (see `Limiter.LoadTemplates`);
+	// the template files are named after them, such as "limit.tmpl" or
+	// "limit.es.tmpl" for the users with the "es" language code.
+	TemplateLimit   = "limit"
+	TemplateWarn    = "warn"
+	TemplateUnlimit = "unlimit"
+
+	// TemplateExt is the extension of the template files.
+	TemplateExt = ".tmpl"
+)
+
+const (
+	// minUntilDate is the minimum amount of time from now the until date
+	// of the bans and the restrictions can be; telegram treats the sooner
+	// dates as forever.
+	minUntilDate = 30 * time.Second
+)
+
+const (
+	// checkSamplesWindow is the amount of the latest checks the latency
+	// and the decision statistics are calculated from.
+	checkSamplesWindow = 1024
+)
+
+const (
+	DefaultReportTopCount = 5
+)
 
 const (
-	DefaultTimeout        = 4 * time.Second
-	DefaultPunishmentTime = 4 * time.Minute
-	DefaultMaxTimeout     = 30 * time.Minute
-	DefaultMessageCount   = 15
+	DefaultDelayMaxDepth = 5
+	DefaultDelayMaxAge   = time.Minute
 )
@@ -0,0 +1,47 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package core
+
+import "time"
+
+const (
+	DefaultTimeout        = 4 * time.Second
+	DefaultPunishmentTime = 4 * time.Minute
+	DefaultMaxTimeout     = 30 * time.Minute
+	DefaultMessageCount   = 15
+)
+
+const (
+	// ResultAllowed means the request is allowed.
+	ResultAllowed Result = iota
+
+	// ResultExempt means the request is allowed without consuming
+	// any quota.
+	ResultExempt
+
+	// ResultLimited means the key is limited because of flooding.
+	ResultLimited
+
+	// ResultIgnored means the key is being ignored by a custom ignore.
+	ResultIgnored
+
+	// ResultDropped means the request is dropped without consuming any
+	// quota, such as a repeated press of the same button.
+	ResultDropped
+)
+
+const (
+	SyncLimit              = "limit"
+	SyncUnlimit            = "unlimit"
+	SyncCustomIgnore       = "custom_ignore"
+	SyncRemoveCustomIgnore = "remove_custom_ignore"
+)
+
+const (
+	// shardBits is the number of the bits of the shard index of the
+	// keys; the limiters have 1 << shardBits shards.
+	shardBits = 5
+)
@@ -0,0 +1,101 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package core
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"time"
+)
+
+// NewLimiter creates a new `Limiter` with the given default profile.
+func NewLimiter(profile Profile) *Limiter {
+	shards := make([]*shard, 1<<shardBits)
+	for i := range shards {
+		shards[i] = &shard{
+			statuses: make(map[int64]*Status),
+		}
+	}
+
+	l := &Limiter{
+		shards: shards,
+	}
+
+	l.profile.Store(&profile)
+	return l
+}
+
+// newLRU returns a new lru list of the keys of the shards, ordered by
+// their last request; the mutexes of the shards should be locked by the
+// caller.
+func newLRU(shards []*shard) *list.List {
+	var statuses []*Status
+	keys := make(map[*Status]int64)
+	for _, s := range shards {
+		for key, status := range s.statuses {
+			statuses = append(statuses, status)
+			keys[status] = key
+		}
+	}
+
+	// the oldest keys end up at the back of the list.
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Last.Before(statuses[j].Last)
+	})
+
+	lru := list.New()
+	for _, status := range statuses {
+		status.elem = lru.PushFront(keys[status])
+	}
+
+	return lru
+}
+
+// newStatus returns an empty status from the pool.
+func newStatus() *Status {
+	s := statusPool.Get().(*Status)
+	*s = Status{}
+	return s
+}
+
+// releaseStatus will put the status (and its custom ignore) back to the
+// pool; the status must not be used after calling this function.
+func releaseStatus(s *Status) {
+	if s.custom != nil {
+		releaseCustomIgnore(s.custom)
+	}
+
+	*s = Status{}
+	statusPool.Put(s)
+}
+
+// newCustomIgnore returns a custom ignore from the pool.
+func newCustomIgnore(d time.Duration, ignoreExceptions bool) *customIgnore {
+	c := customIgnorePool.Get().(*customIgnore)
+	c.startTime = time.Now()
+	c.duration = d
+	c.ignoreException = ignoreExceptions
+	return c
+}
+
+// releaseCustomIgnore will put the custom ignore back to the pool.
+func releaseCustomIgnore(c *customIgnore) {
+	*c = customIgnore{}
+	customIgnorePool.Put(c)
+}
+
+// crossesWarning returns true if the request has made the count of the
+// key reach the warning threshold of the request. the count only grows
+// in a window, so the threshold is crossed at most once per window.
+func crossesWarning(r *Request, p *Profile, count, cost int) bool {
+	if r.WarnThreshold <= 0 || cost <= 0 || count > p.MessageCount {
+		return false
+	}
+
+	warnCount := int(math.Ceil(r.WarnThreshold * float64(p.MessageCount)))
+	return count >= warnCount && count-cost < warnCount
+}
@@ -0,0 +1,995 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//---------------------------------------------------------
+
+// Allow will check a request of the key with the given cost, using the
+// default profile of the limiter.
+func (l *Limiter) Allow(key int64, cost int) Decision {
+	return l.AllowRequest(key, &Request{
+		Cost:   cost,
+		Strict: l.Strict,
+	})
+}
+
+// AllowRequest will check the request of the key and returns the
+// decision of the limiter about it.
+func (l *Limiter) AllowRequest(key int64, r *Request) Decision {
+	p := r.Profile
+	if p == nil {
+		profile := l.GetProfile()
+		p = &profile
+	}
+
+	cost := r.Cost
+	if r.Exempt {
+		cost = 0
+	}
+
+	d := Decision{
+		MaxCount: p.MessageCount,
+	}
+
+	// the keys are evicted after the shard is unlocked.
+	defer l.evict()
+
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	isNew := status == nil
+	if isNew {
+		// the first request of the key is evaluated like the others, so
+		// a request costing more than the whole quota is limited too.
+		status = l.addStatus(s, key)
+		status.Last = time.Now()
+	}
+
+	l.touch(status)
+	status.setWindow(p)
+	if status.limited {
+		if status.releaseBy.IsZero() && r.MaxPunishment > 0 {
+			// the key has been limited manually.
+			status.releaseBy = status.Last.Add(r.MaxPunishment)
+		}
+
+		after := p.Timeout + p.PunishmentTime
+		if status.escalated {
+			after = status.releaseAfter
+		}
+
+		release, capped := status.releaseTime(after)
+		if time.Now().After(release) {
+			status.count = 0
+			status.limited = false
+			status.escalated = false
+			status.releaseBy = time.Time{}
+			status.Last = time.Now()
+			d.Released = true
+			d.Capped = capped
+			return d
+		}
+
+		if r.Strict {
+			now := time.Now()
+			if !r.PartialReset {
+				status.Last = now
+			} else if start := now.Add(p.Timeout - after); start.After(status.Last) {
+				status.Last = start
+			}
+
+			_, capped = status.releaseTime(after)
+		}
+
+		d.Result = ResultLimited
+		d.Count = status.count
+		d.Capped = capped
+		return d
+	}
+
+	throttled := p.Throttle > 0 && !r.Exempt
+	if throttled {
+		// the throttled keys are never punished for flooding; their
+		// requests in the throttle interval are simply dropped.
+		if !isNew && time.Since(status.Last) < p.Throttle {
+			d.Result = ResultDropped
+			d.Count = status.count
+			return d
+		}
+
+		status.count = 0
+	} else if time.Since(status.Last) > p.Timeout {
+		status.count = 0
+	}
+
+	status.count += cost
+	d.Count = status.count
+
+	if (!throttled && status.count > p.MessageCount) || (r.Limit && !r.Exempt) {
+		status.limited = true
+		status.escalated = false
+		status.releaseAfter = p.Timeout + p.PunishmentTime
+		status.Last = time.Now()
+		status.releaseBy = time.Time{}
+		if r.MaxPunishment > 0 {
+			status.releaseBy = status.Last.Add(r.MaxPunishment)
+		}
+
+		d.Result = ResultLimited
+		d.NewlyLimited = true
+		return d
+	}
+
+	status.Last = time.Now()
+	if !throttled {
+		d.Warning = crossesWarning(r, p, status.count, cost)
+	}
+
+	if status.IsCustomLimited() {
+		if !status.custom.ignoreException && r.Exempt {
+			d.Result = ResultExempt
+			return d
+		}
+
+		d.Result = ResultIgnored
+		return d
+	}
+
+	if r.Exempt {
+		d.Result = ResultExempt
+	}
+
+	return d
+}
+
+// Unlimit will free the key from its limitation. it returns true if
+// the key was limited.
+func (l *Limiter) Unlimit(key int64) bool {
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	if status == nil {
+		return false
+	}
+
+	wasLimited := status.limited
+	status.limited = false
+	status.escalated = false
+	status.releaseBy = time.Time{}
+	status.count = 0
+	status.Last = time.Now()
+
+	return wasLimited
+}
+
+// Limit will limit the key manually, as if it has just exceeded its
+// quota; the punishment time of the key starts from now.
+func (l *Limiter) Limit(key int64) {
+	p := l.GetProfile()
+	defer l.evict()
+
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	if status == nil {
+		status = l.addStatus(s, key)
+	}
+
+	status.setWindow(&p)
+	status.limited = true
+	status.escalated = false
+	status.releaseAfter = p.Timeout + p.PunishmentTime
+	status.releaseBy = time.Time{}
+	status.Last = time.Now()
+}
+
+// Escalate will change the punishment of the limited key, so it ends
+// `after` amount of time after its last request, instead of the timeout
+// plus the punishment time of its profile; the punishment cap of the key
+// still applies. it returns false if the key is not limited.
+func (l *Limiter) Escalate(key int64, after time.Duration) bool {
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	if status == nil || !status.limited {
+		return false
+	}
+
+	status.escalated = true
+	status.releaseAfter = after
+	return true
+}
+
+// ApplySyncEvent will apply an event received from another instance of
+// the limiter to the local state.
+func (l *Limiter) ApplySyncEvent(event *SyncEvent) {
+	switch event.Type {
+	case SyncLimit:
+		l.Limit(event.Key)
+	case SyncUnlimit:
+		l.Unlimit(event.Key)
+	case SyncCustomIgnore:
+		l.AddCustomIgnore(event.Key, event.Duration, event.IgnoreExceptions)
+	case SyncRemoveCustomIgnore:
+		l.RemoveCustomIgnore(event.Key)
+	}
+}
+
+// GetStatus returns a copy of the status of the key; it returns nil if
+// the key is not being tracked by the limiter.
+func (l *Limiter) GetStatus(key int64) *Status {
+	s := l.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.statuses[key].clone()
+}
+
+// GetOrCreateStatus returns a copy of the status of the key; the key
+// will be tracked with an empty status if it's not being tracked yet.
+func (l *Limiter) GetOrCreateStatus(key int64) *Status {
+	defer l.evict()
+
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	if status == nil {
+		status = l.addStatus(s, key)
+		status.Last = time.Now()
+	}
+
+	return status.clone()
+}
+
+// GetSnapshot returns a snapshot of the status of the key; it returns
+// nil if the key is not being tracked by the limiter.
+func (l *Limiter) GetSnapshot(key int64) *StatusSnapshot {
+	s := l.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := s.statuses[key]
+	if status == nil {
+		return nil
+	}
+
+	snapshot := &StatusSnapshot{
+		Key:      key,
+		Count:    status.count,
+		LastSeen: status.Last,
+		Limited:  status.limited,
+	}
+
+	if status.limited && status.releaseAfter > 0 {
+		until, _ := status.releaseTime(status.releaseAfter)
+		snapshot.LimitedUntil = &until
+	}
+
+	if status.IsCustomLimited() {
+		snapshot.CustomIgnore = status.GetCustomIgnore()
+		snapshot.CustomIgnore.Key = key
+	}
+
+	return snapshot
+}
+
+// Info returns the quota information of the key with the given profile;
+// the default profile of the limiter is used if p is nil.
+func (l *Limiter) Info(key int64, p *Profile) RateInfo {
+	if p == nil {
+		profile := l.GetProfile()
+		p = &profile
+	}
+
+	info := RateInfo{
+		Limit:     p.MessageCount,
+		Remaining: p.MessageCount,
+	}
+	if p.Throttle > 0 {
+		info.Limit, info.Remaining = 1, 1
+	}
+
+	s := l.getShard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := s.statuses[key]
+	if status == nil {
+		return info
+	}
+
+	now := time.Now()
+	switch {
+	case status.limited:
+		release, _ := status.releaseTime(status.releaseAfter)
+		if now.After(release) {
+			// the punishment is ended by the next request.
+			return info
+		}
+
+		info.Remaining = 0
+		info.ResetAt = release
+		info.RetryAfter = release.Sub(now)
+		return info
+	case status.IsCustomLimited():
+		info.Remaining = 0
+		if status.custom.duration != 0 {
+			info.ResetAt = status.custom.startTime.Add(status.custom.duration)
+			info.RetryAfter = info.ResetAt.Sub(now)
+		}
+
+		return info
+	case p.Throttle > 0:
+		if reset := status.Last.Add(p.Throttle); reset.After(now) {
+			info.Remaining = 0
+			info.ResetAt = reset
+			info.RetryAfter = reset.Sub(now)
+		}
+
+		return info
+	}
+
+	reset := status.Last.Add(p.Timeout)
+	if status.count == 0 || !reset.After(now) {
+		// the window is reset by the next request.
+		return info
+	}
+
+	info.Remaining = p.MessageCount - status.count
+	if info.Remaining < 0 {
+		info.Remaining = 0
+	}
+	info.ResetAt = reset
+	return info
+}
+
+// AddCustomIgnore will make the limiter ignore the key for `d` amount
+// of time (forever if `d` is zero). if ignoreExceptions is false, the
+// exempt requests of the key will still be allowed.
+func (l *Limiter) AddCustomIgnore(key int64, d time.Duration, ignoreExceptions bool) {
+	l.AddCustomIgnores([]int64{key}, d, ignoreExceptions)
+}
+
+// AddCustomIgnores will add the same custom ignore to all of the keys;
+// see `AddCustomIgnore`.
+func (l *Limiter) AddCustomIgnores(keys []int64, d time.Duration, ignoreExceptions bool) {
+	now := time.Now()
+	defer l.evict()
+
+	for _, key := range keys {
+		l.setCustomIgnore(key, now, d, ignoreExceptions)
+	}
+}
+
+// RestoreCustomIgnore will add the custom ignore with its original
+// start time (such as a custom ignore loaded from a storage backend).
+func (l *Limiter) RestoreCustomIgnore(info *CustomIgnoreInfo) {
+	defer l.evict()
+	l.setCustomIgnore(info.Key, info.Start, info.Duration, info.IgnoreExceptions)
+}
+
+// ListCustomIgnores returns the information of all of the active custom
+// ignores of the limiter, sorted by their key.
+func (l *Limiter) ListCustomIgnores() []CustomIgnoreInfo {
+	var list []CustomIgnoreInfo
+	for _, s := range l.shards {
+		s.mutex.RLock()
+		for key, status := range s.statuses {
+			if status == nil || !status.IsCustomLimited() {
+				continue
+			}
+
+			info := status.GetCustomIgnore()
+			info.Key = key
+			list = append(list, *info)
+		}
+		s.mutex.RUnlock()
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// setCustomIgnore will set the custom ignore of the key.
+func (l *Limiter) setCustomIgnore(key int64, start time.Time, d time.Duration, ignoreExceptions bool) {
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	if status == nil {
+		status = l.addStatus(s, key)
+	}
+
+	if status.custom != nil {
+		releaseCustomIgnore(status.custom)
+	}
+
+	status.custom = newCustomIgnore(d, ignoreExceptions)
+	status.custom.startTime = start
+}
+
+// RemoveCustomIgnore will remove the custom ignore of the key. it
+// returns true if the removed custom ignore was ignoring exceptions too.
+func (l *Limiter) RemoveCustomIgnore(key int64) bool {
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	if status == nil || status.custom == nil {
+		return false
+	}
+
+	ignoreException := status.custom.ignoreException
+	releaseCustomIgnore(status.custom)
+	status.custom = nil
+
+	return ignoreException
+}
+
+// SetProfile will set the default limit profile of the limiter; it's
+// safe to be called while the requests are being checked.
+// the new profile is used from the next request of each key: the keys
+// keep their counters and the start of their windows, so the keys which
+// are already above the new message count are limited by their next
+// request, and the punishments in progress end according to the new
+// timeout and punishment time (unless they're escalated).
+func (l *Limiter) SetProfile(p Profile) {
+	l.profile.Store(&p)
+}
+
+// UpdateProfile will change the default limit profile of the limiter
+// atomically, so the concurrent changes of its different fields are not
+// lost; see `SetProfile` for when the changes take effect.
+func (l *Limiter) UpdateProfile(update func(p *Profile)) {
+	for {
+		old := l.profile.Load()
+		p := *old
+		update(&p)
+		if l.profile.CompareAndSwap(old, &p) {
+			return
+		}
+	}
+}
+
+// GetProfile returns the default limit profile of the limiter.
+func (l *Limiter) GetProfile() Profile {
+	return *l.profile.Load()
+}
+
+// Sweep will delete the statuses which are not needed anymore (the
+// keys which are neither limited, nor ignored, and have not sent any
+// requests during the last timeout of their profile). it returns the
+// number of deleted statuses.
+func (l *Limiter) Sweep() int {
+	_, _, deleted := l.sweep(nil)
+	return deleted
+}
+
+// SweepEntries will release the keys whose punishment has ended and will
+// delete the old entries from the memory, just like `Sweep`; it returns
+// the released keys and the copies of the deleted statuses.
+func (l *Limiter) SweepEntries() *SweepResult {
+	result := &SweepResult{
+		Evicted: make(map[int64]Status),
+	}
+
+	result.Released, result.ExpiredIgnores, _ = l.sweep(result.Evicted)
+	return result
+}
+
+// sweep will release the expired punishments and custom ignores, and
+// will delete the old statuses; the copies of the deleted statuses are
+// stored in the evicted map if it's not nil. it returns the released
+// keys, the expired custom ignores and the number of the deleted
+// statuses.
+func (l *Limiter) sweep(evicted map[int64]Status) ([]int64, []CustomIgnoreInfo, int) {
+	timeout := l.GetProfile().Timeout
+
+	var released []int64
+	var expired []CustomIgnoreInfo
+	deleted := 0
+
+	// the shards are swept one by one, so the sweep never blocks the
+	// requests of the other shards.
+	for _, s := range l.shards {
+		s.mutex.Lock()
+		for key, status := range s.statuses {
+			if status == nil {
+				delete(s.statuses, key)
+				continue
+			}
+
+			if status.custom != nil && status.custom.isExpired() {
+				info := status.GetCustomIgnore()
+				info.Key = key
+				expired = append(expired, *info)
+				releaseCustomIgnore(status.custom)
+				status.custom = nil
+			}
+
+			if status.limited && status.releaseAfter > 0 &&
+				status.isReleased() {
+				status.limited = false
+				status.escalated = false
+				status.releaseBy = time.Time{}
+				status.count = 0
+				released = append(released, key)
+			}
+
+			if status.canBeDeleted(timeout) {
+				l.removeStatus(s, key, status)
+				if evicted != nil {
+					evicted[key] = *status
+				}
+
+				releaseStatus(status)
+				deleted++
+			}
+		}
+		s.mutex.Unlock()
+	}
+
+	return released, expired, deleted
+}
+
+// RunSweeper will call `Sweep` once per interval until the stop channel
+// is closed. It blocks its goroutine, so it should be run in a new one.
+func (l *Limiter) RunSweeper(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			l.Sweep()
+		}
+	}
+}
+
+// Len returns the number of keys being tracked by the limiter.
+func (l *Limiter) Len() int {
+	n := 0
+	for _, s := range l.shards {
+		s.mutex.RLock()
+		n += len(s.statuses)
+		s.mutex.RUnlock()
+	}
+
+	return n
+}
+
+// ListLimited returns the keys which are currently limited by the
+// limiter.
+func (l *Limiter) ListLimited() []int64 {
+	var keys []int64
+	for _, s := range l.shards {
+		s.mutex.RLock()
+		for key, status := range s.statuses {
+			if status.limited {
+				keys = append(keys, key)
+			}
+		}
+		s.mutex.RUnlock()
+	}
+
+	return keys
+}
+
+// Clear will remove all of the statuses of the limiter.
+func (l *Limiter) Clear() {
+	for _, s := range l.shards {
+		s.mutex.Lock()
+		for key, status := range s.statuses {
+			l.removeStatus(s, key, status)
+			releaseStatus(status)
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// SetMaxEntries will cap the number of the keys tracked by the limiter;
+// when a new key is tracked beyond the cap, the least recently used keys
+// are evicted. the limited (and ignored) keys are never evicted, so the
+// cap can be exceeded by them. zero (or negative) means no cap.
+// NOTICE: the recency of the keys is kept in a single list, so the
+// requests of the capped limiters contend on it briefly.
+func (l *Limiter) SetMaxEntries(n int) {
+	for _, s := range l.shards {
+		s.mutex.Lock()
+	}
+
+	l.lruMutex.Lock()
+	if n <= 0 {
+		l.maxEntries = 0
+		l.lru = nil
+		l.capped.Store(false)
+		for _, s := range l.shards {
+			for _, status := range s.statuses {
+				status.elem = nil
+			}
+		}
+	} else {
+		l.maxEntries = n
+		l.capped.Store(true)
+		if l.lru == nil {
+			l.lru = newLRU(l.shards)
+		}
+	}
+	l.lruMutex.Unlock()
+
+	for _, s := range l.shards {
+		s.mutex.Unlock()
+	}
+
+	l.evict()
+}
+
+// GetMaxEntries returns the maximum number of the tracked keys; zero
+// means no cap.
+func (l *Limiter) GetMaxEntries() int {
+	l.lruMutex.Lock()
+	defer l.lruMutex.Unlock()
+
+	return l.maxEntries
+}
+
+// Evictions returns the number of the keys evicted because of the cap
+// of the tracked keys (see `SetMaxEntries`).
+func (l *Limiter) Evictions() int {
+	l.lruMutex.Lock()
+	defer l.lruMutex.Unlock()
+
+	return l.evictions
+}
+
+// getShard returns the shard of the key.
+func (l *Limiter) getShard(key int64) *shard {
+	// fibonacci hashing spreads the sequential keys over the shards.
+	return l.shards[(uint64(key)*0x9E3779B97F4A7C15)>>(64-shardBits)]
+}
+
+// addStatus will track the key with a new empty status; the mutex of
+// the shard should be locked by the caller, and `evict` should be called
+// after unlocking it.
+func (l *Limiter) addStatus(s *shard, key int64) *Status {
+	status := newStatus()
+	s.statuses[key] = status
+	if l.capped.Load() {
+		l.lruMutex.Lock()
+		if l.lru != nil {
+			status.elem = l.lru.PushFront(key)
+		}
+		l.lruMutex.Unlock()
+	}
+
+	return status
+}
+
+// removeStatus will stop tracking the key; the status is not released
+// to the pool. the mutex of the shard should be locked by the caller.
+func (l *Limiter) removeStatus(s *shard, key int64, status *Status) {
+	delete(s.statuses, key)
+	if status.elem != nil {
+		l.lruMutex.Lock()
+		if l.lru != nil {
+			l.lru.Remove(status.elem)
+		}
+		l.lruMutex.Unlock()
+		status.elem = nil
+	}
+}
+
+// touch will mark the status as the most recently used one; the mutex
+// of its shard should be locked by the caller.
+func (l *Limiter) touch(status *Status) {
+	if status.elem == nil {
+		return
+	}
+
+	l.lruMutex.Lock()
+	if l.lru != nil {
+		l.lru.MoveToFront(status.elem)
+	}
+	l.lruMutex.Unlock()
+}
+
+// evict will evict the least recently used keys until the number of the
+// tracked keys fits the cap, skipping the limited and ignored keys and
+// the most recently used one. no mutex should be locked by the caller.
+func (l *Limiter) evict() {
+	if !l.capped.Load() {
+		return
+	}
+
+	skipped := make(map[int64]bool)
+	for {
+		// the candidates are picked first, as the mutex of a shard can't
+		// be locked while holding the lru mutex.
+		l.lruMutex.Lock()
+		if l.lru == nil || l.lru.Len() <= l.maxEntries {
+			l.lruMutex.Unlock()
+			return
+		}
+
+		excess := l.lru.Len() - l.maxEntries
+		var candidates []int64
+		for e := l.lru.Back(); e != nil && e != l.lru.Front() && len(candidates) < excess; e = e.Prev() {
+			if key := e.Value.(int64); !skipped[key] {
+				candidates = append(candidates, key)
+			}
+		}
+		l.lruMutex.Unlock()
+
+		if len(candidates) == 0 {
+			return
+		}
+
+		for _, key := range candidates {
+			l.evictKey(key, skipped)
+		}
+	}
+}
+
+// evictKey will evict the key if it can be evicted; otherwise it's added
+// to the skipped keys.
+func (l *Limiter) evictKey(key int64, skipped map[int64]bool) {
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	status := s.statuses[key]
+	if status == nil || status.elem == nil || status.limited || status.IsCustomLimited() {
+		skipped[key] = true
+		return
+	}
+
+	l.removeStatus(s, key, status)
+	releaseStatus(status)
+
+	l.lruMutex.Lock()
+	l.evictions++
+	l.lruMutex.Unlock()
+}
+
+// ExportStatuses returns the records of the statuses of the limiter,
+// so they can be imported later by `ImportStatuses`; the custom ignores
+// are not included.
+func (l *Limiter) ExportStatuses() []StatusRecord {
+	records := make([]StatusRecord, 0, l.Len())
+	for _, s := range l.shards {
+		s.mutex.RLock()
+		for key, status := range s.statuses {
+			records = append(records, StatusRecord{
+				Key:          key,
+				Last:         status.Last,
+				Count:        status.count,
+				Limited:      status.limited,
+				ReleaseAfter: status.releaseAfter,
+				ReleaseBy:    status.releaseBy,
+				Escalated:    status.escalated,
+				Window:       status.window,
+			})
+		}
+		s.mutex.RUnlock()
+	}
+
+	return records
+}
+
+// ImportStatuses will restore the statuses from their records, replacing
+// the current state of the keys (but not their custom ignores).
+func (l *Limiter) ImportStatuses(records []StatusRecord) {
+	defer l.evict()
+
+	for _, record := range records {
+		s := l.getShard(record.Key)
+		s.mutex.Lock()
+		status := s.statuses[record.Key]
+		if status == nil {
+			status = l.addStatus(s, record.Key)
+		}
+
+		status.Last = record.Last
+		status.count = record.Count
+		status.limited = record.Limited
+		status.releaseAfter = record.ReleaseAfter
+		status.releaseBy = record.ReleaseBy
+		status.escalated = record.Escalated
+		status.window = record.Window
+		s.mutex.Unlock()
+	}
+}
+
+//---------------------------------------------------------
+
+// IsLimited will check and see if the key is limited by the limiter
+// or not.
+func (s *Status) IsLimited() bool {
+	return s.limited
+}
+
+// IsCustomLimited will check and see if the key is being ignored by
+// a custom ignore or not.
+func (s *Status) IsCustomLimited() bool {
+	if s.custom == nil {
+		return false
+	}
+
+	// the expired custom ignores are removed by the sweeps, so they
+	// can be reported.
+	return !s.custom.isExpired()
+}
+
+// GetCustomIgnore returns the information of the custom ignore of the
+// status; it returns nil if the status has no custom ignore.
+func (s *Status) GetCustomIgnore() *CustomIgnoreInfo {
+	if s.custom == nil {
+		return nil
+	}
+
+	return &CustomIgnoreInfo{
+		Start:            s.custom.startTime,
+		Duration:         s.custom.duration,
+		IgnoreExceptions: s.custom.ignoreException,
+	}
+}
+
+// GetCount returns the amount of quota units consumed by the key in
+// the current window.
+func (s *Status) GetCount() int {
+	return s.count
+}
+
+// canBeDeleted returns true if the status is not needed anymore; the
+// timeout is the one of the default profile, and the status is kept
+// until the window of its own profile ends too.
+func (s *Status) canBeDeleted(timeout time.Duration) bool {
+	if s.window > timeout {
+		timeout = s.window
+	}
+
+	return !s.limited && !s.IsCustomLimited() && time.Since(s.Last) > timeout
+}
+
+// setWindow will set the window of the status from the profile applied
+// to its request.
+func (s *Status) setWindow(p *Profile) {
+	s.window = p.Timeout
+	if p.Throttle > s.window {
+		s.window = p.Throttle
+	}
+}
+
+// clone returns a copy of the status which doesn't share anything with
+// the original one (so the original can be reused by the pool); it
+// returns nil if the status is nil.
+func (s *Status) clone() *Status {
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	c.elem = nil
+	if s.custom != nil {
+		custom := *s.custom
+		c.custom = &custom
+	}
+
+	return &c
+}
+
+//---------------------------------------------------------
+
+// Validate will check the values of the limit profile and will return
+// an error describing the first nonsensical value it finds.
+func (p *Profile) Validate() error {
+	if p.MessageCount <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidMessageCount, p.MessageCount)
+	}
+
+	if p.Timeout <= 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTimeout, p.Timeout)
+	}
+
+	if p.PunishmentTime < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidPunishment, p.PunishmentTime)
+	}
+
+	if p.Throttle < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidThrottle, p.Throttle)
+	}
+
+	return nil
+}
+
+// IsAllowed returns true if the request should be handled.
+func (d Decision) IsAllowed() bool {
+	return d.Result == ResultAllowed || d.Result == ResultExempt
+}
+
+//---------------------------------------------------------
+
+// ExpiresAt returns the time the custom ignore expires at; it returns
+// the zero time if the custom ignore never expires.
+func (i *CustomIgnoreInfo) ExpiresAt() time.Time {
+	if i.Duration == 0 {
+		return time.Time{}
+	}
+
+	return i.Start.Add(i.Duration)
+}
+
+// IsExpired returns true if the custom ignore has expired.
+func (i *CustomIgnoreInfo) IsExpired() bool {
+	return i.Duration != 0 && time.Since(i.Start) > i.Duration
+}
+
+//---------------------------------------------------------
+
+// releaseTime returns the time in which the punishment of the limited
+// status ends if it doesn't send any other requests, and whether this
+// time has been shortened by the punishment cap.
+func (s *Status) releaseTime(after time.Duration) (time.Time, bool) {
+	release := s.Last.Add(after)
+	if !s.releaseBy.IsZero() && s.releaseBy.Before(release) {
+		return s.releaseBy, true
+	}
+
+	return release, false
+}
+
+// isReleased returns true if the punishment of the limited status has
+// ended.
+func (s *Status) isReleased() bool {
+	release, _ := s.releaseTime(s.releaseAfter)
+	return time.Now().After(release)
+}
+
+// Headers returns the quota information as the X-RateLimit headers
+// (and the Retry-After header if the key is limited), with the reset
+// time in unix seconds and the retry delay in whole seconds.
+func (i RateInfo) Headers() map[string]string {
+	headers := map[string]string{
+		"X-RateLimit-Limit":     strconv.Itoa(i.Limit),
+		"X-RateLimit-Remaining": strconv.Itoa(i.Remaining),
+	}
+
+	if !i.ResetAt.IsZero() {
+		headers["X-RateLimit-Reset"] = strconv.FormatInt(i.ResetAt.Unix(), 10)
+	}
+
+	if i.RetryAfter > 0 {
+		seconds := (i.RetryAfter + time.Second - 1) / time.Second
+		headers["Retry-After"] = strconv.FormatInt(int64(seconds), 10)
+	}
+
+	return headers
+}
+
+func (c *customIgnore) isExpired() bool {
+	return c.duration != 0 && time.Since(c.startTime) > c.duration
+}
@@ -0,0 +1,308 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package core
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status is the flood status of a key in the limiter.
+type Status struct {
+	// Last field is the last time that we received a request
+	// for this key.
+	Last time.Time
+
+	// limited will be true if and only if the current key is
+	// banned in the limiter.
+	limited bool
+
+	// count is the amount of quota units consumed by the key
+	// in the current window.
+	count int
+
+	// window is the timeout (or the throttle interval) of the profile
+	// applied to the last request of the key, so the key is not swept
+	// before its window ends when its profile is longer than the
+	// default one.
+	window time.Duration
+
+	// releaseAfter is the duration after the last request of a limited
+	// key in which its punishment ends.
+	releaseAfter time.Duration
+
+	// releaseBy is the latest time in which the punishment of a limited
+	// key ends, no matter how many requests it sends meanwhile; zero
+	// means the punishment isn't capped.
+	releaseBy time.Time
+
+	// escalated is true if the punishment of the limited key has been
+	// changed by `Limiter.Escalate`, so it ends `releaseAfter` amount of
+	// time after its last request regardless of its profile.
+	escalated bool
+
+	custom *customIgnore
+
+	// elem is the element of the key in the lru list of the limiter;
+	// it's nil if the number of the keys is not capped.
+	elem *list.Element
+}
+
+type customIgnore struct {
+	startTime       time.Time
+	duration        time.Duration
+	ignoreException bool
+}
+
+// StatusRecord is the serializable state of a key, used for carrying
+// the state of the limiter over the restarts; see `Limiter.ExportStatuses`.
+type StatusRecord struct {
+	Key   int64     `json:"key"`
+	Last  time.Time `json:"last"`
+	Count int       `json:"count"`
+
+	// Limited, ReleaseAfter and ReleaseBy describe the punishment of
+	// the key.
+	Limited      bool          `json:"limited,omitempty"`
+	ReleaseAfter time.Duration `json:"release_after,omitempty"`
+	ReleaseBy    time.Time     `json:"release_by,omitempty"`
+	Escalated    bool          `json:"escalated,omitempty"`
+
+	// Window is the timeout of the profile applied to the last request
+	// of the key; zero means the default profile.
+	Window time.Duration `json:"window,omitempty"`
+}
+
+// SweepResult is the outcome of a single sweep of the limiter.
+type SweepResult struct {
+	// Released contains the keys whose punishment has ended.
+	Released []int64
+
+	// Evicted contains the copies of the statuses deleted from the
+	// memory with their key as the map key.
+	Evicted map[int64]Status
+
+	// ExpiredIgnores contains the custom ignores which have expired.
+	ExpiredIgnores []CustomIgnoreInfo
+}
+
+// StatusSnapshot is a copy of the status of a key at a single moment;
+// unlike `Status`, it's safe to keep and to share between goroutines.
+type StatusSnapshot struct {
+	Key   int64 `json:"key"`
+	Count int   `json:"count"`
+
+	// LastSeen is the last time a request of the key has been checked.
+	LastSeen time.Time `json:"last_seen"`
+	Limited  bool      `json:"limited"`
+
+	// LimitedUntil is the time the punishment of the key ends at; it's
+	// nil if the key is not limited.
+	LimitedUntil *time.Time `json:"limited_until,omitempty"`
+
+	// CustomIgnore is the active custom ignore of the key, if any.
+	CustomIgnore *CustomIgnoreInfo `json:"custom_ignore,omitempty"`
+}
+
+// CustomIgnoreInfo is the information of a custom ignore of a key.
+type CustomIgnoreInfo struct {
+	Key   int64     `json:"key"`
+	Start time.Time `json:"start"`
+
+	// Duration is the duration of the custom ignore; zero means the
+	// key is ignored until the custom ignore is removed.
+	Duration         time.Duration `json:"duration"`
+	IgnoreExceptions bool          `json:"ignore_exceptions"`
+}
+
+// RateInfo is the quota information of a key, mirroring the semantics
+// of the X-RateLimit headers of the http apis.
+type RateInfo struct {
+	// Limit is the maximum quota of the key in a window, and Remaining
+	// is the amount of the quota units left in the current window.
+	Limit     int `json:"limit"`
+	Remaining int `json:"remaining"`
+
+	// ResetAt is the time the quota of the key is reset at; it's zero
+	// if the quota is already full (or if the key is ignored forever).
+	ResetAt time.Time `json:"reset_at"`
+
+	// RetryAfter is the time the key has to wait before its next
+	// request is allowed; it's zero if the key is not limited.
+	RetryAfter time.Duration `json:"retry_after_ns"`
+}
+
+// Profile is a set of limiting thresholds.
+type Profile struct {
+	// Timeout is the floodwait checking time of this profile.
+	Timeout time.Duration
+
+	// PunishmentTime is the punishment duration of this profile.
+	PunishmentTime time.Duration
+
+	// MessageCount is the maximum number of messages allowed
+	// in `Timeout` amount of time.
+	MessageCount int
+
+	// Throttle makes the profile throttle the keys instead of punishing
+	// them: at most one request of each key is allowed in `Throttle`
+	// amount of time, and the rest are dropped silently. zero means the
+	// keys are counted and limited as usual.
+	Throttle time.Duration
+}
+
+// Result is the outcome of checking a request.
+type Result int
+
+// Decision is the decision made by the limiter about a request.
+type Decision struct {
+	// Result is the outcome of the check.
+	Result Result
+
+	// Count is the amount of quota units consumed by the key in
+	// the current window (including the checked request).
+	Count int
+
+	// MaxCount is the maximum quota of the key in the window.
+	MaxCount int
+
+	// NewlyLimited will be true if and only if this request caused
+	// the key to be limited.
+	NewlyLimited bool
+
+	// Released will be true if and only if the punishment of the key
+	// has ended by this request.
+	Released bool
+
+	// Capped will be true if the punishment of the key is (or has been)
+	// shortened by `Request.MaxPunishment`.
+	Capped bool
+
+	// Warning will be true if and only if this request made the key
+	// reach the warning threshold of its quota (see `Request.WarnThreshold`);
+	// it happens at most once in each window.
+	Warning bool
+}
+
+// Request contains the details of a single request checked by the
+// limiter.
+type Request struct {
+	// Cost is the amount of quota units consumed by this request.
+	Cost int
+
+	// Exempt should be set to true if the request shouldn't consume
+	// any quota; exempt requests are still blocked when the key is
+	// limited.
+	Exempt bool
+
+	// Strict will make the punishment of the key start over again if
+	// a request arrives while the key is limited.
+	Strict bool
+
+	// Limit will make the key limited by this request immediately,
+	// regardless of its remaining quota; it's ignored for the exempt
+	// requests.
+	Limit bool
+
+	// MaxPunishment is the maximum amount of time a key can remain
+	// limited since it has been limited, even if it keeps sending
+	// requests in the strict mode; zero means no cap.
+	MaxPunishment time.Duration
+
+	// PartialReset will make the strict requests only restart the
+	// timeout part of the punishment, instead of the whole of it.
+	PartialReset bool
+
+	// WarnThreshold is the ratio of the quota (between 0 and 1) in
+	// which the key should be warned before getting limited; zero means
+	// no warning.
+	WarnThreshold float64
+
+	// Profile is the limit profile used for this request; if it's nil,
+	// the default profile of the limiter will be used.
+	Profile *Profile
+}
+
+// Limiter is a framework-agnostic flood limiter, which keeps track of
+// the requests of each key (such as a user id) and limits the keys
+// which send more than the allowed amount of requests.
+// Limiter doesn't run any goroutines; `Sweep` should be called
+// periodically to free the memory used by old entries.
+type Limiter struct {
+	// shards are the striped maps of the statuses; each key belongs to
+	// a single shard (see `getShard`), so the requests of the keys of
+	// different shards never contend with each other.
+	shards []*shard
+
+	// profile is the default limit profile of the limiter; it's stored
+	// as an immutable snapshot, so it can be swapped while the requests
+	// are being checked.
+	profile atomic.Pointer[Profile]
+
+	// Strict is the default value of `Request.Strict` used by `Allow`.
+	Strict bool
+
+	// capped is true if the number of the keys is capped, so the
+	// uncapped limiters don't have to lock the lru mutex at all.
+	capped atomic.Bool
+
+	// lruMutex protects the lru list and its counters; it's always
+	// locked after the mutex of a shard, never before it.
+	lruMutex sync.Mutex
+
+	// maxEntries is the maximum number of the tracked keys; zero means
+	// the number of the keys is not capped.
+	maxEntries int
+
+	// lru is the list of the tracked keys, the most recently used one
+	// first; it's nil if the number of the keys is not capped.
+	lru *list.List
+
+	// evictions is the number of the keys evicted because of the cap.
+	evictions int
+}
+
+// shard is a part of the statuses of a limiter, with its own mutex.
+type shard struct {
+	mutex sync.RWMutex
+
+	// statuses is a map of statuses with their key as the map key.
+	statuses map[int64]*Status
+}
+
+// SyncEvent is an event about a change in the state of a key, which is
+// shared between multiple instances of the limiter (such as several bot
+// workers) through an `EventBus`.
+type SyncEvent struct {
+	// Type is the type of the event, such as `SyncLimit`.
+	Type string `json:"type"`
+
+	Key int64 `json:"key"`
+
+	// Duration is the duration of the custom ignore events.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// IgnoreExceptions is the value of the custom ignore events.
+	IgnoreExceptions bool `json:"ignore_exceptions,omitempty"`
+
+	// Origin is the id of the instance which published the event, so
+	// the instance can ignore its own events.
+	Origin string `json:"origin"`
+}
+
+// EventBus is the interface of the pub/sub backends used for sharing
+// the sync events between multiple instances of the limiter.
+type EventBus interface {
+	// Publish will send the event to all of the subscribers (including
+	// the publisher itself).
+	Publish(event *SyncEvent) error
+
+	// Subscribe will call the handler for each published event until
+	// the returned unsubscribe function is called.
+	Subscribe(handler func(event *SyncEvent)) (unsubscribe func(), err error)
+}
@@ -0,0 +1,30 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrInvalidMessageCount = errors.New("ratelimiter: message count should be greater than zero")
+	ErrInvalidTimeout      = errors.New("ratelimiter: timeout should be greater than zero")
+	ErrInvalidPunishment   = errors.New("ratelimiter: punishment time should not be negative, nor shorter than timeout in strict mode")
+	ErrInvalidThrottle     = errors.New("ratelimiter: throttle interval should not be negative")
+)
+
+var (
+	// statusPool is the pool of the statuses evicted from the limiters,
+	// so the limiters don't have to allocate a new one for each new key.
+	statusPool = sync.Pool{
+		New: func() interface{} { return new(Status) },
+	}
+
+	customIgnorePool = sync.Pool{
+		New: func() interface{} { return new(customIgnore) },
+	}
+)
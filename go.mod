@@ -1,5 +1,8 @@
 module github.com/ALiwoto/ratelimiter
 
-go 1.18
+go 1.19
 
-require github.com/PaulSonOfLars/gotgbot/v2 v2.0.0-rc.25
+require (
+	github.com/PaulSonOfLars/gotgbot/v2 v2.0.0-rc.26
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -6,19 +6,50 @@
 package ratelimiter
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ALiwoto/ratelimiter/core"
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 )
 
 // limiterFilter is the filter method for message types.
 func (l *Limiter) limiterFilter(msg *gotgbot.Message) bool {
-	if !l.isEnabled || l.isStopped || !l.hasTextCondition(msg) {
+	if !l.isEnabled.Load() || l.isStopped.Load() {
 		return false
 	}
 
-	if l.IgnoreMediaGroup && len(msg.MediaGroupId) != 0 {
+	if l.getFlags().OptIn && !l.enrolled.hasMessage(msg) {
+		return false
+	}
+
+	if l.isAllowedCommand(msg) {
+		return false
+	}
+
+	if msg.IsAutomaticForward {
+		l.trackThread(msg)
+		if l.getFlags().ExemptAutoForwards {
+			return false
+		}
+	}
+
+	if l.getFlags().ServicePolicy != ServiceCount && isServiceMessage(msg) {
+		return l.getFlags().ServicePolicy == ServiceDetect && isJoinLeaveMessage(msg) &&
+			!l.isChatDisabled(&msg.Chat)
+	}
+
+	if !l.hasTextCondition(msg) {
+		return false
+	}
+
+	if l.isChatDisabled(&msg.Chat) {
+		return false
+	}
+
+	if l.getFlags().IgnoreMediaGroup && len(msg.MediaGroupId) != 0 {
 		return false
 	}
 
@@ -26,19 +57,19 @@ func (l *Limiter) limiterFilter(msg *gotgbot.Message) bool {
 		return false
 	}
 
-	if len(l.exceptions) != 0 {
-		for _, ex := range l.exceptions {
-			if ex(msg) {
-				return false
-			}
+	if l.isTopicException(msg) {
+		return false
+	}
+
+	for _, ex := range l.exceptions.load() {
+		if ex(msg) {
+			return false
 		}
 	}
 
-	if len(l.conditions) != 0 {
-		for _, con := range l.conditions {
-			if !con(msg) {
-				return false
-			}
+	for _, con := range l.conditions.load() {
+		if !con(msg) {
+			return false
 		}
 	}
 
@@ -47,92 +78,383 @@ func (l *Limiter) limiterFilter(msg *gotgbot.Message) bool {
 
 // callbackFilter is the filter method for callback queries.
 func (l *Limiter) callbackFilter(cq *gotgbot.CallbackQuery) bool {
-	if !l.isEnabled || l.isStopped || !l.ConsiderInline {
+	if !l.isEnabled.Load() || l.isStopped.Load() || !l.getFlags().ConsiderInline {
 		return false
 	}
 
+	if l.getFlags().OptIn && !l.enrolled.hasQuery(cq) {
+		return false
+	}
+
+	if cq.Message != nil {
+		chat := cq.Message.GetChat()
+		if l.isChatDisabled(&chat) {
+			return false
+		}
+	}
+
 	if l.isExceptionQuery(cq) && !l.isIgnoredExceptionQuery(cq) {
 		return false
 	}
 
-	return true
+	if l.isTopicException(getQueryMessage(cq)) {
+		return false
+	}
+
+	for _, ex := range l.callbackExceptions.load() {
+		if ex(cq) {
+			return false
+		}
+	}
+
+	for _, con := range l.callbackConditions.load() {
+		if !con(cq) {
+			return false
+		}
+	}
+
+	return !strings.HasPrefix(cq.Data, ChallengeCallbackPrefix) &&
+		!strings.HasPrefix(cq.Data, AlertCallbackPrefix)
 }
 
-// limiterHandler is the main handler method.
-func (l *Limiter) limiterHandler(b *gotgbot.Bot, ctx *ext.Context) error {
-	var status *UserStatus
-	var id int64
-	if l.ConsiderUser && ctx.EffectiveUser != nil {
-		id = ctx.EffectiveUser.Id
-	} else if ctx.EffectiveChat != nil {
-		id = ctx.EffectiveChat.Id
-	} else {
-		return ext.ContinueGroups
+// inlineFilter is the filter method for inline queries; they are only
+// checked if they have a limit profile.
+func (l *Limiter) inlineFilter(iq *gotgbot.InlineQuery) bool {
+	if !l.isEnabled.Load() || l.isStopped.Load() || l.GetUpdateProfile(UpdateInline) == nil {
+		return false
 	}
 
-	l.mutex.Lock()
-	status = l.userMap[id]
-	if status == nil {
-		status = new(UserStatus)
-		status.Last = time.Now()
-		status.count++
-		l.userMap[id] = status
-		l.mutex.Unlock()
-		if status.IsCustomLimited() {
-			if !status.custom.ignoreException && l.isExceptionCtx(ctx) {
-				return ext.ContinueGroups
-			}
-			return ext.EndGroups
+	if l.getFlags().OptIn && !l.IsEnrolled(iq.From.Id) {
+		return false
+	}
+
+	if !l.isInScope(iq.ChatType) {
+		return false
+	}
+
+	if (l.IsInExceptionList(iq.From.Id) || l.matchExceptionRules(&iq.From)) &&
+		!l.ignoredExceptions.Contains(iq.From.Id) {
+		return false
+	}
+
+	for _, ex := range l.inlineExceptions.load() {
+		if ex(iq) {
+			return false
 		}
-		return ext.ContinueGroups
 	}
 
-	if status.limited {
-		l.mutex.Unlock()
-		if time.Since(status.Last) > l.timeout+l.punishment {
-			status.count = 0
-			status.limited = false
-			status.Last = time.Now()
-			return ext.ContinueGroups
+	for _, con := range l.inlineConditions.load() {
+		if !con(iq) {
+			return false
 		}
+	}
+
+	return true
+}
+
+// reactionFilter is the filter method for message reactions; they are
+// only checked if they have a limit profile.
+func (l *Limiter) reactionFilter(mr *gotgbot.MessageReactionUpdated) bool {
+	if !l.isEnabled.Load() || l.isStopped.Load() || l.GetUpdateProfile(UpdateReaction) == nil {
+		return false
+	}
+
+	if l.isChatDisabled(&mr.Chat) {
+		return false
+	}
+
+	var senderID int64
+	if mr.User != nil {
+		senderID = mr.User.Id
+	} else if mr.ActorChat != nil {
+		senderID = mr.ActorChat.Id
+	}
+
+	if l.getFlags().OptIn && !l.IsEnrolled(senderID) && !l.IsEnrolled(mr.Chat.Id) {
+		return false
+	}
 
-		if l.IsStrict {
-			status.Last = time.Now()
+	isException := l.IsInExceptionList(senderID) || l.IsInExceptionList(mr.Chat.Id) ||
+		l.IsScopedException(mr.Chat.Id, senderID) || l.matchExceptionRules(mr.User)
+	if isException && !l.ignoredExceptions.Contains(senderID) {
+		return false
+	}
+
+	for _, ex := range l.reactionExceptions.load() {
+		if ex(mr) {
+			return false
 		}
+	}
 
-		return ext.EndGroups
+	return true
+}
+
+// reactionCountFilter is the filter method for the reaction counts of
+// the messages; they are only checked if they have a limit profile.
+func (l *Limiter) reactionCountFilter(ctx *ext.Context) bool {
+	mrc := ctx.MessageReactionCount
+	if mrc == nil || !l.isEnabled.Load() || l.isStopped.Load() ||
+		l.GetUpdateProfile(UpdateReactionCount) == nil {
+		return false
+	}
+
+	if l.isChatDisabled(&mrc.Chat) {
+		return false
+	}
+
+	if l.getFlags().OptIn && !l.IsEnrolled(mrc.Chat.Id) {
+		return false
 	}
 
-	if time.Since(status.Last) > l.timeout {
-		status.count = 0
+	return !l.IsInExceptionList(mrc.Chat.Id)
+}
+
+// boostFilter is the filter method for the added and removed chat
+// boosts; they are only checked if they have a limit profile.
+func (l *Limiter) boostFilter(ctx *ext.Context) bool {
+	if (ctx.ChatBoost == nil && ctx.RemovedChatBoost == nil) || !l.isEnabled.Load() ||
+		l.isStopped.Load() || l.GetUpdateProfile(UpdateBoost) == nil {
+		return false
+	}
+
+	chat := ctx.EffectiveChat
+	if l.isChatDisabled(chat) {
+		return false
+	}
+
+	var userID int64
+	if ctx.EffectiveUser != nil {
+		userID = ctx.EffectiveUser.Id
+	}
+
+	if l.getFlags().OptIn && !l.IsEnrolled(userID) && !l.IsEnrolled(chat.Id) {
+		return false
 	}
 
-	if !l.isExceptionCtx(ctx) {
-		status.count++
+	isException := l.IsInExceptionList(userID) || l.IsInExceptionList(chat.Id) ||
+		l.IsScopedException(chat.Id, userID)
+	return !isException || l.ignoredExceptions.Contains(userID)
+}
+
+// challengeFilter is the filter method for the callback queries
+// sent by pressing the challenge buttons.
+func (l *Limiter) challengeFilter(cq *gotgbot.CallbackQuery) bool {
+	return l.isEnabled.Load() && !l.isStopped.Load() &&
+		strings.HasPrefix(cq.Data, ChallengeCallbackPrefix)
+}
+
+// challengeHandler is the handler method for the challenge buttons.
+func (l *Limiter) challengeHandler(b *gotgbot.Bot, ctx *ext.Context) error {
+	cq := ctx.CallbackQuery
+	nonce, answer, ok := parseChallengeData(cq.Data)
+	if !ok {
+		return ext.EndGroups
 	}
 
-	if status.count > l.maxCount {
-		status.limited = true
-		status.Last = time.Now()
+	l.mutex.Lock()
+	pending := l.challenges[nonce]
+	if pending == nil || time.Now().After(pending.expiresAt) {
+		delete(l.challenges, nonce)
 		l.mutex.Unlock()
-		// check for triggers length to prevent from creating
-		// a new goroutine in the case we have no triggers.
-		if len(l.triggers) != 0 {
-			go l.runTriggers(b, ctx)
-		}
+		_, _ = cq.Answer(b, nil)
+		return ext.EndGroups
+	}
 
+	if pending.userID != cq.From.Id {
+		l.mutex.Unlock()
+		_, _ = cq.Answer(b, nil)
 		return ext.EndGroups
 	}
 
+	config := pending.config
+	if pending.answer != answer {
+		l.mutex.Unlock()
+		_, _ = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+			Text: l.translate(cq.From.LanguageCode, config.FailureText, func(b *Bundle) string {
+				return b.ChallengeFailureText
+			}),
+			ShowAlert: true,
+		})
+		return ext.EndGroups
+	}
+
+	delete(l.challenges, nonce)
 	l.mutex.Unlock()
-	status.Last = time.Now()
 
-	if status.IsCustomLimited() {
-		if !status.custom.ignoreException && l.isExceptionCtx(ctx) {
-			return ext.ContinueGroups
-		}
+	l.Unlimit(pending.key)
+	_, _ = cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{
+		Text: l.translate(cq.From.LanguageCode, config.SuccessText, func(b *Bundle) string {
+			return b.ChallengeSuccessText
+		}),
+	})
+
+	if config.DeleteOnSolve {
+		l.runJob(b, func(b *gotgbot.Bot) error {
+			_, err := b.DeleteMessage(pending.chatID, pending.messageID, nil)
+			return err
+		})
+	}
+
+	return ext.EndGroups
+}
+
+// alertFilter is the filter method for the callback queries sent by
+// pressing the buttons of the repeat-offender alerts.
+func (l *Limiter) alertFilter(cq *gotgbot.CallbackQuery) bool {
+	return l.isEnabled.Load() && !l.isStopped.Load() &&
+		strings.HasPrefix(cq.Data, AlertCallbackPrefix)
+}
+
+// alertHandler is the handler method for the buttons of the alerts.
+func (l *Limiter) alertHandler(b *gotgbot.Bot, ctx *ext.Context) error {
+	cq := ctx.CallbackQuery
+	nonce, ban, ok := parseAlertData(cq.Data)
+	if !ok {
 		return ext.EndGroups
 	}
 
+	l.alertMutex.Lock()
+	pending := l.alerts[nonce]
+	delete(l.alerts, nonce)
+	l.alertMutex.Unlock()
+
+	if pending == nil || time.Now().After(pending.expiresAt) {
+		_, _ = cq.Answer(b, nil)
+		return ext.EndGroups
+	}
+
+	if ban {
+		l.runJob(b, func(b *gotgbot.Bot) error {
+			_, err := b.BanChatMember(pending.chatID, pending.userID, nil)
+			return err
+		})
+		l.Audit(&AuditRecord{
+			Action: AuditBan,
+			Key:    pending.key,
+			ChatID: pending.chatID,
+			UserID: pending.userID,
+			Actor:  strconv.FormatInt(cq.From.Id, 10),
+		})
+	} else {
+		l.Unlimit(pending.key)
+	}
+
+	_, _ = cq.Answer(b, nil)
+	if cq.Message != nil {
+		chatID, messageID := cq.Message.GetChat().Id, cq.Message.GetMessageId()
+		l.runJob(b, func(b *gotgbot.Bot) error {
+			// the buttons of the alert can be pressed only once.
+			_, _, err := b.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+				ChatId:    chatID,
+				MessageId: messageID,
+			})
+			return err
+		})
+	}
+
+	return ext.EndGroups
+}
+
+// preCheckoutFilter is the filter method for pre-checkout queries.
+func (l *Limiter) preCheckoutFilter(pcq *gotgbot.PreCheckoutQuery) bool {
+	return l.isEnabled.Load() && !l.isStopped.Load() && l.getFlags().ConsiderPayments &&
+		!l.IsInExceptionList(pcq.From.Id) && (!l.getFlags().OptIn || l.IsEnrolled(pcq.From.Id))
+}
+
+// shippingFilter is the filter method for shipping queries.
+func (l *Limiter) shippingFilter(sq *gotgbot.ShippingQuery) bool {
+	return l.isEnabled.Load() && !l.isStopped.Load() && l.getFlags().ConsiderPayments &&
+		!l.IsInExceptionList(sq.From.Id) && (!l.getFlags().OptIn || l.IsEnrolled(sq.From.Id))
+}
+
+// paymentHandler is the handler method for the payment queries. the
+// rejected queries are answered by the limiter itself, so the users
+// don't have to wait for the checkout to time out.
+func (l *Limiter) paymentHandler(b *gotgbot.Bot, ctx *ext.Context) error {
+	if !l.meetsConditions(ctx) {
+		return ext.ContinueGroups
+	}
+
+	id := l.scopeKey(b, ctx.EffectiveUser.Id)
+	d := l.paymentLimiter.Load().Allow(id, 1)
+	if d.NewlyLimited {
+		if len(l.paymentTriggers.load()) != 0 {
+			go l.runPaymentTriggers(b, ctx)
+		}
+
+		l.notify(EventLimited, ActionIgnore, ctx, id, d)
+	}
+
+	if d.IsAllowed() {
+		return ext.ContinueGroups
+	}
+
+	if b != nil && l.getFlags().Propagation != PropagationAnnotate {
+		text := l.translate(getLanguageCode(ctx), DefaultPaymentLimitedText, func(b *Bundle) string {
+			return b.PaymentLimitedText
+		})
+		l.runJob(b, func(b *gotgbot.Bot) error {
+			return answerPaymentQuery(b, ctx, text)
+		})
+	}
+
+	p := l.GetPaymentProfile()
+	return l.propagate(ctx, id, d, p)
+}
+
+// chatMemberFilter is the filter method for chat member updates.
+// these updates are only used for tracking the newly joined members
+// and the roles of the users, so they are not checked for floodwait
+// at all.
+func (l *Limiter) chatMemberFilter(u *gotgbot.ChatMemberUpdated) bool {
+	return l.isEnabled.Load() && !l.isStopped.Load() &&
+		(l.GetProbationProfile() != nil || l.hasRoleProfiles() || l.GetRaid() != nil ||
+			l.GetFlapping() != nil)
+}
+
+// chatMemberHandler is the handler method for chat member updates.
+func (l *Limiter) chatMemberHandler(b *gotgbot.Bot, ctx *ext.Context) error {
+	u := ctx.ChatMember
+	if l.GetProbationProfile() != nil && isJoinStatus(u.NewChatMember.GetStatus()) &&
+		!isJoinStatus(u.OldChatMember.GetStatus()) {
+		l.AddProbation(u.Chat.Id, u.NewChatMember.GetUser().Id)
+	}
+
+	l.trackRaid(b, ctx)
+	l.trackFlapping(b, ctx)
+
+	if l.hasRoleProfiles() {
+		// keep the cached role of the user up to date.
+		l.SetRole(u.Chat.Id, u.NewChatMember.GetUser().Id, Role(u.NewChatMember.GetStatus()))
+	}
+
+	return ext.ContinueGroups
+}
+
+// limiterHandler is the main handler method.
+func (l *Limiter) limiterHandler(b *gotgbot.Bot, ctx *ext.Context) error {
+	started := time.Now()
+	if !l.meetsConditions(ctx) {
+		l.observeCheck(started, core.ResultExempt)
+		return ext.ContinueGroups
+	}
+
+	id, ok := l.getKey(ctx)
+	if !ok {
+		l.observeCheck(started, core.ResultExempt)
+		return ext.ContinueGroups
+	}
+
+	d, p := l.check(b, ctx, id)
+	l.observeCheck(started, d.Result)
+	if !d.IsAllowed() {
+		if l.delayUpdate(b, ctx, l.scopeKey(b, id), d) {
+			// the update will be handled later.
+			return ext.EndGroups
+		}
+
+		return l.propagate(ctx, id, d, p)
+	}
+
 	return ext.ContinueGroups
 }
@@ -6,17 +6,40 @@
 package ratelimiter
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
-	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+	"gopkg.in/yaml.v3"
 )
 
 // NewLimiter creates a new `Limiter` with the given dispatcher.
+// the dispatcher can be nil, in which case the limiter can be attached
+// to the dispatchers later using `AttachTo`.
 // pass true for the second parameter if you want the limiter to check
 // messages in channels too.
 // pass true for the third parameter if you want the limiter to check
 // edited messages too.
+// zero durations and message count in the config will be replaced with
+// their default values (such as `DefaultTimeout`).
 func NewLimiter(dispatcher *ext.Dispatcher, config *LimiterConfig) *Limiter {
 	l := new(Limiter)
+	l.instanceID = newInstanceID()
 
 	if config == nil {
 		config = DefaultConfig
@@ -24,38 +47,132 @@ func NewLimiter(dispatcher *ext.Dispatcher, config *LimiterConfig) *Limiter {
 
 	l.filter = l.limiterFilter
 	l.handler = l.limiterHandler
-	l.timeout = config.Timeout
-	l.punishment = config.PunishmentTime
-	l.maxCount = config.MessageCount
-	l.maxTimeout = config.MaxTimeout
+	l.core = core.NewLimiter(core.Profile{
+		Timeout:        valueOrDefault(config.Timeout, DefaultTimeout),
+		PunishmentTime: valueOrDefault(config.PunishmentTime, DefaultPunishmentTime),
+		MessageCount:   valueOrDefault(config.MessageCount, DefaultMessageCount),
+		Throttle:       config.Throttle,
+	})
+	l.maxTimeout.Store(int64(valueOrDefault(config.MaxTimeout, DefaultMaxTimeout)))
+	l.checkerInterval.Store(int64(config.CheckerInterval))
+	l.scoreThreshold = DefaultScoreThreshold
 	l.IgnoreMediaGroup = config.IgnoreMediaGroup
-	l.TextOnly = config.TextOnly
+	l.CountAlbumsOnce = config.CountAlbumsOnce
+	l.SetTextOnly(config.TextOnly)
+	if config.CountedTypes != 0 {
+		l.SetCountedTypes(config.CountedTypes)
+	}
+	l.SetChatScopes(config.ChatScopes)
+	l.CountCaptions = config.CountCaptions
+	l.ServicePolicy = config.ServicePolicy
+	l.ConsiderPayments = config.ConsiderPayments
+	l.SetPaymentProfile(config.PaymentProfile)
+	l.SetGlobalProfile(config.GlobalProfile)
+	l.SetMentionProfile(config.MentionProfile)
+	l.SetVoiceProfile(config.VoiceProfile)
+	l.DeleteVoiceFlood = config.DeleteVoiceFlood
+	l.SetJoinFloodProfile(config.JoinFloodProfile)
 	l.ConsiderUser = config.ConsiderUser
 	l.ConsiderInline = config.ConsiderInline
 	l.IsStrict = config.IsStrict
+	l.PartialReset = config.PartialReset
+	l.SetMaxPunishment(config.MaxPunishment)
+	l.SetWarnThreshold(config.WarnThreshold)
+	l.OptIn = config.OptIn
+	l.AddAllowedCommands(config.AllowedCommands...)
+	l.commandProfile.Store(config.CommandProfile)
+	l.ScopeByBot = config.ScopeByBot
+	l.LimitChannelSenders = config.LimitChannelSenders
+	l.channelProfile.Store(config.ChannelSenderProfile)
+	l.channelPostProfile.Store(config.ChannelPostProfile)
+	l.ExemptAutoForwards = config.ExemptAutoForwards
+	l.commentProfile.Store(config.CommentProfile)
+	l.Propagation = config.Propagation
+	l.StopPolicy = config.StopPolicy
+	l.Standalone = config.Standalone
+	l.SetProbation(config.ProbationProfile, config.ProbationDuration)
 
-	h := handlers.NewMessage(l.filter, l.handler)
-	cb := handlers.NewCallback(l.callbackFilter, l.handler)
+	l.SetChallenge(config.Challenge)
+	l.SetReply(config.Reply)
+	for lang, bundle := range config.Bundles {
+		l.SetBundle(lang, bundle)
+	}
+	l.SetStorage(config.Storage)
 
-	l.msgHandler = &h
-	l.msgHandler.AllowChannel = config.ConsiderChannel
-	l.msgHandler.AllowEdited = config.ConsiderEdits
+	if config.WebhookURL != "" {
+		l.SetWebhook(config.WebhookURL)
+	}
 
-	l.allHandlers = append(l.allHandlers, h, cb)
+	for tier, profile := range config.TierProfiles {
+		l.SetTierProfile(tier, profile)
+	}
 
-	for _, currentHandler := range l.allHandlers {
-		if len(config.HandlerGroups) != 0 {
-			for _, current := range config.HandlerGroups {
-				dispatcher.AddHandlerToGroup(currentHandler, current)
-			}
-		} else {
-			dispatcher.AddHandler(currentHandler)
-		}
+	l.SetAdaptive(config.Adaptive)
+	l.SetRisk(config.Risk)
+	l.SetStatsRetention(config.StatsRetention)
+	l.SetStatsHistory(config.StatsHistory)
+	l.SetMaxEntries(config.MaxEntries)
+	l.SetRoleCacheTime(config.RoleCacheTime)
+	for role, profile := range config.RoleProfiles {
+		l.SetRoleProfile(role, profile)
+	}
+
+	for updateType, profile := range config.UpdateProfiles {
+		l.SetUpdateProfile(updateType, profile)
+	}
+
+	if config.CallbackProfile != nil {
+		l.SetCallbackProfile(config.CallbackProfile)
+	}
+
+	l.SetCallbackDebounce(config.CallbackDebounce, config.DebounceToast)
+	l.SetDelay(config.Delay)
+	l.SetRaid(config.Raid)
+	l.SetFlapping(config.Flapping)
+
+	l.initHandlers(config.ConsiderChannel, config.ConsiderEdits, config.ConsiderBusiness)
+	l.handlerGroups = config.HandlerGroups
+
+	if dispatcher != nil {
+		l.AttachTo(dispatcher)
 	}
 
 	return l
 }
 
+// NewFileAuditSink opens the file (creating it if it doesn't exist) and
+// returns an audit sink which appends the records to it as JSON lines.
+// the file should be closed by calling `Close` when it's not needed
+// anymore.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{file: file}, nil
+}
+
+// NewRegistry creates a new empty registry of limiters. most of the bots
+// can simply use `DefaultRegistry`.
+func NewRegistry() *Registry {
+	return &Registry{
+		limiters: make(map[string]*Limiter),
+	}
+}
+
+// NewLimiterProcessor returns a processor which gates every update by
+// the limiter before passing it to the next processor (`ext.BaseProcessor`
+// if next is nil); it can be passed to `ext.DispatcherOpts`.
+// NOTICE: the dispatcher is not known to the limiter this way, so either
+// set `Standalone` to true, or use `Limiter.AttachProcessor` instead.
+func NewLimiterProcessor(l *Limiter, next ext.Processor) *LimiterProcessor {
+	return &LimiterProcessor{
+		Limiter: l,
+		Next:    next,
+	}
+}
+
 // NewFullLimiter creates a new `Limiter` with the given dispatcher.
 // it will initialize a limiter which checks for messages received from
 // channels and edited messages.
@@ -70,3 +187,1621 @@ func NewFullLimiter(dispatcher *ext.Dispatcher) *Limiter {
 		ConsiderInline:   true,
 	})
 }
+
+// LoadConfig will load the limiter configuration from a JSON or YAML
+// file; the format is determined by the extension of the file (".yaml"
+// and ".yml" are treated as YAML, everything else as JSON).
+// the fields which are not present in the file will have the values
+// of `DefaultConfig`.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newDefaultFileConfig()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		err = json.Unmarshal(data, c)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ConfigFromEnv will build the limiter configuration from the environment
+// variables named after the fields of the config file with the given
+// prefix, such as "RATELIMITER_MESSAGE_COUNT" or "RATELIMITER_REPLY_TEXT"
+// for the "RATELIMITER" prefix; it's suitable for the containerized bots,
+// where the config files are awkward.
+// the durations are written the same way as in the config files (such
+// as "4s" or "2m30s"), the lists (such as "RATELIMITER_EXCEPTION_IDS")
+// are separated by commas, and the fields which have no simple form
+// (such as "RATELIMITER_TIER_PROFILES") are written in JSON.
+// the fields without an environment variable will have the values of
+// `DefaultConfig`; the config should be validated by `Config.Validate`.
+func ConfigFromEnv(prefix string) (*Config, error) {
+	prefix = strings.ToUpper(prefix)
+	if prefix != "" && !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+
+	c := newDefaultFileConfig()
+	if _, err := loadEnv(reflect.ValueOf(c).Elem(), prefix); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newDefaultFileConfig returns a new file config with the values of
+// `DefaultConfig`.
+func newDefaultFileConfig() *Config {
+	return &Config{
+		ConsiderChannel:  DefaultConfig.ConsiderChannel,
+		ConsiderUser:     DefaultConfig.ConsiderUser,
+		ConsiderEdits:    DefaultConfig.ConsiderEdits,
+		ConsiderInline:   DefaultConfig.ConsiderInline,
+		IgnoreMediaGroup: DefaultConfig.IgnoreMediaGroup,
+		TextOnly:         DefaultConfig.TextOnly,
+		IsStrict:         DefaultConfig.IsStrict,
+		Timeout:          Duration(DefaultConfig.Timeout),
+		PunishmentTime:   Duration(DefaultConfig.PunishmentTime),
+		MaxTimeout:       Duration(DefaultConfig.MaxTimeout),
+		MessageCount:     DefaultConfig.MessageCount,
+	}
+}
+
+// loadEnv will set the fields of the struct from the environment
+// variables named after their json names with the given prefix; the
+// nested configs (such as the profiles) are set from the variables with
+// the name of their field as prefix. it returns true if any of the
+// fields has been set.
+func loadEnv(v reflect.Value, prefix string) (bool, error) {
+	found := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + strings.ToUpper(tag)
+		current := v.Field(i)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setEnvValue(current, value); err != nil {
+				return false, fmt.Errorf("%w: %s: %v", ErrInvalidEnv, name, err)
+			}
+
+			found = true
+		}
+
+		if field.Type.Kind() != reflect.Pointer || field.Type.Elem().Kind() != reflect.Struct {
+			continue
+		}
+
+		nested := reflect.New(field.Type.Elem())
+		if !current.IsNil() {
+			nested.Elem().Set(current.Elem())
+		}
+
+		set, err := loadEnv(nested.Elem(), name+"_")
+		if err != nil {
+			return false, err
+		}
+
+		if set {
+			current.Set(nested)
+			found = true
+		}
+	}
+
+	return found, nil
+}
+
+// setEnvValue will set the field from the value of its environment
+// variable; the lists of the simple values can be separated by commas.
+func setEnvValue(v reflect.Value, value string) error {
+	if v.Kind() != reflect.Slice || !isSimpleKind(v.Type().Elem().Kind()) ||
+		strings.HasPrefix(strings.TrimSpace(value), "[") {
+		return decodeEnvValue(v, value)
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	s := reflect.MakeSlice(v.Type(), len(items), len(items))
+	for i, item := range items {
+		if err := decodeEnvValue(s.Index(i), item); err != nil {
+			return err
+		}
+	}
+
+	v.Set(s)
+	return nil
+}
+
+// decodeEnvValue will decode a single value of an environment variable
+// into v; the value is decoded as JSON, or as a JSON string if it's not
+// a valid JSON value of the type (such as the durations and the texts).
+func decodeEnvValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Bool {
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+		return nil
+	}
+
+	target := v.Addr().Interface()
+	if v.Kind() != reflect.String && json.Unmarshal([]byte(value), target) == nil {
+		return nil
+	}
+
+	quoted, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(quoted, target)
+}
+
+// isSimpleKind returns true if the values of the kind can be written in
+// a comma separated list.
+func isSimpleKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return false
+}
+
+// NewLimiterFromFile creates a new `Limiter` with the given dispatcher,
+// using the configuration loaded from the file.
+func NewLimiterFromFile(dispatcher *ext.Dispatcher, path string) (*Limiter, error) {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+
+	l := NewLimiter(dispatcher, c.LimiterConfig())
+	_ = l.ApplyFileConfig(c)
+
+	return l, nil
+}
+
+// setContextDispatcher will store the dispatcher in the data of the
+// context; nil dispatchers are not stored.
+func setContextDispatcher(ctx *ext.Context, d *ext.Dispatcher) {
+	if d == nil {
+		return
+	}
+
+	if ctx.Data == nil {
+		ctx.Data = make(map[string]interface{})
+	}
+
+	ctx.Data[ContextDispatcherKey] = d
+}
+
+// setCoreProfile will set the profile of the core limiter of the given
+// pointer, creating a new core limiter if there is none yet.
+func setCoreProfile(ptr *atomic.Pointer[core.Limiter], p core.Profile) {
+	for {
+		if limiter := ptr.Load(); limiter != nil {
+			limiter.SetProfile(p)
+			return
+		}
+
+		if ptr.CompareAndSwap(nil, core.NewLimiter(p)) {
+			return
+		}
+	}
+}
+
+// valueOrDefault returns the default value if the value is zero.
+func valueOrDefault[T time.Duration | int](value, defaultValue T) T {
+	if value == 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// validateValues will check the main values of a limiter configuration
+// and returns an error describing the first nonsensical value.
+func validateValues(timeout, punishment, maxTimeout time.Duration, count int, strict bool) error {
+	if count <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidMessageCount, count)
+	}
+
+	if timeout <= 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidTimeout, timeout)
+	}
+
+	if punishment < 0 || (strict && punishment < timeout) {
+		return fmt.Errorf("%w: %v (timeout is %v)", ErrInvalidPunishment, punishment, timeout)
+	}
+
+	if maxTimeout < time.Second {
+		return fmt.Errorf("%w: %v", ErrInvalidMaxTimeout, maxTimeout)
+	}
+
+	return nil
+}
+
+// parseDuration parses a duration from a string; it also accepts plain
+// numbers, which are treated as nanoseconds.
+func parseDuration(value string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(n), nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// isJoinStatus returns true if the given chat member status means the
+// user is present in the chat.
+func isJoinStatus(status string) bool {
+	switch status {
+	case "member", "restricted", "administrator", "creator":
+		return true
+	}
+	return false
+}
+
+// newNonce returns a random nonce for the callback data of the buttons
+// sent by the limiter, so the pending challenges (and alerts) can't be
+// guessed by the other users.
+func newNonce() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// newInstanceID returns a unique id for a limiter, used as the origin of
+// the events it publishes to the event bus.
+func newInstanceID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + newNonce()
+}
+
+// parseChallengeData will parse the callback data of a challenge button
+// and return its nonce and the index of the pressed choice.
+func parseChallengeData(data string) (string, int, bool) {
+	data = strings.TrimPrefix(data, ChallengeCallbackPrefix)
+	nonce, index, found := strings.Cut(data, ":")
+	if !found {
+		return "", 0, false
+	}
+
+	answer, err := strconv.Atoi(index)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return nonce, answer, true
+}
+
+// pruneIncidents returns the incidents which have happened in the last
+// window amount of time; the incidents are sorted by their time.
+func pruneIncidents(incidents []Incident, window time.Duration) []Incident {
+	i := 0
+	for i < len(incidents) && time.Since(incidents[i].Time) > window {
+		i++
+	}
+
+	return incidents[i:]
+}
+
+// parseAlertData will parse the callback data of an alert button and
+// returns its nonce, and whether it's the ban button.
+func parseAlertData(data string) (string, bool, bool) {
+	data = strings.TrimPrefix(data, AlertCallbackPrefix)
+	nonce, action, found := strings.Cut(data, ":")
+	if !found || (action != "ban" && action != "pardon") {
+		return "", false, false
+	}
+
+	return nonce, action == "ban", true
+}
+
+// normalizeChallenge returns a copy of the challenge configuration with
+// the default values set for its empty fields.
+func normalizeChallenge(config *ChallengeConfig) *ChallengeConfig {
+	if config == nil {
+		return nil
+	}
+
+	c := *config
+	if c.Text == "" {
+		c.Text = DefaultChallengeText
+	}
+
+	if c.SuccessText == "" {
+		c.SuccessText = DefaultChallengeSuccessText
+	}
+
+	if c.FailureText == "" {
+		c.FailureText = DefaultChallengeFailureText
+	}
+
+	if len(c.Choices) < 2 {
+		c.Choices = DefaultChallengeChoices
+	}
+
+	return &c
+}
+
+// normalizeReply returns a copy of the reply configuration with the
+// default values set for its empty fields.
+func normalizeReply(config *ReplyConfig) *ReplyConfig {
+	if config == nil {
+		return nil
+	}
+
+	c := *config
+	if c.Text == "" {
+		c.Text = DefaultReplyText
+	}
+
+	if c.TimeFormat == "" {
+		c.TimeFormat = DefaultReplyTimeFormat
+	}
+
+	return &c
+}
+
+// formatReply will replace the placeholders of the reply text with the
+// values of the update; see `ReplyConfig` for the placeholders.
+func formatReply(text string, ctx *ext.Context, remaining, until string) string {
+	var user, mention, chat string
+	if sender := ctx.EffectiveSender; sender != nil {
+		user = html.EscapeString(sender.Name())
+		mention = user
+		if sender.IsUser() {
+			mention = fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, sender.Id(), user)
+		}
+	}
+
+	if c := ctx.EffectiveChat; c != nil {
+		chat = c.Title
+		if chat == "" {
+			chat = strings.TrimSpace(c.FirstName + " " + c.LastName)
+		}
+		chat = html.EscapeString(chat)
+	}
+
+	return strings.NewReplacer(
+		"{user}", user,
+		"{mention}", mention,
+		"{remaining}", remaining,
+		"{until}", until,
+		"{chat}", chat,
+	).Replace(text)
+}
+
+// templatesModTime returns the last modification time of the template
+// files of the directory; the modification time of the directory itself
+// is considered as well, so the removed files are noticed too.
+func templatesModTime(dir string) (time.Time, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	latest := info.ModTime()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != TemplateExt {
+			continue
+		}
+
+		info, err = entry.Info()
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// startWatcher will load the watched files once and starts watching
+// them in a new goroutine.
+func startWatcher(w *ConfigWatcher) (*ConfigWatcher, error) {
+	w.stop = make(chan struct{})
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	go w.watch()
+
+	return w, nil
+}
+
+// pickTranslation returns the translation picked from the bundle of
+// the language (or its base language); it returns an empty string if
+// there is no translation.
+func pickTranslation(bundles map[string]*Bundle, lang, base string, pick func(b *Bundle) string) string {
+	if b := bundles[lang]; b != nil {
+		if text := pick(b); text != "" {
+			return text
+		}
+	}
+
+	if b := bundles[base]; b != nil && base != lang {
+		return pick(b)
+	}
+
+	return ""
+}
+
+// getLanguageCode returns the language code of the user of the update;
+// it returns an empty string if it's unknown.
+func getLanguageCode(ctx *ext.Context) string {
+	if ctx == nil || ctx.EffectiveUser == nil {
+		return ""
+	}
+
+	return ctx.EffectiveUser.LanguageCode
+}
+
+// chatSettingsKey returns the storage key of the chat's settings.
+func chatSettingsKey(chatID int64) string {
+	return chatSettingsPrefix + strconv.FormatInt(chatID, 10)
+}
+
+// statsChatPrefix returns the prefix of the storage keys of the hourly
+// statistics of the chat.
+func statsChatPrefix(chatID int64) string {
+	return statsPrefix + strconv.FormatInt(chatID, 10) + ":"
+}
+
+// statsKey returns the storage key of the hourly statistics of the chat
+// started at the given time.
+func statsKey(chatID int64, start time.Time) string {
+	return statsChatPrefix(chatID) + strconv.FormatInt(start.Unix(), 10)
+}
+
+// parseStatsKey returns the chat id and the start (as unix time) of the
+// hourly statistics of the storage key.
+func parseStatsKey(key string) (chatID, hour int64, ok bool) {
+	if !strings.HasPrefix(key, statsPrefix) {
+		return 0, 0, false
+	}
+
+	id, start, found := strings.Cut(key[len(statsPrefix):], ":")
+	if !found {
+		return 0, 0, false
+	}
+
+	chatID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	hour, err = strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return chatID, hour, true
+}
+
+// customIgnoreKey returns the storage key of the custom ignore.
+func customIgnoreKey(id int64) string {
+	return customIgnorePrefix + strconv.FormatInt(id, 10)
+}
+
+// federationKey returns the storage key of the verdict of the member of
+// a federation.
+func federationKey(member string, userID int64) string {
+	return federationPrefix + member + ":" + strconv.FormatInt(userID, 10)
+}
+
+// parseFederationKey returns the member and the user id of the storage
+// key of a federation verdict.
+func parseFederationKey(key string) (string, int64, bool) {
+	key = strings.TrimPrefix(key, federationPrefix)
+	i := strings.LastIndex(key, ":")
+	if i <= 0 {
+		return "", 0, false
+	}
+
+	userID, err := strconv.ParseInt(key[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return key[:i], userID, true
+}
+
+// validateFederation will check the federation config and returns an
+// error if its values are nonsensical; nil config is valid.
+func validateFederation(config *FederationConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	if config.Storage == nil || strings.Contains(config.Name, ":") ||
+		config.SyncInterval < 0 || config.SyncTimeout < 0 {
+		return fmt.Errorf("%w: %+v", ErrInvalidFederation, *config)
+	}
+
+	if t := config.Trust; t != nil && (t.Quorum < 0 || t.MaxDuration < 0) {
+		return fmt.Errorf("%w: %+v", ErrInvalidFederation, *t)
+	}
+
+	return nil
+}
+
+// actionKey returns the storage key of the scheduled action.
+func actionKey(chatID, userID int64) string {
+	return actionPrefix + strconv.FormatInt(chatID, 10) + ":" + strconv.FormatInt(userID, 10)
+}
+
+// revertPunishment will call the bot api method which reverts the
+// punishment of the scheduled action.
+func revertPunishment(b *gotgbot.Bot, action *ScheduledAction) error {
+	var err error
+	switch action.Action {
+	case AuditUnmute:
+		if p := action.Permissions; p != nil && (action.RestrictedUntil == 0 ||
+			time.Until(time.Unix(action.RestrictedUntil, 0)) > minUntilDate) {
+			// the user was already restricted before the punishment, so
+			// its own restrictions are restored.
+			_, err = b.RestrictChatMember(action.ChatID, action.UserID, *p, &gotgbot.RestrictChatMemberOpts{
+				UseIndependentChatPermissions: true,
+				UntilDate:                     action.RestrictedUntil,
+			})
+			break
+		}
+
+		// passing true for all of the permissions lifts the restrictions;
+		// the default permissions of the chat still apply to the user.
+		_, err = b.RestrictChatMember(action.ChatID, action.UserID, gotgbot.ChatPermissions{
+			CanSendMessages:       true,
+			CanSendAudios:         true,
+			CanSendDocuments:      true,
+			CanSendPhotos:         true,
+			CanSendVideos:         true,
+			CanSendVideoNotes:     true,
+			CanSendVoiceNotes:     true,
+			CanSendPolls:          true,
+			CanSendOtherMessages:  true,
+			CanAddWebPagePreviews: true,
+			CanChangeInfo:         true,
+			CanInviteUsers:        true,
+			CanPinMessages:        true,
+			CanManageTopics:       true,
+		}, nil)
+	case AuditUnban:
+		_, err = b.UnbanChatMember(action.ChatID, action.UserID, &gotgbot.UnbanChatMemberOpts{
+			OnlyIfBanned: true,
+		})
+	}
+
+	return err
+}
+
+// getRestrictions returns the permissions of the user in the chat and
+// the time its restrictions end at (as unix time, zero meaning forever);
+// it returns nil if the user is not restricted, or if the member can't
+// be looked up.
+func getRestrictions(b *gotgbot.Bot, chatID, userID int64) (*gotgbot.ChatPermissions, int64) {
+	member, err := b.GetChatMember(chatID, userID, nil)
+	if err != nil {
+		return nil, 0
+	}
+
+	restricted, ok := member.(gotgbot.ChatMemberRestricted)
+	if !ok {
+		return nil, 0
+	}
+
+	return &gotgbot.ChatPermissions{
+		CanSendMessages:       restricted.CanSendMessages,
+		CanSendAudios:         restricted.CanSendAudios,
+		CanSendDocuments:      restricted.CanSendDocuments,
+		CanSendPhotos:         restricted.CanSendPhotos,
+		CanSendVideos:         restricted.CanSendVideos,
+		CanSendVideoNotes:     restricted.CanSendVideoNotes,
+		CanSendVoiceNotes:     restricted.CanSendVoiceNotes,
+		CanSendPolls:          restricted.CanSendPolls,
+		CanSendOtherMessages:  restricted.CanSendOtherMessages,
+		CanAddWebPagePreviews: restricted.CanAddWebPagePreviews,
+		CanChangeInfo:         restricted.CanChangeInfo,
+		CanInviteUsers:        restricted.CanInviteUsers,
+		CanPinMessages:        restricted.CanPinMessages,
+		CanManageTopics:       restricted.CanManageTopics,
+	}, restricted.UntilDate
+}
+
+// getUntilDate returns the unix time of the given time for the until
+// date of the bans and the restrictions; as telegram treats the dates
+// less than 30 seconds from now as forever, it's never sooner than that.
+func getUntilDate(until time.Time) int64 {
+	if earliest := time.Now().Add(minUntilDate); until.Before(earliest) {
+		until = earliest
+	}
+
+	return until.Unix()
+}
+
+// getForwardSource returns the id of the channel (or the user) a message
+// has been forwarded from; it returns zero if the source is hidden.
+func getForwardSource(origin gotgbot.MessageOrigin) int64 {
+	merged := origin.MergeMessageOrigin()
+	switch {
+	case merged.Chat != nil:
+		return merged.Chat.Id
+	case merged.SenderChat != nil:
+		return merged.SenderChat.Id
+	case merged.SenderUser != nil:
+		return merged.SenderUser.Id
+	}
+
+	return 0
+}
+
+// getQueryMessage returns the message of the callback query; it returns
+// nil if the message is not accessible.
+func getQueryMessage(cq *gotgbot.CallbackQuery) *gotgbot.Message {
+	switch msg := cq.Message.(type) {
+	case gotgbot.Message:
+		return &msg
+	case *gotgbot.Message:
+		return msg
+	}
+
+	return nil
+}
+
+// isPunishable returns true if the sender of the update can be punished
+// in its chat, which is only possible for the users of the groups.
+func isPunishable(ctx *ext.Context) bool {
+	chat, user := ctx.EffectiveChat, ctx.EffectiveUser
+	return chat != nil && user != nil &&
+		(chat.Type == "group" || chat.Type == "supergroup") &&
+		getSenderChat(ctx.EffectiveMessage) == nil
+}
+
+// getLadderAction returns the action of the n-th violation from the
+// actions of an escalation policy; the violations after the last action
+// take the last one.
+func getLadderAction(actions []Action, n int) Action {
+	if len(actions) == 0 || n <= 0 {
+		return nil
+	}
+
+	if n > len(actions) {
+		n = len(actions)
+	}
+
+	return actions[n-1]
+}
+
+// capDuration converts the duration calculated by an escalation policy to
+// a `time.Duration`, capped by max (if it's not zero) and by the maximum
+// possible duration.
+func capDuration(d float64, max time.Duration) time.Duration {
+	if max > 0 && d > float64(max) {
+		return max
+	}
+
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	return time.Duration(d)
+}
+
+// newLimitInfo returns the information about the limited update.
+func newLimitInfo(ctx *ext.Context, key int64, d core.Decision, p *LimitProfile) *LimitInfo {
+	info := &LimitInfo{
+		Key:          key,
+		Count:        d.Count,
+		MaxCount:     d.MaxCount,
+		NewlyLimited: d.NewlyLimited,
+		Capped:       d.Capped,
+		Profile:      p,
+	}
+
+	if chat := ctx.EffectiveChat; chat != nil {
+		info.ChatID = chat.Id
+	}
+
+	if user := ctx.EffectiveUser; user != nil {
+		info.UserID = user.Id
+	}
+
+	if msg := ctx.EffectiveMessage; msg != nil {
+		info.MessageID = msg.MessageId
+	}
+
+	return info
+}
+
+// getSenderChat returns the channel which has sent the message on its own
+// behalf; it returns nil if the message is sent by a user, or by the chat
+// itself (such as the anonymous admins, or the posts of a channel), or if
+// it's an automatic forward of the linked channel.
+func getSenderChat(msg *gotgbot.Message) *gotgbot.Chat {
+	if msg == nil || msg.SenderChat == nil || msg.IsAutomaticForward {
+		return nil
+	}
+
+	if msg.SenderChat.Id == msg.Chat.Id {
+		return nil
+	}
+
+	return msg.SenderChat
+}
+
+// IsDiscussionComment returns true if the message is a direct reply to
+// an automatic forward of the linked channel of the group, which is
+// a comment under the channel post. the replies to the other comments of
+// the thread are detected by the limiter as well, as long as it has seen
+// the automatic forward itself (see `Limiter.IsComment`).
+func IsDiscussionComment(msg *gotgbot.Message) bool {
+	return msg != nil && !msg.IsAutomaticForward &&
+		msg.ReplyToMessage != nil && msg.ReplyToMessage.IsAutomaticForward
+}
+
+// IsLimited returns true if the update has been marked as limited by
+// a limiter in the "flag only" mode (`PropagationAnnotate`).
+func IsLimited(ctx *ext.Context) bool {
+	limited, _ := ctx.Data[ContextLimitedKey].(bool)
+	return limited
+}
+
+// GetLimitInfo returns the information about the limited update stored
+// by a limiter in the "flag only" mode; it returns nil if the update
+// is not limited.
+func GetLimitInfo(ctx *ext.Context) *LimitInfo {
+	info, _ := ctx.Data[ContextLimitInfoKey].(*LimitInfo)
+	return info
+}
+
+// IsWarned returns true if the update has made its sender reach the
+// warning threshold of the limiter (see `Limiter.SetWarnThreshold`).
+func IsWarned(ctx *ext.Context) bool {
+	warned, _ := ctx.Data[ContextWarnedKey].(bool)
+	return warned
+}
+
+// IsSlowed returns true if the message has been ignored by the slow mode
+// of its chat (see `ChatSettings.SlowMode`).
+func IsSlowed(ctx *ext.Context) bool {
+	slowed, _ := ctx.Data[ContextSlowedKey].(bool)
+	return slowed
+}
+
+// getMessageType returns the type of the message.
+func getMessageType(msg *gotgbot.Message) MessageType {
+	switch {
+	case msg.Text != "":
+		return TypeText
+	case len(msg.Photo) != 0:
+		return TypePhoto
+	case msg.Video != nil:
+		return TypeVideo
+	case msg.Animation != nil:
+		// animations have their document field set as well, so they
+		// have to be checked before documents.
+		return TypeAnimation
+	case msg.Audio != nil:
+		return TypeAudio
+	case msg.Voice != nil:
+		return TypeVoice
+	case msg.VideoNote != nil:
+		return TypeVideoNote
+	case msg.Document != nil:
+		return TypeDocument
+	case msg.Sticker != nil:
+		return TypeSticker
+	case msg.Dice != nil:
+		return TypeDice
+	case msg.Poll != nil:
+		return TypePoll
+	case msg.Location != nil || msg.Venue != nil:
+		return TypeLocation
+	case msg.Contact != nil:
+		return TypeContact
+	}
+
+	return TypeOther
+}
+
+// ParseMessageType converts the names of the message types used in the
+// config files (such as "text", "photo", "video_note" or "media") to
+// a message type mask.
+func ParseMessageType(names ...string) (MessageType, error) {
+	var t MessageType
+	for _, name := range names {
+		current, ok := messageTypeNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidMessageType, name)
+		}
+
+		t |= current
+	}
+
+	return t, nil
+}
+
+// ParseChatScope converts the names of the chat scopes used in the config
+// files (such as "private", "groups" or "channels") to a chat scope mask.
+func ParseChatScope(names ...string) (ChatScope, error) {
+	var s ChatScope
+	for _, name := range names {
+		current, ok := chatScopeNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidChatScope, name)
+		}
+
+		s |= current
+	}
+
+	return s, nil
+}
+
+// getChatScope returns the scope of the given type of chat, as it's sent
+// by telegram; "sender" is the type of the private chats with the sender
+// of the inline queries. it returns zero for the unknown types.
+func getChatScope(chatType string) ChatScope {
+	switch chatType {
+	case gotgbot.ChatTypePrivate, "sender":
+		return ScopePrivate
+	case gotgbot.ChatTypeGroup, gotgbot.ChatTypeSupergroup:
+		return ScopeGroups
+	case gotgbot.ChatTypeChannel:
+		return ScopeChannels
+	default:
+		return 0
+	}
+}
+
+// isServiceMessage returns true if the message is a service message,
+// such as joins, leaves, pins or title changes.
+func isServiceMessage(msg *gotgbot.Message) bool {
+	return isJoinLeaveMessage(msg) || msg.NewChatTitle != "" ||
+		len(msg.NewChatPhoto) != 0 || msg.DeleteChatPhoto ||
+		msg.GroupChatCreated || msg.SupergroupChatCreated ||
+		msg.ChannelChatCreated || msg.MessageAutoDeleteTimerChanged != nil ||
+		msg.MigrateToChatId != 0 || msg.MigrateFromChatId != 0 ||
+		msg.PinnedMessage != nil || msg.WriteAccessAllowed != nil ||
+		msg.ProximityAlertTriggered != nil || msg.BoostAdded != nil ||
+		msg.ForumTopicCreated != nil || msg.ForumTopicEdited != nil ||
+		msg.ForumTopicClosed != nil || msg.ForumTopicReopened != nil ||
+		msg.GeneralForumTopicHidden != nil || msg.GeneralForumTopicUnhidden != nil ||
+		msg.VideoChatScheduled != nil || msg.VideoChatStarted != nil ||
+		msg.VideoChatEnded != nil || msg.VideoChatParticipantsInvited != nil
+}
+
+// getCommand returns the lower-cased name of the command of the message,
+// without the '/' prefix and the username of the bot; it returns an
+// empty string if the message is not a command.
+func getCommand(msg *gotgbot.Message) string {
+	text := msg.Text
+	if len(text) < 2 || text[0] != '/' {
+		return ""
+	}
+
+	if end := strings.IndexAny(text, " \t\n"); end >= 0 {
+		text = text[:end]
+	}
+
+	text = text[1:]
+	if at := strings.IndexByte(text, '@'); at >= 0 {
+		text = text[:at]
+	}
+
+	return strings.ToLower(text)
+}
+
+// isBotMention returns true if the message mentions the bot (by its
+// username or a text mention), or replies to one of its messages.
+func isBotMention(b *gotgbot.Bot, msg *gotgbot.Message) bool {
+	if b == nil || msg == nil {
+		return false
+	}
+
+	if reply := msg.ReplyToMessage; reply != nil && reply.From != nil && reply.From.Id == b.Id {
+		return true
+	}
+
+	return hasBotMention(b, msg.Entities, msg.ParseEntity) ||
+		hasBotMention(b, msg.CaptionEntities, msg.ParseCaptionEntity)
+}
+
+// hasBotMention returns true if one of the entities mentions the bot.
+func hasBotMention(b *gotgbot.Bot, entities []gotgbot.MessageEntity,
+	parse func(gotgbot.MessageEntity) gotgbot.ParsedMessageEntity) bool {
+	for _, entity := range entities {
+		switch entity.Type {
+		case "text_mention":
+			if entity.User != nil && entity.User.Id == b.Id {
+				return true
+			}
+		case "mention":
+			if b.Username != "" &&
+				strings.EqualFold(strings.TrimPrefix(parse(entity).Text, "@"), b.Username) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isJoinLeaveMessage returns true if the message is a service message
+// about new members joining (or a member leaving) the chat.
+func isJoinLeaveMessage(msg *gotgbot.Message) bool {
+	return len(msg.NewChatMembers) != 0 || msg.LeftChatMember != nil
+}
+
+// getJoins returns the id of the group and the users who have joined it
+// in the update, either from a chat member update or a join message.
+func getJoins(ctx *ext.Context) (int64, []int64) {
+	if u := ctx.ChatMember; u != nil {
+		if (u.Chat.Type != "group" && u.Chat.Type != "supergroup") ||
+			!isJoinStatus(u.NewChatMember.GetStatus()) ||
+			isJoinStatus(u.OldChatMember.GetStatus()) {
+			return 0, nil
+		}
+
+		return u.Chat.Id, []int64{u.NewChatMember.GetUser().Id}
+	}
+
+	msg := ctx.Message
+	if msg == nil || len(msg.NewChatMembers) == 0 {
+		return 0, nil
+	}
+
+	users := make([]int64, 0, len(msg.NewChatMembers))
+	for _, member := range msg.NewChatMembers {
+		users = append(users, member.Id)
+	}
+
+	return msg.Chat.Id, users
+}
+
+// countSince returns the amount of the times which are after t.
+func countSince(times map[int64]time.Time, t time.Time) int {
+	count := 0
+	for _, value := range times {
+		if value.After(t) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// isChannelPost returns true if the update is a (maybe edited) post of
+// a channel.
+func isChannelPost(ctx *ext.Context) bool {
+	return ctx.ChannelPost != nil || ctx.EditedChannelPost != nil
+}
+
+// BusinessKey returns the key used by the limiter for the user (or chat)
+// in a conversation of the business account with the given connection
+// id; it can be used for getting the status of the key, or unlimiting it.
+func BusinessKey(connectionID string, id int64) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(connectionID))
+	_, _ = h.Write([]byte(strconv.FormatInt(id, 10)))
+
+	// the sign bit is cleared, so the keys are never mistaken for
+	// the ids of the groups.
+	return int64(h.Sum64() &^ (1 << 63))
+}
+
+// getBusinessConnection returns the business connection id of the update;
+// it returns an empty string if it's not a business message.
+func getBusinessConnection(ctx *ext.Context) string {
+	switch {
+	case ctx.BusinessMessage != nil:
+		return ctx.BusinessMessage.BusinessConnectionId
+	case ctx.EditedBusinessMessage != nil:
+		return ctx.EditedBusinessMessage.BusinessConnectionId
+	}
+
+	return ""
+}
+
+// answerPaymentQuery will reject the payment query of the update with
+// the given error message.
+func answerPaymentQuery(b *gotgbot.Bot, ctx *ext.Context, text string) error {
+	var err error
+	switch {
+	case ctx.PreCheckoutQuery != nil:
+		_, err = ctx.PreCheckoutQuery.Answer(b, false, &gotgbot.AnswerPreCheckoutQueryOpts{
+			ErrorMessage: text,
+		})
+	case ctx.ShippingQuery != nil:
+		_, err = ctx.ShippingQuery.Answer(b, false, &gotgbot.AnswerShippingQueryOpts{
+			ErrorMessage: text,
+		})
+	}
+
+	return err
+}
+
+// CommandKey returns the key used by the limiter for the commands of the
+// user (or chat) when the commands have their own budget (see
+// `Limiter.SetCommandProfile`); it can be used for getting the status of
+// the key, or unlimiting it. if `ScopeByBot` is true, it should be passed
+// to `BotKey` as the id.
+func CommandKey(id int64) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("command:"))
+	_, _ = h.Write([]byte(strconv.FormatInt(id, 10)))
+
+	// the sign bit is cleared, so the keys are never mistaken for
+	// the ids of the groups.
+	return int64(h.Sum64() &^ (1 << 63))
+}
+
+// isCommandMessage returns true if the message starts with a bot command
+// entity.
+func isCommandMessage(msg *gotgbot.Message) bool {
+	return msg != nil && len(msg.Entities) != 0 &&
+		msg.Entities[0].Type == "bot_command" && msg.Entities[0].Offset == 0
+}
+
+// DailyStats merges the hourly statistics returned by `Limiter.StatsRange`
+// into the statistics of each day (in UTC), sorted by their start time.
+func DailyStats(buckets []StatsBucket) []StatsBucket {
+	var days []StatsBucket
+	index := make(map[int64]int)
+	for _, bucket := range buckets {
+		start := bucket.Start.UTC().Truncate(24 * time.Hour)
+		i, ok := index[start.Unix()]
+		if !ok {
+			i = len(days)
+			index[start.Unix()] = i
+			days = append(days, StatsBucket{
+				ChatID: bucket.ChatID,
+				Start:  start,
+				Period: 24 * time.Hour,
+			})
+		}
+
+		days[i].Messages += bucket.Messages
+		days[i].Blocked += bucket.Blocked
+		days[i].Limited += bucket.Limited
+	}
+
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Start.Before(days[j].Start)
+	})
+
+	return days
+}
+
+// BotKey returns the key used by the limiter for the user (or chat) in
+// the updates of the given bot, when `ScopeByBot` is true; it can be used
+// for getting the status of the key, or unlimiting it.
+func BotKey(botID, id int64) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strconv.FormatInt(botID, 10) + ":"))
+	_, _ = h.Write([]byte(strconv.FormatInt(id, 10)))
+
+	// the sign bit is cleared, so the keys are never mistaken for
+	// the ids of the groups.
+	return int64(h.Sum64() &^ (1 << 63))
+}
+
+// copyMap returns a shallow copy of the map; it returns nil if the map
+// is nil.
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[K]V, len(m))
+	for key, value := range m {
+		c[key] = value
+	}
+
+	return c
+}
+
+// copySlice returns a shallow copy of the slice.
+func copySlice[T any](s []T) []T {
+	if len(s) == 0 {
+		return nil
+	}
+
+	return append([]T(nil), s...)
+}
+
+// getMessageTypeNames returns the sorted names of the message types in
+// the mask; it returns nil if the mask has all of the types.
+func getMessageTypeNames(t MessageType) []string {
+	if t == TypeAll || t == 0 {
+		return nil
+	}
+
+	var names []string
+	for name, current := range messageTypeNames {
+		// only the names of the single types are used.
+		if current&(current-1) == 0 && t&current != 0 {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// getChatScopeNames returns the sorted names of the chat scopes in the
+// mask; it returns nil if the mask has all of the scopes.
+func getChatScopeNames(s ChatScope) []string {
+	if s == ScopeAll || s == 0 {
+		return nil
+	}
+
+	var names []string
+	for name, current := range chatScopeNames {
+		if current != ScopeAll && s&current != 0 {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// newProfileConfig converts the limit profile to its serializable form;
+// it returns nil if the profile is nil.
+func newProfileConfig(p *LimitProfile) *ProfileConfig {
+	if p == nil {
+		return nil
+	}
+
+	return &ProfileConfig{
+		Timeout:        Duration(p.Timeout),
+		PunishmentTime: Duration(p.PunishmentTime),
+		MessageCount:   p.MessageCount,
+		Throttle:       Duration(p.Throttle),
+	}
+}
+
+// newChallengeFileConfig converts the challenge config to its serializable
+// form; it returns nil if the config is nil.
+func newChallengeFileConfig(c *ChallengeConfig) *ChallengeFileConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &ChallengeFileConfig{
+		Text:          c.Text,
+		SuccessText:   c.SuccessText,
+		FailureText:   c.FailureText,
+		Choices:       copySlice(c.Choices),
+		Timeout:       Duration(c.Timeout),
+		DeleteOnSolve: c.DeleteOnSolve,
+	}
+}
+
+// newReplyFileConfig converts the reply config to its serializable form;
+// it returns nil if the config is nil.
+func newReplyFileConfig(c *ReplyConfig) *ReplyFileConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &ReplyFileConfig{
+		Text:        c.Text,
+		WarnText:    c.WarnText,
+		UnlimitText: c.UnlimitText,
+		TimeFormat:  c.TimeFormat,
+		DeleteAfter: Duration(c.DeleteAfter),
+	}
+}
+
+// newDelayFileConfig converts the delay config to its serializable form;
+// it returns nil if the config is nil.
+func newDelayFileConfig(c *DelayConfig) *DelayFileConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &DelayFileConfig{
+		MaxDepth: c.MaxDepth,
+		MaxAge:   Duration(c.MaxAge),
+	}
+}
+
+// newRaidFileConfig converts the raid config to its serializable form;
+// it returns nil if the config is nil.
+func newRaidFileConfig(c *RaidConfig) *RaidFileConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &RaidFileConfig{
+		Joins:    c.Joins,
+		Window:   Duration(c.Window),
+		Lockdown: c.Lockdown,
+	}
+}
+
+// newFlappingFileConfig converts the flapping config to its serializable
+// form; it returns nil if the config is nil.
+func newFlappingFileConfig(c *FlappingConfig) *FlappingFileConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &FlappingFileConfig{
+		Changes:        c.Changes,
+		Window:         Duration(c.Window),
+		IgnoreDuration: Duration(c.IgnoreDuration),
+	}
+}
+
+// newChatFileConfig converts the chat settings to their serializable form.
+func newChatFileConfig(s *ChatSettings) ChatFileConfig {
+	return ChatFileConfig{
+		ChatID:         s.ChatID,
+		Disabled:       s.Disabled,
+		Timeout:        Duration(s.Timeout),
+		PunishmentTime: Duration(s.PunishmentTime),
+		MessageCount:   s.MessageCount,
+		Action:         s.Action,
+		SlowMode:       Duration(s.SlowMode),
+		DeleteSlowed:   s.DeleteSlowed,
+	}
+}
+
+// parseName returns the index of the name in the names; it returns -1 if
+// the name is not found.
+func parseName(names []string, name string) int {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, current := range names {
+		if current == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// normalizeAdaptive returns a copy of the adaptive config with its zero
+// values replaced by the default values.
+func normalizeAdaptive(config *AdaptiveConfig) *AdaptiveConfig {
+	c := *config
+	if c.BaseRate == 0 {
+		c.BaseRate = DefaultAdaptiveBaseRate
+	}
+
+	if c.MinFactor == 0 {
+		c.MinFactor = DefaultAdaptiveMinFactor
+	}
+
+	if c.MaxFactor == 0 {
+		c.MaxFactor = DefaultAdaptiveMaxFactor
+	}
+
+	return &c
+}
+
+// normalizeRisk returns a copy of the risk config with its zero values
+// replaced by the default values.
+func normalizeRisk(config *RiskConfig) *RiskConfig {
+	c := *config
+	if c.NewAccountID == 0 {
+		c.NewAccountID = DefaultRiskNewAccountID
+	}
+
+	return &c
+}
+
+// validateRisk will check the risk config and returns an error if its
+// values are nonsensical; nil config is valid.
+func validateRisk(config *RiskConfig) error {
+	if config != nil && config.NewAccountID < 0 {
+		return fmt.Errorf("%w: %+v", ErrInvalidRisk, *config)
+	}
+
+	return nil
+}
+
+// validateAdaptive will check the adaptive config and returns an error
+// if its values are nonsensical; nil config is valid.
+func validateAdaptive(config *AdaptiveConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	c := normalizeAdaptive(config)
+	if c.BaseRate < 0 || c.MinFactor < 0 || c.MaxFactor < c.MinFactor {
+		return fmt.Errorf("%w: %+v", ErrInvalidAdaptive, *config)
+	}
+
+	return nil
+}
+
+// normalizeDelay returns a copy of the delay config with its zero values
+// replaced by the default values.
+func normalizeDelay(config *DelayConfig) *DelayConfig {
+	c := *config
+	if c.MaxDepth == 0 {
+		c.MaxDepth = DefaultDelayMaxDepth
+	}
+
+	if c.MaxAge == 0 {
+		c.MaxAge = DefaultDelayMaxAge
+	}
+
+	return &c
+}
+
+// normalizeRaid returns a copy of the raid config with its zero values
+// replaced by the default values.
+func normalizeRaid(config *RaidConfig) *RaidConfig {
+	c := *config
+	if c.Joins == 0 {
+		c.Joins = DefaultRaidJoins
+	}
+
+	if c.Window == 0 {
+		c.Window = DefaultRaidWindow
+	}
+
+	return &c
+}
+
+// validateRaid will check the raid config and returns an error if its
+// values are negative; nil config is valid.
+func validateRaid(config *RaidConfig) error {
+	if config != nil && (config.Joins < 0 || config.Window < 0) {
+		return fmt.Errorf("%w: %+v", ErrInvalidRaid, *config)
+	}
+
+	return nil
+}
+
+// normalizeFlapping returns a copy of the flapping config with its zero
+// values replaced by the default values.
+func normalizeFlapping(config *FlappingConfig) *FlappingConfig {
+	c := *config
+	if c.Changes == 0 {
+		c.Changes = DefaultFlappingChanges
+	}
+
+	if c.Window == 0 {
+		c.Window = DefaultFlappingWindow
+	}
+
+	return &c
+}
+
+// validateFlapping will check the flapping config and returns an error
+// if its values are negative; nil config is valid.
+func validateFlapping(config *FlappingConfig) error {
+	if config != nil && (config.Changes < 0 || config.Window < 0 || config.IgnoreDuration < 0) {
+		return fmt.Errorf("%w: %+v", ErrInvalidFlapping, *config)
+	}
+
+	return nil
+}
+
+// validateDelay will check the delay config and returns an error if its
+// values are negative; nil config is valid.
+func validateDelay(config *DelayConfig) error {
+	if config != nil && (config.MaxDepth < 0 || config.MaxAge < 0) {
+		return fmt.Errorf("%w: %+v", ErrInvalidDelay, *config)
+	}
+
+	return nil
+}
+
+// getUpdateType returns the type of the update of the context; it
+// returns an empty update type for the updates which are not checked by
+// the limiter.
+func getUpdateType(ctx *ext.Context) UpdateType {
+	switch {
+	case ctx.CallbackQuery != nil:
+		return UpdateCallback
+	case ctx.InlineQuery != nil:
+		return UpdateInline
+	case ctx.MessageReaction != nil:
+		return UpdateReaction
+	case ctx.MessageReactionCount != nil:
+		return UpdateReactionCount
+	case ctx.ChatBoost != nil || ctx.RemovedChatBoost != nil:
+		return UpdateBoost
+	case ctx.EditedMessage != nil || ctx.EditedChannelPost != nil ||
+		ctx.EditedBusinessMessage != nil:
+		return UpdateEdit
+	case ctx.EffectiveMessage != nil:
+		return UpdateMessage
+	}
+
+	return ""
+}
+
+// ParseSchedule parses the cron-like spec and returns its schedule. the
+// spec is either a standard 5-field cron expression
+// ("minute hour day-of-month month day-of-week", where each field accepts
+// "*", numbers, ranges such as "1-5", lists such as "1,15" and steps such
+// as "*/10"), or one of these descriptors:
+// "@hourly", "@daily" (or "@midnight"), "@weekly", "@monthly" and
+// "@every <duration>" (such as "@every 6h").
+// the cron expressions are evaluated in the location of the time passed
+// to `Schedule.Next`.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, spec)
+		}
+
+		return everySchedule(d), nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, spec)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidSchedule, spec)
+		}
+
+		sets[i] = set
+	}
+
+	// both 0 and 7 are sunday.
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute:     sets[0],
+		hour:       sets[1],
+		day:        sets[2],
+		month:      sets[3],
+		weekday:    sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a field of a cron expression and returns the bit
+// set of its values.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, ok := strings.Cut(part, "/"); ok {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, ErrInvalidSchedule
+			}
+
+			part = rangePart
+		}
+
+		start, end := min, max
+		if part != "*" {
+			first, last, isRange := strings.Cut(part, "-")
+			var err error
+			start, err = strconv.Atoi(first)
+			if err != nil {
+				return 0, ErrInvalidSchedule
+			}
+
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(last)
+				if err != nil {
+					return 0, ErrInvalidSchedule
+				}
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, ErrInvalidSchedule
+		}
+
+		for v := start; v <= end; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+// FormatReport is the default format of the scheduled reports; it
+// returns a plain text summary of the report.
+func FormatReport(r *Report) string {
+	var sb strings.Builder
+	sb.WriteString("Rate limiter report\n")
+	sb.WriteString("Period: " + r.Since.Format(time.RFC822) +
+		" - " + r.Until.Format(time.RFC822) + "\n")
+	sb.WriteString("Messages: " + strconv.FormatInt(r.Messages, 10) + "\n")
+	sb.WriteString("Blocked: " + strconv.FormatInt(r.Blocked, 10) + "\n")
+	sb.WriteString("Limits: " + strconv.Itoa(r.Limits) +
+		" (" + strconv.Itoa(r.Offenders) + " offenders)\n")
+
+	if len(r.TopOffenders) != 0 {
+		sb.WriteString("Top offenders:\n")
+		for i, o := range r.TopOffenders {
+			sb.WriteString(strconv.Itoa(i+1) + ". " + strconv.FormatInt(o.Key, 10) +
+				": " + strconv.Itoa(o.Limits) + " limits\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// FormatAlert is the default format of the repeat-offender alerts; it
+// returns a plain text summary of the recent incidents of the offender.
+func FormatAlert(a *Alert) string {
+	var sb strings.Builder
+	sb.WriteString("Repeat offender: " + a.Name + " (" + strconv.FormatInt(a.UserID, 10) + ")\n")
+	sb.WriteString("Incidents: " + strconv.Itoa(len(a.Incidents)) + "\n")
+	for _, incident := range a.Incidents {
+		sb.WriteString("- " + incident.Time.Format(time.RFC822) + " in " +
+			strconv.FormatInt(incident.ChatID, 10) + ": " +
+			strconv.Itoa(incident.Count) + " messages\n")
+	}
+
+	return sb.String()
+}
@@ -6,13 +6,32 @@
 package ratelimiter
 
 import (
-	"sync"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/ALiwoto/ratelimiter/outbound"
+	"github.com/ALiwoto/ratelimiter/storage"
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters"
+	"gopkg.in/yaml.v3"
 )
 
 //---------------------------------------------------------
@@ -21,71 +40,348 @@ import (
 // When the limiter is started (enabled), it will check for
 // check for incoming messages; if they are considered as flood,
 // the limiter won't let the handler functions to be called.
-func (l *Limiter) Start() {
-	if l.isEnabled {
-		return
+// The limiter won't be started if it's not attached to any dispatcher
+// (see `AttachTo`, `AttachProcessor` and `Standalone`), if it's already
+// started, if its configuration is not valid (see `Validate`), or if it
+// can't subscribe to its event bus; the error describes which one.
+func (l *Limiter) Start() error {
+	l.stateMutex.Lock()
+	defer l.stateMutex.Unlock()
+
+	if l.isEnabled.Load() {
+		return ErrAlreadyStarted
+	}
+
+	l.loadFlags()
+	if len(l.dispatchers.load()) == 0 && !l.getFlags().Standalone {
+		return ErrNoDispatcher
+	}
+
+	if err := l.Validate(); err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	if l.joinedUsers == nil {
+		l.joinedUsers = make(map[roleKey]time.Time)
+	}
+
+	if l.challenges == nil {
+		l.challenges = make(map[string]*pendingChallenge)
+	}
+	l.mutex.Unlock()
+
+	store := l.GetStorage()
+	if store != nil {
+		// the limiter can work without the persisted settings, so
+		// the error is not fatal here.
+		_ = l.LoadChatSettings()
+		_ = l.LoadCustomIgnores()
+		_ = l.LoadScheduledActions()
+		_ = l.LoadStatuses()
+	}
+
+	bus := l.GetEventBus()
+	if bus != nil && l.unsubscribe == nil {
+		unsubscribe, err := bus.Subscribe(l.applySyncEvent)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSubscribeFailed, err)
+		}
+
+		l.unsubscribe = unsubscribe
+	}
+
+	if bus != nil {
+		l.startPublisher(bus)
+	}
+
+	l.isEnabled.Store(true)
+	l.isStopped.Store(false)
+
+	l.reportMutex.Lock()
+	l.startReports()
+	l.reportMutex.Unlock()
+
+	if config := l.GetFederation(); config != nil {
+		l.federationSyncMutex.Lock()
+		l.stopFederationSync()
+		l.startFederationSync(config)
+		l.federationSyncMutex.Unlock()
 	}
 
-	if l.mutex == nil {
-		l.mutex = new(sync.RWMutex)
+	l.checkerStop = make(chan struct{})
+	l.checkerDone = make(chan struct{})
+	go l.checker(l.checkerStop, l.checkerDone)
+	return nil
+}
+
+// StartContext will start the limiter just like `Start`, and will stop
+// it as soon as the given context is done.
+func (l *Limiter) StartContext(ctx context.Context) error {
+	if err := l.Start(); err != nil {
+		return err
 	}
 
-	if l.userMap == nil {
-		l.userMap = make(map[int64]*UserStatus)
+	l.stateMutex.Lock()
+	stop := l.checkerStop
+	l.stateMutex.Unlock()
+	if stop == nil {
+		// the limiter has been stopped meanwhile.
+		return nil
 	}
 
-	l.isEnabled = true
-	l.isStopped = false
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.Stop()
+		case <-stop:
+		}
+	}()
 
-	go l.checker()
+	return nil
 }
 
 // Stop method will make this limiter stop checking the incoming
 // messages and will set its variables to nil.
 // the main resources used by this limiter will be freed,
-// such as map and mutex.
+// such as the maps of the joined users and the challenges.
 // but the configuration variables such as message time out will
 // remain the same and won't be set to 0.
+// NOTICE: the errors of flushing the data to the storage are ignored;
+// use `Shutdown` if they should be checked (such as with `StopRetain`).
 func (l *Limiter) Stop() {
-	if l.isStopped {
-		return
+	_ = l.Shutdown()
+}
+
+// Shutdown will stop the limiter just like `Stop`, and returns the error
+// of flushing the statuses (with `StopRetain`) or the statistics to the
+// storage, if any; the limiter is stopped even if flushing fails, so the
+// state which couldn't be flushed is only kept in the memory.
+func (l *Limiter) Shutdown() error {
+	l.stateMutex.Lock()
+	defer l.stateMutex.Unlock()
+
+	if l.isStopped.Load() {
+		return nil
 	}
 
-	l.isEnabled = false
-	l.isStopped = true
+	l.isEnabled.Store(false)
+	l.isStopped.Store(true)
 
-	// make sure that mutex is not nil.
-	if l.mutex != nil {
-		// let another goroutines let go of the mutex;
-		// if you set the userMap value to nil out of nowhere
-		// it MAY cause some troubles.
-		l.mutex.Lock()
-		l.userMap = nil
-		l.mutex.Unlock()
+	if l.checkerStop != nil {
+		// wait for the checker goroutine, so it won't use the resources
+		// which are going to be freed.
+		close(l.checkerStop)
+		<-l.checkerDone
+		l.checkerStop = nil
+		l.checkerDone = nil
+	}
+
+	var err error
+	retain := l.getFlags().StopPolicy == StopRetain
+	if retain {
+		// the statuses are kept in the memory as well, so the storage is
+		// only needed if the process is restarted.
+		err = l.FlushStatuses()
+	}
+
+	// the hourly statistics are kept in the storage regardless of the
+	// stop policy, as they are only useful over longer periods.
+	if statsErr := l.FlushStats(); err == nil {
+		err = statsErr
+	}
+
+	if l.unsubscribe != nil {
+		l.unsubscribe()
+		l.unsubscribe = nil
+	}
+
+	l.stopPublisher()
+	l.stopWebhooks()
+	l.stopReports()
+
+	l.federationSyncMutex.Lock()
+	l.stopFederationSync()
+	l.federationSyncMutex.Unlock()
+
+	l.clearDelayed()
+	l.clearScheduled()
+	l.clearPendingTriggers()
+
+	// the mutex itself is never set to nil, as the handlers which are
+	// still running may use it after the limiter is stopped.
+	l.mutex.Lock()
+	if !retain {
+		l.core.Clear()
+	}
+	l.joinedUsers = nil
+	l.challenges = nil
+	l.mutex.Unlock()
+	return err
+}
+
+// Validate will check the configuration of this limiter and will return
+// an error describing the first nonsensical value it finds, such as
+// zero max message count, or a max cache duration below a second.
+func (l *Limiter) Validate() error {
+	p := l.core.GetProfile()
+	err := validateValues(p.Timeout, p.PunishmentTime, l.GetMaxCacheDuration(), p.MessageCount, l.getFlags().IsStrict)
+	if err != nil {
+		return err
+	}
+
+	if p.Throttle < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidThrottle, p.Throttle)
+	}
+
+	if d := l.GetMaxPunishment(); d < 0 {
+		return fmt.Errorf("%w: max punishment %v", ErrInvalidPunishment, d)
+	}
+
+	if d := time.Duration(l.checkerInterval.Load()); d != 0 && d < time.Second {
+		return fmt.Errorf("%w: %v", ErrInvalidInterval, d)
+	}
+
+	if t := l.GetWarnThreshold(); t < 0 || t > 1 {
+		return fmt.Errorf("%w: %v", ErrInvalidWarning, t)
+	}
+
+	if p := l.GetProbationProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("probation profile: %w", err)
+		}
+	}
+
+	if p := l.GetChannelSenderProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("channel sender profile: %w", err)
+		}
+	}
+
+	if p := l.GetChannelPostProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("channel post profile: %w", err)
+		}
+	}
+
+	if p := l.GetCommentProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("comment profile: %w", err)
+		}
+	}
+
+	if p := l.GetCommandProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("command profile: %w", err)
+		}
+	}
+
+	if err = l.GetJoinFloodProfile().Validate(); err != nil {
+		return fmt.Errorf("join flood profile: %w", err)
+	}
+
+	if err = l.GetPaymentProfile().Validate(); err != nil {
+		return fmt.Errorf("payment profile: %w", err)
+	}
+
+	if p := l.GetGlobalProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("global profile: %w", err)
+		}
+	}
+
+	if p := l.GetMentionProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("mention profile: %w", err)
+		}
+	}
+
+	if p := l.GetVoiceProfile(); p != nil {
+		if err = p.Validate(); err != nil {
+			return fmt.Errorf("voice profile: %w", err)
+		}
+	}
+
+	if err = validateAdaptive(l.GetAdaptive()); err != nil {
+		return err
+	}
+
+	if err = validateRisk(l.GetRisk()); err != nil {
+		return err
+	}
+
+	if err = validateDelay(l.GetDelay()); err != nil {
+		return err
+	}
+
+	if err = validateFederation(l.GetFederation()); err != nil {
+		return err
+	}
+
+	if err = validateRaid(l.GetRaid()); err != nil {
+		return err
+	}
+
+	if err = validateFlapping(l.GetFlapping()); err != nil {
+		return err
+	}
 
-		l.mutex = nil
+	l.tierMutex.RLock()
+	for tier, profile := range l.tierProfiles {
+		if err = profile.Validate(); err != nil {
+			l.tierMutex.RUnlock()
+			return fmt.Errorf("profile of tier %d: %w", tier, err)
+		}
+	}
+	l.tierMutex.RUnlock()
+
+	l.updateMutex.RLock()
+	for updateType, profile := range l.updateProfiles {
+		if err = profile.Validate(); err != nil {
+			l.updateMutex.RUnlock()
+			return fmt.Errorf("profile of update type %s: %w", updateType, err)
+		}
 	}
+	l.updateMutex.RUnlock()
+
+	l.roleMutex.RLock()
+	defer l.roleMutex.RUnlock()
+	for role, profile := range l.roleProfiles {
+		if err = profile.Validate(); err != nil {
+			return fmt.Errorf("profile of role %s: %w", role, err)
+		}
+	}
+
+	return nil
 }
 
 // IsStopped returns true if this limiter is already stopped
 // and doesn't check for incoming messages.
 func (l *Limiter) IsStopped() bool {
-	return l.isStopped
+	return l.isStopped.Load()
 }
 
 // IsEnabled returns true if and only if this limiter is enabled
 // and is checking the incoming messages for floodwait.
 // for enabling the limiter, you need to use `Start` method.
 func (l *Limiter) IsEnabled() bool {
-	return l.isEnabled
+	return l.isEnabled.Load()
 }
 
 // SetTriggerFuncs will set the trigger functions of this limiter.
 // The trigger functions will be triggered when the limiter
 // limits a user. The information passed by it will be the
 // information related to the last message of the user.
+// for punishing the limited users (such as muting them), prefer the
+// enforcement actions (see `AppendActions`), which are reverted by the
+// limiter as well. it replaces the triggers added by `AddTrigger` too.
 func (l *Limiter) SetTriggerFuncs(t ...handlers.Response) {
-	l.triggers = t
+	triggers := make([]*Trigger, 0, len(t))
+	for _, current := range t {
+		triggers = append(triggers, &Trigger{Response: current})
+	}
+
+	l.triggers.store(triggers)
 }
 
 // SetTriggerFunc will set the trigger function of this limiter.
@@ -101,342 +397,8965 @@ func (l *Limiter) SetTriggerFunc(t handlers.Response) {
 // AppendTriggerFuncs will append trigger functions to the trigger
 // functions list of this limiter.
 func (l *Limiter) AppendTriggerFuncs(t ...handlers.Response) {
-	l.triggers = append(l.triggers, t...)
+	for _, current := range t {
+		l.AddTrigger(Trigger{Response: current})
+	}
 }
 
 // AppendTriggerFunc will append a trigger function to the trigger
 // functions list of this limiter.
 func (l *Limiter) AppendTriggerFunc(t handlers.Response) {
-	l.triggers = append(l.triggers, t)
+	l.AddTrigger(Trigger{Response: t})
+}
+
+// AddTrigger will add the trigger to the triggers of this limiter,
+// after the triggers with the same or a higher priority. unlike the
+// plain trigger functions, the trigger can have a filter (such as
+// `TriggerGroupsOnly`), and can stop the triggers after itself.
+func (l *Limiter) AddTrigger(t Trigger) {
+	l.triggers.mutex.Lock()
+	defer l.triggers.mutex.Unlock()
+
+	old := l.triggers.load()
+	i := sort.Search(len(old), func(i int) bool {
+		return old[i].Priority < t.Priority
+	})
+
+	triggers := make([]*Trigger, 0, len(old)+1)
+	triggers = append(triggers, old[:i]...)
+	triggers = append(triggers, &t)
+	triggers = append(triggers, old[i:]...)
+	l.triggers.snapshot.Store(&triggers)
+}
+
+// RemoveTrigger will remove the triggers with the given name from this
+// limiter; it returns false if there is no trigger with the name.
+func (l *Limiter) RemoveTrigger(name string) bool {
+	l.triggers.mutex.Lock()
+	defer l.triggers.mutex.Unlock()
+
+	old := l.triggers.load()
+	triggers := make([]*Trigger, 0, len(old))
+	for _, current := range old {
+		if current.Name != name {
+			triggers = append(triggers, current)
+		}
+	}
+
+	if len(triggers) == len(old) {
+		return false
+	}
+
+	l.triggers.snapshot.Store(&triggers)
+	return true
+}
+
+// GetTriggers returns the triggers of this limiter in the order they
+// are run.
+func (l *Limiter) GetTriggers() []Trigger {
+	old := l.triggers.load()
+	triggers := make([]Trigger, len(old))
+	for i, current := range old {
+		triggers[i] = *current
+	}
+
+	return triggers
+}
+
+// AppendWarnTriggers will append the triggers which are run when a user
+// reaches the warning threshold of its quota (see `SetWarnThreshold`),
+// such as a function which tells them to slow down.
+func (l *Limiter) AppendWarnTriggers(t ...handlers.Response) {
+	l.warnTriggers.append(t...)
+}
+
+// ClearWarnTriggers will remove all of the warning triggers of this
+// limiter.
+func (l *Limiter) ClearWarnTriggers() {
+	l.warnTriggers.store(nil)
+}
+
+// AddException will add an exception filter to this limiter.
+// the message filters are only matched against the messages; use
+// `AddCallbackException`, `AddInlineException` and
+// `AddReactionException` for the other update types.
+func (l *Limiter) AddException(ex filters.Message) {
+	l.exceptions.append(ex)
+}
+
+// AddCallbackException will add an exception filter for the callback
+// queries to this limiter.
+func (l *Limiter) AddCallbackException(ex filters.CallbackQuery) {
+	l.callbackExceptions.append(ex)
+}
+
+// AddInlineException will add an exception filter for the inline
+// queries to this limiter.
+func (l *Limiter) AddInlineException(ex filters.InlineQuery) {
+	l.inlineExceptions.append(ex)
+}
+
+// AddReactionException will add an exception filter for the message
+// reactions to this limiter.
+func (l *Limiter) AddReactionException(ex filters.Reaction) {
+	l.reactionExceptions.append(ex)
+}
+
+// ClearAllExceptions will clear all exception filters of this limiter,
+// of all of the update types.
+// this way, you will be sure that all of incoming updates will be
+// checked for floodwait by this limiter.
+func (l *Limiter) ClearAllExceptions() {
+	l.exceptions.store(nil)
+	l.callbackExceptions.store(nil)
+	l.inlineExceptions.store(nil)
+	l.reactionExceptions.store(nil)
+}
+
+// GetExceptions returns the filters array used by this limiter as
+// its exceptions list.
+func (l *Limiter) GetExceptions() []filters.Message {
+	return copySlice(l.exceptions.load())
+}
+
+// GetCallbackExceptions returns the exception filters of the callback
+// queries.
+func (l *Limiter) GetCallbackExceptions() []filters.CallbackQuery {
+	return copySlice(l.callbackExceptions.load())
+}
+
+// GetInlineExceptions returns the exception filters of the inline
+// queries.
+func (l *Limiter) GetInlineExceptions() []filters.InlineQuery {
+	return copySlice(l.inlineExceptions.load())
+}
+
+// GetReactionExceptions returns the exception filters of the message
+// reactions.
+func (l *Limiter) GetReactionExceptions() []filters.Reaction {
+	return copySlice(l.reactionExceptions.load())
+}
+
+// loadFlags takes a new snapshot of the exported fields of the limiter.
+func (l *Limiter) loadFlags() {
+	l.flagsMutex.Lock()
+	l.flags.Store(l.newFlags())
+	l.flagsMutex.Unlock()
+}
+
+// newFlags returns a new snapshot of the exported fields of the limiter.
+func (l *Limiter) newFlags() *limiterFlags {
+	return &limiterFlags{
+		ScopeByBot:          l.ScopeByBot,
+		IgnoreMediaGroup:    l.IgnoreMediaGroup,
+		CountAlbumsOnce:     l.CountAlbumsOnce,
+		ServicePolicy:       l.ServicePolicy,
+		ConsiderPayments:    l.ConsiderPayments,
+		DeleteVoiceFlood:    l.DeleteVoiceFlood,
+		CountCaptions:       l.CountCaptions,
+		IsStrict:            l.IsStrict,
+		PartialReset:        l.PartialReset,
+		OptIn:               l.OptIn,
+		ConsiderUser:        l.ConsiderUser,
+		ConsiderInline:      l.ConsiderInline,
+		Propagation:         l.Propagation,
+		StopPolicy:          l.StopPolicy,
+		Standalone:          l.Standalone,
+		LimitChannelSenders: l.LimitChannelSenders,
+		ExemptAutoForwards:  l.ExemptAutoForwards,
+	}
+}
+
+// getFlags returns the current snapshot of the exported fields of the
+// limiter; the returned value must not be changed.
+func (l *Limiter) getFlags() *limiterFlags {
+	if f := l.flags.Load(); f != nil {
+		return f
+	}
+
+	l.loadFlags()
+	return l.flags.Load()
+}
+
+// setFlags will publish a new snapshot of the flags changed by the
+// given function; the exported fields are updated as well, so they
+// are kept when the limiter is restarted.
+func (l *Limiter) setFlags(update func(f *limiterFlags)) {
+	l.flagsMutex.Lock()
+	defer l.flagsMutex.Unlock()
+
+	f := l.newFlags()
+	if current := l.flags.Load(); current != nil {
+		*f = *current
+	}
+
+	update(f)
+	l.ScopeByBot = f.ScopeByBot
+	l.IgnoreMediaGroup = f.IgnoreMediaGroup
+	l.CountAlbumsOnce = f.CountAlbumsOnce
+	l.ServicePolicy = f.ServicePolicy
+	l.ConsiderPayments = f.ConsiderPayments
+	l.DeleteVoiceFlood = f.DeleteVoiceFlood
+	l.CountCaptions = f.CountCaptions
+	l.IsStrict = f.IsStrict
+	l.PartialReset = f.PartialReset
+	l.OptIn = f.OptIn
+	l.ConsiderUser = f.ConsiderUser
+	l.ConsiderInline = f.ConsiderInline
+	l.Propagation = f.Propagation
+	l.StopPolicy = f.StopPolicy
+	l.Standalone = f.Standalone
+	l.LimitChannelSenders = f.LimitChannelSenders
+	l.ExemptAutoForwards = f.ExemptAutoForwards
+	l.flags.Store(f)
+}
+
+// IsTextOnly will return true if and only if this limiter is
+// checking for text-only messages.
+func (l *Limiter) IsTextOnly() bool {
+	return l.GetCountedTypes() == TypeText
+}
+
+// SetTextOnly will set the limiter to check for text-only messages.
+// pass true to this method to make the limiter check for text-only
+// messages; passing false makes it check all of the messages again.
+// it's a shortcut for `SetCountedTypes(TypeText)`.
+func (l *Limiter) SetTextOnly(t bool) {
+	if t {
+		l.countedTypes.Store(uint32(TypeText))
+	} else {
+		l.countedTypes.Store(uint32(TypeAll))
+	}
+}
+
+// SetCountedTypes will set the kinds of the messages which count toward
+// the quota, for example:
+//
+//	l.SetCountedTypes(TypeText, TypeSticker, TypeAnimation)
+//
+// other kinds of messages are ignored by the limiter and aren't checked
+// at all. calling it without any type makes the limiter count all of
+// the messages.
+func (l *Limiter) SetCountedTypes(types ...MessageType) {
+	var mask MessageType
+	for _, t := range types {
+		mask |= t
+	}
+
+	if mask == 0 {
+		mask = TypeAll
+	}
+
+	l.countedTypes.Store(uint32(mask))
+}
+
+// SetChatScopes will set the kinds of the chats considered by the
+// limiter, for example:
+//
+//	l.SetChatScopes(ScopeGroups)
+//
+// the updates of the other chats are ignored by the limiter and aren't
+// checked at all. calling it without any scope makes the limiter
+// consider all of the chats.
+func (l *Limiter) SetChatScopes(scopes ...ChatScope) {
+	var mask ChatScope
+	for _, s := range scopes {
+		mask |= s
+	}
+
+	if mask == 0 {
+		mask = ScopeAll
+	}
+
+	l.chatScopes.Store(uint32(mask))
+}
+
+// GetChatScopes returns the mask of the kinds of the chats considered by
+// the limiter.
+func (l *Limiter) GetChatScopes() ChatScope {
+	if scopes := ChatScope(l.chatScopes.Load()); scopes != 0 {
+		return scopes
+	}
+
+	return ScopeAll
+}
+
+// isInScope returns true if the chats of the given type are considered
+// by the limiter; the unknown types are considered as well.
+func (l *Limiter) isInScope(chatType string) bool {
+	s := getChatScope(chatType)
+	return s == 0 || l.GetChatScopes()&s != 0
+}
+
+// GetCountedTypes returns the mask of the kinds of the messages which
+// count toward the quota.
+func (l *Limiter) GetCountedTypes() MessageType {
+	if types := MessageType(l.countedTypes.Load()); types != 0 {
+		return types
+	}
+
+	return TypeAll
+}
+
+// Clone returns a new limiter with the same configuration as this
+// limiter (such as the limits, profiles, exceptions, conditions and
+// triggers), but without any of its runtime state (such as the statuses
+// of the users, custom ignores and pending challenges); so several
+// identically configured limiters can be stamped out for different
+// dispatchers or update types.
+// the new limiter is not attached to any dispatcher (see `AttachTo`),
+// it doesn't share the event bus of this limiter, and it has to be
+// started separately.
+func (l *Limiter) Clone() *Limiter {
+	f := l.getFlags()
+	c := &Limiter{
+		core:             core.NewLimiter(l.core.GetProfile()),
+		handlerGroups:    copySlice(l.handlerGroups),
+		ScopeByBot:       f.ScopeByBot,
+		Standalone:       f.Standalone,
+		IgnoreMediaGroup: f.IgnoreMediaGroup,
+		CountAlbumsOnce:  f.CountAlbumsOnce,
+		ServicePolicy:    f.ServicePolicy,
+		ConsiderPayments: f.ConsiderPayments,
+		CountCaptions:    f.CountCaptions,
+		DeleteVoiceFlood: f.DeleteVoiceFlood,
+		IsStrict:         f.IsStrict,
+		PartialReset:     f.PartialReset,
+		OptIn:            f.OptIn,
+		ConsiderUser:     f.ConsiderUser,
+		ConsiderInline:   f.ConsiderInline,
+		Propagation:      f.Propagation,
+		StopPolicy:       f.StopPolicy,
+
+		LimitChannelSenders: f.LimitChannelSenders,
+		ExemptAutoForwards:  f.ExemptAutoForwards,
+	}
+
+	c.filter = c.limiterFilter
+	c.handler = c.limiterHandler
+	c.instanceID = newInstanceID()
+	c.storage.Store(l.storage.Load())
+	c.sendQueue.Store(l.sendQueue.Load())
+	c.triggers.store(l.triggers.load())
+	c.countedTypes.Store(l.countedTypes.Load())
+	c.chatScopes.Store(l.chatScopes.Load())
+	c.joinFloodTriggers.store(l.joinFloodTriggers.load())
+	c.paymentTriggers.store(l.paymentTriggers.load())
+	c.channelProfile.Store(l.channelProfile.Load())
+	c.channelPostProfile.Store(l.channelPostProfile.Load())
+	c.commentProfile.Store(l.commentProfile.Load())
+	c.commandProfile.Store(l.commandProfile.Load())
+	c.probation.Store(l.probation.Load())
+	c.probationDuration.Store(l.probationDuration.Load())
+	c.challenge.Store(l.challenge.Load())
+	c.reply.Store(l.reply.Load())
+	c.notifiers.store(l.notifiers.load())
+	c.warnTriggers.store(l.warnTriggers.load())
+	c.exceptions.store(l.exceptions.load())
+	c.callbackExceptions.store(l.callbackExceptions.load())
+	c.inlineExceptions.store(l.inlineExceptions.load())
+	c.reactionExceptions.store(l.reactionExceptions.load())
+	c.conditions.store(l.conditions.load())
+	c.callbackConditions.store(l.callbackConditions.load())
+	c.inlineConditions.store(l.inlineConditions.load())
+	c.contextConditions.store(l.contextConditions.load())
+	c.exceptionIDs.set.snapshot.Store(l.exceptionIDs.set.snapshot.Load())
+	c.scopedExceptions.snapshot.Store(l.scopedExceptions.snapshot.Load())
+	c.topicExceptions.snapshot.Store(l.topicExceptions.snapshot.Load())
+	c.blockedSources.set.snapshot.Store(l.blockedSources.set.snapshot.Load())
+	c.blockedActions.store(l.blockedActions.load())
+	c.SetReputationProvider(l.GetReputationProvider())
+	c.reputationActions.store(l.reputationActions.load())
+	c.exceptionRules.store(l.exceptionRules.load())
+	c.enrolled.set.snapshot.Store(l.enrolled.set.snapshot.Load())
+	c.allowedCommands.snapshot.Store(l.allowedCommands.snapshot.Load())
+	c.auditSinks.store(l.auditSinks.load())
+	c.templates.Store(l.templates.Load())
+	c.maxTimeout.Store(l.maxTimeout.Load())
+	c.checkerInterval.Store(l.checkerInterval.Load())
+	c.maxPunishment.Store(l.maxPunishment.Load())
+	c.warnThreshold.Store(l.warnThreshold.Load())
+	c.initHandlers(l.IsAllowingChannels(), l.IsAllowingEdits(), l.IsAllowingBusiness())
+	c.SetJoinFloodProfile(l.GetJoinFloodProfile())
+	c.SetPaymentProfile(l.GetPaymentProfile())
+	c.SetGlobalProfile(l.GetGlobalProfile())
+	c.SetMentionProfile(l.GetMentionProfile())
+	c.SetVoiceProfile(l.GetVoiceProfile())
+	c.SetAdaptive(l.GetAdaptive())
+	c.SetRisk(l.GetRisk())
+	c.SetStatsRetention(l.GetStatsRetention())
+	c.SetStatsHistory(l.GetStatsHistory())
+	c.SetMaxEntries(l.GetMaxEntries())
+	c.SetCallbackDebounce(l.GetCallbackDebounce())
+	c.SetDelay(l.GetDelay())
+	c.SetRaid(l.GetRaid())
+	c.SetFlapping(l.GetFlapping())
+
+	l.tierMutex.RLock()
+	c.tiers = copyMap(l.tiers)
+	c.tierProfiles = copyMap(l.tierProfiles)
+	c.tierResolver = l.tierResolver
+	l.tierMutex.RUnlock()
+
+	l.hookMutex.RLock()
+	c.costFunc = l.costFunc
+	c.decisionHook = l.decisionHook
+	c.unlimitCallbacks = copySlice(l.unlimitCallbacks)
+	c.expireCallbacks = copySlice(l.expireCallbacks)
+	c.customIgnoreCallbacks = copySlice(l.customIgnoreCallbacks)
+	c.scoreFunc = l.scoreFunc
+	c.scoreThreshold = l.scoreThreshold
+	c.scoreCost = l.scoreCost
+	l.hookMutex.RUnlock()
+
+	l.bundleMutex.RLock()
+	c.bundles = copyMap(l.bundles)
+	l.bundleMutex.RUnlock()
+
+	c.punishment = l.GetPunishment()
+	c.alert = l.GetAlertConfig()
+	_ = c.SetLockdown(l.GetLockdownConfig())
+	c.lockdownTriggers.store(l.lockdownTriggers.load())
+	c.raidTriggers.store(l.raidTriggers.load())
+	c.actions.store(l.actions.load())
+	c.SetEscalationPolicy(l.GetEscalationPolicy())
+	_ = c.SetFederation(l.GetFederation())
+	l.punishMutex.Lock()
+	c.punisher = l.punisher
+	l.punishMutex.Unlock()
+
+	l.updateMutex.RLock()
+	c.updateProfiles = copyMap(l.updateProfiles)
+	c.updateTriggers = copyMap(l.updateTriggers)
+	l.updateMutex.RUnlock()
+
+	l.roleMutex.RLock()
+	c.roleProfiles = copyMap(l.roleProfiles)
+	c.roleCacheTime = l.roleCacheTime
+	l.roleMutex.RUnlock()
+
+	l.settingsMutex.RLock()
+	c.chatSettings = copyMap(l.chatSettings)
+	l.settingsMutex.RUnlock()
+
+	return c
+}
+
+// initHandlers will create the handlers of this limiter.
+func (l *Limiter) initHandlers(channel, edits, business bool) {
+	h := handlers.NewMessage(l.filter, l.handler)
+	ch := handlers.NewCallback(l.challengeFilter, l.challengeHandler)
+	ah := handlers.NewCallback(l.alertFilter, l.alertHandler)
+	cb := handlers.NewCallback(l.callbackFilter, l.handler)
+	cm := handlers.NewChatMember(l.chatMemberFilter, l.chatMemberHandler)
+	iq := handlers.NewInlineQuery(l.inlineFilter, l.handler)
+	mr := handlers.NewReaction(l.reactionFilter, l.handler)
+	mrc := &updateHandler{
+		name:     "ratelimiter_reaction_count",
+		filter:   l.reactionCountFilter,
+		response: l.handler,
+	}
+	bh := &updateHandler{
+		name:     "ratelimiter_boost",
+		filter:   l.boostFilter,
+		response: l.handler,
+	}
+	pcq := handlers.NewPreCheckoutQuery(l.preCheckoutFilter, l.paymentHandler)
+	sq := handlers.NewShippingQuery(l.shippingFilter, l.paymentHandler)
+
+	l.msgHandler = &h
+	l.msgHandler.AllowChannel = channel
+	l.msgHandler.AllowEdited = edits
+	l.msgHandler.AllowBusiness = business
+
+	// challenge handler should be added before the callback handler,
+	// as limited users have to be able to answer their challenge.
+	all := []ext.Handler{h, ch, ah, cb, iq, mr, mrc, bh, cm, pcq, sq}
+	l.allHandlers = make([]ext.Handler, len(all))
+	for i, current := range all {
+		l.allHandlers[i] = &namedHandler{
+			Handler: current,
+			name:    fmt.Sprintf("ratelimiter_%p_%d", l, i),
+		}
+	}
+}
+
+// AttachTo will add the handlers of this limiter to the dispatcher, so
+// a single limiter can be used for several bots; set `ScopeByBot` to true
+// if the state of each bot has to be isolated from the others.
+// the handlers are added to the handler groups of the limiter's config.
+// it's safe to attach the limiter while the dispatcher is running (for
+// example by a command of the owner); attaching it to a dispatcher it's
+// already attached to does nothing. see `Detach` for the reverse.
+func (l *Limiter) AttachTo(dispatcher *ext.Dispatcher) {
+	l.attachMutex.Lock()
+	defer l.attachMutex.Unlock()
+
+	if l.isAttached(dispatcher) {
+		return
+	}
+
+	for _, currentHandler := range l.allHandlers {
+		bound := &boundHandler{Handler: currentHandler, dispatcher: dispatcher}
+		for _, current := range l.getHandlerGroups() {
+			dispatcher.AddHandlerToGroup(bound, current)
+		}
+	}
+
+	l.dispatchers.append(dispatcher)
+}
+
+// AttachProcessor will wrap the processor of the dispatcher with a
+// `LimiterProcessor`, so the limiter gates every update before any
+// handler group of the dispatcher runs; unlike `AttachTo`, the limiter
+// doesn't race with the other handlers over the order of the groups.
+// attaching the limiter to a dispatcher it's already attached to (by
+// either of the methods) does nothing.
+// NOTICE: the processor of a running dispatcher can't be replaced
+// safely, so the processor should be attached before the dispatcher
+// starts; after that, it can be detached and attached again freely.
+func (l *Limiter) AttachProcessor(dispatcher *ext.Dispatcher) {
+	l.attachMutex.Lock()
+	defer l.attachMutex.Unlock()
+
+	if l.isAttached(dispatcher) {
+		return
+	}
+
+	if p := l.findProcessor(dispatcher); p != nil {
+		// the processor is kept in the dispatcher after detaching.
+		p.detached.Store(false)
+	} else {
+		dispatcher.Processor = NewLimiterProcessor(l, dispatcher.Processor)
+	}
+
+	l.dispatchers.append(dispatcher)
+}
+
+// Detach will remove the handlers of this limiter from the dispatcher
+// (or disables its processor, see `AttachProcessor`), so the updates of
+// the dispatcher are not checked by the limiter anymore; it's safe to
+// call while the dispatcher is running. it returns false if the limiter
+// is not attached to the dispatcher.
+// NOTICE: the limiter keeps running (and keeps the statuses of the
+// users) even if it's detached from all of its dispatchers.
+func (l *Limiter) Detach(dispatcher *ext.Dispatcher) bool {
+	l.attachMutex.Lock()
+	defer l.attachMutex.Unlock()
+
+	if !l.isAttached(dispatcher) {
+		return false
+	}
+
+	for _, currentHandler := range l.allHandlers {
+		for _, current := range l.getHandlerGroups() {
+			dispatcher.RemoveHandlerFromGroup(currentHandler.Name(), current)
+		}
+	}
+
+	if p := l.findProcessor(dispatcher); p != nil {
+		p.detached.Store(true)
+	}
+
+	var remained []*ext.Dispatcher
+	for _, current := range l.dispatchers.load() {
+		if current != dispatcher {
+			remained = append(remained, current)
+		}
+	}
+	l.dispatchers.store(remained)
+
+	return true
+}
+
+// isAttached returns true if the limiter is attached to the dispatcher.
+func (l *Limiter) isAttached(dispatcher *ext.Dispatcher) bool {
+	for _, current := range l.dispatchers.load() {
+		if current == dispatcher {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findProcessor returns the processor of this limiter in the chain of
+// the processors of the dispatcher; it returns nil if there is none.
+func (l *Limiter) findProcessor(dispatcher *ext.Dispatcher) *LimiterProcessor {
+	p, ok := dispatcher.Processor.(*LimiterProcessor)
+	for ok {
+		if p.Limiter == l {
+			return p
+		}
+
+		p, ok = p.Next.(*LimiterProcessor)
+	}
+
+	return nil
+}
+
+// getHandlerGroups returns the handler groups the handlers of this
+// limiter are added to.
+func (l *Limiter) getHandlerGroups() []int {
+	if len(l.handlerGroups) != 0 {
+		return l.handlerGroups
+	}
+
+	return []int{0}
+}
+
+// GetDispatchers returns the dispatchers this limiter is attached to.
+func (l *Limiter) GetDispatchers() []*ext.Dispatcher {
+	return copySlice(l.dispatchers.load())
+}
+
+// IsAllowingChannels will return true if and only if this limiter
+// is checking for messages from channels.
+func (l *Limiter) IsAllowingChannels() bool {
+	if l.msgHandler == nil {
+		return false
+	}
+	return l.msgHandler.AllowChannel
+}
+
+// IsAllowingEdits will return true if and only if this limiter
+// is checking for "edited message" update from telegram.
+func (l *Limiter) IsAllowingEdits() bool {
+	if l.msgHandler == nil {
+		return false
+	}
+	return l.msgHandler.AllowEdited
+}
+
+// IsAllowingBusiness will return true if and only if this limiter
+// is checking for the messages of the business accounts connected
+// to the bot.
+func (l *Limiter) IsAllowingBusiness() bool {
+	if l.msgHandler == nil {
+		return false
+	}
+	return l.msgHandler.AllowBusiness
+}
+
+// AddExceptionID will add a group/user/channel ID to the exception
+// list of the limiter.
+func (l *Limiter) AddExceptionID(id ...int64) {
+	l.exceptionIDs.Add(id...)
+	l.auditIDs(AuditExempt, id, 0)
+}
+
+// RemoveExceptionID will remove the group/user/channel IDs from the
+// exception list of the limiter.
+func (l *Limiter) RemoveExceptionID(id ...int64) {
+	l.exceptionIDs.Remove(id...)
+	l.auditIDs(AuditUnexempt, id, 0)
+}
+
+// AddScopedException will exempt the user only in the given chat, so a
+// user trusted in one community doesn't bypass the limits of the other
+// chats the bot is in.
+func (l *Limiter) AddScopedException(chatID, userID int64) {
+	l.scopedExceptions.add(ScopedException{ChatID: chatID, UserID: userID})
+	l.auditScoped(AuditExempt, ScopedException{ChatID: chatID, UserID: userID})
+}
+
+// RemoveScopedException will remove the scoped exception of the user in
+// the given chat.
+func (l *Limiter) RemoveScopedException(chatID, userID int64) {
+	l.scopedExceptions.remove(ScopedException{ChatID: chatID, UserID: userID})
+	l.auditScoped(AuditUnexempt, ScopedException{ChatID: chatID, UserID: userID})
+}
+
+// IsScopedException returns true if the user is exempted in the given
+// chat by a scoped exception.
+func (l *Limiter) IsScopedException(chatID, userID int64) bool {
+	return l.scopedExceptions.contains(ScopedException{ChatID: chatID, UserID: userID})
+}
+
+// ListScopedExceptions returns all of the scoped exceptions of the
+// limiter, sorted by their chat id and user id.
+func (l *Limiter) ListScopedExceptions() []ScopedException {
+	list := l.scopedExceptions.keys()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ChatID != list[j].ChatID {
+			return list[i].ChatID < list[j].ChatID
+		}
+		return list[i].UserID < list[j].UserID
+	})
+	return list
+}
+
+// ClearScopedExceptions will remove all of the scoped exceptions of the
+// limiter.
+func (l *Limiter) ClearScopedExceptions() {
+	removed := l.scopedExceptions.keys()
+	l.scopedExceptions.replace(nil)
+	l.auditScoped(AuditUnexempt, removed...)
+}
+
+// AddTopicException will exempt a whole topic of a forum supergroup, so
+// for example a designated off-topic thread is never limited while the
+// rest of the forum is still protected. the messages of the topic (and
+// the buttons pressed under them) are not checked at all.
+func (l *Limiter) AddTopicException(chatID, threadID int64) {
+	l.topicExceptions.add(TopicException{ChatID: chatID, ThreadID: threadID})
+}
+
+// RemoveTopicException will remove the exception of the topic.
+func (l *Limiter) RemoveTopicException(chatID, threadID int64) {
+	l.topicExceptions.remove(TopicException{ChatID: chatID, ThreadID: threadID})
+}
+
+// IsTopicException returns true if the topic of the forum is exempted.
+func (l *Limiter) IsTopicException(chatID, threadID int64) bool {
+	return l.topicExceptions.contains(TopicException{ChatID: chatID, ThreadID: threadID})
+}
+
+// ListTopicExceptions returns all of the topic exceptions of the limiter,
+// sorted by their chat id and thread id.
+func (l *Limiter) ListTopicExceptions() []TopicException {
+	list := l.topicExceptions.keys()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ChatID != list[j].ChatID {
+			return list[i].ChatID < list[j].ChatID
+		}
+		return list[i].ThreadID < list[j].ThreadID
+	})
+	return list
+}
+
+// ClearTopicExceptions will remove all of the topic exceptions of the
+// limiter.
+func (l *Limiter) ClearTopicExceptions() {
+	l.topicExceptions.replace(nil)
+}
+
+// AddBlockedSource will add the channels (or users) to the forward-source
+// blocklist of the limiter; the messages forwarded from them are treated
+// as over-limit, so their senders are limited right away and punished
+// through the usual punishment pipeline (see `AppendBlockedSourceActions`
+// for the actions taken against the forwarded messages themselves).
+// NOTICE: the exceptions are not limited for forwarding such messages.
+func (l *Limiter) AddBlockedSource(ids ...int64) {
+	l.blockedSources.Add(ids...)
+}
+
+// RemoveBlockedSource will remove the ids from the forward-source
+// blocklist of the limiter.
+func (l *Limiter) RemoveBlockedSource(ids ...int64) {
+	l.blockedSources.Remove(ids...)
+}
+
+// IsBlockedSource returns true if the messages forwarded from the id are
+// blocked by the limiter.
+func (l *Limiter) IsBlockedSource(id int64) bool {
+	return l.blockedSources.Contains(id)
+}
+
+// GetBlockedSources returns the forward-source blocklist of the limiter;
+// the changes made to the set are applied to the limiter immediately.
+func (l *Limiter) GetBlockedSources() *IDSet {
+	return &l.blockedSources
+}
+
+// AppendBlockedSourceActions will append the actions which are taken
+// against every message forwarded from a blocked source, such as
+// `&DeleteAction{}`; they are applied even if the sender is already
+// limited.
+func (l *Limiter) AppendBlockedSourceActions(actions ...Action) {
+	l.blockedActions.append(actions...)
+}
+
+// ClearBlockedSourceActions will remove all of the actions taken against
+// the messages forwarded from the blocked sources.
+func (l *Limiter) ClearBlockedSourceActions() {
+	l.blockedActions.store(nil)
+}
+
+// isBlockedForward returns true if the message is forwarded from one of
+// the blocked sources; msg can be nil.
+func (l *Limiter) isBlockedForward(msg *gotgbot.Message) bool {
+	if msg == nil || msg.ForwardOrigin == nil || l.blockedSources.Len() == 0 {
+		return false
+	}
+
+	source := getForwardSource(msg.ForwardOrigin)
+	return source != 0 && l.blockedSources.Contains(source)
+}
+
+// SetReputationProvider will set the external source of the reputations
+// of the users, which is consulted before limiting them; the updates of
+// the known spammers are treated as over-limit, so their senders are
+// limited right away and punished through the usual punishment pipeline
+// (see `AppendReputationActions` for the actions taken against the
+// updates themselves). the reputations are cached for `cacheTime`; zero
+// means `DefaultReputationCacheTime`. pass nil to disable the lookups.
+// NOTICE: the lookups are done in the check path of the updates, so the
+// provider should have a reasonable timeout; the failed lookups are
+// cached as well, with an unknown reputation.
+func (l *Limiter) SetReputationProvider(provider ReputationProvider, cacheTime time.Duration) {
+	if cacheTime <= 0 {
+		cacheTime = DefaultReputationCacheTime
+	}
+
+	l.reputationMutex.Lock()
+	l.reputationProvider = provider
+	l.reputationCacheTime = cacheTime
+	l.reputations = nil
+	l.reputationMutex.Unlock()
+}
+
+// GetReputationProvider returns the reputation provider of the limiter
+// and the amount of time the reputations are cached.
+func (l *Limiter) GetReputationProvider() (ReputationProvider, time.Duration) {
+	l.reputationMutex.RLock()
+	defer l.reputationMutex.RUnlock()
+
+	return l.reputationProvider, l.reputationCacheTime
+}
+
+// GetReputation returns the reputation of the user, looking it up from
+// the reputation provider if it's not cached; it returns an unknown
+// reputation if no provider is set.
+func (l *Limiter) GetReputation(userID int64) (Reputation, error) {
+	l.reputationMutex.RLock()
+	provider := l.reputationProvider
+	cached := l.reputations[userID]
+	l.reputationMutex.RUnlock()
+
+	if provider == nil {
+		return Reputation{}, nil
+	}
+
+	if cached != nil && time.Now().Before(cached.expiresAt) {
+		return cached.reputation, nil
+	}
+
+	reputation, err := provider.Lookup(userID)
+	if err != nil {
+		reputation = Reputation{}
+	}
+
+	l.reputationMutex.Lock()
+	if l.reputations == nil {
+		l.reputations = make(map[int64]*cachedReputation)
+	}
+
+	l.reputations[userID] = &cachedReputation{
+		reputation: reputation,
+		expiresAt:  time.Now().Add(l.reputationCacheTime),
+	}
+	l.reputationMutex.Unlock()
+
+	return reputation, err
+}
+
+// ClearReputationCache will remove all of the cached reputations.
+func (l *Limiter) ClearReputationCache() {
+	l.reputationMutex.Lock()
+	l.reputations = nil
+	l.reputationMutex.Unlock()
+}
+
+// AppendReputationActions will append the actions which are taken
+// against every update of the known spammers, such as `&BanAction{}`;
+// they are applied even if the sender is already limited.
+func (l *Limiter) AppendReputationActions(actions ...Action) {
+	l.reputationActions.append(actions...)
+}
+
+// ClearReputationActions will remove all of the actions taken against
+// the updates of the known spammers.
+func (l *Limiter) ClearReputationActions() {
+	l.reputationActions.store(nil)
+}
+
+// isKnownSpammer returns true if the sender of the update is a known
+// spammer according to the reputation provider.
+func (l *Limiter) isKnownSpammer(ctx *ext.Context) bool {
+	if ctx.EffectiveUser == nil || isChannelPost(ctx) ||
+		getSenderChat(ctx.EffectiveMessage) != nil {
+		return false
+	}
+
+	l.reputationMutex.RLock()
+	provider := l.reputationProvider
+	l.reputationMutex.RUnlock()
+	if provider == nil {
+		return false
+	}
+
+	reputation, _ := l.GetReputation(ctx.EffectiveUser.Id)
+	return reputation.Spammer
+}
+
+// pruneReputations will remove the expired reputations from the cache.
+func (l *Limiter) pruneReputations() {
+	now := time.Now()
+	l.reputationMutex.Lock()
+	for userID, cached := range l.reputations {
+		if now.After(cached.expiresAt) {
+			delete(l.reputations, userID)
+		}
+	}
+	l.reputationMutex.Unlock()
+}
+
+// isTopicException returns true if the message is sent in an exempted
+// topic; msg can be nil.
+func (l *Limiter) isTopicException(msg *gotgbot.Message) bool {
+	if msg == nil || !msg.IsTopicMessage || len(l.topicExceptions.load()) == 0 {
+		return false
+	}
+
+	return l.IsTopicException(msg.Chat.Id, msg.MessageThreadId)
+}
+
+// auditScoped will write a manual record of the action for each of the
+// scoped exceptions to the audit sinks.
+func (l *Limiter) auditScoped(action string, exceptions ...ScopedException) {
+	if len(l.auditSinks.load()) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, ex := range exceptions {
+		l.Audit(&AuditRecord{
+			Time:   now,
+			Action: action,
+			Key:    ex.UserID,
+			ChatID: ex.ChatID,
+			UserID: ex.UserID,
+			Actor:  ActorManual,
+		})
+	}
+}
+
+// AddExceptionRule will add the exception rules to the limiter, so the
+// users can be exempted by their username, premium status or language
+// without knowing their ids; it returns an error (and adds none of the
+// rules) if any of the rules is invalid.
+func (l *Limiter) AddExceptionRule(rules ...ExceptionRule) error {
+	normalized := make([]ExceptionRule, len(rules))
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+
+		normalized[i] = rule.normalize()
+	}
+
+	l.exceptionRules.append(normalized...)
+	return nil
+}
+
+// GetExceptionRules returns the exception rules of the limiter.
+func (l *Limiter) GetExceptionRules() []ExceptionRule {
+	return copySlice(l.exceptionRules.load())
+}
+
+// ClearExceptionRules will remove all of the exception rules of the
+// limiter.
+func (l *Limiter) ClearExceptionRules() {
+	l.exceptionRules.store(nil)
+}
+
+// AddAllowedCommands will add the commands (such as "start" or "/help")
+// to the allowed commands of the limiter; the allowed commands never
+// count toward the quota, and they are always passed to the handlers,
+// even for the limited users, so the punished users can still reach the
+// essential commands (such as "/cancel").
+func (l *Limiter) AddAllowedCommands(commands ...string) {
+	names := make([]string, 0, len(commands))
+	for _, command := range commands {
+		name := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(command), "/"))
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	l.allowedCommands.add(names...)
+}
+
+// RemoveAllowedCommands will remove the commands from the allowed
+// commands of the limiter.
+func (l *Limiter) RemoveAllowedCommands(commands ...string) {
+	names := make([]string, len(commands))
+	for i, command := range commands {
+		names[i] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(command), "/"))
+	}
+
+	l.allowedCommands.remove(names...)
+}
+
+// GetAllowedCommands returns the sorted names of the allowed commands of
+// the limiter, without the '/' prefix.
+func (l *Limiter) GetAllowedCommands() []string {
+	names := l.allowedCommands.keys()
+	sort.Strings(names)
+	return names
+}
+
+// isAllowedCommand returns true if the message is one of the allowed
+// commands of the limiter.
+func (l *Limiter) isAllowedCommand(msg *gotgbot.Message) bool {
+	return len(l.allowedCommands.load()) != 0 &&
+		l.allowedCommands.contains(getCommand(msg))
+}
+
+// Enroll will make the chats (or users) subject to limiting when the
+// limiter is in the opt-in mode (see `OptIn`).
+func (l *Limiter) Enroll(id ...int64) {
+	l.enrolled.Add(id...)
+}
+
+// Unenroll will remove the chats (or users) from the enrolled list of
+// the limiter.
+func (l *Limiter) Unenroll(id ...int64) {
+	l.enrolled.Remove(id...)
+}
+
+// IsEnrolled returns true if the chat (or user) is enrolled in the
+// limiter; the enrolled list is only used in the opt-in mode.
+func (l *Limiter) IsEnrolled(id int64) bool {
+	return l.enrolled.Contains(id)
+}
+
+// GetEnrolled returns the set of the enrolled chats and users of the
+// limiter; the changes made to the set are applied to the limiter
+// immediately.
+func (l *Limiter) GetEnrolled() *IDSet {
+	return &l.enrolled
+}
+
+// GetExceptionIDs returns the set of the exception IDs of the limiter;
+// the changes made to the set are applied to the limiter immediately.
+func (l *Limiter) GetExceptionIDs() *IDSet {
+	return &l.exceptionIDs
+}
+
+// AddCondition will add a condition to be checked by this limiter,
+// if this condition doesn't return true, the limiter won't check
+// the message for anti-flood-wait.
+// the message conditions are only matched against the messages; use
+// `AddCallbackCondition`, `AddInlineCondition` and `AddContextCondition`
+// for the other update types.
+func (l *Limiter) AddCondition(condition filters.Message) {
+	l.conditions.append(condition)
+}
+
+// AddCallbackCondition will add a condition of the callback queries to
+// this limiter; the callback queries which don't meet it are not checked.
+func (l *Limiter) AddCallbackCondition(condition filters.CallbackQuery) {
+	l.callbackConditions.append(condition)
+}
+
+// AddInlineCondition will add a condition of the inline queries to this
+// limiter; the inline queries which don't meet it are not checked.
+func (l *Limiter) AddInlineCondition(condition filters.InlineQuery) {
+	l.inlineConditions.append(condition)
+}
+
+// AddContextCondition will add a condition which is matched against the
+// context of all of the update types checked by this limiter (including
+// the payment queries); the updates which don't meet it are not checked.
+func (l *Limiter) AddContextCondition(condition ContextCondition) {
+	l.contextConditions.append(condition)
+}
+
+// ClearAllConditions clears all condition lists, of all of the update
+// types.
+func (l *Limiter) ClearAllConditions() {
+	l.conditions.store(nil)
+	l.callbackConditions.store(nil)
+	l.inlineConditions.store(nil)
+	l.contextConditions.store(nil)
+}
+
+// AddConditions will accept an array of the conditions and will
+// add them to the condition list of this limiter.
+// you can also pass only one value to this method.
+func (l *Limiter) AddConditions(conditions ...filters.Message) {
+	l.conditions.append(conditions...)
+}
+
+// SetAsConditions will accept an array of conditions and will set
+// the conditions of the limiter to them.
+func (l *Limiter) SetAsConditions(conditions []filters.Message) {
+	l.conditions.store(copySlice(conditions))
+}
+
+// ClearAllExceptions will clear all exception IDs of this limiter.
+// this way, you will be sure that all of incoming updates will be
+// checked for floodwait by this limiter.
+func (l *Limiter) ClearAllExceptionIDs() {
+	removed := l.exceptionIDs.List()
+	l.exceptionIDs.Clear()
+	l.auditIDs(AuditUnexempt, removed, 0)
+}
+
+// IsInExceptionList will check and see if an ID is in the
+// exception list of the listener or not.
+func (l *Limiter) IsInExceptionList(id int64) bool {
+	return l.exceptionIDs.Contains(id)
+}
+
+// SetAsExceptionList will set its argument at the exception
+// list of this limiter. Please notice that this method won't
+// append the list to the already existing exception list; but
+// it will set it to this, so the already existing exception IDs
+// assigned to this limiter will be lost.
+func (l *Limiter) SetAsExceptionList(list []int64) {
+	previous := l.exceptionIDs.List()
+	l.exceptionIDs.Set(list)
+
+	if len(l.auditSinks.load()) == 0 {
+		return
+	}
+
+	var old IDSet
+	old.Set(previous)
+
+	var added, removed []int64
+	for _, id := range previous {
+		if !l.exceptionIDs.Contains(id) {
+			removed = append(removed, id)
+		}
+	}
+
+	for _, id := range l.exceptionIDs.List() {
+		if !old.Contains(id) {
+			added = append(added, id)
+		}
+	}
+
+	l.auditIDs(AuditUnexempt, removed, 0)
+	l.auditIDs(AuditExempt, added, 0)
+}
+
+// GetStatus will get the status of a chat.
+// if `l.ConsiderUser` parameter is set to `true`,
+// the id should be the id of the user; otherwise you should
+// use the id of the chat to get the status.
+// the returned status is a copy, so changing it won't affect the
+// limiter; it returns nil if the chat (or user) is not being tracked.
+func (l *Limiter) GetStatus(id int64) *UserStatus {
+	if l == nil || l.core == nil {
+		return nil
+	}
+
+	return l.core.GetStatus(id)
+}
+
+// GetOrCreateStatus returns a copy of the status of a chat (or user);
+// the chat (or user) will be tracked with an empty status if it's not
+// tracked yet, so the external code (such as admin tools or tests) can
+// seed the state of the limiter.
+func (l *Limiter) GetOrCreateStatus(id int64) *UserStatus {
+	if l == nil || l.core == nil {
+		return nil
+	}
+
+	return l.core.GetOrCreateStatus(id)
+}
+
+// GetStatusSnapshot returns a snapshot of the status of a chat (or user);
+// unlike `GetStatus`, the state of the status is in exported fields, so
+// it can be encoded (such as by the admin apis). it returns nil if the
+// chat (or user) is not being tracked.
+// if `l.ConsiderUser` parameter is set to `true`,
+// the id should be the id of the user; otherwise you should
+// use the id of the chat to get the status.
+func (l *Limiter) GetStatusSnapshot(id int64) *UserStatusSnapshot {
+	if l == nil || l.core == nil {
+		return nil
+	}
+
+	return l.core.GetSnapshot(id)
+}
+
+// Info returns the quota information of a chat (or user) with the
+// default profile of the limiter, such as its remaining quota and the
+// time it's reset at; it's suitable for the bots which expose the
+// limiter behind their apis or mini-apps (see `RateInfo.Headers`).
+// if `l.ConsiderUser` parameter is set to `true`,
+// the id should be the id of the user; otherwise you should
+// pass the id of the chat.
+func (l *Limiter) Info(id int64) RateInfo {
+	return l.core.Info(id, nil)
+}
+
+// SetFloodWaitTime will set the flood wait duration for each
+// chat to send `maxCount` message per this amount of time.
+// if they send more than this amount of messages during this time,
+// they will be limited by this limiter and so their messages
+// won't be handled in the current group.
+// (Notice: if `ConsiderUser` is set to `true`, this duration will
+// be applied to unique users in the chat; not the total chat.)
+// the windows in progress are measured with the new duration from the
+// next message of each chat (or user).
+func (l *Limiter) SetFloodWaitTime(d time.Duration) {
+	l.core.UpdateProfile(func(p *core.Profile) {
+		p.Timeout = d
+	})
+}
+
+// SetPunishmentDuration will set the punishment duration of
+// the chat (or a user) after being limited by this limiter.
+// Users needs to spend this amount of time + `l.timeout` to become
+// free and so the handlers will work again for it.
+// NOTICE: if `IsStrict` is set to `true`, as long as user sends
+// message to the bot, the amount of passed-punishment time will
+// become 0; so the user needs to stop sending messages to the bot
+// until the punishment time is passed, otherwise the user will be
+// limited forever (unless the punishments are capped by `SetMaxPunishment`).
+// the punishments in progress end according to the new duration as well,
+// unless they have been escalated.
+func (l *Limiter) SetPunishmentDuration(d time.Duration) {
+	l.core.UpdateProfile(func(p *core.Profile) {
+		p.PunishmentTime = d
+	})
+}
+
+// SetMaxMessageCount sets the possible messages count in the
+// anti-flood-wait amount of time (which is `l.timeout`).
+// in that period of time, chat (or user) needs to send less than
+// this much message, otherwise they will be limited by this limiter
+// and so as a result of that their messages will be ignored by the bot.
+// the counters of the current windows are kept, so the chats (or users)
+// which are already above the new count are limited by their next message.
+func (l *Limiter) SetMaxMessageCount(count int) {
+	l.core.UpdateProfile(func(p *core.Profile) {
+		p.MessageCount = count
+	})
+}
+
+// SetThrottle will switch the limiter to the throttle mode: instead of
+// punishing the flooders, the limiter lets at most one update of each
+// chat (or user) through in every `d` amount of time, and silently drops
+// the rest of them. it's ideal for the "search as you type" inline bots
+// and the noisy callback flows; use `SetUpdateProfile` with a throttled
+// profile to throttle only some of the update types.
+// pass zero to disable the throttle mode.
+func (l *Limiter) SetThrottle(d time.Duration) {
+	l.core.UpdateProfile(func(p *core.Profile) {
+		p.Throttle = d
+	})
+}
+
+// GetThrottle returns the throttle interval of the limiter; zero means
+// the throttle mode is disabled.
+func (l *Limiter) GetThrottle() time.Duration {
+	return l.core.GetProfile().Throttle
+}
+
+// SetMaxPunishment will set the maximum amount of time a chat (or user)
+// can remain limited since it has been limited, no matter how many
+// messages it keeps sending in the strict mode; so even the persistent
+// flooders are released eventually.
+// pass zero to remove the cap.
+// the cap applies to the punishments in progress as well, unless they
+// have been capped already.
+func (l *Limiter) SetMaxPunishment(d time.Duration) {
+	l.maxPunishment.Store(int64(d))
+}
+
+// GetMaxPunishment returns the maximum punishment time of the limiter;
+// zero means the punishments are not capped.
+func (l *Limiter) GetMaxPunishment() time.Duration {
+	return time.Duration(l.maxPunishment.Load())
+}
+
+// SetWarnThreshold will set the ratio of the quota (between 0 and 1) in
+// which the users are warned before getting limited; for example with
+// 0.8 and a max message count of 10, the warning triggers are run on
+// the 8th message of the users. the users are warned at most once per
+// window. pass zero to disable the warnings.
+func (l *Limiter) SetWarnThreshold(threshold float64) {
+	l.warnThreshold.Store(math.Float64bits(threshold))
+}
+
+// GetWarnThreshold returns the warning threshold of the limiter; zero
+// means the warnings are disabled.
+func (l *Limiter) GetWarnThreshold() float64 {
+	return math.Float64frombits(l.warnThreshold.Load())
+}
+
+// SetMaxCacheDuration will set the max duration for caching algorithm.
+// WARNING: this value should always be greater than the
+// `timeout` + `punishment` values of the limiter;
+// otherwise this method will set the max cache duration to
+// `timeout` + `punishment` + 1.
+func (l *Limiter) SetMaxCacheDuration(d time.Duration) {
+	p := l.core.GetProfile()
+	if d <= p.PunishmentTime+p.Timeout {
+		d = p.PunishmentTime + p.Timeout + time.Minute
+	}
+
+	l.maxTimeout.Store(int64(d))
+}
+
+// GetMaxCacheDuration returns the max duration of the caching algorithm.
+func (l *Limiter) GetMaxCacheDuration() time.Duration {
+	return time.Duration(l.maxTimeout.Load())
+}
+
+// SetCheckerInterval will set the interval of the cleanup loop of the
+// limiter, independent of the max cache duration; so a long cache
+// duration (which is needed to keep the punishments cached) won't delay
+// the cleanups. pass zero to use the max cache duration as the interval.
+// NOTICE: a running checker keeps its interval; the new interval is used
+// once the limiter is restarted.
+func (l *Limiter) SetCheckerInterval(d time.Duration) {
+	l.checkerInterval.Store(int64(d))
+}
+
+// GetCheckerInterval returns the interval of the cleanup loop of the
+// limiter.
+func (l *Limiter) GetCheckerInterval() time.Duration {
+	if d := time.Duration(l.checkerInterval.Load()); d > 0 {
+		return d
+	}
+
+	return l.GetMaxCacheDuration()
+}
+
+// SetDefaultInterval will set a default value to the checker's interval.
+// It's recommended that users use `SetMaxCacheDuration` method instead of this one.
+// If you haven't set any other parameters for the limiter, this will set the interval
+// to 60 seconds at least.
+func (l *Limiter) SetDefaultInterval() {
+	p := l.core.GetProfile()
+	l.maxTimeout.Store(int64(p.PunishmentTime + p.Timeout + time.Minute))
+}
+
+// AddCustomIgnore will make the limiter ignore the messages of the
+// given user (or chat) for `d` amount of time; pass zero to ignore them
+// until `RemoveCustomIgnore` is called. if ignoreExceptions is true, the
+// user will be ignored even if it's in the exception list.
+func (l *Limiter) AddCustomIgnore(id int64, d time.Duration, ignoreExceptions bool) {
+	l.AddCustomIgnores([]int64{id}, d, ignoreExceptions)
+}
+
+// AddCustomIgnores will add the same custom ignore to all of the given
+// users (or chats) at once; see `AddCustomIgnore`.
+// if a storage backend is set, the custom ignores will be persisted as
+// well, so they survive the restarts.
+func (l *Limiter) AddCustomIgnores(ids []int64, d time.Duration, ignoreExceptions bool) {
+	l.core.AddCustomIgnores(ids, d, ignoreExceptions)
+	if ignoreExceptions {
+		l.ignoredExceptions.Add(ids...)
+	}
+
+	l.auditIDs(AuditIgnore, ids, d)
+
+	start := time.Now()
+	for _, id := range ids {
+		l.publish(&core.SyncEvent{
+			Type:             core.SyncCustomIgnore,
+			Key:              id,
+			Duration:         d,
+			IgnoreExceptions: ignoreExceptions,
+		})
+
+		// the limiter still works without the persisted custom
+		// ignores, so the error is not fatal here.
+		_ = l.persistCustomIgnore(&CustomIgnoreInfo{
+			Key:              id,
+			Start:            start,
+			Duration:         d,
+			IgnoreExceptions: ignoreExceptions,
+		})
+	}
+}
+
+// RemoveCustomIgnore will remove the custom ignore of the given user
+// (or chat).
+func (l *Limiter) RemoveCustomIgnore(id int64) {
+	if l.core.RemoveCustomIgnore(id) {
+		l.removeFromIgnoredExceptions(id)
+		l.auditIDs(AuditUnignore, []int64{id}, 0)
+	}
+
+	l.publish(&core.SyncEvent{
+		Type: core.SyncRemoveCustomIgnore,
+		Key:  id,
+	})
+
+	store := l.GetStorage()
+	if store != nil {
+		_ = store.Delete(customIgnoreKey(id))
+	}
+}
+
+// ListCustomIgnores returns the information of all of the active custom
+// ignores of the limiter, sorted by the id of their user (or chat).
+func (l *Limiter) ListCustomIgnores() []CustomIgnoreInfo {
+	return l.core.ListCustomIgnores()
+}
+
+// OnCustomIgnoreExpire will add a callback which is called whenever a
+// custom ignore expires; the expired custom ignores are noticed by the
+// checker goroutine (every max cache duration).
+// the callbacks are called in a separate goroutine.
+func (l *Limiter) OnCustomIgnoreExpire(callback CustomIgnoreCallback) {
+	l.hookMutex.Lock()
+	l.customIgnoreCallbacks = append(l.customIgnoreCallbacks, callback)
+	l.hookMutex.Unlock()
+}
+
+// LoadCustomIgnores will load all of the persisted custom ignores from
+// the storage backend; the expired ones are removed from the storage.
+// this method is called by `Start` automatically.
+func (l *Limiter) LoadCustomIgnores() error {
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	keys, err := store.Keys(customIgnorePrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data, err := store.Get(key)
+		if err != nil {
+			return err
+		}
+
+		info := new(CustomIgnoreInfo)
+		if err = json.Unmarshal(data, info); err != nil {
+			return err
+		}
+
+		if info.IsExpired() {
+			_ = store.Delete(key)
+			continue
+		}
+
+		l.core.RestoreCustomIgnore(info)
+		if info.IgnoreExceptions {
+			l.addIgnoredExceptions(info.Key)
+		}
+	}
+
+	return nil
+}
+
+// persistCustomIgnore will store the custom ignore in the storage
+// backend (if any).
+func (l *Limiter) persistCustomIgnore(info *CustomIgnoreInfo) error {
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return store.Set(customIgnoreKey(info.Key), data)
+}
+
+// FlushStatuses will write the statuses of the users to the storage
+// backend (if any), so they can be loaded by `LoadStatuses` after a
+// restart; it's called by `Stop` automatically when the stop policy is
+// `StopRetain`.
+func (l *Limiter) FlushStatuses() error {
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(l.core.ExportStatuses())
+	if err != nil {
+		return err
+	}
+
+	return store.Set(statusesKey, data)
+}
+
+// LoadStatuses will load the statuses of the users flushed to the storage
+// backend by `FlushStatuses`, and removes them from the storage.
+// this method is called by `Start` automatically.
+func (l *Limiter) LoadStatuses() error {
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	data, err := store.Get(statusesKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	var records []core.StatusRecord
+	if err = json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+
+	l.core.ImportStatuses(records)
+	return store.Delete(statusesKey)
+}
+
+// SetPunishment will set the punishment taken against the limited users
+// in the group chats, such as muting them until their punishment is over.
+// the punishments are reverted by the limiter automatically when they
+// are over (or when the users are unlimited manually); the reversals are
+// persisted in the storage backend (if any), so they are run even if the
+// bot is restarted meanwhile. pass nil to disable the punishments; the
+// already scheduled reversals are still run.
+func (l *Limiter) SetPunishment(config *PunishmentConfig) error {
+	if config != nil {
+		if config.Bot == nil || config.Type < PunishmentNone || config.Type > PunishmentBan {
+			return fmt.Errorf("%w: %+v", ErrInvalidPunisher, *config)
+		}
+
+		c := *config
+		config = &c
+	}
+
+	l.punishMutex.Lock()
+	l.punishment = config
+	if config != nil {
+		l.punisher = config.Bot
+	}
+	l.punishMutex.Unlock()
+
+	return nil
+}
+
+// GetPunishment returns the punishment configuration of this limiter.
+// it will return nil if the punishments are disabled.
+func (l *Limiter) GetPunishment() *PunishmentConfig {
+	l.punishMutex.Lock()
+	defer l.punishMutex.Unlock()
+
+	if l.punishment == nil {
+		return nil
+	}
+
+	c := *l.punishment
+	return &c
+}
+
+// ScheduledActions returns the reversals of the punishments which are
+// waiting for the punishments to end, sorted by their time.
+func (l *Limiter) ScheduledActions() []ScheduledAction {
+	l.punishMutex.Lock()
+	actions := make([]ScheduledAction, 0, len(l.scheduled))
+	for _, s := range l.scheduled {
+		actions = append(actions, s.ScheduledAction)
+	}
+	l.punishMutex.Unlock()
+
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].At.Before(actions[j].At)
+	})
+
+	return actions
+}
+
+// LoadScheduledActions will load all of the persisted reversals of the
+// punishments from the storage backend and schedules them again; the
+// ones which are already due are run immediately.
+// this method is called by `Start` automatically.
+func (l *Limiter) LoadScheduledActions() error {
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	keys, err := store.Keys(actionPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		data, err := store.Get(key)
+		if err != nil {
+			return err
+		}
+
+		action := new(ScheduledAction)
+		if err = json.Unmarshal(data, action); err != nil {
+			return err
+		}
+
+		l.scheduleAction(nil, action, false)
+	}
+
+	return nil
+}
+
+// punish will punish the sender of the update, who has just been limited
+// by the limiter, and schedules the reversal of the punishment.
+func (l *Limiter) punish(ctx *ext.Context, key int64) {
+	config := l.GetPunishment()
+	if config == nil || config.Type == PunishmentNone || !isPunishable(ctx) {
+		return
+	}
+
+	chat, user := ctx.EffectiveChat, ctx.EffectiveUser
+
+	until := time.Now()
+	if snapshot := l.core.GetSnapshot(key); snapshot != nil && snapshot.LimitedUntil != nil {
+		until = *snapshot.LimitedUntil
+	}
+
+	action := &ScheduledAction{
+		Key:    key,
+		ChatID: chat.Id,
+		UserID: user.Id,
+		Action: AuditUnmute,
+		At:     until,
+	}
+
+	record := &AuditRecord{
+		Action:   AuditMute,
+		Key:      key,
+		ChatID:   chat.Id,
+		UserID:   user.Id,
+		Actor:    ActorLimiter,
+		Reason:   ActionIgnore,
+		Duration: Duration(time.Until(until)),
+	}
+
+	if config.Type == PunishmentBan {
+		action.Action = AuditUnban
+		record.Action = AuditBan
+	}
+
+	// the punishment is lifted by telegram itself as well, in case the
+	// scheduled reversal can't be run (such as when the bot is down).
+	untilDate := getUntilDate(until)
+	l.runJob(config.Bot, func(b *gotgbot.Bot) error {
+		var err error
+		if config.Type == PunishmentBan {
+			_, err = b.BanChatMember(action.ChatID, action.UserID, &gotgbot.BanChatMemberOpts{
+				UntilDate: untilDate,
+			})
+		} else {
+			// the current restrictions of the user are restored by the
+			// reversal, instead of lifting all of them.
+			action.Permissions, action.RestrictedUntil = getRestrictions(b, action.ChatID, action.UserID)
+			_, err = b.RestrictChatMember(action.ChatID, action.UserID, gotgbot.ChatPermissions{},
+				&gotgbot.RestrictChatMemberOpts{
+					UntilDate: untilDate,
+				})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		l.scheduleAction(b, action, true)
+		l.Audit(record)
+		return nil
+	})
+}
+
+// scheduleAction will schedule the reversal of a punishment, replacing
+// the previous one of the user in the chat; if persist is true, the
+// action is stored in the storage backend as well. b is the bot which
+// runs the reversal; if it's nil, the bot of the punishments is used.
+func (l *Limiter) scheduleAction(b *gotgbot.Bot, action *ScheduledAction, persist bool) {
+	store := l.GetStorage()
+	if persist && store != nil {
+		if data, err := json.Marshal(action); err == nil {
+			_ = store.Set(actionKey(action.ChatID, action.UserID), data)
+		}
+	}
+
+	k := roleKey{chatID: action.ChatID, userID: action.UserID}
+	s := &scheduledAction{ScheduledAction: *action, bot: b}
+
+	l.punishMutex.Lock()
+	defer l.punishMutex.Unlock()
+
+	if l.scheduled == nil {
+		l.scheduled = make(map[roleKey]*scheduledAction)
+	}
+
+	if old := l.scheduled[k]; old != nil {
+		old.timer.Stop()
+	}
+
+	l.scheduled[k] = s
+	s.timer = time.AfterFunc(time.Until(action.At), func() {
+		l.runAction(k, s)
+	})
+}
+
+// runAction will run the scheduled reversal of a punishment; it does
+// nothing if the action has already been run or replaced. the failed
+// reversals remain in the storage, so they are retried on the next start.
+func (l *Limiter) runAction(k roleKey, s *scheduledAction) {
+	l.punishMutex.Lock()
+	if l.scheduled[k] != s {
+		l.punishMutex.Unlock()
+		return
+	}
+
+	s.timer.Stop()
+	delete(l.scheduled, k)
+	b := s.bot
+	if b == nil {
+		b = l.punisher
+	}
+	l.punishMutex.Unlock()
+
+	if b == nil {
+		return
+	}
+
+	action := s.ScheduledAction
+	store := l.GetStorage()
+	l.runJob(b, func(b *gotgbot.Bot) error {
+		if err := revertPunishment(b, &action); err != nil {
+			return err
+		}
+
+		if store != nil {
+			_ = store.Delete(actionKey(action.ChatID, action.UserID))
+		}
+
+		l.Audit(&AuditRecord{
+			Action: action.Action,
+			Key:    action.Key,
+			ChatID: action.ChatID,
+			UserID: action.UserID,
+			Actor:  ActorLimiter,
+			Reason: ActionExpire,
+		})
+		return nil
+	})
+}
+
+// revertPunishments will run the scheduled reversals of the key right
+// away; it's used when the key is released before its punishment time
+// is over, such as by solving a challenge.
+func (l *Limiter) revertPunishments(key int64) {
+	l.punishMutex.Lock()
+	var due []*scheduledAction
+	for _, s := range l.scheduled {
+		if s.Key == key {
+			due = append(due, s)
+		}
+	}
+
+	applied := l.applied[key]
+	delete(l.applied, key)
+	l.punishMutex.Unlock()
+
+	for _, s := range due {
+		l.runAction(roleKey{chatID: s.ChatID, userID: s.UserID}, s)
+	}
+
+	for _, a := range applied {
+		a := a
+		l.runJob(a.bot, func(b *gotgbot.Bot) error {
+			return a.action.Revert(b, &a.info)
+		})
+	}
+}
+
+// clearScheduled will stop the timers of the scheduled actions; the
+// persisted actions are loaded again by the next start.
+func (l *Limiter) clearScheduled() {
+	l.punishMutex.Lock()
+	for _, s := range l.scheduled {
+		s.timer.Stop()
+	}
+	l.scheduled = nil
+	l.punishMutex.Unlock()
+}
+
+// AppendActions will append the enforcement actions which are applied to
+// the newly limited users, in addition to ignoring their updates; for
+// example `&MuteAction{}` and `&WarnAction{Text: "..."}` mute the limited
+// users and warn them. the actions are reverted when the punishment of
+// the users is over (or when they are unlimited manually).
+// NOTICE: the actions need a bot, so they are not applied to the updates
+// checked by `Check`.
+func (l *Limiter) AppendActions(actions ...Action) {
+	l.actions.append(actions...)
+}
+
+// ClearActions will remove all of the enforcement actions of the limiter.
+func (l *Limiter) ClearActions() {
+	l.actions.store(nil)
+}
+
+// GetActions returns the enforcement actions of the limiter.
+func (l *Limiter) GetActions() []Action {
+	return l.actions.load()
+}
+
+// applyActions will apply the enforcement actions to the sender of the
+// update, who has just been limited; the actions which are applied
+// successfully are reverted when the key is released.
+func (l *Limiter) applyActions(b *gotgbot.Bot, ctx *ext.Context, key int64, d core.Decision, p *LimitProfile, actions []Action) {
+	if len(actions) == 0 {
+		return
+	}
+
+	info := newLimitInfo(ctx, key, d, p)
+	if snapshot := l.core.GetSnapshot(key); snapshot != nil && snapshot.LimitedUntil != nil {
+		info.Until = *snapshot.LimitedUntil
+	}
+
+	for _, action := range actions {
+		if action == nil {
+			continue
+		}
+
+		// every action is a separate job, so a failing action doesn't
+		// prevent the others, and the retries don't repeat them.
+		action := action
+		l.runJob(b, func(b *gotgbot.Bot) error {
+			if err := action.Apply(b, ctx, info); err != nil {
+				return err
+			}
+
+			l.actionApplied(b, action, info)
+			return nil
+		})
+	}
+}
+
+// actionApplied will audit the applied action (if it's a built-in one),
+// and keeps it until the key is released, so it can be reverted.
+func (l *Limiter) actionApplied(b *gotgbot.Bot, action Action, info *LimitInfo) {
+	builtin, ok := action.(builtinAction)
+	if ok && builtin.auditAction() != "" {
+		l.Audit(&AuditRecord{
+			Action:   builtin.auditAction(),
+			Key:      info.Key,
+			ChatID:   info.ChatID,
+			UserID:   info.UserID,
+			Actor:    ActorLimiter,
+			Reason:   ActionIgnore,
+			Duration: Duration(time.Until(info.Until)),
+		})
+	}
+
+	if ok {
+		// the reversals of the built-in actions are persisted; the other
+		// built-in actions can't be reverted.
+		if builtin.reversal() != "" {
+			l.scheduleAction(b, &ScheduledAction{
+				Key:    info.Key,
+				ChatID: info.ChatID,
+				UserID: info.UserID,
+				Action: builtin.reversal(),
+				At:     info.Until,
+			}, true)
+		}
+		return
+	}
+
+	l.punishMutex.Lock()
+	if l.applied == nil {
+		l.applied = make(map[int64][]*appliedAction)
+	}
+
+	l.applied[info.Key] = append(l.applied[info.Key], &appliedAction{
+		action: action,
+		bot:    b,
+		info:   *info,
+	})
+	l.punishMutex.Unlock()
+}
+
+// SetEscalationPolicy will set the escalation policy of the punishments;
+// when a user is limited, their violations in the last `window` amount
+// of time are passed to the policy, which chooses their punishment time
+// and an extra enforcement action (see `Action`), so the repeat offenders
+// get harsher punishments; the punishment cap of the limiter (see
+// `SetMaxPunishment`) still applies. window defaults to
+// `DefaultEscalationWindow`.
+// pass nil to disable the escalation.
+func (l *Limiter) SetEscalationPolicy(policy EscalationPolicy, window time.Duration) {
+	if window <= 0 {
+		window = DefaultEscalationWindow
+	}
+
+	l.punishMutex.Lock()
+	l.escalation = policy
+	l.escalationWindow = window
+	if policy == nil {
+		l.violations = nil
+	}
+	l.punishMutex.Unlock()
+}
+
+// GetEscalationPolicy returns the escalation policy of the punishments
+// and its window; the policy is nil if the escalation is disabled.
+func (l *Limiter) GetEscalationPolicy() (EscalationPolicy, time.Duration) {
+	l.punishMutex.Lock()
+	defer l.punishMutex.Unlock()
+
+	return l.escalation, l.escalationWindow
+}
+
+// GetViolations returns the recent violations of the key remembered by
+// the escalation policy, oldest first.
+func (l *Limiter) GetViolations(key int64) []Incident {
+	l.punishMutex.Lock()
+	defer l.punishMutex.Unlock()
+
+	return copySlice(pruneIncidents(l.violations[key], l.escalationWindow))
+}
+
+// ClearViolations will forget the violations of the key, so their next
+// punishment is not escalated.
+func (l *Limiter) ClearViolations(key int64) {
+	l.punishMutex.Lock()
+	delete(l.violations, key)
+	l.punishMutex.Unlock()
+}
+
+// escalate will record the violation of the key, which has just been
+// limited, and applies the punishment time chosen by the escalation
+// policy; it returns the extra action chosen by the policy, if any.
+func (l *Limiter) escalate(ctx *ext.Context, key int64, d core.Decision, p *LimitProfile) Action {
+	l.punishMutex.Lock()
+	policy := l.escalation
+	if policy == nil {
+		l.punishMutex.Unlock()
+		return nil
+	}
+
+	if l.violations == nil {
+		l.violations = make(map[int64][]Incident)
+	}
+
+	violation := Incident{
+		Time:  time.Now(),
+		Count: d.Count,
+	}
+	if ctx.EffectiveChat != nil {
+		violation.ChatID = ctx.EffectiveChat.Id
+	}
+
+	history := append(pruneIncidents(l.violations[key], l.escalationWindow), violation)
+	l.violations[key] = history
+	history = copySlice(history)
+	l.punishMutex.Unlock()
+
+	e := policy.Escalate(history)
+	if e.Duration > 0 {
+		l.core.Escalate(key, p.Timeout+e.Duration)
+	}
+
+	return e.Action
+}
+
+// pruneViolations will remove the violations which are out of the window
+// of the escalation policy.
+func (l *Limiter) pruneViolations() {
+	l.punishMutex.Lock()
+	defer l.punishMutex.Unlock()
+
+	for key, violations := range l.violations {
+		violations = pruneIncidents(violations, l.escalationWindow)
+		if len(violations) == 0 {
+			delete(l.violations, key)
+			continue
+		}
+
+		l.violations[key] = violations
+	}
+}
+
+// SetProbation will set the limit profile applied to the newly joined
+// members of the chats for `d` amount of time after they have joined.
+// Users will be graduated to the normal limits of the limiter after
+// this time is passed. pass nil as profile to disable probation mode.
+// NOTICE: the bot has to receive `chat_member` updates (or the service
+// messages about new members) so the limiter can track the joins.
+func (l *Limiter) SetProbation(profile *LimitProfile, d time.Duration) {
+	if d <= 0 {
+		d = DefaultProbationTime
+	}
+
+	l.probationDuration.Store(int64(d))
+	l.probation.Store(profile)
+}
+
+// GetProbationProfile returns the limit profile applied to newly
+// joined members. it will return nil if probation mode is disabled.
+func (l *Limiter) GetProbationProfile() *LimitProfile {
+	return l.probation.Load()
+}
+
+// getProbationDuration returns the amount of time the newly joined
+// members stay in probation mode.
+func (l *Limiter) getProbationDuration() time.Duration {
+	return time.Duration(l.probationDuration.Load())
+}
+
+// SetChannelSenderProfile will set the limit profile applied to the
+// channels sending messages into the groups, when `LimitChannelSenders`
+// is true. pass nil to use the default limits for them.
+func (l *Limiter) SetChannelSenderProfile(profile *LimitProfile) {
+	l.channelProfile.Store(profile)
+}
+
+// GetChannelSenderProfile returns the limit profile applied to the
+// channel senders; it will return nil if the default limits are used.
+func (l *Limiter) GetChannelSenderProfile() *LimitProfile {
+	return l.channelProfile.Load()
+}
+
+// SetChannelPostProfile will set the limit profile applied to the posts
+// of the channels, so the runaway automated posters can be throttled with
+// different limits than the users. pass nil to use the default limits.
+// NOTICE: channel posts are only checked if `ConsiderChannel` has been
+// set to true in the config of the limiter.
+func (l *Limiter) SetChannelPostProfile(profile *LimitProfile) {
+	l.channelPostProfile.Store(profile)
+}
+
+// GetChannelPostProfile returns the limit profile applied to the posts of
+// the channels; it will return nil if the default limits are used.
+func (l *Limiter) GetChannelPostProfile() *LimitProfile {
+	return l.channelPostProfile.Load()
+}
+
+// SetCommentProfile will set the limit profile applied to the comments
+// of the users under the channel posts, in the linked discussion group
+// of the channel. pass nil to use the default limits for them.
+func (l *Limiter) SetCommentProfile(profile *LimitProfile) {
+	l.commentProfile.Store(profile)
+}
+
+// GetCommentProfile returns the limit profile applied to the comments
+// under the channel posts; it will return nil if the default limits are
+// used.
+func (l *Limiter) GetCommentProfile() *LimitProfile {
+	return l.commentProfile.Load()
+}
+
+// SetCommandProfile will give the commands (the messages starting with
+// a bot command) a separate budget with the given limit profile; so the
+// command abuse can be caught quickly with a strict profile, without
+// penalizing the chat activity of the users, and the other way around.
+// the commands are counted with `CommandKey` of the user (or chat) as
+// their key, so `Unlimit` of the user doesn't unlimit their commands.
+// pass nil to count the commands with the rest of the messages.
+func (l *Limiter) SetCommandProfile(profile *LimitProfile) {
+	l.commandProfile.Store(profile)
+}
+
+// GetCommandProfile returns the limit profile of the commands; it will
+// return nil if the commands don't have a separate budget.
+func (l *Limiter) GetCommandProfile() *LimitProfile {
+	return l.commandProfile.Load()
+}
+
+// isCountedCommand returns true if the update is a command which should
+// be counted in the separate budget of the commands.
+func (l *Limiter) isCountedCommand(ctx *ext.Context) bool {
+	return l.GetCommandProfile() != nil && ctx.Message != nil && isCommandMessage(ctx.Message)
+}
+
+// IsComment returns true if the message is a comment under a channel
+// post in the linked discussion group of the channel; it's either a reply
+// to the automatic forward of the post, or a message in the thread of an
+// automatic forward which has been seen by the limiter.
+func (l *Limiter) IsComment(msg *gotgbot.Message) bool {
+	if IsDiscussionComment(msg) {
+		return true
+	}
+
+	if msg == nil || msg.IsAutomaticForward || msg.IsTopicMessage ||
+		msg.MessageThreadId == 0 {
+		return false
+	}
+
+	l.threadMutex.Lock()
+	defer l.threadMutex.Unlock()
+
+	_, ok := l.threads[threadKey{chatID: msg.Chat.Id, threadID: msg.MessageThreadId}]
+	return ok
+}
+
+// slowDown returns true if the message should be ignored by the slow
+// mode of its chat, because its sender has sent another message in the
+// chat recently; the message is deleted as well if the chat settings
+// say so. b can be nil, in which case the message is not deleted.
+func (l *Limiter) slowDown(b *gotgbot.Bot, ctx *ext.Context) bool {
+	msg := ctx.EffectiveMessage
+	if msg == nil || ctx.EffectiveSender == nil {
+		return false
+	}
+
+	interval, deleteSlowed := l.getSlowMode(msg.Chat.Id)
+	if interval <= 0 || l.isExceptionCtx(ctx) {
+		return false
+	}
+
+	key := roleKey{chatID: msg.Chat.Id, userID: ctx.EffectiveSender.Id()}
+	now := time.Now()
+
+	l.slowMutex.Lock()
+	if until, ok := l.slowed[key]; ok && now.Before(until) {
+		l.slowMutex.Unlock()
+		if ctx.Data == nil {
+			ctx.Data = make(map[string]interface{})
+		}
+
+		ctx.Data[ContextSlowedKey] = true
+		if b != nil && deleteSlowed {
+			l.runJob(b, func(b *gotgbot.Bot) error {
+				_, err := b.DeleteMessage(msg.Chat.Id, msg.MessageId, nil)
+				return err
+			})
+		}
+
+		return true
+	}
+
+	if l.slowed == nil {
+		l.slowed = make(map[roleKey]time.Time)
+	}
+
+	l.slowed[key] = now.Add(interval)
+	l.slowMutex.Unlock()
+	return false
+}
+
+// getSlowMode returns the slow mode interval of the chat, which is the
+// raised slow mode of the chat (if any) or the slow mode of its settings,
+// whichever is longer; and whether the slowed messages should be deleted.
+func (l *Limiter) getSlowMode(chatID int64) (time.Duration, bool) {
+	var interval time.Duration
+	var deleteSlowed bool
+	if settings := l.getChatSettings(chatID); settings != nil {
+		interval, deleteSlowed = settings.SlowMode, settings.DeleteSlowed
+	}
+
+	l.slowMutex.Lock()
+	raised := l.raisedSlowModes[chatID]
+	l.slowMutex.Unlock()
+
+	if raised > interval {
+		interval = raised
+	}
+
+	return interval, deleteSlowed
+}
+
+// RaiseSlowMode will raise the slow mode of the chat to the given
+// interval for d amount of time (such as during a raid), after which the
+// slow mode of the chat settings (if any) is used again; calling it
+// again for the chat replaces the interval and extends the cooldown.
+// see `ChatSettings.SlowMode` for more information about the slow mode.
+func (l *Limiter) RaiseSlowMode(chatID int64, interval, d time.Duration) {
+	if interval <= 0 || d <= 0 {
+		l.EndChatState(chatID, StateSlowMode)
+		return
+	}
+
+	l.slowMutex.Lock()
+	if l.raisedSlowModes == nil {
+		l.raisedSlowModes = make(map[int64]time.Duration)
+	}
+	l.raisedSlowModes[chatID] = interval
+	l.slowMutex.Unlock()
+
+	l.startCooldown(chatID, StateSlowMode, d, func() {
+		l.slowMutex.Lock()
+		delete(l.raisedSlowModes, chatID)
+		l.slowMutex.Unlock()
+	})
+}
+
+// ChatState returns the temporary states of the chat (such as its
+// lockdown, raised slow mode or raid alert), each of which is reversed
+// automatically when its cooldown is over.
+func (l *Limiter) ChatState(chatID int64) ChatState {
+	state := ChatState{ChatID: chatID}
+
+	l.cooldownMutex.Lock()
+	for key, cooldown := range l.cooldowns {
+		if key.chatID != chatID {
+			continue
+		}
+
+		if state.Until == nil {
+			state.Until = make(map[CooldownState]time.Time)
+		}
+
+		state.Until[key.state] = cooldown.until
+	}
+	l.cooldownMutex.Unlock()
+
+	l.slowMutex.Lock()
+	state.SlowMode = l.raisedSlowModes[chatID]
+	l.slowMutex.Unlock()
+
+	return state
+}
+
+// EndChatState will end the temporary state of the chat before its
+// cooldown is over, and reverses it. it returns false if the chat is
+// not in that state.
+func (l *Limiter) EndChatState(chatID int64, state CooldownState) bool {
+	return l.endCooldown(cooldownKey{chatID: chatID, state: state}, nil)
+}
+
+// startCooldown will put the chat in the temporary state for d amount of
+// time; revert is called when the state is over. if the chat is already
+// in the state, its cooldown is extended instead (and revert is not
+// replaced), in which case it returns false.
+func (l *Limiter) startCooldown(chatID int64, state CooldownState, d time.Duration, revert func()) bool {
+	key := cooldownKey{chatID: chatID, state: state}
+
+	l.cooldownMutex.Lock()
+	defer l.cooldownMutex.Unlock()
+
+	if cooldown := l.cooldowns[key]; cooldown != nil {
+		cooldown.until = time.Now().Add(d)
+		cooldown.timer.Reset(d)
+		return false
+	}
+
+	if l.cooldowns == nil {
+		l.cooldowns = make(map[cooldownKey]*chatCooldown)
+	}
+
+	cooldown := &chatCooldown{
+		until:  time.Now().Add(d),
+		revert: revert,
+	}
+	cooldown.timer = time.AfterFunc(d, func() {
+		l.endCooldown(key, cooldown)
+	})
+	l.cooldowns[key] = cooldown
+	return true
+}
+
+// endCooldown will end the temporary state and reverses it; it returns
+// false if the state is not active. expected is the cooldown whose timer
+// has fired, which is only ended if it's still active and hasn't been
+// extended meanwhile; pass nil to end the state unconditionally.
+func (l *Limiter) endCooldown(key cooldownKey, expected *chatCooldown) bool {
+	l.cooldownMutex.Lock()
+	cooldown := l.cooldowns[key]
+	if cooldown == nil || (expected != nil &&
+		(cooldown != expected || time.Now().Before(cooldown.until))) {
+		l.cooldownMutex.Unlock()
+		return false
+	}
+
+	delete(l.cooldowns, key)
+	cooldown.timer.Stop()
+	l.cooldownMutex.Unlock()
+
+	if cooldown.revert != nil {
+		cooldown.revert()
+	}
+
+	return true
+}
+
+// pruneSlowed will forget the users whose slow mode interval is over.
+func (l *Limiter) pruneSlowed() {
+	now := time.Now()
+	l.slowMutex.Lock()
+	for key, until := range l.slowed {
+		if now.After(until) {
+			delete(l.slowed, key)
+		}
+	}
+	l.slowMutex.Unlock()
+}
+
+// isNewAlbum returns false if the message belongs to a media group which
+// has been seen by the limiter before; the media group of the message is
+// remembered otherwise.
+func (l *Limiter) isNewAlbum(msg *gotgbot.Message) bool {
+	if msg == nil || msg.MediaGroupId == "" {
+		return true
+	}
+
+	key := albumKey{chatID: msg.Chat.Id, groupID: msg.MediaGroupId}
+
+	l.albumMutex.Lock()
+	defer l.albumMutex.Unlock()
+
+	if _, ok := l.albums[key]; ok {
+		return false
+	}
+
+	if l.albums == nil {
+		l.albums = make(map[albumKey]time.Time)
+	}
+
+	l.albums[key] = time.Now()
+	return true
+}
+
+// pruneAlbums will forget the media groups which are older than
+// `DefaultAlbumLifetime`.
+func (l *Limiter) pruneAlbums() {
+	l.albumMutex.Lock()
+	for key, seen := range l.albums {
+		if time.Since(seen) > DefaultAlbumLifetime {
+			delete(l.albums, key)
+		}
+	}
+	l.albumMutex.Unlock()
+}
+
+// trackThread will remember the discussion thread of the automatic
+// forward, so the comments of the thread can be detected.
+func (l *Limiter) trackThread(msg *gotgbot.Message) {
+	l.threadMutex.Lock()
+	if l.threads == nil {
+		l.threads = make(map[threadKey]time.Time)
+	}
+
+	l.threads[threadKey{chatID: msg.Chat.Id, threadID: msg.MessageId}] = time.Now()
+	l.threadMutex.Unlock()
+}
+
+// pruneThreads will forget the discussion threads which are older than
+// `DefaultThreadLifetime`.
+func (l *Limiter) pruneThreads() {
+	l.threadMutex.Lock()
+	for key, seen := range l.threads {
+		if time.Since(seen) > DefaultThreadLifetime {
+			delete(l.threads, key)
+		}
+	}
+	l.threadMutex.Unlock()
+}
+
+// SetCallbackProfile will set the limit profile applied to the callback
+// queries, so pressing the buttons can be tolerated (or punished)
+// differently than typing messages. the callback queries still share
+// the status (and the punishment) of the user with their messages.
+// pass nil to use the default limits.
+// NOTICE: callback queries are only checked if `ConsiderInline` has
+// been set to true in the config of the limiter.
+func (l *Limiter) SetCallbackProfile(profile *LimitProfile) {
+	l.SetUpdateProfile(UpdateCallback, profile)
+}
+
+// GetCallbackProfile returns the limit profile applied to the callback
+// queries; it will return nil if the default limits are used.
+func (l *Limiter) GetCallbackProfile() *LimitProfile {
+	return l.GetUpdateProfile(UpdateCallback)
+}
+
+// SetDelay will enable the delay mode of the limiter: instead of being
+// dropped, the limited updates are queued (up to `MaxDepth` updates of
+// each user) and are re-injected into the dispatcher one by one when the
+// user is released, at the pace allowed by the limits; so the legitimate
+// fast typers are slowed down rather than ignored. the updates waiting
+// for more than `MaxAge` are dropped. a short punishment time is
+// recommended in this mode. pass nil to disable the delay mode.
+// NOTICE: the updates are re-injected into the dispatcher they have been
+// received from (see `ContextDispatcherKey`), and they are checked by the
+// limiter again; the re-injected updates have the time they were queued
+// at in their context's data (see `ContextDelayedKey`). the updates of
+// the dispatchers the limiter is not attached to (such as the ones gated
+// by `NewLimiterProcessor`) can't be re-injected, so they are dropped as
+// usual.
+func (l *Limiter) SetDelay(config *DelayConfig) {
+	if config != nil {
+		config = normalizeDelay(config)
+	}
+
+	l.delayMutex.Lock()
+	l.delay = config
+	l.delayMutex.Unlock()
+
+	if config == nil {
+		l.clearDelayed()
+	}
+}
+
+// GetDelay returns a copy of the configuration of the delay mode; it
+// returns nil if the delay mode is disabled.
+func (l *Limiter) GetDelay() *DelayConfig {
+	l.delayMutex.Lock()
+	defer l.delayMutex.Unlock()
+
+	if l.delay == nil {
+		return nil
+	}
+
+	c := *l.delay
+	return &c
+}
+
+// DelayedCount returns the amount of the updates of the user (or chat)
+// waiting in the queue of the delay mode.
+func (l *Limiter) DelayedCount(key int64) int {
+	l.delayMutex.Lock()
+	defer l.delayMutex.Unlock()
+
+	if q := l.delayed[key]; q != nil {
+		return len(q.updates)
+	}
+
+	return 0
+}
+
+// delayUpdate will queue the limited update if the delay mode is enabled;
+// it returns false if the update has not been queued, so it should be
+// dropped as usual.
+func (l *Limiter) delayUpdate(b *gotgbot.Bot, ctx *ext.Context, key int64, d core.Decision) bool {
+	dispatcher, _ := ctx.Data[ContextDispatcherKey].(*ext.Dispatcher)
+	if b == nil || ctx.Update == nil || d.Result != core.ResultLimited ||
+		dispatcher == nil || !l.isAttached(dispatcher) {
+		return false
+	}
+
+	l.delayMutex.Lock()
+	defer l.delayMutex.Unlock()
+
+	if l.delay == nil {
+		return false
+	}
+
+	u := &delayedUpdate{bot: b, update: ctx.Update, queuedAt: time.Now(), dispatcher: dispatcher}
+	requeued := false
+	if queuedAt, ok := ctx.Data[ContextDelayedKey].(time.Time); ok {
+		// the update has been re-injected, but the user is still
+		// limited; it keeps its place at the front of the queue.
+		u.queuedAt = queuedAt
+		requeued = true
+	}
+
+	if time.Since(u.queuedAt) > l.delay.MaxAge {
+		return false
+	}
+
+	if l.delayed == nil {
+		l.delayed = make(map[int64]*delayQueue)
+	}
+
+	q := l.delayed[key]
+	if q == nil {
+		q = &delayQueue{}
+		l.delayed[key] = q
+	}
+
+	switch {
+	case requeued:
+		q.updates = append([]*delayedUpdate{u}, q.updates...)
+	case len(q.updates) >= l.delay.MaxDepth:
+		return false
+	default:
+		q.updates = append(q.updates, u)
+	}
+
+	if q.timer == nil {
+		q.timer = time.AfterFunc(l.delayWait(key), func() {
+			l.drainDelayed(key)
+		})
+	}
+
+	return true
+}
+
+// drainDelayed will re-inject the first update of the queue of the key
+// into the dispatcher, and will schedule the next re-injection.
+func (l *Limiter) drainDelayed(key int64) {
+	l.delayMutex.Lock()
+	q := l.delayed[key]
+	if q == nil || l.delay == nil {
+		l.delayMutex.Unlock()
+		return
+	}
+
+	q.timer = nil
+	for len(q.updates) != 0 && time.Since(q.updates[0].queuedAt) > l.delay.MaxAge {
+		q.updates = q.updates[1:]
+	}
+
+	if len(q.updates) == 0 {
+		delete(l.delayed, key)
+		l.delayMutex.Unlock()
+		return
+	}
+
+	next := func() {
+		l.drainDelayed(key)
+	}
+
+	if wait := l.delayWait(key); wait > 0 {
+		// the key is still limited.
+		q.timer = time.AfterFunc(wait, next)
+		l.delayMutex.Unlock()
+		return
+	}
+
+	u := q.updates[0]
+	q.updates = q.updates[1:]
+	if len(q.updates) != 0 {
+		q.timer = time.AfterFunc(l.delayInterval(), next)
+	} else {
+		delete(l.delayed, key)
+	}
+	l.delayMutex.Unlock()
+
+	if !l.isAttached(u.dispatcher) {
+		// the limiter has been detached from the dispatcher of the
+		// update in the meantime, so the update is dropped.
+		return
+	}
+
+	_ = u.dispatcher.ProcessUpdate(u.bot, u.update, map[string]interface{}{
+		ContextDelayedKey: u.queuedAt,
+	})
+}
+
+// delayWait returns the amount of time remained until the key is
+// released; it returns zero if the key is not limited.
+func (l *Limiter) delayWait(key int64) time.Duration {
+	snapshot := l.core.GetSnapshot(key)
+	if snapshot == nil || !snapshot.Limited {
+		return 0
+	}
+
+	if snapshot.LimitedUntil == nil {
+		return l.delayInterval()
+	}
+
+	// the key is released by its next update after this time.
+	return time.Until(*snapshot.LimitedUntil) + time.Millisecond
+}
+
+// delayInterval returns the interval between the re-injected updates,
+// which is the pace allowed by the default profile of the limiter.
+func (l *Limiter) delayInterval() time.Duration {
+	p := l.core.GetProfile()
+	if p.MessageCount <= 0 {
+		// the profile can be changed at runtime without validation.
+		return p.Timeout
+	}
+
+	return p.Timeout / time.Duration(p.MessageCount)
+}
+
+// clearDelayed will drop all of the queued updates of the delay mode.
+func (l *Limiter) clearDelayed() {
+	l.delayMutex.Lock()
+	for key, q := range l.delayed {
+		if q.timer != nil {
+			q.timer.Stop()
+		}
+
+		delete(l.delayed, key)
+	}
+	l.delayMutex.Unlock()
+}
+
+// SetCallbackDebounce will make the limiter drop the repeated presses of
+// the same button (the same callback data) by the same user in the given
+// interval, independent of the quota of the user; the dropped presses
+// don't count toward the quota. if toast is not empty, the dropped
+// presses are answered with it. pass zero to disable the debounce.
+// NOTICE: callback queries are only checked if `ConsiderInline` has
+// been set to true in the config of the limiter.
+func (l *Limiter) SetCallbackDebounce(interval time.Duration, toast string) {
+	l.debounceMutex.Lock()
+	defer l.debounceMutex.Unlock()
+
+	if interval <= 0 {
+		l.debounceInterval = 0
+		l.debounceToast = ""
+		l.presses = nil
+		return
+	}
+
+	l.debounceInterval = interval
+	l.debounceToast = toast
+	if l.presses == nil {
+		l.presses = make(map[pressKey]time.Time)
+	}
+}
+
+// GetCallbackDebounce returns the debounce interval of the button
+// presses and the toast the dropped presses are answered with.
+func (l *Limiter) GetCallbackDebounce() (time.Duration, string) {
+	l.debounceMutex.Lock()
+	defer l.debounceMutex.Unlock()
+
+	return l.debounceInterval, l.debounceToast
+}
+
+// debounce returns true if the button has already been pressed by the
+// same user in the debounce interval; otherwise it will record the
+// press.
+func (l *Limiter) debounce(key int64, cq *gotgbot.CallbackQuery) bool {
+	l.debounceMutex.Lock()
+	defer l.debounceMutex.Unlock()
+
+	if l.debounceInterval == 0 {
+		return false
+	}
+
+	now := time.Now()
+	press := pressKey{userID: key, data: cq.Data}
+	if last, ok := l.presses[press]; ok && now.Sub(last) < l.debounceInterval {
+		return true
+	}
+
+	l.presses[press] = now
+	return false
+}
+
+// prunePresses will remove the button presses which are older than the
+// debounce interval.
+func (l *Limiter) prunePresses() {
+	l.debounceMutex.Lock()
+	for press, last := range l.presses {
+		if time.Since(last) >= l.debounceInterval {
+			delete(l.presses, press)
+		}
+	}
+	l.debounceMutex.Unlock()
+}
+
+// SetUpdateProfile will set the limit profile used for the given update
+// type, so each interaction channel (such as the edits, the button
+// presses or the reactions) can have its own thresholds. all of the
+// update types of a user still share the same status, so flooding
+// through one of them limits the user in all of them.
+// pass nil as profile to make the update type use the default limits.
+// NOTICE: the inline queries, the reactions, the reaction counts and
+// the boosts are only checked when they have a profile; the bot has to
+// request the "message_reaction", "message_reaction_count", "chat_boost"
+// and "removed_chat_boost" updates from telegram for limiting them.
+func (l *Limiter) SetUpdateProfile(updateType UpdateType, profile *LimitProfile) {
+	l.updateMutex.Lock()
+	if l.updateProfiles == nil {
+		l.updateProfiles = make(map[UpdateType]*LimitProfile)
+	}
+
+	if profile == nil {
+		delete(l.updateProfiles, updateType)
+	} else {
+		l.updateProfiles[updateType] = profile
+	}
+	l.updateMutex.Unlock()
+}
+
+// GetUpdateProfile returns the limit profile of the given update type;
+// it will return nil if the update type is using the default limits.
+func (l *Limiter) GetUpdateProfile(updateType UpdateType) *LimitProfile {
+	l.updateMutex.RLock()
+	defer l.updateMutex.RUnlock()
+
+	return l.updateProfiles[updateType]
+}
+
+// AppendUpdateTriggers will append the triggers to the given update
+// type; when a user gets limited by an update of this type, its triggers
+// are run instead of the triggers of the limiter.
+func (l *Limiter) AppendUpdateTriggers(updateType UpdateType, t ...handlers.Response) {
+	l.updateMutex.Lock()
+	if l.updateTriggers == nil {
+		l.updateTriggers = make(map[UpdateType][]handlers.Response)
+	}
+
+	l.updateTriggers[updateType] = append(copySlice(l.updateTriggers[updateType]), t...)
+	l.updateMutex.Unlock()
+}
+
+// ClearUpdateTriggers will remove the triggers of the given update type,
+// so the triggers of the limiter are run for it again.
+func (l *Limiter) ClearUpdateTriggers(updateType UpdateType) {
+	l.updateMutex.Lock()
+	delete(l.updateTriggers, updateType)
+	l.updateMutex.Unlock()
+}
+
+// getUpdateProfile returns the limit profile of the type of the update;
+// it returns nil if the update type is using the default limits.
+func (l *Limiter) getUpdateProfile(ctx *ext.Context) *LimitProfile {
+	l.updateMutex.RLock()
+	defer l.updateMutex.RUnlock()
+
+	if len(l.updateProfiles) == 0 {
+		return nil
+	}
+
+	return l.updateProfiles[getUpdateType(ctx)]
+}
+
+// getTriggers returns the triggers which should be run when the sender
+// of the update gets limited, or when their punishment is extended in
+// the strict mode if extension is true.
+func (l *Limiter) getTriggers(ctx *ext.Context, key int64, extension bool) []*Trigger {
+	l.updateMutex.RLock()
+	if len(l.updateTriggers) != 0 {
+		if triggers, ok := l.updateTriggers[getUpdateType(ctx)]; ok {
+			l.updateMutex.RUnlock()
+			if extension {
+				// the triggers of the update types have no filters.
+				return nil
+			}
+
+			selected := make([]*Trigger, 0, len(triggers))
+			for _, current := range triggers {
+				selected = append(selected, &Trigger{Response: current})
+			}
+
+			return selected
+		}
+	}
+	l.updateMutex.RUnlock()
+
+	triggers := l.triggers.load()
+	first := false
+	for _, current := range triggers {
+		if current.Filter&TriggerFirstOffense != 0 {
+			first = l.isFirstOffense(key, !extension)
+			break
+		}
+	}
+
+	var selected []*Trigger
+	for _, current := range triggers {
+		if !current.matches(ctx, extension, first) {
+			continue
+		}
+
+		selected = append(selected, current)
+		if current.Stop {
+			break
+		}
+	}
+
+	return selected
+}
+
+// startTriggers will run the immediate triggers of the key, and will
+// schedule the delayed ones and the ones waiting for the end of the
+// punishment of the key.
+func (l *Limiter) startTriggers(b *gotgbot.Bot, ctx *ext.Context, key int64, triggers []*Trigger) {
+	var immediate []handlers.Response
+	for _, current := range triggers {
+		if current.Delay <= 0 && current.Filter&TriggerRelease == 0 {
+			immediate = append(immediate, current.Response)
+			continue
+		}
+
+		l.scheduleTrigger(b, ctx, key, current)
+	}
+
+	// check for triggers length to prevent from creating
+	// a new goroutine in the case we have no triggers.
+	if len(immediate) != 0 {
+		go l.runTriggers(b, ctx, immediate)
+	}
+}
+
+// scheduleTrigger will add the trigger to the pending triggers of the
+// key; its delay starts now, unless it's waiting for the end of the
+// punishment of the key.
+func (l *Limiter) scheduleTrigger(b *gotgbot.Bot, ctx *ext.Context, key int64, t *Trigger) {
+	l.triggerMutex.Lock()
+	defer l.triggerMutex.Unlock()
+
+	if l.pendingTriggers == nil {
+		l.pendingTriggers = make(map[int64][]*pendingTrigger)
+	}
+
+	pending := &pendingTrigger{
+		bot:     b,
+		ctx:     ctx,
+		trigger: t,
+	}
+	if t.Filter&TriggerRelease == 0 {
+		l.startTriggerTimer(key, pending)
+	}
+
+	l.pendingTriggers[key] = append(l.pendingTriggers[key], pending)
+}
+
+// startTriggerTimer will start the delay of the pending trigger; the
+// trigger mutex should be held by the caller.
+func (l *Limiter) startTriggerTimer(key int64, pending *pendingTrigger) {
+	pending.timer = time.AfterFunc(pending.trigger.Delay, func() {
+		if l.takeTrigger(key, pending) {
+			_ = pending.trigger.Response(pending.bot, pending.ctx)
+		}
+	})
+}
+
+// takeTrigger will remove the pending trigger of the key; it returns
+// false if the trigger has been cancelled in the meantime.
+func (l *Limiter) takeTrigger(key int64, pending *pendingTrigger) bool {
+	l.triggerMutex.Lock()
+	defer l.triggerMutex.Unlock()
+
+	triggers := l.pendingTriggers[key]
+	for i, current := range triggers {
+		if current != pending {
+			continue
+		}
+
+		if len(triggers) == 1 {
+			delete(l.pendingTriggers, key)
+		} else {
+			l.pendingTriggers[key] = append(copySlice(triggers[:i]), triggers[i+1:]...)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// releaseTriggers will start the delay of the pending triggers of the
+// key which are waiting for the end of its punishment.
+func (l *Limiter) releaseTriggers(key int64) {
+	l.triggerMutex.Lock()
+	defer l.triggerMutex.Unlock()
+
+	for _, pending := range l.pendingTriggers[key] {
+		if pending.timer == nil {
+			l.startTriggerTimer(key, pending)
+		}
+	}
+}
+
+// cancelTriggers will cancel all of the pending triggers of the key.
+func (l *Limiter) cancelTriggers(key int64) {
+	l.triggerMutex.Lock()
+	defer l.triggerMutex.Unlock()
+
+	for _, pending := range l.pendingTriggers[key] {
+		if pending.timer != nil {
+			pending.timer.Stop()
+		}
+	}
+
+	delete(l.pendingTriggers, key)
+}
+
+// prunePendingTriggers will remove the triggers waiting for the end of
+// the punishment of the keys which are not tracked anymore (such as the
+// evicted ones), as their punishment never ends.
+func (l *Limiter) prunePendingTriggers() {
+	l.triggerMutex.Lock()
+	defer l.triggerMutex.Unlock()
+
+	for key, triggers := range l.pendingTriggers {
+		if l.core.GetSnapshot(key) != nil {
+			continue
+		}
+
+		var remained []*pendingTrigger
+		for _, pending := range triggers {
+			if pending.timer != nil {
+				remained = append(remained, pending)
+			}
+		}
+
+		if len(remained) == 0 {
+			delete(l.pendingTriggers, key)
+		} else {
+			l.pendingTriggers[key] = remained
+		}
+	}
+}
+
+// clearPendingTriggers will cancel all of the pending triggers.
+func (l *Limiter) clearPendingTriggers() {
+	l.triggerMutex.Lock()
+	for _, triggers := range l.pendingTriggers {
+		for _, pending := range triggers {
+			if pending.timer != nil {
+				pending.timer.Stop()
+			}
+		}
+	}
+	l.pendingTriggers = nil
+	l.triggerMutex.Unlock()
+}
+
+// isFirstOffense returns true if the current offense of the key is its
+// first one in the offense window; record should be true if the key has
+// been newly limited, so the offense is recorded.
+func (l *Limiter) isFirstOffense(key int64, record bool) bool {
+	l.offenseMutex.Lock()
+	defer l.offenseMutex.Unlock()
+
+	offense := l.offenses[key]
+	if time.Since(offense.last) > DefaultOffenseWindow {
+		offense = offenseRecord{}
+	}
+
+	if record {
+		offense.count++
+		offense.last = time.Now()
+		if l.offenses == nil {
+			l.offenses = make(map[int64]offenseRecord)
+		}
+
+		l.offenses[key] = offense
+	}
+
+	return offense.count <= 1
+}
+
+// pruneOffenses will remove the offenses which are out of the offense
+// window.
+func (l *Limiter) pruneOffenses() {
+	l.offenseMutex.Lock()
+	for key, offense := range l.offenses {
+		if time.Since(offense.last) > DefaultOffenseWindow {
+			delete(l.offenses, key)
+		}
+	}
+	l.offenseMutex.Unlock()
+}
+
+// AddProbation will put a user in probation mode in the chat manually,
+// as if they have just joined the chat.
+func (l *Limiter) AddProbation(chatID, userID int64) {
+	l.mutex.Lock()
+	if l.joinedUsers != nil {
+		l.joinedUsers[roleKey{chatID: chatID, userID: userID}] = time.Now()
+	}
+	l.mutex.Unlock()
+}
+
+// RemoveProbation will graduate a user from probation mode in the chat
+// before their probation time is passed.
+func (l *Limiter) RemoveProbation(chatID, userID int64) {
+	l.mutex.Lock()
+	delete(l.joinedUsers, roleKey{chatID: chatID, userID: userID})
+	l.mutex.Unlock()
+}
+
+// IsOnProbation returns true if and only if the user has joined the chat
+// recently and is still being checked with the probation profile there;
+// joining a chat doesn't put the user in probation mode in the others.
+func (l *Limiter) IsOnProbation(chatID, userID int64) bool {
+	if l.GetProbationProfile() == nil {
+		return false
+	}
+
+	l.mutex.RLock()
+	joined, ok := l.joinedUsers[roleKey{chatID: chatID, userID: userID}]
+	l.mutex.RUnlock()
+
+	return ok && time.Since(joined) < l.getProbationDuration()
+}
+
+// SetTier will assign a tier to the specified user (or chat) id.
+// if `l.ConsiderUser` is set to `true`, the id should be the id of the
+// user; otherwise you should use the id of the chat.
+// Manually assigned tiers take precedence over the tier resolver.
+func (l *Limiter) SetTier(id int64, tier Tier) {
+	l.tierMutex.Lock()
+	if l.tiers == nil {
+		l.tiers = make(map[int64]Tier)
+	}
+	l.tiers[id] = tier
+	l.tierMutex.Unlock()
+}
+
+// RemoveTier will remove the manually assigned tier of the id, so the
+// tier resolver (if any) will decide about its tier again.
+func (l *Limiter) RemoveTier(id int64) {
+	l.tierMutex.Lock()
+	delete(l.tiers, id)
+	l.tierMutex.Unlock()
+}
+
+// GetTier returns the manually assigned tier of the id.
+// it will return `TierNormal` if the id has no assigned tier.
+func (l *Limiter) GetTier(id int64) Tier {
+	l.tierMutex.RLock()
+	defer l.tierMutex.RUnlock()
+
+	return l.tiers[id]
+}
+
+// SetTierProfile will set the limit profile used for the given tier.
+// pass nil as profile to make the tier use the default limits.
+func (l *Limiter) SetTierProfile(tier Tier, profile *LimitProfile) {
+	l.tierMutex.Lock()
+	if l.tierProfiles == nil {
+		l.tierProfiles = make(map[Tier]*LimitProfile)
+	}
+
+	if profile == nil {
+		delete(l.tierProfiles, tier)
+	} else {
+		l.tierProfiles[tier] = profile
+	}
+	l.tierMutex.Unlock()
+}
+
+// GetTierProfile returns the limit profile of the given tier; it will
+// return nil if the tier is using the default limits.
+func (l *Limiter) GetTierProfile(tier Tier) *LimitProfile {
+	l.tierMutex.RLock()
+	defer l.tierMutex.RUnlock()
+
+	return l.tierProfiles[tier]
+}
+
+// SetTierResolver will set the tier resolver function of this limiter.
+// The resolver is called for every checked update whose key doesn't
+// have any manually assigned tier.
+func (l *Limiter) SetTierResolver(resolver TierResolver) {
+	l.tierMutex.Lock()
+	l.tierResolver = resolver
+	l.tierMutex.Unlock()
+}
+
+// getTierProfile returns the limit profile of the tier of the given
+// update; it will return nil if the default limits should be used.
+func (l *Limiter) getTierProfile(ctx *ext.Context, id int64) *LimitProfile {
+	l.tierMutex.RLock()
+	if len(l.tierProfiles) == 0 {
+		l.tierMutex.RUnlock()
+		return nil
+	}
+
+	tier, ok := l.tiers[id]
+	resolver := l.tierResolver
+	l.tierMutex.RUnlock()
+
+	// the resolver is called outside of the lock, so it can safely
+	// use the tier methods of the limiter itself.
+	if !ok && resolver != nil {
+		tier = resolver(ctx)
+	}
+
+	return l.GetTierProfile(tier)
+}
+
+// SetScoreFunc will set the function used to score the checked updates,
+// so external classifiers (or heuristics) can be integrated with the
+// limiter; the updates with a score above the threshold (see
+// `SetScoreThreshold`) are punished by the limiter.
+// pass nil to disable the scoring.
+func (l *Limiter) SetScoreFunc(f ScoreFunc) {
+	l.hookMutex.Lock()
+	l.scoreFunc = f
+	l.hookMutex.Unlock()
+}
+
+// SetScoreThreshold will set the score above which the updates are
+// punished; such updates consume `extraCost` more units of the quota
+// of their sender. if `extraCost` is zero (or negative), they will limit
+// their sender immediately.
+func (l *Limiter) SetScoreThreshold(threshold float64, extraCost int) {
+	if extraCost < 0 {
+		extraCost = 0
+	}
+
+	l.hookMutex.Lock()
+	l.scoreThreshold = threshold
+	l.scoreCost = extraCost
+	l.hookMutex.Unlock()
+}
+
+// GetScoreThreshold returns the score threshold and the extra cost of
+// the updates above it.
+func (l *Limiter) GetScoreThreshold() (float64, int) {
+	l.hookMutex.RLock()
+	defer l.hookMutex.RUnlock()
+
+	return l.scoreThreshold, l.scoreCost
+}
+
+// SetCostFunc will set the function used to determine the amount of
+// quota units consumed by each checked update, so for example long
+// messages or expensive commands can cost more than the others.
+// non-positive costs are considered as 1; pass nil to make every update
+// cost 1 unit again.
+func (l *Limiter) SetCostFunc(f CostFunc) {
+	l.hookMutex.Lock()
+	l.costFunc = f
+	l.hookMutex.Unlock()
+}
+
+// SetDecisionHook will set the function which is called with the
+// decision of the limiter about each checked update, after all of the
+// built-in logic has run; the returned decision will be used instead,
+// so the hook can veto a limit or force one.
+// vetoing a decision which has newly limited the key will free the key
+// from its limitation; vetoing the decision of an already limited key
+// only lets that single update through.
+// forcing the limit of an allowed update will limit its key immediately.
+// pass nil to remove the hook.
+func (l *Limiter) SetDecisionHook(hook DecisionHook) {
+	l.hookMutex.Lock()
+	l.decisionHook = hook
+	l.hookMutex.Unlock()
+}
+
+// applyDecisionHook returns the decision of the update after passing it
+// to the decision hook (if any), and applies the changes made by the
+// hook to the state of the key.
+func (l *Limiter) applyDecisionHook(ctx *ext.Context, key int64, d core.Decision) core.Decision {
+	l.hookMutex.RLock()
+	hook := l.decisionHook
+	l.hookMutex.RUnlock()
+
+	if hook == nil {
+		return d
+	}
+
+	final := hook(ctx, d)
+	switch {
+	case d.IsAllowed() == final.IsAllowed():
+	case final.IsAllowed():
+		if d.NewlyLimited {
+			l.core.Unlimit(key)
+		}
+		final.NewlyLimited = false
+	default:
+		if d.Result != core.ResultLimited {
+			l.core.Limit(key)
+			final.Result = core.ResultLimited
+			final.NewlyLimited = true
+		}
+	}
+
+	return final
+}
+
+// getCost returns the cost of the update; it returns true as the second
+// value if the update should limit its sender immediately.
+func (l *Limiter) getCost(ctx *ext.Context) (int, bool) {
+	l.hookMutex.RLock()
+	costFunc, scoreFunc := l.costFunc, l.scoreFunc
+	threshold, extraCost := l.scoreThreshold, l.scoreCost
+	l.hookMutex.RUnlock()
+
+	cost := 1
+	if costFunc != nil {
+		if c := costFunc(ctx); c > 0 {
+			cost = c
+		}
+	}
+
+	if scoreFunc == nil || scoreFunc(ctx) <= threshold {
+		return cost, false
+	}
+
+	if extraCost == 0 {
+		return cost, true
+	}
+
+	return cost + extraCost, false
+}
+
+// SetRisk will enable the account heuristics of the limiter; the risk
+// signals of the senders (such as new accounts or missing usernames) raise
+// the cost of their updates, or lower their max message count if
+// `LowerThreshold` is set. zero values of the config are replaced by the
+// default values. pass nil to disable the heuristics.
+func (l *Limiter) SetRisk(config *RiskConfig) {
+	if config != nil {
+		config = normalizeRisk(config)
+	}
+
+	l.hookMutex.Lock()
+	l.risk = config
+	l.hookMutex.Unlock()
+}
+
+// GetRisk returns the configuration of the account heuristics; it
+// returns nil if the heuristics are disabled.
+func (l *Limiter) GetRisk() *RiskConfig {
+	l.hookMutex.RLock()
+	defer l.hookMutex.RUnlock()
+
+	return l.risk
+}
+
+// GetRiskScore returns the risk of the user according to the account
+// heuristics of the limiter; it returns 0 if the heuristics are disabled.
+func (l *Limiter) GetRiskScore(user *gotgbot.User) int {
+	config := l.GetRisk()
+	if config == nil {
+		return 0
+	}
+
+	return config.Score(user)
+}
+
+// applyRisk will apply the risk of the sender of the update to the
+// request, either to its cost or to the max message count of its profile.
+func (l *Limiter) applyRisk(ctx *ext.Context, r *core.Request) {
+	config := l.GetRisk()
+	if config == nil || ctx.EffectiveUser == nil {
+		return
+	}
+
+	risk := config.Score(ctx.EffectiveUser)
+	if risk == 0 {
+		return
+	}
+
+	if !config.LowerThreshold {
+		r.Cost += risk
+		if r.Cost < 1 {
+			r.Cost = 1
+		}
+		return
+	}
+
+	p := r.Profile
+	if p == nil {
+		p = l.getDefaultProfile()
+	}
+
+	lowered := *p
+	lowered.MessageCount -= risk
+	if lowered.MessageCount < 1 {
+		lowered.MessageCount = 1
+	}
+
+	r.Profile = &lowered
+}
+
+// SetRoleProfile will set the limit profile used for the users with
+// the given chat member status, so for example the restricted users can
+// be throttled harder than the others automatically.
+// pass nil as profile to make the role use the default limits.
+// NOTICE: the roles are resolved using `getChatMember` method of the
+// telegram api (and are cached), so the bot has to be a member of the
+// chats.
+func (l *Limiter) SetRoleProfile(role Role, profile *LimitProfile) {
+	l.roleMutex.Lock()
+	if l.roleProfiles == nil {
+		l.roleProfiles = make(map[Role]*LimitProfile)
+	}
+
+	if profile == nil {
+		delete(l.roleProfiles, role)
+	} else {
+		l.roleProfiles[role] = profile
+	}
+	l.roleMutex.Unlock()
+}
+
+// GetRoleProfile returns the limit profile of the given role; it will
+// return nil if the role is using the default limits.
+func (l *Limiter) GetRoleProfile(role Role) *LimitProfile {
+	l.roleMutex.RLock()
+	defer l.roleMutex.RUnlock()
+
+	return l.roleProfiles[role]
+}
+
+// SetRoleCacheTime will set the amount of time the resolved roles of
+// the users are cached. zero means `DefaultRoleCacheTime`.
+func (l *Limiter) SetRoleCacheTime(d time.Duration) {
+	if d <= 0 {
+		d = DefaultRoleCacheTime
+	}
+
+	l.roleMutex.Lock()
+	l.roleCacheTime = d
+	l.roleMutex.Unlock()
+}
+
+// SetRole will set the role of the user in the chat manually, it will be
+// cached as if it has been resolved from the telegram api.
+func (l *Limiter) SetRole(chatID, userID int64, role Role) {
+	l.roleMutex.Lock()
+	if l.roles == nil {
+		l.roles = make(map[roleKey]*cachedRole)
+	}
+
+	l.roles[roleKey{chatID: chatID, userID: userID}] = &cachedRole{
+		role:      role,
+		expiresAt: time.Now().Add(l.roleCacheTime),
+	}
+	l.roleMutex.Unlock()
+}
+
+// ClearRoleCache will remove all of the cached roles.
+func (l *Limiter) ClearRoleCache() {
+	l.roleMutex.Lock()
+	l.roles = nil
+	l.roleMutex.Unlock()
+}
+
+// getRole returns the role of the user in the chat, resolving it using
+// the telegram api if it's not cached. b can be nil, in which case only
+// the cached roles are returned.
+func (l *Limiter) getRole(b *gotgbot.Bot, chatID, userID int64) Role {
+	key := roleKey{chatID: chatID, userID: userID}
+
+	l.roleMutex.RLock()
+	cached := l.roles[key]
+	l.roleMutex.RUnlock()
+
+	if cached != nil && time.Now().Before(cached.expiresAt) {
+		return cached.role
+	}
+
+	if b == nil {
+		return ""
+	}
+
+	// the failed lookups are cached as well (with an empty role), so
+	// we won't send a request for every message of the user.
+	var role Role
+	member, err := b.GetChatMember(chatID, userID, nil)
+	if err == nil {
+		role = Role(member.GetStatus())
+	}
+
+	l.SetRole(chatID, userID, role)
+	return role
+}
+
+// getRoleProfile returns the limit profile of the role of the sender of
+// the update; it will return nil if the default limits should be used.
+func (l *Limiter) getRoleProfile(b *gotgbot.Bot, ctx *ext.Context) *LimitProfile {
+	if !l.hasRoleProfiles() || ctx.EffectiveChat == nil || ctx.EffectiveUser == nil ||
+		getSenderChat(ctx.EffectiveMessage) != nil {
+		return nil
+	}
+
+	chat := ctx.EffectiveChat
+	if chat.Type != "group" && chat.Type != "supergroup" {
+		return nil
+	}
+
+	role := l.getRole(b, chat.Id, ctx.EffectiveUser.Id)
+	if role == "" {
+		return nil
+	}
+
+	return l.GetRoleProfile(role)
+}
+
+// hasRoleProfiles returns true if there is any role profile set.
+func (l *Limiter) hasRoleProfiles() bool {
+	l.roleMutex.RLock()
+	defer l.roleMutex.RUnlock()
+
+	return len(l.roleProfiles) != 0
+}
+
+// getProfile returns the limit profile which should be applied to
+// the given update; it returns nil if the default profile should be
+// applied. b can be nil, in which case the roles are only resolved
+// from the cache.
+func (l *Limiter) getProfile(b *gotgbot.Bot, ctx *ext.Context, id int64) *LimitProfile {
+	if p := l.getTierProfile(ctx, id); p != nil {
+		return p
+	}
+
+	if p := l.GetChannelSenderProfile(); p != nil && l.getFlags().LimitChannelSenders &&
+		getSenderChat(ctx.EffectiveMessage) != nil {
+		return p
+	}
+
+	if p := l.GetChannelPostProfile(); p != nil && isChannelPost(ctx) {
+		return p
+	}
+
+	if p := l.GetCommentProfile(); p != nil && l.IsComment(ctx.EffectiveMessage) {
+		return p
+	}
+
+	if l.isCountedCommand(ctx) {
+		return l.GetCommandProfile()
+	}
+
+	if p := l.getUpdateProfile(ctx); p != nil {
+		return p
+	}
+
+	if p := l.getRoleProfile(b, ctx); p != nil {
+		return p
+	}
+
+	if l.getFlags().ConsiderUser && ctx.EffectiveUser != nil && ctx.EffectiveChat != nil &&
+		l.IsOnProbation(ctx.EffectiveChat.Id, ctx.EffectiveUser.Id) {
+		if p := l.GetProbationProfile(); p != nil {
+			return p
+		}
+	}
+
+	return l.getChatProfile(ctx)
+}
+
+// getDefaultProfile returns the default limit profile of this limiter.
+func (l *Limiter) getDefaultProfile() *LimitProfile {
+	p := l.core.GetProfile()
+	return &p
+}
+
+// getChatProfile returns the default limit profile of this limiter
+// with the overrides of the chat's settings applied to it; it returns
+// nil if the chat has no overrides, so the default profile doesn't have
+// to be copied for every single update.
+func (l *Limiter) getChatProfile(ctx *ext.Context) *LimitProfile {
+	if ctx.EffectiveChat == nil {
+		return nil
+	}
+
+	settings := l.getChatSettings(ctx.EffectiveChat.Id)
+	if settings == nil || (settings.Timeout <= 0 && settings.PunishmentTime <= 0 &&
+		settings.MessageCount <= 0) {
+		return nil
+	}
+
+	p := l.getDefaultProfile()
+
+	if settings.Timeout > 0 {
+		p.Timeout = settings.Timeout
+	}
+
+	if settings.PunishmentTime > 0 {
+		p.PunishmentTime = settings.PunishmentTime
+	}
+
+	if settings.MessageCount > 0 {
+		p.MessageCount = settings.MessageCount
+	}
+
+	return p
+}
+
+// getChallengeConfig returns the challenge configuration which should be
+// used for the given chat; it returns nil if no challenge should be sent.
+func (l *Limiter) getChallengeConfig(chat *gotgbot.Chat) *ChallengeConfig {
+	challenge := l.challenge.Load()
+	if chat == nil {
+		return challenge
+	}
+
+	settings := l.getChatSettings(chat.Id)
+	if settings == nil {
+		return challenge
+	}
+
+	switch settings.Action {
+	case ActionIgnore:
+		return nil
+	case ActionChallenge:
+		if challenge == nil {
+			return normalizeChallenge(&ChallengeConfig{})
+		}
+	}
+
+	return challenge
+}
+
+// SetFederation will make the limiter a member of a federation; the
+// members of a federation share their currently limited (and banned)
+// users through the shared storage of the federation, and each of them
+// applies the verdicts of the trusted members locally, as custom ignores
+// of the reported users. the verdicts are exported and applied by a
+// separate goroutine while the limiter is running (see the sync interval
+// and timeout of the config), or by `SyncFederation`.
+// the remote verdicts are lifted as soon as they're revoked (or fall
+// below the quorum of the trust policy). pass nil to leave the
+// federation; the remote verdicts which have been applied so far are
+// kept until they expire in that case.
+// NOTICE: the verdicts are about the users, so the federation is only
+// useful if `ConsiderUser` is true; the limited users are only exported
+// if the keys of the limiter are not scoped by the bot.
+func (l *Limiter) SetFederation(config *FederationConfig) error {
+	if err := validateFederation(config); err != nil {
+		return err
+	}
+
+	l.federationMutex.Lock()
+	defer l.federationMutex.Unlock()
+
+	if config != nil {
+		c := *config
+		config = &c
+	}
+
+	l.federation = config
+	l.exported = nil
+	l.federated = nil
+
+	l.federationSyncMutex.Lock()
+	defer l.federationSyncMutex.Unlock()
+
+	l.stopFederationSync()
+	if config != nil && l.isEnabled.Load() && !l.isStopped.Load() {
+		l.startFederationSync(config)
+	}
+
+	return nil
+}
+
+// GetFederation returns the configuration of the federation of the
+// limiter; it returns nil if the limiter is not a member of any
+// federation.
+func (l *Limiter) GetFederation() *FederationConfig {
+	l.federationMutex.Lock()
+	defer l.federationMutex.Unlock()
+
+	return l.federation
+}
+
+// FederateBan will export a ban verdict about the user to the federation
+// of the limiter, so the other members apply it as well; zero duration
+// means the verdict stays until it's revoked by `RevokeVerdict`.
+func (l *Limiter) FederateBan(userID int64, d time.Duration) error {
+	verdict := &FederationVerdict{
+		UserID: userID,
+		Banned: true,
+	}
+
+	if d > 0 {
+		verdict.Until = time.Now().Add(d)
+	}
+
+	l.federationMutex.Lock()
+	defer l.federationMutex.Unlock()
+
+	return l.exportVerdict(verdict)
+}
+
+// RevokeVerdict will remove the verdict of the limiter about the user
+// from its federation.
+func (l *Limiter) RevokeVerdict(userID int64) error {
+	l.federationMutex.Lock()
+	defer l.federationMutex.Unlock()
+
+	config := l.federation
+	if config == nil || config.Name == "" {
+		return ErrNoFederation
+	}
+
+	delete(l.exported, userID)
+	return config.Storage.Delete(federationKey(config.Name, userID))
+}
+
+// IsFederated returns true if a remote verdict about the user is applied
+// by the limiter.
+func (l *Limiter) IsFederated(userID int64) bool {
+	l.federationMutex.Lock()
+	defer l.federationMutex.Unlock()
+
+	until, ok := l.federated[userID]
+	return ok && (until.IsZero() || time.Now().Before(until))
+}
+
+// SyncFederation will export the verdicts of the limiter to its
+// federation, and applies the trusted verdicts of the other members
+// locally; it's called by the sync goroutine of the federation
+// automatically while the limiter is running.
+func (l *Limiter) SyncFederation() error {
+	l.federationMutex.Lock()
+	defer l.federationMutex.Unlock()
+
+	config := l.federation
+	if config == nil {
+		return nil
+	}
+
+	if config.Name != "" {
+		if err := l.exportLimited(); err != nil {
+			return err
+		}
+	}
+
+	return l.applyVerdicts()
+}
+
+// startFederationSync will start the sync goroutine of the federation;
+// federationSyncMutex should be held by the caller.
+func (l *Limiter) startFederationSync(config *FederationConfig) {
+	interval := config.SyncInterval
+	if interval == 0 {
+		interval = l.GetCheckerInterval()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.federationCancel = cancel
+	l.federationDone = make(chan struct{})
+	go l.federationSyncer(ctx, config, interval, l.federationDone)
+}
+
+// stopFederationSync will stop the sync goroutine of the federation and
+// waits for it to return; federationSyncMutex should be held by the
+// caller.
+func (l *Limiter) stopFederationSync() {
+	if l.federationCancel == nil {
+		return
+	}
+
+	l.federationCancel()
+	<-l.federationDone
+	l.federationCancel = nil
+	l.federationDone = nil
+}
+
+// federationSyncer should be run in a new goroutine; it syncs the
+// federation once per interval until ctx is done, and closes the done
+// channel when it returns.
+func (l *Limiter) federationSyncer(ctx context.Context, config *FederationConfig, interval time.Duration, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.syncFederation(ctx, config)
+		}
+	}
+}
+
+// syncFederation will sync the federation once, and reports its error
+// to the error handler of the config and to the notifiers; the sync is
+// abandoned (not waited for) if it takes longer than the sync timeout,
+// or if ctx is done meanwhile.
+func (l *Limiter) syncFederation(ctx context.Context, config *FederationConfig) {
+	if !l.federationSyncing.CompareAndSwap(false, true) {
+		// the previous sync has timed out, but it's still running.
+		return
+	}
+
+	timeout := config.SyncTimeout
+	if timeout == 0 {
+		timeout = DefaultFederationSyncTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		defer l.federationSyncing.Store(false)
+		result <- l.SyncFederation()
+	}()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			err = ErrFederationTimeout
+		}
+	}
+
+	if err == nil {
+		return
+	}
+
+	if config.OnError != nil {
+		config.OnError(err)
+	}
+
+	l.notifyError(EventFederationError, err)
+}
+
+// exportVerdict will write the verdict of the limiter to the storage of
+// its federation; federationMutex should be held by the caller.
+func (l *Limiter) exportVerdict(verdict *FederationVerdict) error {
+	config := l.federation
+	if config == nil || config.Name == "" {
+		return ErrNoFederation
+	}
+
+	verdict.Member = config.Name
+	data, err := json.Marshal(verdict)
+	if err != nil {
+		return err
+	}
+
+	err = config.Storage.Set(federationKey(config.Name, verdict.UserID), data)
+	if err != nil {
+		return err
+	}
+
+	if l.exported == nil {
+		l.exported = make(map[int64]*FederationVerdict)
+	}
+
+	l.exported[verdict.UserID] = verdict
+	return nil
+}
+
+// exportLimited will export the currently limited users of the limiter
+// to its federation, and removes the verdicts which are over;
+// federationMutex should be held by the caller.
+func (l *Limiter) exportLimited() error {
+	limited := make(map[int64]bool)
+	if l.getFlags().ConsiderUser && !l.getFlags().ScopeByBot {
+		for _, id := range l.core.ListLimited() {
+			snapshot := l.core.GetSnapshot(id)
+			if id <= 0 || snapshot == nil || snapshot.LimitedUntil == nil {
+				// the channels and the chats are not federated.
+				continue
+			}
+
+			limited[id] = true
+			old := l.exported[id]
+			if old != nil && (old.Banned || old.Until.Equal(*snapshot.LimitedUntil)) {
+				continue
+			}
+
+			err := l.exportVerdict(&FederationVerdict{
+				UserID: id,
+				Until:  *snapshot.LimitedUntil,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	now := time.Now()
+	for id, verdict := range l.exported {
+		expired := !verdict.Until.IsZero() && now.After(verdict.Until)
+		if expired || (!verdict.Banned && !limited[id]) {
+			if err := l.federation.Storage.Delete(federationKey(verdict.Member, id)); err != nil {
+				return err
+			}
+
+			delete(l.exported, id)
+		}
+	}
+
+	return nil
+}
+
+// applyVerdicts will apply the trusted verdicts of the other members of
+// the federation locally, and lifts the ones which have been revoked;
+// federationMutex should be held by the caller.
+func (l *Limiter) applyVerdicts() error {
+	config := l.federation
+	keys, err := config.Storage.Keys(federationPrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	reports := make(map[int64]int)
+	ends := make(map[int64]time.Time)
+	for _, key := range keys {
+		member, userID, ok := parseFederationKey(key)
+		if !ok || member == config.Name || !config.Trust.trusts(member) {
+			continue
+		}
+
+		data, err := config.Storage.Get(key)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				// the verdict has been revoked meanwhile.
+				continue
+			}
+
+			return err
+		}
+
+		verdict := new(FederationVerdict)
+		if json.Unmarshal(data, verdict) != nil {
+			continue
+		}
+
+		if !verdict.Until.IsZero() && now.After(verdict.Until) {
+			continue
+		}
+
+		if !verdict.Banned && config.Trust != nil && config.Trust.BansOnly {
+			continue
+		}
+
+		end, seen := ends[userID]
+		if !seen || (!end.IsZero() && (verdict.Until.IsZero() || verdict.Until.After(end))) {
+			ends[userID] = verdict.Until
+		}
+
+		reports[userID]++
+	}
+
+	if l.federated == nil {
+		l.federated = make(map[int64]time.Time)
+	}
+
+	for userID, count := range reports {
+		if count < config.Trust.quorum() {
+			delete(ends, userID)
+			continue
+		}
+
+		end := config.Trust.capEnd(now, ends[userID])
+		if old, ok := l.federated[userID]; ok && old.Equal(end) {
+			continue
+		}
+
+		var d time.Duration
+		if !end.IsZero() {
+			d = end.Sub(now)
+		}
+
+		l.AddCustomIgnore(userID, d, false)
+		l.federated[userID] = end
+	}
+
+	for userID, end := range l.federated {
+		if _, ok := ends[userID]; ok {
+			continue
+		}
+
+		if end.IsZero() || now.Before(end) {
+			// the verdict has been revoked by its members.
+			l.RemoveCustomIgnore(userID)
+		}
+
+		delete(l.federated, userID)
+	}
+
+	return nil
+}
+
+// Lookup calls f(userID).
+func (f ReputationFunc) Lookup(userID int64) (Reputation, error) {
+	return f(userID)
+}
+
+// trusts returns true if the member of the federation is trusted by the
+// policy; nil policy trusts all of the members.
+func (t *TrustPolicy) trusts(member string) bool {
+	if t == nil || len(t.Members) == 0 {
+		return true
+	}
+
+	for _, m := range t.Members {
+		if m == member {
+			return true
+		}
+	}
+
+	return false
+}
+
+// quorum returns the number of the members which have to report a user
+// before their verdict is applied.
+func (t *TrustPolicy) quorum() int {
+	if t == nil || t.Quorum < 1 {
+		return 1
+	}
+
+	return t.Quorum
+}
+
+// capEnd returns the end of a remote verdict capped by the max duration
+// of the policy.
+func (t *TrustPolicy) capEnd(now, end time.Time) time.Time {
+	if t == nil || t.MaxDuration == 0 {
+		return end
+	}
+
+	max := now.Add(t.MaxDuration)
+	if end.IsZero() || end.After(max) {
+		return max
+	}
+
+	return end
+}
+
+// SetStorage will set the storage backend of this limiter, which is
+// used for persisting the data of the limiter, such as chat settings.
+func (l *Limiter) SetStorage(s storage.Storage) {
+	if s == nil {
+		l.storage.Store(nil)
+		return
+	}
+
+	l.storage.Store(&s)
+}
+
+// GetStorage returns the storage backend of this limiter.
+func (l *Limiter) GetStorage() storage.Storage {
+	if s := l.storage.Load(); s != nil {
+		return *s
+	}
+
+	return nil
+}
+
+// SetChatSettings will set the runtime settings of a chat, overriding
+// the default configuration of the limiter for that chat. if a storage
+// backend is set, the settings will be persisted as well.
+func (l *Limiter) SetChatSettings(settings *ChatSettings) error {
+	if settings == nil {
+		return nil
+	}
+
+	c := *settings
+	l.settingsMutex.Lock()
+	if l.chatSettings == nil {
+		l.chatSettings = make(map[int64]*ChatSettings)
+	}
+	l.chatSettings[c.ChatID] = &c
+	l.settingsMutex.Unlock()
+
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return err
+	}
+
+	return store.Set(chatSettingsKey(c.ChatID), data)
+}
+
+// GetChatSettings returns a copy of the runtime settings of the chat.
+// it will return nil if the chat has no specific settings.
+func (l *Limiter) GetChatSettings(chatID int64) *ChatSettings {
+	settings := l.getChatSettings(chatID)
+	if settings == nil {
+		return nil
+	}
+
+	c := *settings
+	return &c
+}
+
+// RemoveChatSettings will remove the runtime settings of the chat (from
+// the storage backend as well), so the default configuration of the
+// limiter will be used for it again.
+func (l *Limiter) RemoveChatSettings(chatID int64) error {
+	l.settingsMutex.Lock()
+	delete(l.chatSettings, chatID)
+	l.settingsMutex.Unlock()
+
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	return store.Delete(chatSettingsKey(chatID))
+}
+
+// LoadChatSettings will load all of the persisted chat settings from
+// the storage backend. this method is called by `Start` automatically.
+func (l *Limiter) LoadChatSettings() error {
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	keys, err := store.Keys(chatSettingsPrefix)
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[int64]*ChatSettings, len(keys))
+	for _, key := range keys {
+		data, err := store.Get(key)
+		if err != nil {
+			return err
+		}
+
+		settings := new(ChatSettings)
+		if err = json.Unmarshal(data, settings); err != nil {
+			return err
+		}
+
+		loaded[settings.ChatID] = settings
+	}
+
+	l.settingsMutex.Lock()
+	l.chatSettings = loaded
+	l.settingsMutex.Unlock()
+
+	return nil
+}
+
+// getChatSettings returns the runtime settings of the chat without
+// copying it.
+func (l *Limiter) getChatSettings(chatID int64) *ChatSettings {
+	l.settingsMutex.RLock()
+	defer l.settingsMutex.RUnlock()
+
+	return l.chatSettings[chatID]
+}
+
+// isChatDisabled returns true if the limiter is disabled in the given
+// chat by its runtime settings, or if the chat is out of the chat scopes
+// of the limiter.
+func (l *Limiter) isChatDisabled(chat *gotgbot.Chat) bool {
+	if chat == nil {
+		return false
+	}
+
+	if !l.isInScope(chat.Type) {
+		return true
+	}
+
+	settings := l.getChatSettings(chat.Id)
+	return settings != nil && settings.Disabled
+}
+
+// trackJoins will put the new members of the chat (if any) in
+// probation mode.
+func (l *Limiter) trackJoins(msg *gotgbot.Message) {
+	if l.GetProbationProfile() == nil || msg == nil || len(msg.NewChatMembers) == 0 {
+		return
+	}
+
+	for _, member := range msg.NewChatMembers {
+		l.AddProbation(msg.Chat.Id, member.Id)
+	}
+}
+
+// SetServicePolicy will set the policy of the limiter for handling the
+// service messages, such as joins, leaves, pins and title changes.
+func (l *Limiter) SetServicePolicy(policy ServicePolicy) {
+	l.setFlags(func(f *limiterFlags) {
+		f.ServicePolicy = policy
+	})
+}
+
+// SetJoinFloodProfile will set the threshold of the join/leave spam
+// detector used when the service policy is `ServiceDetect`; for example
+// a profile with 10 as message count and 1 minute as timeout detects
+// more than 10 joins (or leaves) in a minute in a chat.
+// pass nil to use `DefaultJoinFloodProfile`.
+func (l *Limiter) SetJoinFloodProfile(profile *LimitProfile) {
+	if profile == nil {
+		profile = DefaultJoinFloodProfile
+	}
+
+	setCoreProfile(&l.joinDetector, *profile)
+}
+
+// GetJoinFloodProfile returns the threshold of the join/leave spam
+// detector.
+func (l *Limiter) GetJoinFloodProfile() *LimitProfile {
+	limiter := l.joinDetector.Load()
+	if limiter == nil {
+		return DefaultJoinFloodProfile
+	}
+
+	p := limiter.GetProfile()
+	return &p
+}
+
+// AppendJoinFloodTriggers will append the triggers which are run when
+// a join/leave flood is detected in a chat, such as a function which
+// locks the chat for a while.
+func (l *Limiter) AppendJoinFloodTriggers(t ...handlers.Response) {
+	l.joinFloodTriggers.append(t...)
+}
+
+// IsJoinFlooding returns true if a join/leave flood has been detected in
+// the chat, and its punishment time is not over yet.
+func (l *Limiter) IsJoinFlooding(chatID int64) bool {
+	limiter := l.joinDetector.Load()
+	if limiter == nil {
+		return false
+	}
+
+	snapshot := limiter.GetSnapshot(chatID)
+	return snapshot != nil && snapshot.Limited
+}
+
+// checkJoinFlood will pass the join and leave messages of the update to
+// the join/leave spam detector, and runs the join flood triggers if a
+// flood is detected. b can be nil, in which case the triggers are not run.
+func (l *Limiter) checkJoinFlood(b *gotgbot.Bot, ctx *ext.Context) {
+	msg := ctx.EffectiveMessage
+	detector := l.joinDetector.Load()
+	if detector == nil || !isJoinLeaveMessage(msg) {
+		return
+	}
+
+	// every member of a join message is counted, as bots add a lot of
+	// members in a single message.
+	cost := len(msg.NewChatMembers)
+	if msg.LeftChatMember != nil {
+		cost++
+	}
+
+	d := detector.Allow(msg.Chat.Id, cost)
+	if !d.NewlyLimited {
+		return
+	}
+
+	if triggers := l.joinFloodTriggers.load(); b != nil && len(triggers) != 0 {
+		go func() {
+			for _, trigger := range triggers {
+				if trigger != nil {
+					trigger(b, ctx)
+				}
+			}
+		}()
+	}
+
+	l.notify(EventJoinFlood, ActionIgnore, ctx, msg.Chat.Id, d)
+}
+
+// SetLockdown will enable the chat-wide lockdowns: when the messages of
+// all of the members of a chat together exceed the threshold (such as in
+// a raid), the whole chat is locked down for the cooldown period; its
+// updates are dropped, and the chat is made read-only if a bot is set.
+// the lockdown triggers are run when a chat is locked down.
+// pass nil to disable the lockdowns; the ongoing lockdowns are lifted in
+// that case.
+func (l *Limiter) SetLockdown(config *LockdownConfig) error {
+	if config != nil {
+		if config.Threshold <= 0 || config.Window < 0 || config.Cooldown < 0 {
+			return fmt.Errorf("%w: %+v", ErrInvalidLockdown, *config)
+		}
+
+		c := *config
+		if c.Window == 0 {
+			c.Window = DefaultLockdownWindow
+		}
+
+		if c.Cooldown == 0 {
+			c.Cooldown = DefaultLockdownCooldown
+		}
+
+		config = &c
+	}
+
+	l.lockdownMutex.Lock()
+	l.lockdown = config
+	l.lockdownDetector = nil
+	if config != nil {
+		l.lockdownDetector = core.NewLimiter(core.Profile{
+			Timeout:        config.Window,
+			PunishmentTime: config.Cooldown,
+			MessageCount:   config.Threshold,
+		})
+	}
+
+	var chats []int64
+	for chatID := range l.lockdowns {
+		chats = append(chats, chatID)
+	}
+	l.lockdownMutex.Unlock()
+
+	if config == nil {
+		for _, chatID := range chats {
+			l.LiftLockdown(chatID)
+		}
+	}
+
+	return nil
+}
+
+// GetLockdownConfig returns the configuration of the chat-wide lockdowns
+// of this limiter. it will return nil if the lockdowns are disabled.
+func (l *Limiter) GetLockdownConfig() *LockdownConfig {
+	l.lockdownMutex.Lock()
+	defer l.lockdownMutex.Unlock()
+
+	if l.lockdown == nil {
+		return nil
+	}
+
+	c := *l.lockdown
+	return &c
+}
+
+// AppendLockdownTriggers will append the triggers which are run when
+// a chat is locked down, such as a function which notifies its admins.
+func (l *Limiter) AppendLockdownTriggers(t ...handlers.Response) {
+	l.lockdownTriggers.append(t...)
+}
+
+// ClearLockdownTriggers will remove all of the lockdown triggers.
+func (l *Limiter) ClearLockdownTriggers() {
+	l.lockdownTriggers.store(nil)
+}
+
+// IsLockedDown returns true if the chat is locked down at the moment.
+func (l *Limiter) IsLockedDown(chatID int64) bool {
+	l.lockdownMutex.Lock()
+	defer l.lockdownMutex.Unlock()
+
+	return l.lockdowns[chatID] != nil
+}
+
+// LiftLockdown will end the lockdown of the chat before its cooldown is
+// over, and restores the permissions of the chat if they have been
+// changed. it returns false if the chat is not locked down.
+func (l *Limiter) LiftLockdown(chatID int64) bool {
+	return l.EndChatState(chatID, StateLockdown)
+}
+
+// releaseLockdown will end the lockdown of the chat; it's the reversal
+// of the lockdown state of the chat.
+func (l *Limiter) releaseLockdown(chatID int64) {
+	l.lockdownMutex.Lock()
+	lockdown := l.lockdowns[chatID]
+	if lockdown == nil {
+		l.lockdownMutex.Unlock()
+		return
+	}
+
+	delete(l.lockdowns, chatID)
+	if l.lockdownDetector != nil {
+		l.lockdownDetector.Unlimit(chatID)
+	}
+
+	var b *gotgbot.Bot
+	if l.lockdown != nil {
+		b = l.lockdown.Bot
+	}
+	l.lockdownMutex.Unlock()
+
+	if b != nil && lockdown.permissions != nil {
+		permissions := *lockdown.permissions
+		l.runJob(b, func(b *gotgbot.Bot) error {
+			_, err := b.SetChatPermissions(chatID, permissions, &gotgbot.SetChatPermissionsOpts{
+				UseIndependentChatPermissions: true,
+			})
+			return err
+		})
+	}
+
+	l.notify(EventLockdownEnd, ActionExpire, nil, chatID, core.Decision{})
+}
+
+// checkLockdown will count the message of the update toward the
+// aggregate counter of its chat, and locks the chat down if the threshold
+// is exceeded. it returns true if the update should be dropped because
+// its chat is locked down. b can be nil, in which case the triggers are
+// not run.
+func (l *Limiter) checkLockdown(b *gotgbot.Bot, ctx *ext.Context) bool {
+	msg := ctx.EffectiveMessage
+	if msg == nil || (msg.Chat.Type != "group" && msg.Chat.Type != "supergroup") {
+		return false
+	}
+
+	l.lockdownMutex.Lock()
+	detector := l.lockdownDetector
+	config := l.lockdown
+	locked := l.lockdowns[msg.Chat.Id] != nil
+	l.lockdownMutex.Unlock()
+
+	if detector == nil || l.isExceptionCtx(ctx) {
+		return false
+	}
+
+	if locked {
+		return true
+	}
+
+	d := detector.Allow(msg.Chat.Id, 1)
+	if !d.NewlyLimited {
+		return false
+	}
+
+	l.startLockdown(b, ctx, msg.Chat.Id, config, d)
+	return true
+}
+
+// startLockdown will lock the chat down for the cooldown period of the
+// lockdowns.
+func (l *Limiter) startLockdown(b *gotgbot.Bot, ctx *ext.Context, chatID int64, config *LockdownConfig, d core.Decision) {
+	lockdown := new(chatLockdown)
+
+	l.lockdownMutex.Lock()
+	if l.lockdowns[chatID] != nil {
+		l.lockdownMutex.Unlock()
+		return
+	}
+
+	if l.lockdowns == nil {
+		l.lockdowns = make(map[int64]*chatLockdown)
+	}
+
+	l.lockdowns[chatID] = lockdown
+	l.lockdownMutex.Unlock()
+
+	l.startCooldown(chatID, StateLockdown, config.Cooldown, func() {
+		l.releaseLockdown(chatID)
+	})
+
+	if config.Bot != nil {
+		l.runJob(config.Bot, func(b *gotgbot.Bot) error {
+			return l.makeReadOnly(b, chatID, lockdown)
+		})
+	}
+
+	if b != nil {
+		if triggers := l.lockdownTriggers.load(); len(triggers) != 0 {
+			go l.runTriggers(b, ctx, triggers)
+		}
+	}
+
+	l.notify(EventLockdown, ActionIgnore, ctx, chatID, d)
+}
+
+// makeReadOnly will save the permissions of the chat in the lockdown,
+// and will make the chat read-only; the permissions are not changed if
+// the lockdown has been lifted meanwhile.
+func (l *Limiter) makeReadOnly(b *gotgbot.Bot, chatID int64, lockdown *chatLockdown) error {
+	chat, err := b.GetChat(chatID, nil)
+	if err != nil {
+		return err
+	}
+
+	permissions := chat.Permissions
+	if permissions == nil {
+		permissions = new(gotgbot.ChatPermissions)
+	}
+
+	l.lockdownMutex.Lock()
+	if l.lockdowns[chatID] != lockdown {
+		l.lockdownMutex.Unlock()
+		return nil
+	}
+
+	lockdown.permissions = permissions
+	l.lockdownMutex.Unlock()
+
+	_, err = b.SetChatPermissions(chatID, gotgbot.ChatPermissions{}, &gotgbot.SetChatPermissionsOpts{
+		UseIndependentChatPermissions: true,
+	})
+	return err
+}
+
+// SetRaid will enable the raid detection: the joins of the groups are
+// tracked (using both `chat_member` updates and the join messages), and
+// a burst of joins (such as 20 joins in a minute) is considered a raid.
+// when a raid is detected, the members who have joined in the burst are
+// put in probation mode again (if it's enabled), the chat is locked down
+// if `config.Lockdown` is true, and the raid triggers are run.
+// zero values of the config are replaced by the default values. pass nil
+// to disable the raid detection.
+func (l *Limiter) SetRaid(config *RaidConfig) {
+	if config != nil {
+		config = normalizeRaid(config)
+	}
+
+	l.raidMutex.Lock()
+	l.raid = config
+	if config == nil {
+		l.raids = nil
+	} else if l.raids == nil {
+		l.raids = make(map[int64]*chatRaid)
+	}
+	l.raidMutex.Unlock()
+}
+
+// GetRaid returns a copy of the configuration of the raid detection; it
+// returns nil if the raid detection is disabled.
+func (l *Limiter) GetRaid() *RaidConfig {
+	l.raidMutex.Lock()
+	defer l.raidMutex.Unlock()
+
+	if l.raid == nil {
+		return nil
+	}
+
+	c := *l.raid
+	return &c
+}
+
+// AppendRaidTriggers will append the triggers which are run when a raid
+// is detected in a chat, such as a function which notifies its admins.
+func (l *Limiter) AppendRaidTriggers(t ...handlers.Response) {
+	l.raidTriggers.append(t...)
+}
+
+// ClearRaidTriggers will remove all of the raid triggers.
+func (l *Limiter) ClearRaidTriggers() {
+	l.raidTriggers.store(nil)
+}
+
+// IsRaided returns true if a raid has been detected in the chat, and the
+// join rate of the chat is still above the threshold.
+func (l *Limiter) IsRaided(chatID int64) bool {
+	l.raidMutex.Lock()
+	defer l.raidMutex.Unlock()
+
+	raid := l.raids[chatID]
+	if l.raid == nil || raid == nil || !raid.active {
+		return false
+	}
+
+	return countSince(raid.joins, time.Now().Add(-l.raid.Window)) >= l.raid.Joins
+}
+
+// trackRaid will count the joins of the update (if any) toward the raid
+// detection of their chat, and starts a raid if the threshold is reached.
+// b can be nil, in which case the triggers are not run.
+func (l *Limiter) trackRaid(b *gotgbot.Bot, ctx *ext.Context) {
+	chatID, users := getJoins(ctx)
+	if len(users) == 0 {
+		return
+	}
+
+	now := time.Now()
+	l.raidMutex.Lock()
+	config := l.raid
+	if config == nil {
+		l.raidMutex.Unlock()
+		return
+	}
+
+	raid := l.raids[chatID]
+	if raid == nil {
+		raid = &chatRaid{joins: make(map[int64]time.Time)}
+		l.raids[chatID] = raid
+	}
+
+	since := now.Add(-config.Window)
+	for userID, joined := range raid.joins {
+		if joined.Before(since) {
+			delete(raid.joins, userID)
+		}
+	}
+
+	// the same join may be received both as a chat member update and
+	// as a join message, so it's only counted once.
+	for _, userID := range users {
+		if _, ok := raid.joins[userID]; !ok {
+			raid.joins[userID] = now
+		}
+	}
+
+	if len(raid.joins) < config.Joins {
+		active := raid.active
+		raid.active = false
+		l.raidMutex.Unlock()
+		if active {
+			l.EndChatState(chatID, StateRaid)
+		}
+		return
+	}
+
+	if raid.active {
+		l.raidMutex.Unlock()
+
+		// the raid alert lasts as long as the raid goes on.
+		l.startCooldown(chatID, StateRaid, config.Window, nil)
+		return
+	}
+
+	raid.active = true
+	raiders := make([]int64, 0, len(raid.joins))
+	for userID := range raid.joins {
+		raiders = append(raiders, userID)
+	}
+	l.raidMutex.Unlock()
+
+	l.startRaid(b, ctx, chatID, config, raiders)
+}
+
+// startRaid will take the actions of the raid detection for the raided
+// chat.
+func (l *Limiter) startRaid(b *gotgbot.Bot, ctx *ext.Context, chatID int64, config *RaidConfig, raiders []int64) {
+	l.startCooldown(chatID, StateRaid, config.Window, func() {
+		l.raidMutex.Lock()
+		if raid := l.raids[chatID]; raid != nil {
+			raid.active = false
+		}
+		l.raidMutex.Unlock()
+
+		l.notify(EventRaidEnd, ActionExpire, nil, chatID, core.Decision{})
+	})
+
+	// restart the probation of the raiders, so their probation lasts
+	// as long as the others who join later in the raid.
+	if l.GetProbationProfile() != nil {
+		for _, userID := range raiders {
+			l.AddProbation(chatID, userID)
+		}
+	}
+
+	if config.Lockdown {
+		l.lockdownMutex.Lock()
+		lockdown := l.lockdown
+		l.lockdownMutex.Unlock()
+
+		if lockdown != nil {
+			l.startLockdown(b, ctx, chatID, lockdown, core.Decision{})
+		}
+	}
+
+	if b != nil {
+		if triggers := l.raidTriggers.load(); len(triggers) != 0 {
+			go l.runTriggers(b, ctx, triggers)
+		}
+	}
+
+	l.notify(EventRaid, ActionIgnore, ctx, chatID, core.Decision{})
+}
+
+// pruneRaids will remove the join trackers of the chats which have had
+// no joins in the window of the raid detection.
+func (l *Limiter) pruneRaids() {
+	l.raidMutex.Lock()
+	defer l.raidMutex.Unlock()
+
+	if l.raid == nil {
+		return
+	}
+
+	since := time.Now().Add(-l.raid.Window)
+	for chatID, raid := range l.raids {
+		if countSince(raid.joins, since) == 0 {
+			delete(l.raids, chatID)
+		}
+	}
+}
+
+// SetFlapping will enable the flapping detection: the joins and leaves
+// of the users are tracked using the `chat_member` updates, and the users
+// who join and leave a chat too many times in the window of the config
+// (such as 6 times in 10 minutes) are considered flapping. the flapping
+// users are custom ignored for `config.IgnoreDuration` (if it's not zero),
+// and the flapping triggers are run, so the admins can be notified.
+// zero values of the config are replaced by the default values. pass nil
+// to disable the flapping detection.
+// NOTICE: the bot has to request the "chat_member" updates from telegram
+// for this to work; the custom ignores are keyed by the id of the users,
+// so they only matter if `ConsiderUser` is true.
+func (l *Limiter) SetFlapping(config *FlappingConfig) {
+	if config != nil {
+		config = normalizeFlapping(config)
+	}
+
+	l.flapMutex.Lock()
+	l.flapping = config
+	if config == nil {
+		l.flaps = nil
+	} else if l.flaps == nil {
+		l.flaps = make(map[flapKey][]time.Time)
+	}
+	l.flapMutex.Unlock()
+}
+
+// GetFlapping returns a copy of the configuration of the flapping
+// detection; it returns nil if the flapping detection is disabled.
+func (l *Limiter) GetFlapping() *FlappingConfig {
+	l.flapMutex.Lock()
+	defer l.flapMutex.Unlock()
+
+	if l.flapping == nil {
+		return nil
+	}
+
+	c := *l.flapping
+	return &c
+}
+
+// AppendFlappingTriggers will append the triggers which are run when
+// a user is found flapping in a chat, such as a function which notifies
+// the admins of the chat.
+func (l *Limiter) AppendFlappingTriggers(t ...handlers.Response) {
+	l.flappingTriggers.append(t...)
+}
+
+// ClearFlappingTriggers will remove all of the flapping triggers.
+func (l *Limiter) ClearFlappingTriggers() {
+	l.flappingTriggers.store(nil)
+}
+
+// trackFlapping will count the join (or leave) of the chat member update
+// toward the flapping detection of the user, and takes the actions of
+// the flapping detection if the threshold is reached. b can be nil, in
+// which case the triggers are not run.
+func (l *Limiter) trackFlapping(b *gotgbot.Bot, ctx *ext.Context) {
+	u := ctx.ChatMember
+	if u == nil || isJoinStatus(u.NewChatMember.GetStatus()) == isJoinStatus(u.OldChatMember.GetStatus()) {
+		return
+	}
+
+	now := time.Now()
+	key := flapKey{chatID: u.Chat.Id, userID: u.NewChatMember.GetUser().Id}
+	l.flapMutex.Lock()
+	config := l.flapping
+	if config == nil {
+		l.flapMutex.Unlock()
+		return
+	}
+
+	since := now.Add(-config.Window)
+	flaps := l.flaps[key]
+	for len(flaps) != 0 && flaps[0].Before(since) {
+		flaps = flaps[1:]
+	}
+
+	flaps = append(flaps, now)
+	if len(flaps) < config.Changes {
+		l.flaps[key] = flaps
+		l.flapMutex.Unlock()
+		return
+	}
+
+	// the user has to flap again as many times to be caught again.
+	delete(l.flaps, key)
+	l.flapMutex.Unlock()
+
+	id := l.scopeKey(b, key.userID)
+	if config.IgnoreDuration > 0 {
+		l.AddCustomIgnore(id, config.IgnoreDuration, false)
+	}
+
+	if b != nil {
+		if triggers := l.flappingTriggers.load(); len(triggers) != 0 {
+			go l.runTriggers(b, ctx, triggers)
+		}
+	}
+
+	l.notify(EventFlapping, ActionIgnore, ctx, id, core.Decision{})
+}
+
+// pruneFlaps will remove the join/leave trackers of the users who have
+// had no joins or leaves in the window of the flapping detection.
+func (l *Limiter) pruneFlaps() {
+	l.flapMutex.Lock()
+	defer l.flapMutex.Unlock()
+
+	if l.flapping == nil {
+		return
+	}
+
+	since := time.Now().Add(-l.flapping.Window)
+	for key, flaps := range l.flaps {
+		if flaps[len(flaps)-1].Before(since) {
+			delete(l.flaps, key)
+		}
+	}
+}
+
+// SetPaymentProfile will set the limit profile of the payment queries
+// (pre-checkout and shipping queries) of the users; they are counted
+// separately from the messages. pass nil to use `DefaultPaymentProfile`.
+// NOTICE: payment queries are only checked if `ConsiderPayments` is true.
+func (l *Limiter) SetPaymentProfile(profile *LimitProfile) {
+	if profile == nil {
+		profile = DefaultPaymentProfile
+	}
+
+	setCoreProfile(&l.paymentLimiter, *profile)
+}
+
+// GetPaymentProfile returns the limit profile of the payment queries.
+func (l *Limiter) GetPaymentProfile() *LimitProfile {
+	limiter := l.paymentLimiter.Load()
+	if limiter == nil {
+		return DefaultPaymentProfile
+	}
+
+	p := limiter.GetProfile()
+	return &p
+}
+
+// AppendPaymentTriggers will append the triggers which are run when
+// a user is limited for sending too many payment queries.
+func (l *Limiter) AppendPaymentTriggers(t ...handlers.Response) {
+	l.paymentTriggers.append(t...)
+}
+
+// runPaymentTriggers will run the payment triggers of the limiter.
+// this method should be called in a separate goroutine.
+func (l *Limiter) runPaymentTriggers(b *gotgbot.Bot, ctx *ext.Context) {
+	for _, trigger := range l.paymentTriggers.load() {
+		if trigger != nil {
+			trigger(b, ctx)
+		}
+	}
+}
+
+// SetGlobalProfile will enable the global tracking of the users; in this
+// mode the updates of each user are counted across all of the chats
+// seen by the bot as well, using the given profile, so the users who
+// spread their spam thinly across many chats (to stay under the limits
+// of each of them) are limited too. the users exceeding the global
+// profile are limited in the chat of their update, just like the others.
+// pass nil to disable the global tracking.
+func (l *Limiter) SetGlobalProfile(profile *LimitProfile) {
+	if profile == nil {
+		l.globalLimiter.Store(nil)
+		return
+	}
+
+	setCoreProfile(&l.globalLimiter, *profile)
+}
+
+// GetGlobalProfile returns the limit profile of the global tracking; it
+// returns nil if the global tracking is disabled.
+func (l *Limiter) GetGlobalProfile() *LimitProfile {
+	limiter := l.globalLimiter.Load()
+	if limiter == nil {
+		return nil
+	}
+
+	p := limiter.GetProfile()
+	return &p
+}
+
+// IsGloballyLimited returns true if the user has exceeded the global
+// profile of the limiter, and their punishment time is not over yet.
+func (l *Limiter) IsGloballyLimited(userID int64) bool {
+	limiter := l.globalLimiter.Load()
+	if limiter == nil {
+		return false
+	}
+
+	snapshot := limiter.GetSnapshot(userID)
+	return snapshot != nil && snapshot.Limited
+}
+
+// checkGlobal will count the update in the global tracker of its sender
+// and returns true if the sender has exceeded the global profile.
+func (l *Limiter) checkGlobal(b *gotgbot.Bot, ctx *ext.Context, cost int) bool {
+	global := l.globalLimiter.Load()
+	if global == nil || ctx.EffectiveUser == nil || isChannelPost(ctx) {
+		return false
+	}
+
+	key := l.scopeKey(b, ctx.EffectiveUser.Id)
+	d := global.Allow(key, cost)
+	if d.NewlyLimited {
+		l.notify(EventGlobalLimit, ActionIgnore, ctx, key, d)
+	}
+
+	return !d.IsAllowed()
+}
+
+// SetMentionProfile will set a stricter quota for the messages which
+// mention the bot (by its username or a text mention) or reply to one
+// of its messages, as these are the messages which usually trigger the
+// expensive handlers of the bots. the mentions are counted separately
+// (with the same key as the other updates), and the users exceeding the
+// mention profile are limited as usual; the rest of their messages are
+// still counted against the default limits too.
+// pass nil to disable it.
+// NOTICE: the mentions can only be detected when the bot is known, so
+// they are not counted by the methods which check a single message
+// without a bot (such as `CheckMessage`).
+func (l *Limiter) SetMentionProfile(profile *LimitProfile) {
+	if profile == nil {
+		l.mentionLimiter.Store(nil)
+		return
+	}
+
+	setCoreProfile(&l.mentionLimiter, *profile)
+}
+
+// GetMentionProfile returns the limit profile of the messages mentioning
+// the bot; it returns nil if they don't have a separate quota.
+func (l *Limiter) GetMentionProfile() *LimitProfile {
+	limiter := l.mentionLimiter.Load()
+	if limiter == nil {
+		return nil
+	}
+
+	p := limiter.GetProfile()
+	return &p
+}
+
+// checkMention will count the update in the mention quota of its key if
+// it mentions the bot, and returns true if the key has exceeded the
+// mention profile.
+func (l *Limiter) checkMention(b *gotgbot.Bot, ctx *ext.Context, key int64, cost int) bool {
+	mention := l.mentionLimiter.Load()
+	if mention == nil || !isBotMention(b, ctx.EffectiveMessage) {
+		return false
+	}
+
+	d := mention.Allow(key, cost)
+	if d.NewlyLimited {
+		l.notify(EventMentionLimit, ActionIgnore, ctx, key, d)
+	}
+
+	return !d.IsAllowed()
+}
+
+// SetVoiceProfile will set a dedicated quota for the voice messages and
+// the video notes, which are often used for harassment floods. they are
+// counted separately (with the same key as the other updates), and the
+// users exceeding the voice profile are limited as usual; their voice
+// messages still count against the default limits too. set
+// `DeleteVoiceFlood` to delete the voice messages sent over the quota.
+// pass nil to disable it (see `DefaultVoiceProfile`).
+// NOTICE: the voice messages and the video notes have to be among the
+// counted types of the limiter (see `SetCountedTypes`).
+func (l *Limiter) SetVoiceProfile(profile *LimitProfile) {
+	if profile == nil {
+		l.voiceLimiter.Store(nil)
+		return
+	}
+
+	setCoreProfile(&l.voiceLimiter, *profile)
+}
+
+// GetVoiceProfile returns the limit profile of the voice messages and
+// the video notes; it returns nil if they don't have a separate quota.
+func (l *Limiter) GetVoiceProfile() *LimitProfile {
+	limiter := l.voiceLimiter.Load()
+	if limiter == nil {
+		return nil
+	}
+
+	p := limiter.GetProfile()
+	return &p
+}
+
+// checkVoice will count the update in the voice quota of its key if it's
+// a voice message or a video note, and returns true if the key has
+// exceeded the voice profile.
+func (l *Limiter) checkVoice(ctx *ext.Context, key int64, cost int) bool {
+	voice := l.voiceLimiter.Load()
+	msg := ctx.EffectiveMessage
+	if voice == nil || msg == nil || (msg.Voice == nil && msg.VideoNote == nil) {
+		return false
+	}
+
+	d := voice.Allow(key, cost)
+	if d.NewlyLimited {
+		l.notify(EventVoiceFlood, ActionIgnore, ctx, key, d)
+	}
+
+	return !d.IsAllowed()
+}
+
+// SetAdaptive will enable the adaptive limits; in this mode the max
+// message count of the users scales with the recent overall message rate
+// of their group, which is recalculated periodically by the checker
+// goroutine (every max cache duration). zero values of the config are
+// replaced by the default values. pass nil to disable the adaptive
+// limits.
+// NOTICE: a single flooding user raises the rate of a quiet chat as well,
+// so the max factor should be kept reasonably small.
+func (l *Limiter) SetAdaptive(config *AdaptiveConfig) {
+	l.activityMutex.Lock()
+	defer l.activityMutex.Unlock()
+
+	if config == nil {
+		l.adaptive = nil
+		l.activities = nil
+		return
+	}
+
+	l.adaptive = normalizeAdaptive(config)
+	if l.activities == nil {
+		l.activities = make(map[int64]*chatActivity)
+		l.activitySince = time.Now()
+	}
+}
+
+// GetAdaptive returns the configuration of the adaptive limits; it
+// returns nil if the adaptive limits are disabled.
+func (l *Limiter) GetAdaptive() *AdaptiveConfig {
+	l.activityMutex.Lock()
+	defer l.activityMutex.Unlock()
+
+	return l.adaptive
+}
+
+// GetActivityFactor returns the current scaling factor of the max message
+// count in the chat; it returns 1 if the limits of the chat are not
+// scaled.
+func (l *Limiter) GetActivityFactor(chatID int64) float64 {
+	l.activityMutex.Lock()
+	defer l.activityMutex.Unlock()
+
+	activity := l.activities[chatID]
+	if activity == nil || activity.factor == 0 {
+		return 1
+	}
+
+	return activity.factor
+}
+
+// adaptProfile records the activity of the chat of the update and returns
+// the profile scaled by the activity factor of the chat.
+func (l *Limiter) adaptProfile(ctx *ext.Context, p *LimitProfile) *LimitProfile {
+	chat := ctx.EffectiveChat
+	if chat == nil || (chat.Type != "group" && chat.Type != "supergroup") {
+		return p
+	}
+
+	l.activityMutex.Lock()
+	if l.adaptive == nil {
+		l.activityMutex.Unlock()
+		return p
+	}
+
+	activity := l.activities[chat.Id]
+	if activity == nil {
+		activity = &chatActivity{factor: 1}
+		l.activities[chat.Id] = activity
+	}
+
+	activity.count++
+	factor := activity.factor
+	l.activityMutex.Unlock()
+
+	if factor == 1 {
+		return p
+	}
+
+	if p == nil {
+		p = l.getDefaultProfile()
+	}
+
+	scaled := *p
+	scaled.MessageCount = int(math.Round(float64(p.MessageCount) * factor))
+	if scaled.MessageCount < 1 {
+		scaled.MessageCount = 1
+	}
+
+	return &scaled
+}
+
+// updateActivities will recalculate the activity factors of the chats from
+// their message rate since the last calculation.
+func (l *Limiter) updateActivities() {
+	l.activityMutex.Lock()
+	defer l.activityMutex.Unlock()
+
+	if l.adaptive == nil {
+		return
+	}
+
+	minutes := time.Since(l.activitySince).Minutes()
+	l.activitySince = time.Now()
+	if minutes <= 0 {
+		return
+	}
+
+	for chatID, activity := range l.activities {
+		if activity.count == 0 {
+			// the chat has been totally quiet, so there is no need
+			// to keep it in the memory anymore.
+			delete(l.activities, chatID)
+			continue
+		}
+
+		factor := float64(activity.count) / minutes / l.adaptive.BaseRate
+		activity.factor = math.Max(l.adaptive.MinFactor, math.Min(l.adaptive.MaxFactor, factor))
+		activity.count = 0
+	}
+}
+
+// SetStatsRetention will enable the statistics of the chats (see
+// `ChatStats`); the statistics of each chat are reset once they are
+// older than the retention window. pass zero to disable the statistics.
+func (l *Limiter) SetStatsRetention(d time.Duration) {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+
+	if d <= 0 {
+		l.statsRetention = 0
+		l.stats = nil
+		return
+	}
+
+	l.statsRetention = d
+	if l.stats == nil {
+		l.stats = make(map[int64]*chatStats)
+	}
+}
+
+// GetStatsRetention returns the length of the retention window of the
+// chat statistics; zero means the statistics are disabled.
+func (l *Limiter) GetStatsRetention() time.Duration {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+
+	return l.statsRetention
+}
+
+// ChatStats returns the statistics of the chat in the current retention
+// window, such as the amount of the messages seen and blocked; it
+// returns nil if there are no statistics for the chat (or if the
+// statistics are disabled).
+func (l *Limiter) ChatStats(chatID int64) *ChatStats {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+
+	stats := l.stats[chatID]
+	if stats == nil || time.Since(stats.since) > l.statsRetention {
+		return nil
+	}
+
+	return &ChatStats{
+		ChatID:       chatID,
+		Messages:     stats.messages,
+		Blocked:      stats.blocked,
+		LimitedUsers: len(stats.limitedUsers),
+		Since:        stats.since,
+		LastActivity: stats.lastActivity,
+	}
+}
+
+// recordStats will record the decision about the update in the
+// statistics of its chat.
+func (l *Limiter) recordStats(ctx *ext.Context, key int64, d core.Decision) {
+	chat := ctx.EffectiveChat
+	if chat == nil {
+		return
+	}
+
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+
+	now := time.Now()
+	if l.statsHistory != 0 {
+		l.recordStatsBucket(chat.Id, now, d)
+	}
+
+	if l.statsRetention == 0 {
+		return
+	}
+
+	stats := l.stats[chat.Id]
+	if stats == nil || now.Sub(stats.since) > l.statsRetention {
+		stats = &chatStats{since: now}
+		l.stats[chat.Id] = stats
+	}
+
+	stats.messages++
+	stats.lastActivity = now
+	if !d.IsAllowed() {
+		stats.blocked++
+	}
+
+	if d.NewlyLimited {
+		if stats.limitedUsers == nil {
+			stats.limitedUsers = make(map[int64]struct{})
+		}
+
+		stats.limitedUsers[key] = struct{}{}
+	}
+}
+
+// pruneChatStats will remove the chat statistics which are older than
+// the retention window.
+func (l *Limiter) pruneChatStats() {
+	l.statsMutex.Lock()
+	for chatID, stats := range l.stats {
+		if time.Since(stats.since) > l.statsRetention {
+			delete(l.stats, chatID)
+		}
+	}
+	l.statsMutex.Unlock()
+}
+
+// recordStatsBucket will record the decision in the hourly statistics
+// of the chat; the stats mutex should be held by the caller.
+func (l *Limiter) recordStatsBucket(chatID int64, now time.Time, d core.Decision) {
+	key := statsBucketKey{chatID: chatID, hour: now.Truncate(time.Hour).Unix()}
+	bucket := l.statsBuckets[key]
+	if bucket == nil {
+		bucket = &StatsBucket{
+			ChatID: chatID,
+			Start:  time.Unix(key.hour, 0),
+			Period: time.Hour,
+		}
+		l.statsBuckets[key] = bucket
+	}
+
+	bucket.Messages++
+	if !d.IsAllowed() {
+		bucket.Blocked++
+	}
+
+	if d.NewlyLimited {
+		bucket.Limited++
+	}
+}
+
+// SetStatsHistory will enable the hourly statistics of the chats, which
+// are flushed to the storage backend once per checker interval and are
+// kept for the given duration (see `StatsRange`); without a storage
+// backend, they are only kept in the memory. pass zero to disable the
+// history.
+func (l *Limiter) SetStatsHistory(d time.Duration) {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+
+	if d <= 0 {
+		l.statsHistory = 0
+		l.statsBuckets = nil
+		return
+	}
+
+	l.statsHistory = d
+	if l.statsBuckets == nil {
+		l.statsBuckets = make(map[statsBucketKey]*StatsBucket)
+	}
+}
+
+// GetStatsHistory returns how long the hourly statistics of the chats
+// are kept; zero means the history is disabled.
+func (l *Limiter) GetStatsHistory() time.Duration {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+
+	return l.statsHistory
+}
+
+// FlushStats will add the hourly statistics of the chats collected since
+// the last flush to the ones in the storage backend (if any); it's
+// called by the checker and by `Stop` automatically.
+// the statistics which couldn't be written are kept for the next flush.
+func (l *Limiter) FlushStats() error {
+	store := l.GetStorage()
+	if store == nil {
+		return nil
+	}
+
+	l.statsMutex.Lock()
+	buckets := l.statsBuckets
+	if len(buckets) == 0 {
+		l.statsMutex.Unlock()
+		return nil
+	}
+	l.statsBuckets = make(map[statsBucketKey]*StatsBucket)
+	l.statsMutex.Unlock()
+
+	var firstErr error
+	for key, bucket := range buckets {
+		err := l.flushStatsBucket(store, bucket)
+		if err == nil {
+			continue
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		l.restoreStatsBucket(key, bucket)
+	}
+
+	return firstErr
+}
+
+// flushStatsBucket will add the counters of the bucket to the bucket of
+// the same hour in the storage backend.
+func (l *Limiter) flushStatsBucket(store storage.Storage, bucket *StatsBucket) error {
+	key := statsKey(bucket.ChatID, bucket.Start)
+	stored := *bucket
+	data, err := store.Get(key)
+	if err == nil {
+		var previous StatsBucket
+		if err = json.Unmarshal(data, &previous); err != nil {
+			return err
+		}
+
+		stored.Messages += previous.Messages
+		stored.Blocked += previous.Blocked
+		stored.Limited += previous.Limited
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	data, err = json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	return store.Set(key, data)
+}
+
+// restoreStatsBucket will put back the counters of a bucket which
+// couldn't be flushed, so they are retried in the next flush.
+func (l *Limiter) restoreStatsBucket(key statsBucketKey, bucket *StatsBucket) {
+	l.statsMutex.Lock()
+	defer l.statsMutex.Unlock()
+
+	if l.statsBuckets == nil {
+		// the history has been disabled in the meantime.
+		return
+	}
+
+	current := l.statsBuckets[key]
+	if current == nil {
+		l.statsBuckets[key] = bucket
+		return
+	}
+
+	current.Messages += bucket.Messages
+	current.Blocked += bucket.Blocked
+	current.Limited += bucket.Limited
+}
+
+// pruneStatsHistory will flush the hourly statistics of the chats to the
+// storage backend, and removes the ones older than the history.
+func (l *Limiter) pruneStatsHistory() {
+	l.statsMutex.Lock()
+	history := l.statsHistory
+	if history == 0 {
+		l.statsMutex.Unlock()
+		return
+	}
+
+	oldest := time.Now().Add(-history).Unix()
+	store := l.GetStorage()
+	if store == nil {
+		for key := range l.statsBuckets {
+			if key.hour < oldest {
+				delete(l.statsBuckets, key)
+			}
+		}
+		l.statsMutex.Unlock()
+		return
+	}
+	l.statsMutex.Unlock()
+
+	// the failed buckets are retried in the next interval, so the error
+	// is not fatal here.
+	_ = l.FlushStats()
+
+	keys, err := store.Keys(statsPrefix)
+	if err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		_, hour, ok := parseStatsKey(key)
+		if ok && hour < oldest {
+			_ = store.Delete(key)
+		}
+	}
+}
+
+// StatsRange returns the hourly statistics of the chat which have been
+// started in the given range of time (from inclusive, to exclusive),
+// sorted by their start time; it contains both the statistics in the
+// storage backend and the ones which haven't been flushed yet.
+// use `DailyStats` to merge them into the daily statistics.
+func (l *Limiter) StatsRange(chatID int64, from, to time.Time) ([]StatsBucket, error) {
+	inRange := func(hour int64) bool {
+		start := time.Unix(hour, 0)
+		return !start.Before(from) && start.Before(to)
+	}
+
+	merged := make(map[int64]*StatsBucket)
+	store := l.GetStorage()
+	if store != nil {
+		keys, err := store.Keys(statsChatPrefix(chatID))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			_, hour, ok := parseStatsKey(key)
+			if !ok || !inRange(hour) {
+				continue
+			}
+
+			data, err := store.Get(key)
+			if err != nil {
+				if errors.Is(err, storage.ErrNotFound) {
+					// pruned in the meantime.
+					continue
+				}
+
+				return nil, err
+			}
+
+			bucket := new(StatsBucket)
+			if err = json.Unmarshal(data, bucket); err != nil {
+				return nil, err
+			}
+
+			merged[hour] = bucket
+		}
+	}
+
+	l.statsMutex.Lock()
+	for key, bucket := range l.statsBuckets {
+		if key.chatID != chatID || !inRange(key.hour) {
+			continue
+		}
+
+		current := merged[key.hour]
+		if current == nil {
+			copied := *bucket
+			merged[key.hour] = &copied
+			continue
+		}
+
+		current.Messages += bucket.Messages
+		current.Blocked += bucket.Blocked
+		current.Limited += bucket.Limited
+	}
+	l.statsMutex.Unlock()
+
+	buckets := make([]StatsBucket, 0, len(merged))
+	for _, bucket := range merged {
+		buckets = append(buckets, *bucket)
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Start.Before(buckets[j].Start)
+	})
+
+	return buckets, nil
+}
+
+// SetReport will schedule the summary reports of the activity of this
+// limiter (such as the totals and the top offenders), which are sent to
+// the admin chat periodically according to the schedule of the config.
+// the activity is collected from the time the reports are set, and is
+// reset after sending each report. pass nil to disable the reports.
+func (l *Limiter) SetReport(config *ReportConfig) error {
+	var state *reportState
+	if config != nil {
+		if config.Bot == nil || config.ChatID == 0 || config.TopCount < 0 {
+			return fmt.Errorf("%w: %+v", ErrInvalidReport, *config)
+		}
+
+		schedule, err := ParseSchedule(config.Schedule)
+		if err != nil {
+			return err
+		}
+
+		state = &reportState{
+			config:    *config,
+			schedule:  schedule,
+			since:     time.Now(),
+			offenders: make(map[int64]int),
+		}
+
+		if state.config.TopCount == 0 {
+			state.config.TopCount = DefaultReportTopCount
+		}
+
+		if state.config.Format == nil {
+			state.config.Format = FormatReport
+		}
+	}
+
+	l.reportMutex.Lock()
+	defer l.reportMutex.Unlock()
+
+	if l.report != nil && l.report.stop != nil {
+		close(l.report.stop)
+	}
+
+	l.report = state
+	if state != nil && l.isEnabled.Load() && !l.isStopped.Load() {
+		l.startReports()
+	}
+
+	return nil
+}
+
+// GetReportConfig returns a copy of the config of the scheduled reports
+// of this limiter; it returns nil if there are no scheduled reports.
+func (l *Limiter) GetReportConfig() *ReportConfig {
+	l.reportMutex.Lock()
+	defer l.reportMutex.Unlock()
+
+	if l.report == nil {
+		return nil
+	}
+
+	config := l.report.config
+	return &config
+}
+
+// GetReport returns the report of the activity collected since the last
+// scheduled report was sent; it returns nil if there are no scheduled
+// reports.
+func (l *Limiter) GetReport() *Report {
+	l.reportMutex.Lock()
+	defer l.reportMutex.Unlock()
+
+	if l.report == nil {
+		return nil
+	}
+
+	return l.report.compose(time.Now())
+}
+
+// SendReport will send the report of the collected activity to the admin
+// chat immediately, and will reset the collected activity.
+func (l *Limiter) SendReport() error {
+	l.reportMutex.Lock()
+	state := l.report
+	if state == nil {
+		l.reportMutex.Unlock()
+		return fmt.Errorf("%w: no scheduled reports", ErrInvalidReport)
+	}
+
+	config := state.config
+	r := state.reset(time.Now())
+	l.reportMutex.Unlock()
+
+	text := config.Format(r)
+	l.runJob(config.Bot, func(b *gotgbot.Bot) error {
+		_, err := b.SendMessage(config.ChatID, text, nil)
+		return err
+	})
+
+	return nil
+}
+
+// startReports will start the scheduler goroutine of the reports; the
+// report mutex should be locked by the caller.
+func (l *Limiter) startReports() {
+	state := l.report
+	if state == nil || state.stop != nil {
+		return
+	}
+
+	state.stop = make(chan struct{})
+	go l.runReports(state.schedule, state.stop)
+}
+
+// stopReports will stop the scheduler goroutine of the reports.
+func (l *Limiter) stopReports() {
+	l.reportMutex.Lock()
+	if l.report != nil && l.report.stop != nil {
+		close(l.report.stop)
+		l.report.stop = nil
+	}
+	l.reportMutex.Unlock()
+}
+
+// runReports will send the reports according to the schedule until the
+// stop channel is closed.
+func (l *Limiter) runReports(schedule Schedule, stop chan struct{}) {
+	for {
+		timer := time.NewTimer(time.Until(schedule.Next(time.Now())))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			_ = l.SendReport()
+		}
+	}
+}
+
+// recordReport will record the decision about the update in the
+// activity of the scheduled reports.
+func (l *Limiter) recordReport(key int64, d core.Decision) {
+	l.reportMutex.Lock()
+	defer l.reportMutex.Unlock()
+
+	state := l.report
+	if state == nil {
+		return
+	}
+
+	state.messages++
+	if !d.IsAllowed() {
+		state.blocked++
+	}
+
+	if d.NewlyLimited {
+		state.limits++
+		state.offenders[key]++
+	}
+}
+
+// SetAlert will set the configuration of the repeat-offender alerts of
+// this limiter; when a user gets limited `Threshold` times in `Window`
+// amount of time, the limiter alerts the admins with a summary of the
+// recent incidents of the user, and the buttons for banning the user
+// from the chat of the last incident, or pardoning (unlimiting) them.
+// zero values of the config are replaced by the default values. pass nil
+// to disable the alerts.
+func (l *Limiter) SetAlert(config *AlertConfig) error {
+	if config != nil {
+		if config.Bot == nil || config.Threshold < 0 || config.Window < 0 {
+			return fmt.Errorf("%w: %+v", ErrInvalidAlert, *config)
+		}
+
+		c := *config
+		if c.Threshold == 0 {
+			c.Threshold = DefaultAlertThreshold
+		}
+
+		if c.Window == 0 {
+			c.Window = DefaultAlertWindow
+		}
+
+		if c.Format == nil {
+			c.Format = FormatAlert
+		}
+
+		config = &c
+	}
+
+	l.alertMutex.Lock()
+	l.alert = config
+	if config == nil {
+		l.incidents = nil
+		l.alerts = nil
+	}
+	l.alertMutex.Unlock()
+
+	return nil
+}
+
+// GetAlertConfig returns the configuration of the repeat-offender alerts
+// of this limiter. it will return nil if the alerts are disabled.
+func (l *Limiter) GetAlertConfig() *AlertConfig {
+	l.alertMutex.Lock()
+	defer l.alertMutex.Unlock()
+
+	if l.alert == nil {
+		return nil
+	}
+
+	c := *l.alert
+	return &c
+}
+
+// GetIncidents returns the recent incidents of the key (in the window
+// of the alerts), sorted by their time.
+func (l *Limiter) GetIncidents(key int64) []Incident {
+	l.alertMutex.Lock()
+	defer l.alertMutex.Unlock()
+
+	return copySlice(l.incidents[key])
+}
+
+// recordIncident will record the incident of the key, which has just
+// been limited, and alerts the admins if the key has reached the
+// threshold of the alerts.
+func (l *Limiter) recordIncident(ctx *ext.Context, key int64, d core.Decision) {
+	l.alertMutex.Lock()
+	config := l.alert
+	if config == nil || ctx.EffectiveChat == nil || ctx.EffectiveSender == nil {
+		l.alertMutex.Unlock()
+		return
+	}
+
+	if l.incidents == nil {
+		l.incidents = make(map[int64][]Incident)
+	}
+
+	incidents := pruneIncidents(l.incidents[key], config.Window)
+	incidents = append(incidents, Incident{
+		Time:   time.Now(),
+		ChatID: ctx.EffectiveChat.Id,
+		Count:  d.Count,
+	})
+
+	if len(incidents) < config.Threshold {
+		l.incidents[key] = incidents
+		l.alertMutex.Unlock()
+		return
+	}
+
+	// the incidents are reported once, so the next alert of the user
+	// needs a new series of incidents.
+	delete(l.incidents, key)
+	alert := &Alert{
+		Key:       key,
+		UserID:    ctx.EffectiveSender.Id(),
+		Name:      ctx.EffectiveSender.Name(),
+		ChatID:    ctx.EffectiveChat.Id,
+		Incidents: incidents,
+	}
+
+	if l.alerts == nil {
+		l.alerts = make(map[string]*pendingAlert)
+	}
+
+	nonce := newNonce()
+	l.alerts[nonce] = &pendingAlert{
+		key:       key,
+		userID:    alert.UserID,
+		chatID:    alert.ChatID,
+		expiresAt: time.Now().Add(config.Window),
+	}
+	l.alertMutex.Unlock()
+
+	l.sendAlert(config, alert, nonce)
+}
+
+// sendAlert will send the alert to the admin chat of the config (or to
+// the admins of the chat of the alert), with the ban and pardon buttons.
+func (l *Limiter) sendAlert(config *AlertConfig, alert *Alert, nonce string) {
+	text := config.Format(alert)
+	opts := &gotgbot.SendMessageOpts{
+		ReplyMarkup: gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{
+				{Text: DefaultAlertBanText, CallbackData: AlertCallbackPrefix + nonce + ":ban"},
+				{Text: DefaultAlertPardonText, CallbackData: AlertCallbackPrefix + nonce + ":pardon"},
+			}},
+		},
+	}
+
+	l.runJob(config.Bot, func(b *gotgbot.Bot) error {
+		if config.ChatID != 0 {
+			_, err := b.SendMessage(config.ChatID, text, opts)
+			return err
+		}
+
+		admins, err := b.GetChatAdministrators(alert.ChatID, nil)
+		if err != nil {
+			return err
+		}
+
+		for _, admin := range admins {
+			if user := admin.GetUser(); !user.IsBot {
+				// the admins who haven't started the bot can't receive
+				// the alert.
+				_, _ = b.SendMessage(user.Id, text, opts)
+			}
+		}
+
+		return nil
+	})
+}
+
+// pruneAlerts will remove the expired incidents and the alerts whose
+// buttons can't be pressed anymore.
+func (l *Limiter) pruneAlerts() {
+	l.alertMutex.Lock()
+	defer l.alertMutex.Unlock()
+
+	if l.alert == nil {
+		return
+	}
+
+	for key, incidents := range l.incidents {
+		if incidents = pruneIncidents(incidents, l.alert.Window); len(incidents) == 0 {
+			delete(l.incidents, key)
+		} else {
+			l.incidents[key] = incidents
+		}
+	}
+
+	for nonce, pending := range l.alerts {
+		if time.Now().After(pending.expiresAt) {
+			delete(l.alerts, nonce)
+		}
+	}
+}
+
+// SetChallenge will set the verification challenge configuration of
+// this limiter. When a user is limited, a message containing the
+// challenge buttons will be sent to the chat; once the user presses the
+// correct button, they will be unlimited automatically.
+// pass nil to disable the challenges.
+// NOTICE: the challenges are only sent if `ConsiderUser` is true, so
+// a single user can't unlimit a whole chat by solving one.
+func (l *Limiter) SetChallenge(config *ChallengeConfig) {
+	l.challenge.Store(normalizeChallenge(config))
+}
+
+// GetChallenge returns the verification challenge configuration of
+// this limiter. it will return nil if challenges are disabled.
+func (l *Limiter) GetChallenge() *ChallengeConfig {
+	return l.challenge.Load()
+}
+
+// SetReply will set the built-in reply of this limiter; when a message
+// gets its sender limited (or warned, if `WarnText` is set), the limiter
+// replies to it with the templated text of the config, so no custom
+// trigger is needed for telling the users to slow down. zero values of
+// the config are replaced by the default values. pass nil to disable
+// the built-in reply.
+// NOTICE: only the messages are replied; the other updates (such as
+// the callback queries) are limited silently.
+func (l *Limiter) SetReply(config *ReplyConfig) {
+	l.reply.Store(normalizeReply(config))
+}
+
+// GetReply returns the built-in reply configuration of this limiter.
+// it will return nil if the built-in reply is disabled.
+func (l *Limiter) GetReply() *ReplyConfig {
+	return l.reply.Load()
+}
+
+// SetBundle will set the translation of the built-in messages (such as
+// the replies and the challenges) for the users with the given language
+// code, such as "es" or "pt-br"; the users with a regional language code
+// fall back to the bundle of its base language. the bundle overrides the
+// built-in translation of the language (see `DefaultBundles`).
+// pass nil to remove the bundle of the language.
+func (l *Limiter) SetBundle(lang string, bundle *Bundle) {
+	lang = strings.ToLower(lang)
+
+	l.bundleMutex.Lock()
+	defer l.bundleMutex.Unlock()
+
+	if bundle == nil {
+		delete(l.bundles, lang)
+		return
+	}
+
+	if l.bundles == nil {
+		l.bundles = make(map[string]*Bundle)
+	}
+
+	b := *bundle
+	l.bundles[lang] = &b
+}
+
+// GetBundle returns the translation bundle set for the language code;
+// it returns nil if there is no bundle for the language.
+func (l *Limiter) GetBundle(lang string) *Bundle {
+	l.bundleMutex.RLock()
+	defer l.bundleMutex.RUnlock()
+
+	return l.bundles[strings.ToLower(lang)]
+}
+
+// translate returns the text translated to the language; pick should
+// return the translation of the text from a bundle. the built-in
+// translations are only used if the text is the default one.
+func (l *Limiter) translate(lang, text string, pick func(b *Bundle) string) string {
+	if lang == "" {
+		return text
+	}
+
+	lang = strings.ToLower(lang)
+	base, _, _ := strings.Cut(lang, "-")
+
+	l.bundleMutex.RLock()
+	translated := pickTranslation(l.bundles, lang, base, pick)
+	l.bundleMutex.RUnlock()
+
+	if translated == "" && text == pick(defaultBundle) {
+		translated = pickTranslation(DefaultBundles, lang, base, pick)
+	}
+
+	if translated == "" {
+		return text
+	}
+
+	return translated
+}
+
+// Unlimit will free the chat (or user) from the limitation of this
+// limiter, so its messages will be handled again.
+// if `l.ConsiderUser` parameter is set to `true`,
+// the id should be the id of the user; otherwise you should
+// use the id of the chat.
+func (l *Limiter) Unlimit(id int64) {
+	if l.core.Unlimit(id) {
+		l.released(nil, id, ActionManual, core.Decision{
+			MaxCount: l.core.GetProfile().MessageCount,
+		})
+		l.publish(&core.SyncEvent{
+			Type: core.SyncUnlimit,
+			Key:  id,
+		})
+	}
+}
+
+// Limit will limit the chat (or user) manually, as if it has just sent
+// too many messages; its punishment time starts from now.
+// if `l.ConsiderUser` parameter is set to `true`,
+// the id should be the id of the user; otherwise you should
+// use the id of the chat.
+func (l *Limiter) Limit(id int64) {
+	l.core.Limit(id)
+	l.notify(EventLimited, ActionManual, nil, id, core.Decision{
+		MaxCount: l.core.GetProfile().MessageCount,
+	})
+	l.publish(&core.SyncEvent{
+		Type: core.SyncLimit,
+		Key:  id,
+	})
+}
+
+// AllowID will check a request of the chat (or user) directly, without
+// any telegram update; it consumes `cost` units of its quota and returns
+// the decision of the limiter. it's useful for checking the requests
+// received from outside of the dispatcher (such as the rpc server).
+// if `l.ConsiderUser` parameter is set to `true`,
+// the id should be the id of the user; otherwise you should
+// use the id of the chat.
+func (l *Limiter) AllowID(id int64, cost int) core.Decision {
+	if cost <= 0 {
+		cost = 1
+	}
+
+	d := l.core.AllowRequest(id, &core.Request{
+		Cost:   cost,
+		Strict: l.getFlags().IsStrict,
+
+		MaxPunishment: l.GetMaxPunishment(),
+		PartialReset:  l.getFlags().PartialReset,
+	})
+
+	if d.Released {
+		l.released(nil, id, ActionExpire, d)
+	}
+
+	if d.NewlyLimited {
+		l.notify(EventLimited, ActionIgnore, nil, id, d)
+		l.publish(&core.SyncEvent{
+			Type: core.SyncLimit,
+			Key:  id,
+		})
+	}
+
+	return d
+}
+
+// ListLimited returns the ids of the chats (or users) which are
+// currently limited by this limiter.
+func (l *Limiter) ListLimited() []int64 {
+	return l.core.ListLimited()
+}
+
+// ExportLimited will write the records of the currently punished chats
+// (or users) to w as JSON, with the remaining duration of their
+// punishment; unlike `FlushStatuses`, the counters of the other keys are
+// not included, so the moderation state can be backed up or migrated to
+// another bot using `ImportLimited`.
+func (l *Limiter) ExportLimited(w io.Writer) error {
+	now := time.Now()
+	records := make([]LimitedRecord, 0)
+	for _, id := range l.core.ListLimited() {
+		snapshot := l.core.GetSnapshot(id)
+		if snapshot == nil || snapshot.LimitedUntil == nil {
+			continue
+		}
+
+		remaining := snapshot.LimitedUntil.Sub(now)
+		if remaining <= 0 {
+			continue
+		}
+
+		records = append(records, LimitedRecord{
+			Key:       id,
+			Remaining: Duration(remaining),
+		})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+
+	return json.NewEncoder(w).Encode(records)
+}
+
+// ImportLimited will read the records written by `ExportLimited` from r
+// and punishes their chats (or users) for the remaining duration of
+// their punishment, starting from now; the current state of the keys
+// is replaced.
+func (l *Limiter) ImportLimited(r io.Reader) error {
+	var records []LimitedRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	statuses := make([]core.StatusRecord, 0, len(records))
+	for _, record := range records {
+		if record.Remaining <= 0 {
+			continue
+		}
+
+		// the punishments are marked as escalated, so they keep their
+		// own duration instead of the one of the profile.
+		statuses = append(statuses, core.StatusRecord{
+			Key:          record.Key,
+			Last:         now,
+			Limited:      true,
+			ReleaseAfter: time.Duration(record.Remaining),
+			Escalated:    true,
+		})
+	}
+
+	l.core.ImportStatuses(statuses)
+	return nil
+}
+
+// Metrics returns a snapshot of the state of this limiter.
+func (l *Limiter) Metrics() Metrics {
+	latency, decisions := l.CheckStats()
+	return Metrics{
+		Enabled:   l.isEnabled.Load() && !l.isStopped.Load(),
+		Tracked:   l.CacheSize(),
+		Limited:   len(l.core.ListLimited()),
+		Evictions: l.core.Evictions(),
+		Sweep:     l.GetSweepStats(),
+		Latency:   latency,
+		Decisions: decisions,
+	}
+}
+
+// CheckStats returns the rolling latency percentiles of the check path
+// of this limiter and the distribution of its decisions, calculated from
+// the latest checked updates.
+func (l *Limiter) CheckStats() (LatencyStats, DecisionStats) {
+	return l.checkSamples.snapshot()
+}
+
+// observeCheck will record the time spent on checking an update since
+// the given time, and the result of its decision.
+func (l *Limiter) observeCheck(started time.Time, result core.Result) {
+	l.checkSamples.add(time.Since(started), result)
+}
+
+// Health returns the liveness report of this limiter, suitable for the
+// healthcheck endpoints of the bots. the limiter is considered unhealthy
+// if it's not running, if its checker has stopped or is late for more
+// than two intervals, or if its storage backend is not reachable.
+func (l *Limiter) Health() HealthReport {
+	report := HealthReport{
+		Enabled:          l.isEnabled.Load() && !l.isStopped.Load(),
+		StorageReachable: true,
+	}
+
+	l.stateMutex.Lock()
+	if l.checkerDone != nil {
+		select {
+		case <-l.checkerDone:
+		default:
+			report.CheckerAlive = true
+		}
+	}
+	l.stateMutex.Unlock()
+
+	if last := l.GetSweepStats().LastSweep; !last.IsZero() {
+		report.SinceLastSweep = time.Since(last)
+	}
+
+	store := l.GetStorage()
+	if store != nil {
+		_, err := store.Get(healthKey)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			report.StorageReachable = false
+			report.StorageError = err.Error()
+		}
+	}
+
+	if q := l.sendQueue.Load(); q != nil {
+		report.SendQueueDepth = q.Depth()
+	}
+
+	l.delayMutex.Lock()
+	for _, q := range l.delayed {
+		report.DelayQueueDepth += len(q.updates)
+	}
+	l.delayMutex.Unlock()
+
+	l.punishMutex.Lock()
+	report.ScheduledActions = len(l.scheduled)
+	l.punishMutex.Unlock()
+
+	report.Healthy = report.Enabled && report.CheckerAlive && report.StorageReachable &&
+		report.SinceLastSweep <= 2*l.GetCheckerInterval()
+	return report
+}
+
+// CacheSize returns the amount of the statuses kept in the memory by
+// this limiter.
+func (l *Limiter) CacheSize() int {
+	return l.core.Len()
+}
+
+// SetMaxEntries will cap the number of the chats (or users) tracked by
+// this limiter, protecting the memory when the bot is added to enormous
+// groups or is targeted by a flood of unique users; beyond the cap, the
+// least recently seen keys are evicted. the limited (and ignored) keys
+// are never evicted. zero means no cap.
+// NOTICE: the evicted keys start over with an empty status, so the cap
+// shouldn't be smaller than the number of the active keys.
+func (l *Limiter) SetMaxEntries(n int) {
+	l.core.SetMaxEntries(n)
+}
+
+// GetMaxEntries returns the maximum number of the tracked keys; zero
+// means no cap.
+func (l *Limiter) GetMaxEntries() int {
+	return l.core.GetMaxEntries()
+}
+
+// GetSweepStats returns the statistics of the sweeps done by the checker
+// goroutine of this limiter.
+func (l *Limiter) GetSweepStats() SweepStats {
+	l.hookMutex.RLock()
+	defer l.hookMutex.RUnlock()
+
+	return l.sweepStats
+}
+
+// GetCore returns the underlying core limiter.
+func (l *Limiter) GetCore() *core.Limiter {
+	return l.core
+}
+
+// SetEventBus will set the event bus used for sharing the limit events
+// with the other instances of the limiter (such as other bot workers
+// sharing the same redis backend). it should be called before `Start`.
+//
+// Consistency model: only the state changes (limit, unlimit and custom
+// ignores) are shared; the message counters remain local to each
+// instance, and the punishments expire independently on each instance.
+// The events are applied asynchronously, so for a short time after an
+// event the instances may decide differently about the same user.
+// The events of an instance are published in order by a single goroutine
+// while the limiter is running; if the bus can't keep up and more than
+// `DefaultPublishQueueSize` events are waiting, the new ones are dropped.
+func (l *Limiter) SetEventBus(bus core.EventBus) {
+	if bus == nil {
+		l.eventBus.Store(nil)
+		return
+	}
+
+	l.eventBus.Store(&bus)
+}
+
+// GetEventBus returns the event bus of this limiter.
+func (l *Limiter) GetEventBus() core.EventBus {
+	if bus := l.eventBus.Load(); bus != nil {
+		return *bus
+	}
+
+	return nil
+}
+
+// publish will put the event in the publish queue of the limiter, so
+// it's published to the event bus after the events before it. the event
+// is dropped if the publisher isn't running or its queue is full.
+func (l *Limiter) publish(event *core.SyncEvent) {
+	l.publishMutex.Lock()
+	defer l.publishMutex.Unlock()
+
+	if l.publishQueue == nil {
+		return
+	}
+
+	event.Origin = l.instanceID
+	select {
+	case l.publishQueue <- event:
+	default:
+	}
+}
+
+// startPublisher will start the goroutine which publishes the queued
+// events to the given bus, if it's not running yet.
+func (l *Limiter) startPublisher(bus core.EventBus) {
+	l.publishMutex.Lock()
+	defer l.publishMutex.Unlock()
+
+	if l.publishQueue != nil {
+		return
+	}
+
+	l.publishQueue = make(chan *core.SyncEvent, DefaultPublishQueueSize)
+	l.publishDone = make(chan struct{})
+	go l.publisher(bus, l.publishQueue, l.publishDone)
+}
+
+// stopPublisher will close the publish queue and waits for the publisher
+// goroutine to publish the events remaining in it.
+func (l *Limiter) stopPublisher() {
+	l.publishMutex.Lock()
+	queue, done := l.publishQueue, l.publishDone
+	l.publishQueue = nil
+	l.publishDone = nil
+	l.publishMutex.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-done
+	}
+}
+
+// publisher publishes the events of the queue to the bus one by one,
+// until the queue is closed.
+func (l *Limiter) publisher(bus core.EventBus, queue <-chan *core.SyncEvent, done chan<- struct{}) {
+	defer close(done)
+
+	for event := range queue {
+		_ = bus.Publish(event)
+	}
+}
+
+// applySyncEvent will apply an event published by the other instances
+// to this limiter.
+func (l *Limiter) applySyncEvent(event *core.SyncEvent) {
+	if event == nil || event.Origin == l.instanceID {
+		return
+	}
+
+	l.core.ApplySyncEvent(event)
+	switch event.Type {
+	case core.SyncCustomIgnore:
+		if event.IgnoreExceptions {
+			l.addIgnoredExceptions(event.Key)
+		}
+	case core.SyncRemoveCustomIgnore:
+		l.removeFromIgnoredExceptions(event.Key)
+	}
+}
+
+// AddNotifier will add an event notifier to this limiter. notifiers
+// are notified whenever a user gets limited or unlimited.
+func (l *Limiter) AddNotifier(n EventNotifier) {
+	l.notifiers.append(n)
+}
+
+// ClearNotifiers will remove all of the event notifiers of this limiter.
+func (l *Limiter) ClearNotifiers() {
+	l.notifiers.store(nil)
+}
+
+// AddAuditSink will add an audit sink to this limiter; the sinks receive
+// a record of every enforcement action of the limiter, such as limits,
+// unlimits, custom ignores and exemption changes.
+func (l *Limiter) AddAuditSink(sink AuditSink) {
+	l.auditSinks.append(sink)
+}
+
+// ClearAuditSinks will remove all of the audit sinks of this limiter.
+func (l *Limiter) ClearAuditSinks() {
+	l.auditSinks.store(nil)
+}
+
+// Audit will write the record to the audit sinks of this limiter; the
+// bots can use it for recording the actions taken by their own triggers
+// (such as `AuditDelete` and `AuditMute`), so the audit log contains all
+// of the enforcement actions. the time of the record is set to now if
+// it's zero.
+func (l *Limiter) Audit(record *AuditRecord) {
+	sinks := l.auditSinks.load()
+	if len(sinks) == 0 || record == nil {
+		return
+	}
+
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+
+	for _, sink := range sinks {
+		if sink != nil {
+			_ = sink.Audit(record)
+		}
+	}
+}
+
+// auditEvent will write the record of the limit event to the audit sinks.
+func (l *Limiter) auditEvent(event *LimitEvent) {
+	record := &AuditRecord{
+		Time:   event.Time,
+		Action: AuditLimit,
+		Key:    event.Key,
+		ChatID: event.ChatID,
+		UserID: event.UserID,
+		Actor:  ActorLimiter,
+		Reason: event.Action,
+	}
+
+	switch {
+	case event.Type == EventUnlimited:
+		record.Action = AuditUnlimit
+	case event.Type == EventWarned:
+		record.Action = AuditWarn
+	case event.Type == EventJoinFlood, event.Type == EventLockdown,
+		event.Type == EventLockdownEnd, event.Type == EventRaid, event.Type == EventRaidEnd,
+		event.Type == EventBlockedSource, event.Type == EventGlobalLimit,
+		event.Type == EventReputation, event.Type == EventFlapping:
+		record.Reason = event.Type
+	}
+
+	if event.Action == ActionManual {
+		record.Actor = ActorManual
+	}
+
+	l.Audit(record)
+}
+
+// auditIDs will write a manual record of the action for each of the ids
+// to the audit sinks.
+func (l *Limiter) auditIDs(action string, ids []int64, d time.Duration) {
+	if len(l.auditSinks.load()) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		l.Audit(&AuditRecord{
+			Time:     now,
+			Action:   action,
+			Key:      id,
+			Actor:    ActorManual,
+			Duration: Duration(d),
+		})
+	}
+}
+
+// SetWebhook will add a `WebhookNotifier` to this limiter, which will
+// POST the events as JSON to the given url, with the default retry
+// and backoff values. the webhook is stopped by `Stop` together with
+// the limiter.
+func (l *Limiter) SetWebhook(url string) *WebhookNotifier {
+	n := &WebhookNotifier{
+		URL:        url,
+		MaxRetries: DefaultWebhookRetries,
+		Backoff:    DefaultWebhookBackoff,
+	}
+	l.AddNotifier(n)
+	return n
+}
+
+// stopWebhooks will stop the worker goroutines of the webhooks of this
+// limiter; they are started again by the next events.
+func (l *Limiter) stopWebhooks() {
+	for _, n := range l.notifiers.load() {
+		if w, ok := n.(*WebhookNotifier); ok {
+			w.Stop()
+		}
+	}
+}
+
+// OnUnlimit will add a callback which is called whenever the punishment
+// of a chat (or user) ends, either because it has expired or because it
+// has been unlimited manually; so the bots can unmute the users or tell
+// them they may talk again.
+// the callbacks are called in a separate goroutine.
+// NOTICE: an expired punishment is noticed either by the next update
+// of the key, or by the checker goroutine (every max cache duration).
+func (l *Limiter) OnUnlimit(callback UnlimitCallback) {
+	l.hookMutex.Lock()
+	l.unlimitCallbacks = append(l.unlimitCallbacks, callback)
+	l.hookMutex.Unlock()
+}
+
+// OnExpire will add a callback which is called whenever the status of
+// a chat (or user) is evicted from the memory by the checker goroutine,
+// so the bots can persist the final statistics of it.
+// the callbacks are called in a separate goroutine.
+func (l *Limiter) OnExpire(callback ExpireCallback) {
+	l.hookMutex.Lock()
+	l.expireCallbacks = append(l.expireCallbacks, callback)
+	l.hookMutex.Unlock()
+}
+
+// released will notify the notifiers and the unlimit callbacks about
+// the end of the punishment of the key. ctx can be nil.
+func (l *Limiter) released(ctx *ext.Context, key int64, action string, d core.Decision) {
+	l.notify(EventUnlimited, action, ctx, key, d)
+	l.revertPunishments(key)
+	if action == ActionManual {
+		l.cancelTriggers(key)
+	} else {
+		l.releaseTriggers(key)
+	}
+
+	l.hookMutex.RLock()
+	callbacks := l.unlimitCallbacks
+	l.hookMutex.RUnlock()
+
+	if len(callbacks) == 0 {
+		return
+	}
+
+	go func() {
+		for _, callback := range callbacks {
+			if callback != nil {
+				callback(key, action)
+			}
+		}
+	}()
+}
+
+// sweep will sweep the statuses of the core limiter, and will run the
+// callbacks of the released and evicted keys.
+func (l *Limiter) sweep() {
+	started := time.Now()
+	result := l.core.SweepEntries()
+
+	l.hookMutex.Lock()
+	l.sweepStats.Sweeps++
+	l.sweepStats.LastSweep = started
+	l.sweepStats.LastDuration = time.Since(started)
+	l.sweepStats.Evicted = len(result.Evicted)
+	l.sweepStats.Released = len(result.Released)
+	l.hookMutex.Unlock()
+
+	l.expireCustomIgnores(result.ExpiredIgnores)
+
+	maxCount := l.core.GetProfile().MessageCount
+	for _, key := range result.Released {
+		l.released(nil, key, ActionExpire, core.Decision{
+			MaxCount: maxCount,
+		})
+	}
+
+	l.hookMutex.RLock()
+	callbacks := l.expireCallbacks
+	l.hookMutex.RUnlock()
+
+	if len(callbacks) == 0 || len(result.Evicted) == 0 {
+		return
+	}
+
+	go func() {
+		for key := range result.Evicted {
+			status := result.Evicted[key]
+			for _, callback := range callbacks {
+				if callback != nil {
+					callback(key, &status)
+				}
+			}
+		}
+	}()
+}
+
+// expireCustomIgnores will clean up after the expired custom ignores,
+// and will run the custom ignore callbacks for them.
+func (l *Limiter) expireCustomIgnores(expired []CustomIgnoreInfo) {
+	if len(expired) == 0 {
+		return
+	}
+
+	store := l.GetStorage()
+	for i := range expired {
+		if expired[i].IgnoreExceptions {
+			l.removeFromIgnoredExceptions(expired[i].Key)
+		}
+
+		if store != nil {
+			_ = store.Delete(customIgnoreKey(expired[i].Key))
+		}
+	}
+
+	l.hookMutex.RLock()
+	callbacks := l.customIgnoreCallbacks
+	l.hookMutex.RUnlock()
+
+	if len(callbacks) == 0 {
+		return
+	}
+
+	go func() {
+		for _, info := range expired {
+			for _, callback := range callbacks {
+				if callback != nil {
+					callback(info)
+				}
+			}
+		}
+	}()
+}
+
+// notify will send a new event to the notifiers of this limiter.
+// ctx can be nil.
+func (l *Limiter) notify(eventType, action string, ctx *ext.Context, key int64, d core.Decision) {
+	if len(l.notifiers.load()) == 0 && len(l.auditSinks.load()) == 0 {
+		return
+	}
+
+	event := &LimitEvent{
+		Type:     eventType,
+		Key:      key,
+		Count:    d.Count,
+		MaxCount: d.MaxCount,
+		Action:   action,
+		Time:     time.Now(),
+	}
+
+	if ctx != nil {
+		if ctx.EffectiveChat != nil {
+			event.ChatID = ctx.EffectiveChat.Id
+		}
+
+		if ctx.EffectiveSender != nil {
+			event.UserID = ctx.EffectiveSender.Id()
+		}
+	}
+
+	l.auditEvent(event)
+	l.sendEvent(event)
+}
+
+// notifyError will send a new failure event with the error to the
+// notifiers of this limiter; the failures are not audited.
+func (l *Limiter) notifyError(eventType string, err error) {
+	l.sendEvent(&LimitEvent{
+		Type:  eventType,
+		Error: err.Error(),
+		Time:  time.Now(),
+	})
+}
+
+// sendEvent will send the event to the notifiers of this limiter; they
+// are called synchronously, so they receive the events in order.
+func (l *Limiter) sendEvent(event *LimitEvent) {
+	for _, n := range l.notifiers.load() {
+		if n != nil {
+			_ = n.Notify(event)
+		}
+	}
+}
+
+// answerDebounced will answer the dropped button press with the debounce
+// toast, if there is any; b can be nil, in which case nothing is sent.
+func (l *Limiter) answerDebounced(b *gotgbot.Bot, cq *gotgbot.CallbackQuery) {
+	_, toast := l.GetCallbackDebounce()
+	if b == nil || toast == "" {
+		return
+	}
+
+	toast = l.translate(cq.From.LanguageCode, toast, func(b *Bundle) string {
+		return b.DebounceToast
+	})
+
+	l.runJob(b, func(b *gotgbot.Bot) error {
+		_, err := cq.Answer(b, &gotgbot.AnswerCallbackQueryOpts{Text: toast})
+		return err
+	})
+}
+
+// sendChallenge will send a verification challenge for the limited
+// status to the chat, using the send queue if there is any.
+func (l *Limiter) sendChallenge(b *gotgbot.Bot, ctx *ext.Context, config *ChallengeConfig, key int64, p *LimitProfile) {
+	if config == nil || ctx.EffectiveChat == nil || ctx.EffectiveUser == nil {
+		return
+	}
+
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = p.PunishmentTime
+	}
+
+	nonce := newNonce()
+	answer := rand.Intn(len(config.Choices))
+	var row []gotgbot.InlineKeyboardButton
+	for i, choice := range config.Choices {
+		row = append(row, gotgbot.InlineKeyboardButton{
+			Text:         choice,
+			CallbackData: ChallengeCallbackPrefix + nonce + ":" + strconv.Itoa(i),
+		})
+	}
+
+	text := l.translate(ctx.EffectiveUser.LanguageCode, config.Text, func(b *Bundle) string {
+		return b.ChallengeText
+	})
+
+	chatID := ctx.EffectiveChat.Id
+	userID := ctx.EffectiveUser.Id
+	l.runJob(b, func(b *gotgbot.Bot) error {
+		msg, err := b.SendMessage(chatID, strings.ReplaceAll(text, "{choice}", config.Choices[answer]),
+			&gotgbot.SendMessageOpts{
+				ReplyMarkup: gotgbot.InlineKeyboardMarkup{
+					InlineKeyboard: [][]gotgbot.InlineKeyboardButton{row},
+				},
+			})
+		if err != nil {
+			return err
+		}
+
+		l.mutex.Lock()
+		if l.challenges != nil {
+			l.challenges[nonce] = &pendingChallenge{
+				key:       key,
+				userID:    userID,
+				chatID:    chatID,
+				messageID: msg.MessageId,
+				answer:    answer,
+				config:    config,
+				expiresAt: time.Now().Add(timeout),
+			}
+		}
+		l.mutex.Unlock()
+
+		return nil
+	})
+}
+
+// sendReply will reply to the message of the update with the given
+// text of the built-in reply, after replacing its placeholders.
+func (l *Limiter) sendReply(b *gotgbot.Bot, ctx *ext.Context, config *ReplyConfig, name, text, remaining string, until time.Time) {
+	msg := ctx.EffectiveMessage
+	if msg == nil {
+		return
+	}
+
+	text = l.renderReply(name, ctx, config, text, remaining, until)
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	opts := &gotgbot.SendMessageOpts{
+		BusinessConnectionId: getBusinessConnection(ctx),
+		ParseMode:            gotgbot.ParseModeHTML,
+		ReplyParameters: &gotgbot.ReplyParameters{
+			MessageId:                msg.MessageId,
+			AllowSendingWithoutReply: true,
+		},
+	}
+	if msg.IsTopicMessage {
+		opts.MessageThreadId = msg.MessageThreadId
+	}
+
+	chatID := msg.Chat.Id
+	l.runJob(b, func(b *gotgbot.Bot) error {
+		sent, err := b.SendMessage(chatID, text, opts)
+		if err != nil || config.DeleteAfter <= 0 || opts.BusinessConnectionId != "" {
+			return err
+		}
+
+		time.AfterFunc(config.DeleteAfter, func() {
+			l.runJob(b, func(b *gotgbot.Bot) error {
+				_, err := b.DeleteMessage(chatID, sent.MessageId, nil)
+				return err
+			})
+		})
+		return nil
+	})
+}
+
+// replyLimited will reply to the message which has got its sender
+// limited with the built-in reply of the limiter.
+func (l *Limiter) replyLimited(b *gotgbot.Bot, ctx *ext.Context, key int64) {
+	config := l.reply.Load()
+	if config == nil {
+		return
+	}
+
+	until := time.Now()
+	if snapshot := l.core.GetSnapshot(key); snapshot != nil && snapshot.LimitedUntil != nil {
+		until = *snapshot.LimitedUntil
+	}
+
+	text := l.translate(getLanguageCode(ctx), config.Text, func(b *Bundle) string {
+		return b.LimitText
+	})
+
+	remaining := time.Until(until).Round(time.Second)
+	l.sendReply(b, ctx, config, TemplateLimit, text, remaining.String(), until)
+}
+
+// replyUnlimited will reply to the first message of the user whose
+// punishment is over with the built-in reply of the limiter.
+func (l *Limiter) replyUnlimited(b *gotgbot.Bot, ctx *ext.Context) {
+	config := l.reply.Load()
+	if config == nil || (config.UnlimitText == "" && !l.hasTemplate(TemplateUnlimit)) {
+		return
+	}
+
+	text := l.translate(getLanguageCode(ctx), config.UnlimitText, func(b *Bundle) string {
+		return b.UnlimitText
+	})
+	l.sendReply(b, ctx, config, TemplateUnlimit, text, "0s", time.Now())
+}
+
+// SetSendQueue will set the send queue of this limiter. When the queue
+// is set, the requests sent by the limiter itself (such as challenges)
+// are sent through the queue, so they are retried on 429 errors.
+// NOTICE: the requests are still sent by the bot which has received the
+// update, not the bot of the queue; so the same queue can be shared by
+// all of the bots the limiter is attached to.
+func (l *Limiter) SetSendQueue(q *outbound.Queue) {
+	l.sendQueue.Store(q)
+}
+
+// GetSendQueue returns the send queue of this limiter.
+func (l *Limiter) GetSendQueue() *outbound.Queue {
+	return l.sendQueue.Load()
+}
+
+// runJob will run the job with the given bot using the send queue of the
+// limiter; if there is no send queue, or it's full, the job will be run
+// in a new goroutine.
+func (l *Limiter) runJob(b *gotgbot.Bot, job outbound.Job) {
+	// the job is bound to the bot here, as the queue runs its jobs with
+	// its own bot.
+	bound := func(*gotgbot.Bot) error {
+		return job(b)
+	}
+
+	if q := l.sendQueue.Load(); q != nil && q.Enqueue(bound) == nil {
+		return
+	}
+
+	go func() {
+		_ = job(b)
+	}()
+}
+
+// Check will check the update and returns the decision of the limiter
+// about it, exactly as the limiter's handler does; the update is counted
+// toward the quota of its sender. it's useful for the bots which don't
+// use the dispatcher (see `Standalone`), or want to control the limiter
+// manually inside their own handlers.
+// the updates which are not checked by the limiter at all (such as the
+// exceptions, or when the limiter is stopped) are exempt.
+// NOTICE: as there is no bot here, the triggers and the challenges of
+// the limiter are not run for the updates checked by this method.
+func (l *Limiter) Check(ctx *ext.Context) Decision {
+	started := time.Now()
+	if !l.shouldCheck(ctx) || !l.meetsConditions(ctx) {
+		l.observeCheck(started, core.ResultExempt)
+		return Decision{Result: core.ResultExempt}
+	}
+
+	id, ok := l.getKey(ctx)
+	if !ok {
+		l.observeCheck(started, core.ResultExempt)
+		return Decision{Result: core.ResultExempt}
+	}
+
+	d, _ := l.check(nil, ctx, id)
+	l.observeCheck(started, d.Result)
+	return d
+}
+
+// CheckMessage will check the message and returns the decision of the
+// limiter about it; see `Check` for more information.
+func (l *Limiter) CheckMessage(msg *gotgbot.Message) Decision {
+	return l.Check(ext.NewContext(&gotgbot.Update{Message: msg}, nil))
+}
+
+// meetsConditions returns true if the update meets all of the context
+// conditions of the limiter.
+func (l *Limiter) meetsConditions(ctx *ext.Context) bool {
+	for _, con := range l.contextConditions.load() {
+		if !con(ctx) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shouldCheck returns true if the update passes the filters of the
+// limiter's handlers.
+func (l *Limiter) shouldCheck(ctx *ext.Context) bool {
+	switch {
+	case ctx.CallbackQuery != nil:
+		return l.callbackFilter(ctx.CallbackQuery)
+	case ctx.InlineQuery != nil:
+		return l.inlineFilter(ctx.InlineQuery)
+	case ctx.MessageReaction != nil:
+		return l.reactionFilter(ctx.MessageReaction)
+	case ctx.MessageReactionCount != nil:
+		return l.reactionCountFilter(ctx)
+	case ctx.ChatBoost != nil || ctx.RemovedChatBoost != nil:
+		return l.boostFilter(ctx)
+	case ctx.EffectiveMessage != nil:
+		return l.limiterFilter(ctx.EffectiveMessage)
+	}
+
+	return false
+}
+
+// check will check the update with the given key and will take the
+// actions of the limiter for the newly limited keys. b can be nil, in
+// which case the triggers and the challenges are not run.
+func (l *Limiter) check(b *gotgbot.Bot, ctx *ext.Context, id int64) (core.Decision, *LimitProfile) {
+	l.trackJoins(ctx.Message)
+	l.trackRaid(b, ctx)
+	if l.getFlags().ServicePolicy == ServiceDetect && ctx.EffectiveMessage != nil &&
+		isServiceMessage(ctx.EffectiveMessage) {
+		// service messages are not counted toward the quota of
+		// their senders in this mode.
+		l.checkJoinFlood(b, ctx)
+		return core.Decision{Result: core.ResultExempt}, nil
+	}
+
+	if cq := ctx.CallbackQuery; cq != nil && l.debounce(l.scopeKey(b, id), cq) {
+		l.answerDebounced(b, cq)
+		return core.Decision{Result: core.ResultDropped}, nil
+	}
+
+	if l.checkLockdown(b, ctx) || l.slowDown(b, ctx) {
+		return core.Decision{Result: core.ResultDropped}, nil
+	}
+
+	p := l.adaptProfile(ctx, l.getProfile(b, ctx, id))
+
+	// the profile is resolved by the id, but the state is kept by the
+	// scoped key.
+	if l.isCountedCommand(ctx) {
+		id = CommandKey(id)
+	}
+
+	id = l.scopeKey(b, id)
+	r := &core.Request{
+		Exempt:  l.isExceptionCtx(ctx),
+		Strict:  l.getFlags().IsStrict,
+		Profile: p,
+
+		MaxPunishment: l.GetMaxPunishment(),
+		PartialReset:  l.getFlags().PartialReset,
+		WarnThreshold: l.GetWarnThreshold(),
+	}
+
+	if r.Exempt {
+		// no need to score the updates of the exceptions.
+		r.Cost = 1
+	} else {
+		r.Cost, r.Limit = l.getCost(ctx)
+		l.applyRisk(ctx, r)
+	}
+
+	if l.getFlags().CountAlbumsOnce && !l.isNewAlbum(ctx.EffectiveMessage) {
+		// the rest of the album is not counted, but it's still
+		// blocked if its sender is limited.
+		r.Cost = 0
+		r.Limit = false
+	}
+
+	blocked := !r.Exempt && l.isBlockedForward(ctx.EffectiveMessage)
+	flagged := !r.Exempt && !blocked && l.isKnownSpammer(ctx)
+	if blocked || flagged || (!r.Exempt && l.checkGlobal(b, ctx, r.Cost)) {
+		r.Limit = true
+	}
+
+	if !r.Exempt && l.checkMention(b, ctx, id, r.Cost) {
+		r.Limit = true
+	}
+
+	voiceFlood := !r.Exempt && l.checkVoice(ctx, id, r.Cost)
+	if voiceFlood {
+		r.Limit = true
+	}
+
+	d := l.applyDecisionHook(ctx, id, l.core.AllowRequest(id, r))
+	l.recordStats(ctx, id, d)
+	l.recordReport(id, d)
+	if p == nil && !d.IsAllowed() {
+		// the profile is only needed by the limited updates.
+		p = l.getDefaultProfile()
+	}
+
+	if d.Released {
+		l.released(ctx, id, ActionExpire, d)
+		if b != nil {
+			l.replyUnlimited(b, ctx)
+		}
+	}
+
+	if d.Warning {
+		l.warn(b, ctx, id, d)
+	}
+
+	if blocked {
+		if b != nil {
+			l.applyActions(b, ctx, id, d, p, l.blockedActions.load())
+		}
+
+		l.notify(EventBlockedSource, ActionIgnore, ctx, id, d)
+	}
+
+	if voiceFlood && l.getFlags().DeleteVoiceFlood && b != nil {
+		l.applyActions(b, ctx, id, d, p, []Action{&DeleteAction{}})
+	}
+
+	if flagged {
+		if b != nil {
+			l.applyActions(b, ctx, id, d, p, l.reputationActions.load())
+		}
+
+		l.notify(EventReputation, ActionIgnore, ctx, id, d)
+	}
+
+	if b != nil && r.Strict && d.Result == core.ResultLimited && !d.NewlyLimited {
+		// the punishment of the user has been extended by this update.
+		l.startTriggers(b, ctx, id, l.getTriggers(ctx, id, true))
+	}
+
+	if d.NewlyLimited {
+		if b != nil {
+			l.startTriggers(b, ctx, id, l.getTriggers(ctx, id, false))
+			l.replyLimited(b, ctx, id)
+		}
+
+		actions := l.actions.load()
+		if action := l.escalate(ctx, id, d, p); action != nil {
+			actions = append(copySlice(actions), action)
+		}
+
+		l.punish(ctx, id)
+		if b != nil {
+			l.applyActions(b, ctx, id, d, p, actions)
+		}
+		l.recordIncident(ctx, id, d)
+
+		action := ActionIgnore
+
+		// channels cannot solve the challenges, as there is no
+		// real user behind their messages; the business chats are not
+		// challenged either, as they belong to the business account.
+		// the challenges unlimit the key of the solver, so they're only
+		// sent if the key belongs to the user (and not the whole chat).
+		challenge := l.getChallengeConfig(ctx.EffectiveChat)
+		if b != nil && challenge != nil && l.getFlags().ConsiderUser &&
+			getSenderChat(ctx.EffectiveMessage) == nil && getBusinessConnection(ctx) == "" {
+			action = ActionChallenge
+			l.sendChallenge(b, ctx, challenge, id, p)
+		}
+
+		l.notify(EventLimited, action, ctx, id, d)
+		l.publish(&core.SyncEvent{
+			Type: core.SyncLimit,
+			Key:  id,
+		})
+	}
+
+	return d, p
+}
+
+// SetFlagOnly will put the limiter in the "flag only" mode; the limited
+// updates won't be swallowed by the limiter anymore, they will only be
+// marked as limited in the context's data, so the other handlers can
+// decide themselves whether to respond (see `IsLimited` and `GetLimitInfo`).
+// passing false sets the propagation back to `PropagationEndGroups`.
+func (l *Limiter) SetFlagOnly(flagOnly bool) {
+	l.setFlags(func(f *limiterFlags) {
+		if flagOnly {
+			f.Propagation = PropagationAnnotate
+		} else {
+			f.Propagation = PropagationEndGroups
+		}
+	})
+}
+
+// IsFlagOnly returns true if the limiter is in the "flag only" mode.
+func (l *Limiter) IsFlagOnly() bool {
+	return l.getFlags().Propagation == PropagationAnnotate
+}
+
+// propagate returns the error which should be returned from the handler
+// for the limited update, based on the propagation of the limiter.
+func (l *Limiter) propagate(ctx *ext.Context, key int64, d core.Decision, p *LimitProfile) error {
+	switch l.getFlags().Propagation {
+	case PropagationEndGroup:
+		return nil
+	case PropagationAnnotate:
+		if ctx.Data == nil {
+			ctx.Data = make(map[string]interface{})
+		}
+
+		ctx.Data[ContextLimitedKey] = true
+		ctx.Data[ContextLimitInfoKey] = newLimitInfo(ctx, key, d, p)
+		return ext.ContinueGroups
+	default:
+		return ext.EndGroups
+	}
+}
+
+// scopeKey returns the key scoped by the id of the bot if `ScopeByBot`
+// is true; b can be nil, in which case the key is not scoped.
+func (l *Limiter) scopeKey(b *gotgbot.Bot, key int64) int64 {
+	if !l.getFlags().ScopeByBot || b == nil {
+		return key
+	}
+
+	return BotKey(b.Id, key)
+}
+
+// getKey returns the key of the update in the limiter; it returns false
+// if the update cannot be checked at all.
+// the messages sent on behalf of a channel (or an anonymous admin) have
+// a dummy user as their sender, so they are keyed by the id of the
+// sender chat instead.
+func (l *Limiter) getKey(ctx *ext.Context) (int64, bool) {
+	if isChannelPost(ctx) {
+		// the posts of a channel are sent by the channel itself.
+		return ctx.EffectiveChat.Id, true
+	}
+
+	if l.getFlags().LimitChannelSenders {
+		if chat := getSenderChat(ctx.EffectiveMessage); chat != nil {
+			return chat.Id, true
+		}
+	}
+
+	var id int64
+	if l.getFlags().ConsiderUser && ctx.EffectiveSender != nil {
+		id = ctx.EffectiveSender.Id()
+	}
+
+	if id == 0 && ctx.EffectiveChat != nil {
+		id = ctx.EffectiveChat.Id
+	}
+
+	if id == 0 {
+		return 0, false
+	}
+
+	if connection := getBusinessConnection(ctx); connection != "" {
+		// the same user may talk to several business accounts
+		// connected to the bot; each conversation has its own limits.
+		return BusinessKey(connection, id), true
+	}
+
+	return id, true
+}
+
+// hasTextCondition will check if the message meets the message condition
+// or not.
+// basically this method will check if the type of the message is one of
+// the counted types of the limiter (see `SetCountedTypes`).
+func (l *Limiter) hasTextCondition(msg *gotgbot.Message) bool {
+	types := l.GetCountedTypes()
+	if types&getMessageType(msg) != 0 {
+		return true
+	}
+
+	// photo-with-caption spam shouldn't be able to bypass the text
+	// only limiters.
+	return l.getFlags().CountCaptions && types&TypeText != 0 && msg.Caption != ""
+}
+
+// warn will mark the update as the one which has made the user reach
+// the warning threshold, and runs the warning triggers of the limiter.
+// b can be nil, in which case the triggers are not run.
+func (l *Limiter) warn(b *gotgbot.Bot, ctx *ext.Context, key int64, d core.Decision) {
+	if ctx.Data == nil {
+		ctx.Data = make(map[string]interface{})
+	}
+
+	ctx.Data[ContextWarnedKey] = true
+	if b != nil {
+		if triggers := l.warnTriggers.load(); len(triggers) != 0 {
+			go l.runTriggers(b, ctx, triggers)
+		}
+
+		if config := l.reply.Load(); config != nil && (config.WarnText != "" || l.hasTemplate(TemplateWarn)) {
+			text := l.translate(getLanguageCode(ctx), config.WarnText, func(b *Bundle) string {
+				return b.WarnText
+			})
+
+			remaining := strconv.Itoa(d.MaxCount - d.Count)
+			l.sendReply(b, ctx, config, TemplateWarn, text, remaining, time.Now())
+		}
+	}
+
+	l.notify(EventWarned, ActionWarn, ctx, key, d)
+}
+
+// runTriggers will run the given triggers of the limiter.
+// this method should be called in a separate goroutine.
+func (l *Limiter) runTriggers(b *gotgbot.Bot, ctx *ext.Context, triggers []handlers.Response) {
+	for _, trigger := range triggers {
+		if trigger != nil {
+			trigger(b, ctx)
+		}
+	}
+}
+
+// isException will check and see if msg can be ignored because
+// it's id is in the exception list or not. This method's usage
+// is internal-only.
+func (l *Limiter) isException(msg *gotgbot.Message) bool {
+	if l.exceptionIDs.hasMessage(msg) {
+		return true
+	}
+
+	if msg == nil {
+		return false
+	}
+
+	if len(l.scopedExceptions.load()) != 0 {
+		if msg.From != nil && l.IsScopedException(msg.Chat.Id, msg.From.Id) {
+			return true
+		}
+
+		if msg.SenderChat != nil && l.IsScopedException(msg.Chat.Id, msg.SenderChat.Id) {
+			return true
+		}
+	}
+
+	return l.matchExceptionRules(msg.From)
+}
+
+func (l *Limiter) isExceptionCtx(ctx *ext.Context) bool {
+	if ctx.CallbackQuery != nil {
+		return l.isExceptionQuery(ctx.CallbackQuery)
+	}
+	return l.isException(ctx.Message)
+}
+
+// isException will check and see if msg can be ignored because
+// it's id is in the exception list or not. This method's usage
+// is internal-only.
+func (l *Limiter) isExceptionQuery(cq *gotgbot.CallbackQuery) bool {
+	if l.exceptionIDs.hasQuery(cq) {
+		return true
+	}
+
+	if cq == nil {
+		return false
+	}
+
+	if cq.Message != nil && l.IsScopedException(cq.Message.GetChat().Id, cq.From.Id) {
+		return true
+	}
+
+	return l.matchExceptionRules(&cq.From)
+}
+
+// matchExceptionRules returns true if the user matches any of the
+// exception rules of the limiter; u can be nil.
+func (l *Limiter) matchExceptionRules(u *gotgbot.User) bool {
+	if u == nil {
+		return false
+	}
+
+	for _, rule := range l.exceptionRules.load() {
+		if rule.Match(u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isIgnoredException will check and see if msg cannot be ignored because
+// it's id is in the exception list or not. This method's usage
+// is internal-only.
+func (l *Limiter) isIgnoredException(msg *gotgbot.Message) bool {
+	return l.ignoredExceptions.hasMessage(msg)
+}
+
+// isIgnoredException will check and see if msg cannot be ignored because
+// it's id is in the exception list or not. This method's usage
+// is internal-only.
+func (l *Limiter) isIgnoredExceptionQuery(cq *gotgbot.CallbackQuery) bool {
+	return l.ignoredExceptions.hasQuery(cq)
+}
+
+func (l *Limiter) addIgnoredExceptions(id int64) {
+	l.ignoredExceptions.Add(id)
+}
+
+func (l *Limiter) removeFromIgnoredExceptions(id int64) {
+	l.ignoredExceptions.Remove(id)
+}
+
+// checker should be run in a new goroutine as it blocks its goroutine
+// with a for-loop. This method's duty is to clear the old user's status
+// from the cache once per checker interval, until the stop channel is
+// closed; it closes the done channel when it returns.
+func (l *Limiter) checker(stop, done chan struct{}) {
+	defer close(done)
+
+	// the interval is already validated by `Start` method, so
+	// we won't end up running an unlimited loop with the highest
+	// possible speed here.
+	ticker := time.NewTicker(l.GetCheckerInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			l.cleanup()
+		}
+	}
+}
+
+// cleanup will clear the old entries of the caches of the limiter; it's
+// called by the checker goroutine once per checker interval.
+func (l *Limiter) cleanup() {
+	l.sweep()
+	l.sweepRoles()
+	l.pruneChatStats()
+	l.pruneStatsHistory()
+	l.prunePresses()
+	l.pruneAlerts()
+	l.pruneThreads()
+	l.pruneAlbums()
+	l.pruneSlowed()
+	l.pruneRaids()
+	l.pruneFlaps()
+	l.pruneViolations()
+	l.pruneOffenses()
+	l.prunePendingTriggers()
+	l.pruneReputations()
+	l.updateActivities()
+
+	if limiter := l.joinDetector.Load(); limiter != nil {
+		limiter.Sweep()
+	}
+
+	if limiter := l.paymentLimiter.Load(); limiter != nil {
+		limiter.Sweep()
+	}
+
+	if limiter := l.globalLimiter.Load(); limiter != nil {
+		limiter.Sweep()
+	}
+
+	if limiter := l.mentionLimiter.Load(); limiter != nil {
+		limiter.Sweep()
+	}
+
+	if limiter := l.voiceLimiter.Load(); limiter != nil {
+		limiter.Sweep()
+	}
+
+	l.lockdownMutex.Lock()
+	detector := l.lockdownDetector
+	l.lockdownMutex.Unlock()
+	if detector != nil {
+		detector.Sweep()
+	}
+
+	l.mutex.Lock()
+	for key, joined := range l.joinedUsers {
+		if time.Since(joined) > l.getProbationDuration() {
+			delete(l.joinedUsers, key)
+		}
+	}
+
+	for nonce, pending := range l.challenges {
+		if time.Now().After(pending.expiresAt) {
+			delete(l.challenges, nonce)
+		}
+	}
+	l.mutex.Unlock()
+}
+
+// sweepRoles will remove the expired roles from the cache.
+func (l *Limiter) sweepRoles() {
+	l.roleMutex.Lock()
+	for key, cached := range l.roles {
+		if time.Now().After(cached.expiresAt) {
+			delete(l.roles, key)
+		}
+	}
+	l.roleMutex.Unlock()
+}
+
+//---------------------------------------------------------
+
+// Config returns the current configuration of this limiter, which can
+// be persisted (for example as JSON), compared with another config, or
+// be applied to another limiter using `ApplyConfig`.
+// NOTICE: the things which can't be serialized (such as the triggers,
+// exceptions filters, conditions, notifiers and the tier resolver) are
+// not part of the config.
+func (l *Limiter) Config() Config {
+	p := l.core.GetProfile()
+	c := Config{
+		ConsiderChannel:  l.IsAllowingChannels(),
+		ConsiderUser:     l.getFlags().ConsiderUser,
+		ConsiderEdits:    l.IsAllowingEdits(),
+		ConsiderBusiness: l.IsAllowingBusiness(),
+		ConsiderInline:   l.getFlags().ConsiderInline,
+		IgnoreMediaGroup: l.getFlags().IgnoreMediaGroup,
+		CountAlbumsOnce:  l.getFlags().CountAlbumsOnce,
+		TextOnly:         l.IsTextOnly(),
+		IsStrict:         l.getFlags().IsStrict,
+		PartialReset:     l.getFlags().PartialReset,
+		OptIn:            l.getFlags().OptIn,
+		CountedTypes:     getMessageTypeNames(l.GetCountedTypes()),
+		ChatScopes:       getChatScopeNames(l.GetChatScopes()),
+		CountCaptions:    l.getFlags().CountCaptions,
+		ConsiderPayments: l.getFlags().ConsiderPayments,
+		DeleteVoiceFlood: l.getFlags().DeleteVoiceFlood,
+		ScopeByBot:       l.getFlags().ScopeByBot,
+		Propagation:      l.getFlags().Propagation,
+		StopPolicy:       l.getFlags().StopPolicy,
+		ServicePolicy:    l.getFlags().ServicePolicy,
+		Timeout:          Duration(p.Timeout),
+		PunishmentTime:   Duration(p.PunishmentTime),
+		MaxTimeout:       Duration(l.GetMaxCacheDuration()),
+		MessageCount:     p.MessageCount,
+		Throttle:         Duration(p.Throttle),
+		MaxPunishment:    Duration(l.GetMaxPunishment()),
+		CheckerInterval:  Duration(l.checkerInterval.Load()),
+		WarnThreshold:    l.GetWarnThreshold(),
+		ExceptionIDs:     l.exceptionIDs.List(),
+		Enrolled:         l.enrolled.List(),
+		AllowedCommands:  l.GetAllowedCommands(),
+
+		ScopedExceptions:     l.ListScopedExceptions(),
+		TopicExceptions:      l.ListTopicExceptions(),
+		BlockedSources:       l.blockedSources.List(),
+		ExceptionRules:       l.GetExceptionRules(),
+		LimitChannelSenders:  l.getFlags().LimitChannelSenders,
+		ExemptAutoForwards:   l.getFlags().ExemptAutoForwards,
+		ProbationProfile:     newProfileConfig(l.GetProbationProfile()),
+		ProbationDuration:    Duration(l.getProbationDuration()),
+		ChannelSenderProfile: newProfileConfig(l.GetChannelSenderProfile()),
+		ChannelPostProfile:   newProfileConfig(l.GetChannelPostProfile()),
+		CommentProfile:       newProfileConfig(l.GetCommentProfile()),
+		JoinFloodProfile:     newProfileConfig(l.GetJoinFloodProfile()),
+		PaymentProfile:       newProfileConfig(l.GetPaymentProfile()),
+		GlobalProfile:        newProfileConfig(l.GetGlobalProfile()),
+		MentionProfile:       newProfileConfig(l.GetMentionProfile()),
+		CommandProfile:       newProfileConfig(l.GetCommandProfile()),
+		VoiceProfile:         newProfileConfig(l.GetVoiceProfile()),
+		Challenge:            newChallengeFileConfig(l.challenge.Load()),
+		Reply:                newReplyFileConfig(l.reply.Load()),
+		Delay:                newDelayFileConfig(l.GetDelay()),
+		Raid:                 newRaidFileConfig(l.GetRaid()),
+		Flapping:             newFlappingFileConfig(l.GetFlapping()),
+		Adaptive:             l.GetAdaptive(),
+		Risk:                 l.GetRisk(),
+		StatsRetention:       Duration(l.GetStatsRetention()),
+		StatsHistory:         Duration(l.GetStatsHistory()),
+		MaxEntries:           l.GetMaxEntries(),
+	}
+
+	debounce, toast := l.GetCallbackDebounce()
+	c.CallbackDebounce = Duration(debounce)
+	c.DebounceToast = toast
+
+	l.tierMutex.RLock()
+	if len(l.tierProfiles) != 0 {
+		c.TierProfiles = make(map[Tier]*ProfileConfig, len(l.tierProfiles))
+		for tier, profile := range l.tierProfiles {
+			c.TierProfiles[tier] = newProfileConfig(profile)
+		}
+	}
+	l.tierMutex.RUnlock()
+
+	l.bundleMutex.RLock()
+	if len(l.bundles) != 0 {
+		c.Bundles = make(map[string]*Bundle, len(l.bundles))
+		for lang, bundle := range l.bundles {
+			b := *bundle
+			c.Bundles[lang] = &b
+		}
+	}
+	l.bundleMutex.RUnlock()
+
+	l.updateMutex.RLock()
+	if len(l.updateProfiles) != 0 {
+		c.UpdateProfiles = make(map[UpdateType]*ProfileConfig, len(l.updateProfiles))
+		for updateType, profile := range l.updateProfiles {
+			c.UpdateProfiles[updateType] = newProfileConfig(profile)
+		}
+	}
+	l.updateMutex.RUnlock()
+
+	l.roleMutex.RLock()
+	c.RoleCacheTime = Duration(l.roleCacheTime)
+	if len(l.roleProfiles) != 0 {
+		c.RoleProfiles = make(map[Role]*ProfileConfig, len(l.roleProfiles))
+		for role, profile := range l.roleProfiles {
+			c.RoleProfiles[role] = newProfileConfig(profile)
+		}
+	}
+	l.roleMutex.RUnlock()
+
+	l.settingsMutex.RLock()
+	for _, settings := range l.chatSettings {
+		c.Chats = append(c.Chats, newChatFileConfig(settings))
+	}
+	l.settingsMutex.RUnlock()
+
+	sort.Slice(c.Chats, func(i, j int) bool {
+		return c.Chats[i].ChatID < c.Chats[j].ChatID
+	})
+
+	return c
+}
+
+// ApplyConfig will apply the whole configuration to this limiter; the
+// values are swapped one by one, not all at once (see `ApplyFileConfig`
+// for more information).
+func (l *Limiter) ApplyConfig(c Config) error {
+	return l.ApplyFileConfig(&c)
+}
+
+// ApplyFileConfig will apply the configuration loaded from a file to
+// this limiter. it's safe to call it while the limiter is running;
+// each value of the config is swapped on its own, so the updates being
+// checked while the config is applied may see some of the new values
+// together with some of the old ones.
+// NOTICE: `ConsiderChannel`, `ConsiderEdits` and `ConsiderBusiness` can't
+// be changed after the limiter is created, so they are ignored by this
+// method. the chat overrides of the config are added to the current
+// chat settings of the limiter (replacing the existing ones of the same
+// chats); the settings of the other chats are kept.
+// If the configuration is not valid, it won't be applied at all and
+// the validation error will be returned.
+func (l *Limiter) ApplyFileConfig(c *Config) error {
+	if c == nil {
+		return nil
+	}
+
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	l.setFlags(func(f *limiterFlags) {
+		f.ConsiderUser = c.ConsiderUser
+		f.ConsiderInline = c.ConsiderInline
+		f.IgnoreMediaGroup = c.IgnoreMediaGroup
+		f.CountAlbumsOnce = c.CountAlbumsOnce
+		f.CountCaptions = c.CountCaptions
+		f.IsStrict = c.IsStrict
+		f.PartialReset = c.PartialReset
+		f.OptIn = c.OptIn
+		f.LimitChannelSenders = c.LimitChannelSenders
+		f.ExemptAutoForwards = c.ExemptAutoForwards
+		f.ConsiderPayments = c.ConsiderPayments
+		f.ScopeByBot = c.ScopeByBot
+		f.Propagation = c.Propagation
+		f.StopPolicy = c.StopPolicy
+		f.ServicePolicy = c.ServicePolicy
+		f.DeleteVoiceFlood = c.DeleteVoiceFlood
+	})
+	l.SetTextOnly(c.TextOnly)
+	if len(c.CountedTypes) != 0 {
+		// the types are already validated above.
+		types, _ := ParseMessageType(c.CountedTypes...)
+		l.SetCountedTypes(types)
+	}
+	// the scopes are already validated above as well.
+	scopes, _ := ParseChatScope(c.ChatScopes...)
+	l.SetChatScopes(scopes)
+	l.enrolled.Set(c.Enrolled)
+	l.allowedCommands.replace(nil)
+	l.AddAllowedCommands(c.AllowedCommands...)
+	l.core.SetProfile(core.Profile{
+		Timeout:        time.Duration(c.Timeout),
+		PunishmentTime: time.Duration(c.PunishmentTime),
+		MessageCount:   c.MessageCount,
+		Throttle:       time.Duration(c.Throttle),
+	})
+	l.maxTimeout.Store(int64(c.MaxTimeout))
+	l.SetMaxPunishment(time.Duration(c.MaxPunishment))
+	l.SetCheckerInterval(time.Duration(c.CheckerInterval))
+	l.SetWarnThreshold(c.WarnThreshold)
+	l.exceptionIDs.Set(c.ExceptionIDs)
+	l.scopedExceptions.replace(c.ScopedExceptions)
+	l.topicExceptions.replace(c.TopicExceptions)
+	l.blockedSources.Set(c.BlockedSources)
+	l.ClearExceptionRules()
+	_ = l.AddExceptionRule(c.ExceptionRules...)
+
+	l.SetProbation(c.ProbationProfile.LimitProfile(), time.Duration(c.ProbationDuration))
+	l.SetChannelSenderProfile(c.ChannelSenderProfile.LimitProfile())
+	l.SetChannelPostProfile(c.ChannelPostProfile.LimitProfile())
+	l.SetCommentProfile(c.CommentProfile.LimitProfile())
+	l.SetJoinFloodProfile(c.JoinFloodProfile.LimitProfile())
+	l.SetPaymentProfile(c.PaymentProfile.LimitProfile())
+	l.SetGlobalProfile(c.GlobalProfile.LimitProfile())
+	l.SetMentionProfile(c.MentionProfile.LimitProfile())
+	l.SetCommandProfile(c.CommandProfile.LimitProfile())
+	l.SetVoiceProfile(c.VoiceProfile.LimitProfile())
+	l.SetChallenge(c.Challenge.ChallengeConfig())
+	l.SetReply(c.Reply.ReplyConfig())
+	l.SetDelay(c.Delay.DelayConfig())
+	l.SetRaid(c.Raid.RaidConfig())
+	l.SetFlapping(c.Flapping.FlappingConfig())
+	l.SetAdaptive(c.Adaptive)
+	l.SetRisk(c.Risk)
+	l.SetStatsRetention(time.Duration(c.StatsRetention))
+	l.SetStatsHistory(time.Duration(c.StatsHistory))
+	l.SetMaxEntries(c.MaxEntries)
+	l.SetCallbackDebounce(time.Duration(c.CallbackDebounce), c.DebounceToast)
+
+	l.tierMutex.Lock()
+	l.tierProfiles = make(map[Tier]*LimitProfile, len(c.TierProfiles))
+	for tier, profile := range c.TierProfiles {
+		if profile != nil {
+			l.tierProfiles[tier] = profile.LimitProfile()
+		}
+	}
+	l.tierMutex.Unlock()
+
+	l.bundleMutex.Lock()
+	l.bundles = nil
+	l.bundleMutex.Unlock()
+	for lang, bundle := range c.Bundles {
+		l.SetBundle(lang, bundle)
+	}
+
+	l.updateMutex.Lock()
+	l.updateProfiles = make(map[UpdateType]*LimitProfile, len(c.UpdateProfiles))
+	for updateType, profile := range c.UpdateProfiles {
+		if profile != nil {
+			l.updateProfiles[updateType] = profile.LimitProfile()
+		}
+	}
+	l.updateMutex.Unlock()
+
+	l.SetRoleCacheTime(time.Duration(c.RoleCacheTime))
+	l.roleMutex.Lock()
+	l.roleProfiles = make(map[Role]*LimitProfile, len(c.RoleProfiles))
+	for role, profile := range c.RoleProfiles {
+		if profile != nil {
+			l.roleProfiles[role] = profile.LimitProfile()
+		}
+	}
+	l.roleMutex.Unlock()
+
+	l.settingsMutex.Lock()
+	if l.chatSettings == nil {
+		l.chatSettings = make(map[int64]*ChatSettings)
+	}
+
+	for _, chat := range c.Chats {
+		l.chatSettings[chat.ChatID] = chat.ChatSettings()
+	}
+	l.settingsMutex.Unlock()
+
+	return nil
+}
+
+// WatchConfig will watch the config file in the given path and apply
+// it to this limiter whenever the file is modified. the file is checked
+// for modifications once per interval (`DefaultConfigWatchInterval` is
+// used if interval is zero).
+// the config file is applied once before this method returns.
+func (l *Limiter) WatchConfig(path string, interval time.Duration) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		interval = DefaultConfigWatchInterval
+	}
+
+	return startWatcher(&ConfigWatcher{
+		interval: interval,
+		modified: func() (time.Time, error) {
+			info, err := os.Stat(path)
+			if err != nil {
+				return time.Time{}, err
+			}
+
+			return info.ModTime(), nil
+		},
+		load: func() error {
+			c, err := LoadConfig(path)
+			if err != nil {
+				return err
+			}
+
+			return l.ApplyFileConfig(c)
+		},
+	})
+}
+
+// WatchTemplates will watch the directory of the templates of the built-in
+// replies and loads them again (see `LoadTemplates`) whenever a template
+// file is added, removed or modified, so the phrasing of the bot can be
+// changed without restarting it. the directory is checked once per
+// interval (`DefaultConfigWatchInterval` is used if interval is zero).
+// the templates are loaded once before this method returns.
+func (l *Limiter) WatchTemplates(dir string, interval time.Duration) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		interval = DefaultConfigWatchInterval
+	}
+
+	return startWatcher(&ConfigWatcher{
+		interval: interval,
+		modified: func() (time.Time, error) {
+			return templatesModTime(dir)
+		},
+		load: func() error {
+			return l.LoadTemplates(dir)
+		},
+	})
+}
+
+// LoadTemplates will load the templates of the built-in replies from the
+// template files of the directory, such as "limit.tmpl" (see the names
+// of `TemplateLimit`, `TemplateWarn` and `TemplateUnlimit`); a template
+// can be translated by a file with the language code of the users, such
+// as "limit.es.tmpl". the files are parsed by the text/template package
+// and are executed with `TemplateData`; the templates override the texts
+// of the reply config (see `SetReply`), which is still needed for
+// enabling the replies.
+// the old templates remain in effect if the directory has an invalid
+// template file. pass an empty path to remove the templates.
+func (l *Limiter) LoadTemplates(dir string) error {
+	if dir == "" {
+		l.templates.Store(nil)
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	set := make(templateSet)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != TemplateExt {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSuffix(entry.Name(), TemplateExt))
+		name, _, _ := strings.Cut(key, ".")
+		if name != TemplateLimit && name != TemplateWarn && name != TemplateUnlimit {
+			return fmt.Errorf("%w: unknown template %q", ErrInvalidTemplate, entry.Name())
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		t, err := template.New(key).Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+		}
+
+		set[key] = t
+	}
+
+	l.templates.Store(&set)
+	return nil
+}
+
+// hasTemplate returns true if the template of the built-in reply has
+// been loaded (in any language).
+func (l *Limiter) hasTemplate(name string) bool {
+	set := l.templates.Load()
+	if set == nil {
+		return false
+	}
+
+	for key := range *set {
+		if key == name || strings.HasPrefix(key, name+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderReply returns the text of the built-in reply with the given name,
+// rendered by its template if any; otherwise the placeholders of the text
+// are replaced. the text is used if the template fails as well.
+func (l *Limiter) renderReply(name string, ctx *ext.Context, config *ReplyConfig, text, remaining string, until time.Time) string {
+	formatted := formatReply(text, ctx, remaining, until.Format(config.TimeFormat))
+	set := l.templates.Load()
+	if set == nil {
+		return formatted
+	}
+
+	lang := strings.ToLower(getLanguageCode(ctx))
+	base, _, _ := strings.Cut(lang, "-")
+	t := (*set)[name+"."+lang]
+	if t == nil {
+		t = (*set)[name+"."+base]
+	}
+	if t == nil {
+		t = (*set)[name]
+	}
+	if t == nil {
+		return formatted
+	}
+
+	data := &TemplateData{
+		Remaining: remaining,
+		Until:     until.Format(config.TimeFormat),
+		UntilTime: until,
+		Language:  lang,
+	}
+	if sender := ctx.EffectiveSender; sender != nil {
+		data.UserID = sender.Id()
+	}
+	if c := ctx.EffectiveChat; c != nil {
+		data.ChatID = c.Id
+	}
+
+	// the names are escaped the same way as the placeholders.
+	data.User = formatReply("{user}", ctx, "", "")
+	data.Mention = formatReply("{mention}", ctx, "", "")
+	data.Chat = formatReply("{chat}", ctx, "", "")
+
+	var buf strings.Builder
+	if err := t.Execute(&buf, data); err != nil {
+		return formatted
+	}
+
+	return buf.String()
+}
+
+// Stop will stop watching the config file.
+func (w *ConfigWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
+
+// watch should be run in a new goroutine; it checks the config file
+// for modifications until the watcher is stopped.
+func (w *ConfigWatcher) watch() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			modTime, err := w.modified()
+			if err != nil {
+				w.onError(err)
+				continue
+			}
+
+			if modTime.Equal(w.modTime) {
+				continue
+			}
+
+			if err = w.reload(); err != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// reload will load the watched files and apply them to the limiter.
+func (w *ConfigWatcher) reload() error {
+	modTime, err := w.modified()
+	if err != nil {
+		return err
+	}
+
+	if err = w.load(); err != nil {
+		return err
+	}
+
+	w.modTime = modTime
+	return nil
+}
+
+// onError calls the error handler of the watcher, if any.
+func (w *ConfigWatcher) onError(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+//---------------------------------------------------------
+
+// LimiterConfig converts the file config to a `LimiterConfig`, which
+// can be passed to `NewLimiter`.
+func (c *Config) LimiterConfig() *LimiterConfig {
+	// invalid type names are reported by `Validate`.
+	types, _ := ParseMessageType(c.CountedTypes...)
+	scopes, _ := ParseChatScope(c.ChatScopes...)
+
+	return &LimiterConfig{
+		ConsiderChannel:  c.ConsiderChannel,
+		ConsiderUser:     c.ConsiderUser,
+		ConsiderEdits:    c.ConsiderEdits,
+		ConsiderBusiness: c.ConsiderBusiness,
+		ConsiderInline:   c.ConsiderInline,
+		IgnoreMediaGroup: c.IgnoreMediaGroup,
+		CountAlbumsOnce:  c.CountAlbumsOnce,
+		TextOnly:         c.TextOnly,
+		IsStrict:         c.IsStrict,
+		PartialReset:     c.PartialReset,
+		Timeout:          time.Duration(c.Timeout),
+		PunishmentTime:   time.Duration(c.PunishmentTime),
+		MaxTimeout:       time.Duration(c.MaxTimeout),
+		MessageCount:     c.MessageCount,
+		Throttle:         time.Duration(c.Throttle),
+		MaxPunishment:    time.Duration(c.MaxPunishment),
+		CheckerInterval:  time.Duration(c.CheckerInterval),
+		WarnThreshold:    c.WarnThreshold,
+		CountedTypes:     types,
+		ChatScopes:       scopes,
+		CountCaptions:    c.CountCaptions,
+
+		ScopeByBot:       c.ScopeByBot,
+		ConsiderPayments: c.ConsiderPayments,
+		DeleteVoiceFlood: c.DeleteVoiceFlood,
+		Propagation:      c.Propagation,
+		StopPolicy:       c.StopPolicy,
+		ServicePolicy:    c.ServicePolicy,
+
+		LimitChannelSenders: c.LimitChannelSenders,
+		ExemptAutoForwards:  c.ExemptAutoForwards,
+	}
+}
+
+// Validate will check the values of the file config and will return an
+// error describing the first nonsensical value it finds.
+func (c *Config) Validate() error {
+	err := validateValues(time.Duration(c.Timeout), time.Duration(c.PunishmentTime),
+		time.Duration(c.MaxTimeout), c.MessageCount, c.IsStrict)
+	if err != nil {
+		return err
+	}
+
+	if c.Throttle < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidThrottle, time.Duration(c.Throttle))
+	}
+
+	if c.MaxPunishment < 0 {
+		return fmt.Errorf("%w: max punishment %v", ErrInvalidPunishment, time.Duration(c.MaxPunishment))
+	}
+
+	if c.CheckerInterval != 0 && c.CheckerInterval < Duration(time.Second) {
+		return fmt.Errorf("%w: %v", ErrInvalidInterval, time.Duration(c.CheckerInterval))
+	}
+
+	if c.WarnThreshold < 0 || c.WarnThreshold > 1 {
+		return fmt.Errorf("%w: %v", ErrInvalidWarning, c.WarnThreshold)
+	}
+
+	if _, err = ParseMessageType(c.CountedTypes...); err != nil {
+		return err
+	}
+
+	if _, err = ParseChatScope(c.ChatScopes...); err != nil {
+		return err
+	}
+
+	if err = validateAdaptive(c.Adaptive); err != nil {
+		return err
+	}
+
+	if err = validateRisk(c.Risk); err != nil {
+		return err
+	}
+
+	if err = validateDelay(c.Delay.DelayConfig()); err != nil {
+		return err
+	}
+
+	if err = validateRaid(c.Raid.RaidConfig()); err != nil {
+		return err
+	}
+
+	if err = validateFlapping(c.Flapping.FlappingConfig()); err != nil {
+		return err
+	}
+
+	for i := range c.ExceptionRules {
+		if err = c.ExceptionRules[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	profiles := map[string]*ProfileConfig{
+		"probation":      c.ProbationProfile,
+		"channel sender": c.ChannelSenderProfile,
+		"channel post":   c.ChannelPostProfile,
+		"comment":        c.CommentProfile,
+		"join flood":     c.JoinFloodProfile,
+		"payment":        c.PaymentProfile,
+		"global":         c.GlobalProfile,
+		"mention":        c.MentionProfile,
+		"command":        c.CommandProfile,
+		"voice":          c.VoiceProfile,
+	}
+
+	for tier, profile := range c.TierProfiles {
+		profiles[fmt.Sprintf("tier %d", tier)] = profile
+	}
+
+	for role, profile := range c.RoleProfiles {
+		profiles["role "+string(role)] = profile
+	}
+
+	for updateType, profile := range c.UpdateProfiles {
+		if !updateType.IsValid() {
+			return fmt.Errorf("%w: %q", ErrInvalidUpdateType, updateType)
+		}
+
+		profiles["update type "+string(updateType)] = profile
+	}
+
+	for name, profile := range profiles {
+		if profile == nil {
+			continue
+		}
+
+		if err = profile.LimitProfile().Validate(); err != nil {
+			return fmt.Errorf("%s profile: %w", name, err)
+		}
+	}
+
+	for _, chat := range c.Chats {
+		if chat.MessageCount < 0 || chat.Timeout < 0 || chat.PunishmentTime < 0 ||
+			chat.SlowMode < 0 {
+			return fmt.Errorf("%w: negative values for chat %d", ErrInvalidChatSettings, chat.ChatID)
+		}
+	}
+
+	return nil
+}
+
+// LimitProfile converts the profile config to a `LimitProfile`; it
+// returns nil if the profile config is nil.
+func (p *ProfileConfig) LimitProfile() *LimitProfile {
+	if p == nil {
+		return nil
+	}
+
+	return &LimitProfile{
+		Timeout:        time.Duration(p.Timeout),
+		PunishmentTime: time.Duration(p.PunishmentTime),
+		MessageCount:   p.MessageCount,
+		Throttle:       time.Duration(p.Throttle),
+	}
+}
+
+// ChallengeConfig converts the challenge file config to a
+// `ChallengeConfig`; it returns nil if the file config is nil.
+func (c *ChallengeFileConfig) ChallengeConfig() *ChallengeConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &ChallengeConfig{
+		Text:          c.Text,
+		SuccessText:   c.SuccessText,
+		FailureText:   c.FailureText,
+		Choices:       copySlice(c.Choices),
+		Timeout:       time.Duration(c.Timeout),
+		DeleteOnSolve: c.DeleteOnSolve,
+	}
+}
+
+// ReplyConfig converts the reply file config to a `ReplyConfig`; it
+// returns nil if the file config is nil.
+func (c *ReplyFileConfig) ReplyConfig() *ReplyConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &ReplyConfig{
+		Text:        c.Text,
+		WarnText:    c.WarnText,
+		UnlimitText: c.UnlimitText,
+		TimeFormat:  c.TimeFormat,
+		DeleteAfter: time.Duration(c.DeleteAfter),
+	}
+}
+
+// DelayConfig converts the delay file config to a `DelayConfig`; it
+// returns nil if the file config is nil.
+func (c *DelayFileConfig) DelayConfig() *DelayConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &DelayConfig{
+		MaxDepth: c.MaxDepth,
+		MaxAge:   time.Duration(c.MaxAge),
+	}
+}
+
+// RaidConfig converts the raid file config to a `RaidConfig`; it
+// returns nil if the file config is nil.
+func (c *RaidFileConfig) RaidConfig() *RaidConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &RaidConfig{
+		Joins:    c.Joins,
+		Window:   time.Duration(c.Window),
+		Lockdown: c.Lockdown,
+	}
+}
+
+// FlappingConfig converts the flapping file config to a `FlappingConfig`;
+// it returns nil if the file config is nil.
+func (c *FlappingFileConfig) FlappingConfig() *FlappingConfig {
+	if c == nil {
+		return nil
+	}
+
+	return &FlappingConfig{
+		Changes:        c.Changes,
+		Window:         time.Duration(c.Window),
+		IgnoreDuration: time.Duration(c.IgnoreDuration),
+	}
+}
+
+// Score returns the risk of the user, which is the sum of the weights of
+// the risk signals matched by the user.
+func (c *RiskConfig) Score(user *gotgbot.User) int {
+	if c == nil || user == nil {
+		return 0
+	}
+
+	risk := 0
+	newAccountID := c.NewAccountID
+	if newAccountID == 0 {
+		newAccountID = DefaultRiskNewAccountID
+	}
+
+	if user.Id > newAccountID {
+		risk += c.NewAccount
+	}
+
+	if user.Username == "" {
+		risk += c.NoUsername
+	}
+
+	if user.IsPremium {
+		risk += c.Premium
+	}
+
+	if user.LastName == "" && user.Username == "" && user.LanguageCode == "" {
+		risk += c.EmptyProfile
+	}
+
+	return risk
+}
+
+// ChatSettings converts the chat file config to `ChatSettings`.
+func (c *ChatFileConfig) ChatSettings() *ChatSettings {
+	return &ChatSettings{
+		ChatID:         c.ChatID,
+		Disabled:       c.Disabled,
+		Timeout:        time.Duration(c.Timeout),
+		PunishmentTime: time.Duration(c.PunishmentTime),
+		MessageCount:   c.MessageCount,
+		Action:         c.Action,
+		SlowMode:       time.Duration(c.SlowMode),
+		DeleteSlowed:   c.DeleteSlowed,
+	}
+}
+
+//---------------------------------------------------------
+
+// MarshalJSON encodes the duration as a human readable string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes the duration from a string or a number.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		value = string(data)
+	}
+
+	parsed, err := parseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML encodes the duration as a human readable string.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// UnmarshalYAML decodes the duration from a string or a number.
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := parseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+//---------------------------------------------------------
+
+// Notify will queue the event to be sent to the endpoint of the webhook;
+// the events are sent in order by the worker goroutine of the webhook,
+// which retries the failed requests with an exponential backoff. it
+// returns `ErrWebhookQueueFull` if too many events are waiting, which is
+// reported to `OnError` as well.
+func (w *WebhookNotifier) Notify(event *LimitEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.queue == nil {
+		size := w.QueueSize
+		if size <= 0 {
+			size = DefaultWebhookQueueSize
+		}
+
+		var ctx context.Context
+		ctx, w.cancel = context.WithCancel(context.Background())
+		w.queue = make(chan []byte, size)
+		w.done = make(chan struct{})
+		go w.worker(ctx, w.queue, w.done)
+	}
+
+	select {
+	case w.queue <- payload:
+		return nil
+	default:
+		if w.OnError != nil {
+			w.OnError(ErrWebhookQueueFull)
+		}
+		return ErrWebhookQueueFull
+	}
+}
+
+// Stop will stop the worker goroutine of the webhook, aborting its
+// current request; the events waiting in the queue are dropped. the
+// next event starts the worker again.
+func (w *WebhookNotifier) Stop() {
+	w.mutex.Lock()
+	cancel, done := w.cancel, w.done
+	w.queue = nil
+	w.cancel = nil
+	w.done = nil
+	w.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// worker sends the queued events to the endpoint one by one, until the
+// context is canceled.
+func (w *WebhookNotifier) worker(ctx context.Context, queue <-chan []byte, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-queue:
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := w.send(ctx, payload)
+			if err != nil && ctx.Err() == nil && w.OnError != nil {
+				w.OnError(err)
+			}
+		}
+	}
+}
+
+// send will send the payload to the endpoint of the webhook, retrying
+// with an exponential backoff when the request fails because of the
+// network or the server (5xx and 429 responses).
+func (w *WebhookNotifier) send(ctx context.Context, payload []byte) error {
+	backoff := w.Backoff
+	for i := 0; ; i++ {
+		retry, err := w.post(ctx, payload)
+		if err == nil || !retry || i >= w.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+	}
+}
+
+// post will send the payload to the endpoint of the webhook once; the
+// returned bool is true if the failed request can be retried.
+func (w *WebhookNotifier) post(ctx context.Context, payload []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = webhookClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+
+	return false, nil
+}
+
+//---------------------------------------------------------
+
+// Audit will append the record to the file as a JSON line.
+func (s *FileAuditSink) Audit(record *AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+// Close will close the file of the sink.
+func (s *FileAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+//---------------------------------------------------------
+
+//---------------------------------------------------------
+
+// NewLimiter creates a new limiter with the given dispatcher and config
+// (see the package-level `NewLimiter`) and registers it with the given
+// name; it returns `ErrLimiterExists` if the name is already taken.
+func (r *Registry) NewLimiter(name string, dispatcher *ext.Dispatcher, config *LimiterConfig) (*Limiter, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.limiters[name] != nil {
+		return nil, ErrLimiterExists
+	}
+
+	l := NewLimiter(dispatcher, config)
+	r.limiters[name] = l
+	return l, nil
+}
+
+// Register will register the limiter with the given name; it returns
+// `ErrLimiterExists` if the name is already taken.
+func (r *Registry) Register(name string, l *Limiter) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.limiters[name] != nil {
+		return ErrLimiterExists
+	}
+
+	r.limiters[name] = l
+	return nil
+}
+
+// Get returns the limiter registered with the given name; it returns
+// nil if there is no such limiter.
+func (r *Registry) Get(name string) *Limiter {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.limiters[name]
+}
+
+// Remove will remove the limiter with the given name from the registry
+// and returns it; the limiter is not stopped.
+func (r *Registry) Remove(name string) *Limiter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	l := r.limiters[name]
+	delete(r.limiters, name)
+	return l
+}
+
+// Names returns the sorted names of the registered limiters.
+func (r *Registry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	names := make([]string, 0, len(r.limiters))
+	for name := range r.limiters {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// StartAll will start all of the registered limiters; the limiters are
+// started even if some of them fail, and the first error is returned.
+func (r *Registry) StartAll() error {
+	var first error
+	for _, l := range r.getAll() {
+		if err := l.Start(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// StopAll will stop all of the registered limiters.
+func (r *Registry) StopAll() {
+	for _, l := range r.getAll() {
+		l.Stop()
+	}
+}
+
+// Metrics returns the metrics of all of the registered limiters.
+func (r *Registry) Metrics() *RegistryMetrics {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	m := &RegistryMetrics{
+		Limiters: make(map[string]Metrics, len(r.limiters)),
+	}
+
+	for name, l := range r.limiters {
+		current := l.Metrics()
+		m.Limiters[name] = current
+		m.Tracked += current.Tracked
+		m.Limited += current.Limited
+	}
+
+	return m
+}
+
+// getAll returns all of the registered limiters.
+func (r *Registry) getAll() []*Limiter {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	all := make([]*Limiter, 0, len(r.limiters))
+	for _, l := range r.limiters {
+		all = append(all, l)
+	}
+
+	return all
+}
+
+//---------------------------------------------------------
+
+// compose returns the report of the collected activity until the time.
+func (s *reportState) compose(until time.Time) *Report {
+	r := &Report{
+		Since:     s.since,
+		Until:     until,
+		Messages:  s.messages,
+		Blocked:   s.blocked,
+		Limits:    s.limits,
+		Offenders: len(s.offenders),
+	}
+
+	for key, limits := range s.offenders {
+		r.TopOffenders = append(r.TopOffenders, Offender{Key: key, Limits: limits})
+	}
+
+	sort.Slice(r.TopOffenders, func(i, j int) bool {
+		a, b := r.TopOffenders[i], r.TopOffenders[j]
+		if a.Limits != b.Limits {
+			return a.Limits > b.Limits
+		}
+
+		return a.Key < b.Key
+	})
+
+	if len(r.TopOffenders) > s.config.TopCount {
+		r.TopOffenders = r.TopOffenders[:s.config.TopCount]
+	}
+
+	return r
+}
+
+// reset returns the report of the collected activity until the time,
+// and will start collecting a new period.
+func (s *reportState) reset(until time.Time) *Report {
+	r := s.compose(until)
+	s.since = until
+	s.messages = 0
+	s.blocked = 0
+	s.limits = 0
+	s.offenders = make(map[int64]int)
+
+	return r
+}
+
+//---------------------------------------------------------
+
+// Next returns the first minute after t which matches the schedule.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// a matching time is always found within a few years (february
+	// 29th being the worst case), so the loop is bounded.
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return limit
+}
+
+// matchDay returns true if the day of t matches the day of month and
+// the day of week fields of the schedule.
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	day := s.day&(1<<uint(t.Day())) != 0
+	weekday := s.weekday&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// Next returns t plus the interval of the schedule.
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+//---------------------------------------------------------
+
+// Apply does nothing, as the limiter ignores the limited updates anyway.
+func (a *IgnoreAction) Apply(b *gotgbot.Bot, ctx *ext.Context, info *LimitInfo) error {
+	return nil
+}
+
+// Revert does nothing.
+func (a *IgnoreAction) Revert(b *gotgbot.Bot, info *LimitInfo) error {
+	return nil
+}
+
+func (a *IgnoreAction) auditAction() string { return "" }
+func (a *IgnoreAction) reversal() string    { return "" }
+
+// Apply will delete the limited message.
+func (a *DeleteAction) Apply(b *gotgbot.Bot, ctx *ext.Context, info *LimitInfo) error {
+	if info.MessageID == 0 {
+		return nil
+	}
+
+	_, err := b.DeleteMessage(info.ChatID, info.MessageID, nil)
+	return err
+}
+
+// Revert does nothing, as the deleted messages can't be restored.
+func (a *DeleteAction) Revert(b *gotgbot.Bot, info *LimitInfo) error {
+	return nil
+}
+
+func (a *DeleteAction) auditAction() string { return AuditDelete }
+func (a *DeleteAction) reversal() string    { return "" }
+
+// Apply will restrict the limited user from sending messages to the group.
+func (a *MuteAction) Apply(b *gotgbot.Bot, ctx *ext.Context, info *LimitInfo) error {
+	if !isPunishable(ctx) {
+		return nil
+	}
+
+	_, err := b.RestrictChatMember(info.ChatID, info.UserID, gotgbot.ChatPermissions{}, nil)
+	return err
+}
+
+// Revert will lift the restrictions of the user.
+func (a *MuteAction) Revert(b *gotgbot.Bot, info *LimitInfo) error {
+	return revertPunishment(b, &ScheduledAction{
+		ChatID: info.ChatID,
+		UserID: info.UserID,
+		Action: AuditUnmute,
+	})
+}
+
+func (a *MuteAction) auditAction() string { return AuditMute }
+func (a *MuteAction) reversal() string    { return AuditUnmute }
+
+// Apply will remove the limited user from the group, without banning
+// them.
+func (a *KickAction) Apply(b *gotgbot.Bot, ctx *ext.Context, info *LimitInfo) error {
+	if !isPunishable(ctx) {
+		return nil
+	}
+
+	_, err := b.BanChatMember(info.ChatID, info.UserID, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.UnbanChatMember(info.ChatID, info.UserID, &gotgbot.UnbanChatMemberOpts{
+		OnlyIfBanned: true,
+	})
+	return err
+}
+
+// Revert does nothing, as the kicked users can join the group again.
+func (a *KickAction) Revert(b *gotgbot.Bot, info *LimitInfo) error {
+	return nil
+}
+
+func (a *KickAction) auditAction() string { return AuditKick }
+func (a *KickAction) reversal() string    { return "" }
+
+// Apply will ban the limited user from the group.
+func (a *BanAction) Apply(b *gotgbot.Bot, ctx *ext.Context, info *LimitInfo) error {
+	if !isPunishable(ctx) {
+		return nil
+	}
+
+	_, err := b.BanChatMember(info.ChatID, info.UserID, nil)
+	return err
+}
+
+// Revert will unban the user.
+func (a *BanAction) Revert(b *gotgbot.Bot, info *LimitInfo) error {
+	return revertPunishment(b, &ScheduledAction{
+		ChatID: info.ChatID,
+		UserID: info.UserID,
+		Action: AuditUnban,
+	})
+}
+
+func (a *BanAction) auditAction() string { return AuditBan }
+func (a *BanAction) reversal() string    { return AuditUnban }
+
+// Apply will reply to the limited message with the warning.
+func (a *WarnAction) Apply(b *gotgbot.Bot, ctx *ext.Context, info *LimitInfo) error {
+	if info.ChatID == 0 || a.Text == "" {
+		return nil
+	}
+
+	remaining := time.Until(info.Until).Round(time.Second)
+	text := formatReply(a.Text, ctx, remaining.String(), info.Until.Format(DefaultReplyTimeFormat))
+	opts := &gotgbot.SendMessageOpts{
+		BusinessConnectionId: getBusinessConnection(ctx),
+		ParseMode:            gotgbot.ParseModeHTML,
+	}
+
+	if info.MessageID != 0 {
+		opts.ReplyParameters = &gotgbot.ReplyParameters{
+			MessageId:                info.MessageID,
+			AllowSendingWithoutReply: true,
+		}
+	}
+
+	if msg := ctx.EffectiveMessage; msg != nil && msg.IsTopicMessage {
+		opts.MessageThreadId = msg.MessageThreadId
+	}
+
+	_, err := b.SendMessage(info.ChatID, text, opts)
+	return err
+}
+
+// Revert does nothing, as the warning has already been sent.
+func (a *WarnAction) Revert(b *gotgbot.Bot, info *LimitInfo) error {
+	return nil
+}
+
+func (a *WarnAction) auditAction() string { return AuditWarn }
+func (a *WarnAction) reversal() string    { return "" }
+
+//---------------------------------------------------------
+
+// Escalate returns the punishment of the n-th violation, which is
+// `Base + (n-1) * Step`.
+func (e *LinearEscalation) Escalate(history []Incident) Escalation {
+	n := len(history)
+	base := e.Base
+	if base == 0 {
+		base = DefaultPunishmentTime
+	}
+
+	return Escalation{
+		Action:   getLadderAction(e.Actions, n),
+		Duration: capDuration(float64(base)+float64(n-1)*float64(e.Step), e.Max),
+	}
+}
+
+// Escalate returns the punishment of the n-th violation, which is
+// `Base * Factor^(n-1)`.
+func (e *ExponentialEscalation) Escalate(history []Incident) Escalation {
+	n := len(history)
+	base, factor := e.Base, e.Factor
+	if base == 0 {
+		base = DefaultPunishmentTime
+	}
+
+	if factor == 0 {
+		factor = DefaultEscalationFactor
+	}
+
+	return Escalation{
+		Action:   getLadderAction(e.Actions, n),
+		Duration: capDuration(float64(base)*math.Pow(factor, float64(n-1)), e.Max),
+	}
+}
+
+//---------------------------------------------------------
+
+// IsValid returns true if the update type is one of the update types
+// checked by the limiter.
+func (t UpdateType) IsValid() bool {
+	switch t {
+	case UpdateMessage, UpdateEdit, UpdateCallback, UpdateInline, UpdateReaction,
+		UpdateReactionCount, UpdateBoost:
+		return true
+	}
+
+	return false
+}
+
+//---------------------------------------------------------
+
+// String returns the name of the propagation used in the config files.
+func (p Propagation) String() string {
+	if p < 0 || int(p) >= len(propagationNames) {
+		return "propagation(" + strconv.Itoa(int(p)) + ")"
+	}
+
+	return propagationNames[p]
+}
+
+// MarshalText will marshal the propagation as its name, such as
+// "end_groups" or "annotate".
+func (p Propagation) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(propagationNames) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPropagation, int(p))
+	}
+
+	return []byte(propagationNames[p]), nil
+}
+
+// UnmarshalText will unmarshal the propagation from its name.
+func (p *Propagation) UnmarshalText(text []byte) error {
+	i := parseName(propagationNames, string(text))
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidPropagation, text)
+	}
+
+	*p = Propagation(i)
+	return nil
+}
+
+// String returns the name of the stop policy used in the config files.
+func (p StopPolicy) String() string {
+	if p < 0 || int(p) >= len(stopPolicyNames) {
+		return "stop_policy(" + strconv.Itoa(int(p)) + ")"
+	}
+
+	return stopPolicyNames[p]
+}
+
+// MarshalText will marshal the stop policy as its name, such as "clear"
+// or "retain".
+func (p StopPolicy) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(stopPolicyNames) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidStopPolicy, int(p))
+	}
+
+	return []byte(stopPolicyNames[p]), nil
+}
+
+// UnmarshalText will unmarshal the stop policy from its name.
+func (p *StopPolicy) UnmarshalText(text []byte) error {
+	i := parseName(stopPolicyNames, string(text))
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidStopPolicy, text)
+	}
+
+	*p = StopPolicy(i)
+	return nil
+}
+
+// String returns the name of the service policy used in the config files.
+func (p ServicePolicy) String() string {
+	if p < 0 || int(p) >= len(servicePolicyNames) {
+		return "policy(" + strconv.Itoa(int(p)) + ")"
+	}
+
+	return servicePolicyNames[p]
 }
 
-// AddException will add an exception filter to this limiter.
-func (l *Limiter) AddException(ex filters.Message) {
-	l.exceptions = append(l.exceptions, ex)
+// MarshalText will marshal the service policy as its name, such as
+// "count" or "detect".
+func (p ServicePolicy) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(servicePolicyNames) {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPolicy, int(p))
+	}
+
+	return []byte(servicePolicyNames[p]), nil
 }
 
-// ClearAllExceptions will clear all exception of this limiter.
-// this way, you will be sure that all of incoming updates will be
-// checked for floodwait by this limiter.
-func (l *Limiter) ClearAllExceptions() {
-	l.exceptions = nil
+// UnmarshalText will unmarshal the service policy from its name.
+func (p *ServicePolicy) UnmarshalText(text []byte) error {
+	i := parseName(servicePolicyNames, string(text))
+	if i < 0 {
+		return fmt.Errorf("%w: %q", ErrInvalidPolicy, text)
+	}
+
+	*p = ServicePolicy(i)
+	return nil
 }
 
-// GetExceptions returns the filters array used by this limiter as
-// its exceptions list.
-func (l *Limiter) GetExceptions() []filters.Message {
-	return l.exceptions
+//---------------------------------------------------------
+
+// CheckUpdate returns true if the update should be handled by this
+// handler.
+func (h *updateHandler) CheckUpdate(b *gotgbot.Bot, ctx *ext.Context) bool {
+	return h.filter(ctx)
 }
 
-// IsTextOnly will return true if and only if this limiter is
-// checking for text-only messages.
-func (l *Limiter) IsTextOnly() bool {
-	return l.TextOnly
+// HandleUpdate will handle the update by the response of the handler.
+func (h *updateHandler) HandleUpdate(b *gotgbot.Bot, ctx *ext.Context) error {
+	return h.response(b, ctx)
 }
 
-// SetTextOnly will set the limiter to check for text-only messages.
-// pass true to this method to make the limiter check for text-only
-// messages.
-func (l *Limiter) SetTextOnly(t bool) {
-	l.TextOnly = t
+// Name returns the name of the handler.
+func (h *updateHandler) Name() string {
+	return h.name
 }
 
-// IsAllowingChannels will return true if and only if this limiter
-// is checking for messages from channels.
-func (l *Limiter) IsAllowingChannels() bool {
-	if l.msgHandler == nil {
+// matches returns true if the update meets the filter of the trigger;
+// extension is true if the punishment of the user is being extended in
+// the strict mode, and first is true if it's the first offense of them.
+func (t *Trigger) matches(ctx *ext.Context, extension, first bool) bool {
+	if t.Response == nil || extension != (t.Filter&TriggerStrictExtension != 0) {
 		return false
 	}
-	return l.msgHandler.AllowChannel
-}
 
-// IsAllowingEdits will return true if and only if this limiter
-// is checking for "edited message" update from telegram.
-func (l *Limiter) IsAllowingEdits() bool {
-	if l.msgHandler == nil {
+	if t.Filter&TriggerGroupsOnly != 0 &&
+		(ctx.EffectiveChat == nil || getChatScope(ctx.EffectiveChat.Type) != ScopeGroups) {
 		return false
 	}
-	return l.msgHandler.AllowEdited
-}
 
-// AddExceptionID will add a group/user/channel ID to the exception
-// list of the limiter.
-func (l *Limiter) AddExceptionID(id ...int64) {
-	l.exceptionIDs = append(l.exceptionIDs, id...)
+	return t.Filter&TriggerFirstOffense == 0 || first
 }
 
-// AddCondition will add a condition to be checked by this limiter,
-// if this condition doesn't return true, the limiter won't check
-// the message for anti-flood-wait.
-func (l *Limiter) AddCondition(condition filters.Message) {
-	l.conditions = append(l.conditions, condition)
+// Name returns the name of the handler, which is unique to the limiter.
+func (h *namedHandler) Name() string {
+	return h.name
 }
 
-// ClearAllConditions clears all condition list.
-func (l *Limiter) ClearAllConditions() {
-	l.conditions = nil
+// HandleUpdate will store the dispatcher of the handler in the context,
+// and then handles the update.
+func (h *boundHandler) HandleUpdate(b *gotgbot.Bot, ctx *ext.Context) error {
+	setContextDispatcher(ctx, h.dispatcher)
+	return h.Handler.HandleUpdate(b, ctx)
 }
 
-// AddConditions will accept an array of the conditions and will
-// add them to the condition list of this limiter.
-// you can also pass only one value to this method.
-func (l *Limiter) AddConditions(conditions ...filters.Message) {
-	l.conditions = append(l.conditions, conditions...)
-}
+//---------------------------------------------------------
 
-// SetAsConditions will accept an array of conditions and will set
-// the conditions of the limiter to them.
-func (l *Limiter) SetAsConditions(conditions []filters.Message) {
-	l.conditions = conditions
+// add will add a sample to the ring, replacing the oldest one if the
+// ring is full.
+func (s *checkSamples) add(latency time.Duration, result core.Result) {
+	s.mutex.Lock()
+	s.latencies[s.next] = latency
+	s.results[s.next] = result
+	s.next = (s.next + 1) % checkSamplesWindow
+	if s.count < checkSamplesWindow {
+		s.count++
+	}
+	s.mutex.Unlock()
 }
 
-// ClearAllExceptions will clear all exception IDs of this limiter.
-// this way, you will be sure that all of incoming updates will be
-// checked for floodwait by this limiter.
-func (l *Limiter) ClearAllExceptionIDs() {
-	l.exceptionIDs = nil
-}
+// snapshot returns the latency percentiles and the decision distribution
+// of the samples in the ring.
+func (s *checkSamples) snapshot() (LatencyStats, DecisionStats) {
+	s.mutex.Lock()
+	latencies := make([]time.Duration, s.count)
+	copy(latencies, s.latencies[:s.count])
 
-// IsInExceptionList will check and see if an ID is in the
-// exception list of the listener or not.
-func (l *Limiter) IsInExceptionList(id int64) bool {
-	if len(l.exceptionIDs) == 0 {
-		return false
+	var decisions DecisionStats
+	for _, result := range s.results[:s.count] {
+		switch result {
+		case core.ResultAllowed:
+			decisions.Allowed++
+		case core.ResultExempt:
+			decisions.Exempt++
+		default:
+			decisions.Limited++
+		}
 	}
+	s.mutex.Unlock()
 
-	for _, ex := range l.exceptionIDs {
-		if ex == id {
-			return true
+	if len(latencies) == 0 {
+		return LatencyStats{}, decisions
+	}
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+
+	percentile := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(latencies)))) - 1
+		if i < 0 {
+			i = 0
 		}
+
+		return latencies[i]
 	}
 
-	return false
-}
+	total := float64(len(latencies))
+	decisions.AllowedPercent = float64(decisions.Allowed) * 100 / total
+	decisions.ExemptPercent = float64(decisions.Exempt) * 100 / total
+	decisions.LimitedPercent = float64(decisions.Limited) * 100 / total
 
-// SetAsExceptionList will set its argument at the exception
-// list of this limiter. Please notice that this method won't
-// append the list to the already existing exception list; but
-// it will set it to this, so the already existing exception IDs
-// assigned to this limiter will be lost.
-func (l *Limiter) SetAsExceptionList(list []int64) {
-	l.exceptionIDs = list
+	return LatencyStats{
+		Samples: len(latencies),
+		P50:     percentile(0.5),
+		P90:     percentile(0.9),
+		P99:     percentile(0.99),
+		Max:     latencies[len(latencies)-1],
+	}, decisions
 }
 
-// GetStatus will get the status of a chat.
-// if `l.ConsiderUser` parameter is set to `true`,
-// the id should be the id of the user; otherwise you should
-// use the id of the chat to get the status.
-func (l *Limiter) GetStatus(id int64) *UserStatus {
-	var status *UserStatus
-	l.mutex.RLock()
-	status = l.userMap[id]
-	l.mutex.RUnlock()
+// ProcessUpdate will run the handlers of the limiter on the update, the
+// same way as a handler group of the dispatcher does, and passes the
+// update to the next processor unless the limiter has ended the groups.
+func (p *LimiterProcessor) ProcessUpdate(d *ext.Dispatcher, b *gotgbot.Bot, ctx *ext.Context) error {
+	if !p.detached.Load() && p.Limiter.gateUpdate(d, b, ctx) {
+		return nil
+	}
 
-	return status
-}
+	next := p.Next
+	if next == nil {
+		next = ext.BaseProcessor{}
+	}
 
-// SetFloodWaitTime will set the flood wait duration for each
-// chat to send `maxCount` message per this amount of time.
-// if they send more than this amount of messages during this time,
-// they will be limited by this limiter and so their messages
-// won't be handled in the current group.
-// (Notice: if `ConsiderUser` is set to `true`, this duration will
-// be applied to unique users in the chat; not the total chat.)
-func (l *Limiter) SetFloodWaitTime(d time.Duration) {
-	l.timeout = d
+	return next.ProcessUpdate(d, b, ctx)
 }
 
-// SetPunishmentDuration will set the punishment duration of
-// the chat (or a user) after being limited by this limiter.
-// Users needs to spend this amount of time + `l.timeout` to become
-// free and so the handlers will work again for it.
-// NOTICE: if `IsStrict` is set to `true`, as long as user sends
-// message to the bot, the amount of passed-punishment time will
-// become 0; so the user needs to stop sending messages to the bot
-// until the punishment time is passed, otherwise the user will be
-// limited forever.
-func (l *Limiter) SetPunishmentDuration(d time.Duration) {
-	l.punishment = d
-}
+// gateUpdate will run the handlers of the limiter on the update; it
+// returns true if the update should not be processed anymore.
+func (l *Limiter) gateUpdate(d *ext.Dispatcher, b *gotgbot.Bot, ctx *ext.Context) bool {
+	setContextDispatcher(ctx, d)
+	for _, current := range l.allHandlers {
+		if !current.CheckUpdate(b, ctx) {
+			continue
+		}
 
-// SetMaxMessageCount sets the possible messages count in the
-// anti-flood-wait amount of time (which is `l.timeout`).
-// in that period of time, chat (or user) needs to send less than
-// this much message, otherwise they will be limited by this limiter
-// and so as a result of that their messages will be ignored by the bot.
-func (l *Limiter) SetMaxMessageCount(count int) {
-	l.maxCount = count
+		err := current.HandleUpdate(b, ctx)
+		switch {
+		case err == nil:
+			return false
+		case errors.Is(err, ext.ContinueGroups):
+			continue
+		case errors.Is(err, ext.EndGroups):
+			return true
+		}
+
+		// the other errors are reported to the error handler of the
+		// dispatcher, the same way as the errors of the handlers.
+		if d != nil && d.Error != nil {
+			switch d.Error(b, ctx, err) {
+			case ext.DispatcherActionContinueGroups:
+				continue
+			case ext.DispatcherActionEndGroups:
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return false
 }
 
-// SetMaxCacheDuration will set the max duration for caching algorithm.
-// WARNING: this value should always be greater than the
-// `timeout` + `punishment` values of the limiter;
-// otherwise this method will set the max cache duration to
-// `timeout` + `punishment` + 1.
-func (l *Limiter) SetMaxCacheDuration(d time.Duration) {
-	if d > l.punishment+l.timeout {
-		l.maxTimeout = d
-	} else {
-		l.maxTimeout = l.punishment + l.timeout + time.Minute
+// load returns the current snapshot of the list; the returned slice
+// must not be modified.
+func (c *cowList[T]) load() []T {
+	if s := c.snapshot.Load(); s != nil {
+		return *s
 	}
+
+	return nil
 }
 
-// SetDefaultInterval will set a default value to the checker's interval.
-// It's recommended that users use `SetMaxCacheDuration` method instead of this one.
-// If you haven't set any other parameters for the limiter, this will set the interval
-// to 60 seconds at least.
-func (l *Limiter) SetDefaultInterval() {
-	l.maxTimeout = l.punishment + l.timeout + time.Minute
+// store will replace the list with the given items.
+func (c *cowList[T]) store(items []T) {
+	c.mutex.Lock()
+	c.snapshot.Store(&items)
+	c.mutex.Unlock()
 }
 
-func (l *Limiter) AddCustomIgnore(id int64, d time.Duration, ignoreExceptions bool) {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+// append will append the items to a copy of the list and will replace
+// the list with it.
+func (c *cowList[T]) append(items ...T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	status := l.userMap[id]
-	if status == nil {
-		status = new(UserStatus)
-		status.custom = &customIgnore{
-			startTime:       time.Now(),
-			duration:        d,
-			ignoreException: ignoreExceptions,
-		}
-		l.userMap[id] = status
-		if ignoreExceptions {
-			l.addIgnoredExceptions(id)
-		}
+	old := c.load()
+	s := make([]T, 0, len(old)+len(items))
+	s = append(append(s, old...), items...)
+	c.snapshot.Store(&s)
+}
 
-		return
-	}
+//---------------------------------------------------------
 
-	status.custom = &customIgnore{
-		startTime:       time.Now(),
-		duration:        d,
-		ignoreException: ignoreExceptions,
+// Validate will check the exception rule and returns an error if it's
+// empty (so it would match everyone), or has a malformed pattern.
+func (r *ExceptionRule) Validate() error {
+	if r.Username == "" && !r.Premium && r.LanguageCode == "" {
+		return fmt.Errorf("%w: empty rule", ErrInvalidRule)
 	}
-	if ignoreExceptions {
-		l.addIgnoredExceptions(id)
+
+	if _, err := path.Match(r.Username, ""); err != nil {
+		return fmt.Errorf("%w: %q: %v", ErrInvalidRule, r.Username, err)
 	}
+
+	return nil
 }
 
-func (l *Limiter) RemoveCustomIgnore(id int64) {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+// Match returns true if the user matches all of the non-empty fields of
+// the rule.
+func (r *ExceptionRule) Match(u *gotgbot.User) bool {
+	if u == nil || (r.Premium && !u.IsPremium) {
+		return false
+	}
 
-	status := l.userMap[id]
-	if status == nil || status.custom == nil {
-		return
+	if r.LanguageCode != "" && !strings.EqualFold(r.LanguageCode, u.LanguageCode) {
+		return false
 	}
 
-	if status.custom.ignoreException {
-		l.removeFromIgnoredExceptions(id)
+	if r.Username == "" {
+		return true
 	}
-	status.custom = nil
-}
 
-// hasTextCondition will check if the message meets the message condition
-// or not.
-// basically if l.TextOnly is set to true, this method will check if
-// the message is a normal text message or not.
-func (l *Limiter) hasTextCondition(msg *gotgbot.Message) bool {
-	if l.TextOnly {
-		return len(msg.Text) > 0
+	if u.Username == "" {
+		return false
 	}
 
-	return true
+	matched, _ := path.Match(r.Username, strings.ToLower(u.Username))
+	return matched
 }
 
-// runTriggers will run the triggers of the limiter.
-// this method should be called in a separate goroutine.
-func (l *Limiter) runTriggers(b *gotgbot.Bot, ctx *ext.Context) {
-	for _, trigger := range l.triggers {
-		if trigger != nil {
-			trigger(b, ctx)
-		}
-	}
+// normalize returns a copy of the rule with the '@' prefix of its
+// username removed and the username lower-cased.
+func (r ExceptionRule) normalize() ExceptionRule {
+	r.Username = strings.ToLower(strings.TrimPrefix(r.Username, "@"))
+	return r
 }
 
-// isException will check and see if msg can be ignored because
-// it's id is in the exception list or not. This method's usage
-// is internal-only.
-func (l *Limiter) isException(msg *gotgbot.Message) bool {
-	if len(l.exceptionIDs) == 0 || msg == nil {
-		return false
-	}
+//---------------------------------------------------------
 
-	for _, ex := range l.exceptionIDs {
-		if msg.From != nil {
-			if ex == msg.From.Id || ex == msg.Chat.Id {
-				return true
-			}
-		} else {
-			if ex == msg.Chat.Id {
-				return true
-			}
-		}
+// Add will add the ids to the set.
+func (s *IDSet) Add(ids ...int64) {
+	s.set.add(ids...)
+}
 
-	}
+// Remove will remove the ids from the set.
+func (s *IDSet) Remove(ids ...int64) {
+	s.set.remove(ids...)
+}
 
-	return false
+// Set will replace all of the ids of the set with the given ids.
+func (s *IDSet) Set(ids []int64) {
+	s.set.replace(ids)
 }
 
-func (l *Limiter) isExceptionCtx(ctx *ext.Context) bool {
-	if ctx.CallbackQuery != nil {
-		return l.isExceptionQuery(ctx.CallbackQuery)
-	}
-	return l.isException(ctx.Message)
+// Clear will remove all of the ids from the set.
+func (s *IDSet) Clear() {
+	s.set.replace(nil)
 }
 
-// isException will check and see if msg can be ignored because
-// it's id is in the exception list or not. This method's usage
-// is internal-only.
-func (l *Limiter) isExceptionQuery(cq *gotgbot.CallbackQuery) bool {
-	if len(l.exceptionIDs) == 0 || cq == nil {
-		return false
-	}
+// Contains returns true if the id is in the set.
+func (s *IDSet) Contains(id int64) bool {
+	return s.set.contains(id)
+}
 
-	for _, ex := range l.exceptionIDs {
-		if ex == cq.From.Id || (cq.Message != nil && ex == cq.Message.GetChat().Id) {
-			return true
-		}
-	}
+// Len returns the amount of the ids in the set.
+func (s *IDSet) Len() int {
+	return len(s.set.load())
+}
 
-	return false
+// List returns the sorted ids of the set; it returns nil if the set
+// is empty.
+func (s *IDSet) List() []int64 {
+	ids := s.set.keys()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
 }
 
-// isIgnoredException will check and see if msg cannot be ignored because
-// it's id is in the exception list or not. This method's usage
-// is internal-only.
-func (l *Limiter) isIgnoredException(msg *gotgbot.Message) bool {
-	if len(l.ignoredExceptions) == 0 {
+// hasMessage returns true if the chat, the sender or the sender chat of
+// the message is in the set.
+func (s *IDSet) hasMessage(msg *gotgbot.Message) bool {
+	m := s.set.load()
+	if len(m) == 0 || msg == nil {
 		return false
 	}
 
-	for _, ex := range l.ignoredExceptions {
-		if msg.From != nil {
-			if ex == msg.From.Id || ex == msg.Chat.Id {
-				return true
-			}
-		} else {
-			if ex == msg.Chat.Id {
-				return true
-			}
+	if _, ok := m[msg.Chat.Id]; ok {
+		return true
+	}
+
+	if msg.From != nil {
+		if _, ok := m[msg.From.Id]; ok {
+			return true
+		}
+	}
+
+	if msg.SenderChat != nil {
+		if _, ok := m[msg.SenderChat.Id]; ok {
+			return true
 		}
 	}
 
 	return false
 }
 
-// isIgnoredException will check and see if msg cannot be ignored because
-// it's id is in the exception list or not. This method's usage
-// is internal-only.
-func (l *Limiter) isIgnoredExceptionQuery(cq *gotgbot.CallbackQuery) bool {
-	if len(l.ignoredExceptions) == 0 {
+// hasQuery returns true if the sender or the chat of the callback query
+// is in the set.
+func (s *IDSet) hasQuery(cq *gotgbot.CallbackQuery) bool {
+	m := s.set.load()
+	if len(m) == 0 || cq == nil {
 		return false
 	}
 
-	for _, ex := range l.ignoredExceptions {
-		if ex == cq.From.Id || (cq.Message != nil && ex == cq.Message.GetChat().Id) {
+	if _, ok := m[cq.From.Id]; ok {
+		return true
+	}
+
+	if cq.Message != nil {
+		if _, ok := m[cq.Message.GetChat().Id]; ok {
 			return true
 		}
 	}
@@ -444,90 +9363,97 @@ func (l *Limiter) isIgnoredExceptionQuery(cq *gotgbot.CallbackQuery) bool {
 	return false
 }
 
-func (l *Limiter) addIgnoredExceptions(id int64) {
-	if len(l.ignoredExceptions) == 0 {
-		l.ignoredExceptions = append(l.ignoredExceptions, id)
+//---------------------------------------------------------
+
+// add will add the keys to a copy of the set and will replace the set
+// with it.
+func (s *cowSet[K]) add(keys ...K) {
+	if len(keys) == 0 {
 		return
 	}
-	for _, ex := range l.ignoredExceptions {
-		if ex == id {
-			return
-		}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.load()
+	m := make(map[K]struct{}, len(old)+len(keys))
+	for key := range old {
+		m[key] = struct{}{}
+	}
+
+	for _, key := range keys {
+		m[key] = struct{}{}
 	}
-	l.ignoredExceptions = append(l.ignoredExceptions, id)
+
+	s.snapshot.Store(&m)
 }
 
-func (l *Limiter) removeFromIgnoredExceptions(id int64) {
-	if len(l.ignoredExceptions) == 0 {
+// remove will remove the keys from a copy of the set and will replace
+// the set with it.
+func (s *cowSet[K]) remove(keys ...K) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.load()
+	if len(old) == 0 || len(keys) == 0 {
 		return
 	}
-	for i, ex := range l.ignoredExceptions {
-		if ex == id {
-			l.ignoredExceptions = append(l.ignoredExceptions[:i], l.ignoredExceptions[i+1:]...)
-			return
-		}
+
+	m := make(map[K]struct{}, len(old))
+	for key := range old {
+		m[key] = struct{}{}
 	}
-}
 
-// checker should be run in a new goroutine as it blocks its goroutine
-// with a for-loop. This method's duty is to clear the old user's status
-// from the cache using `l.maxTimeout` parameter.
-func (l *Limiter) checker() {
-	for l.isEnabled && !l.isStopped {
-		if l.maxTimeout < time.Second {
-			// if we don't do this, we will end up running an unlimited
-			// loop with highest possible speed (which will cause high
-			// cpu usage).
-			l.SetDefaultInterval()
-		}
-		time.Sleep(l.maxTimeout)
-
-		// added this checker just in-case so we can
-		// prevent the panics in the future.
-		if l.userMap == nil || l.mutex == nil {
-			// return from the cleaner function and let the
-			// goroutine die.
-			return
-		}
+	for _, key := range keys {
+		delete(m, key)
+	}
 
-		if len(l.userMap) == 0 {
-			continue
-		}
+	s.snapshot.Store(&m)
+}
 
-		l.mutex.Lock()
-		for key, value := range l.userMap {
-			if value == nil || value.canBeDeleted(l) {
-				delete(l.userMap, key)
-			}
+// replace will replace all of the keys of the set with the given keys.
+func (s *cowSet[K]) replace(keys []K) {
+	var m map[K]struct{}
+	if len(keys) != 0 {
+		m = make(map[K]struct{}, len(keys))
+		for _, key := range keys {
+			m[key] = struct{}{}
 		}
-		l.mutex.Unlock()
 	}
-}
 
-//---------------------------------------------------------
+	s.mutex.Lock()
+	s.snapshot.Store(&m)
+	s.mutex.Unlock()
+}
 
-// IsLimited will check and see if the chat (or user) is
-// limited by this limiter or not.
-func (s *UserStatus) IsLimited() bool {
-	return s.limited
+// contains returns true if the key is in the set.
+func (s *cowSet[K]) contains(key K) bool {
+	_, ok := s.load()[key]
+	return ok
 }
 
-func (s *UserStatus) IsCustomLimited() bool {
-	if s.custom == nil {
-		return false
+// keys returns the keys of the set in no particular order; it returns
+// nil if the set is empty.
+func (s *cowSet[K]) keys() []K {
+	m := s.load()
+	if len(m) == 0 {
+		return nil
 	}
 
-	if time.Since(s.custom.startTime) > s.custom.duration && s.custom.duration != 0 {
-		s.custom = nil
-		return false
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
 	}
 
-	return true
+	return keys
 }
 
-func (s *UserStatus) canBeDeleted(l *Limiter) bool {
-	return s.Last.IsZero() ||
-		(time.Since(s.Last) > l.timeout && !s.limited && !s.IsCustomLimited())
-}
+// load returns the current snapshot of the set; the returned map must
+// not be modified.
+func (s *cowSet[K]) load() map[K]struct{} {
+	if m := s.snapshot.Load(); m != nil {
+		return *m
+	}
 
-//---------------------------------------------------------
+	return nil
+}
@@ -0,0 +1,26 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package outbound
+
+import "time"
+
+// these are the limits documented by telegram for the bots.
+const (
+	DefaultGlobalPerSecond  = 30
+	DefaultGroupPerMinute   = 20
+	DefaultPrivatePerSecond = 1
+)
+
+const (
+	// cleanupInterval is the interval of removing the idle chat buckets.
+	cleanupInterval = time.Minute
+)
+
+const (
+	DefaultQueueSize    = 1024
+	DefaultQueueWorkers = 4
+	DefaultMaxRetries   = 5
+)
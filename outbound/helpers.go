@@ -0,0 +1,128 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package outbound
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// NewClient creates a new outbound limiter client which wraps the given
+// client. pass nil as config to use telegram's default limits.
+func NewClient(client gotgbot.BotClient, config *Config) *Client {
+	c := Config{}
+	if config != nil {
+		c = *config
+	}
+
+	if c.GlobalPerSecond <= 0 {
+		c.GlobalPerSecond = DefaultGlobalPerSecond
+	}
+
+	if c.GroupPerMinute <= 0 {
+		c.GroupPerMinute = DefaultGroupPerMinute
+	}
+
+	if c.PrivatePerSecond <= 0 {
+		c.PrivatePerSecond = DefaultPrivatePerSecond
+	}
+
+	if c.IsLimitedMethod == nil {
+		c.IsLimitedMethod = IsSendingMethod
+	}
+
+	return &Client{
+		BotClient:   client,
+		mutex:       new(sync.Mutex),
+		global:      newBucket(float64(c.GlobalPerSecond), float64(c.GlobalPerSecond)),
+		chats:       make(map[string]*bucket),
+		config:      c,
+		lastCleanup: time.Now(),
+	}
+}
+
+// Wrap will replace the client of the bot with an outbound limiter
+// client wrapping it, and returns the new client.
+func Wrap(bot *gotgbot.Bot, config *Config) *Client {
+	c := NewClient(bot.BotClient, config)
+	bot.BotClient = c
+	return c
+}
+
+// NewQueue creates a new send queue for the given bot. pass nil as
+// config to use the default values. the queue should be started using
+// its `Start` method before enqueuing any jobs.
+func NewQueue(bot *gotgbot.Bot, config *QueueConfig) *Queue {
+	c := QueueConfig{}
+	if config != nil {
+		c = *config
+	}
+
+	if c.Size <= 0 {
+		c.Size = DefaultQueueSize
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = DefaultQueueWorkers
+	}
+
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = DefaultMaxRetries
+	}
+
+	return &Queue{
+		bot:    bot,
+		config: c,
+		wg:     new(sync.WaitGroup),
+		mutex:  new(sync.Mutex),
+	}
+}
+
+// RetryAfter returns the retry_after duration of a 429 error returned
+// by telegram; the second value is false if the error is not a 429 error.
+func RetryAfter(err error) (time.Duration, bool) {
+	var tgErr *gotgbot.TelegramError
+	if !errors.As(err, &tgErr) || tgErr.Code != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if tgErr.ResponseParams == nil || tgErr.ResponseParams.RetryAfter <= 0 {
+		return time.Second, true
+	}
+
+	return time.Duration(tgErr.ResponseParams.RetryAfter) * time.Second, true
+}
+
+// IsSendingMethod returns true if the method sends a new message to
+// a chat, such as "sendMessage" or "forwardMessage".
+func IsSendingMethod(method string) bool {
+	return strings.HasPrefix(method, "send") ||
+		strings.HasPrefix(method, "forward") ||
+		strings.HasPrefix(method, "copy")
+}
+
+// isGroupChatID returns true if the chat id belongs to a group or
+// a channel.
+func isGroupChatID(chatID string) bool {
+	return strings.HasPrefix(chatID, "-") || strings.HasPrefix(chatID, "@")
+}
+
+// newBucket creates a new full bucket.
+func newBucket(capacity, rate float64) *bucket {
+	return &bucket{
+		tokens:   capacity,
+		capacity: capacity,
+		rate:     rate,
+		last:     time.Now(),
+	}
+}
@@ -0,0 +1,270 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package outbound
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+//---------------------------------------------------------
+
+// RequestWithContext will wait until the request can be sent without
+// exceeding the limits, and then sends it using the wrapped client.
+func (c *Client) RequestWithContext(ctx context.Context, token string, method string, params map[string]string, data map[string]gotgbot.NamedReader, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	if c.config.IsLimitedMethod(method) {
+		if err := c.Wait(ctx, params["chat_id"]); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.BotClient.RequestWithContext(ctx, token, method, params, data, opts)
+}
+
+// Wait will block until a request to the given chat can be sent without
+// exceeding the limits, or until the context is done. pass an empty
+// chat id for requests which are not sent to a specific chat.
+// if the context is done before the request can be sent, the reserved
+// tokens are given back, so the next requests won't wait for it.
+func (c *Client) Wait(ctx context.Context, chatID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	delay, chat := c.reserve(chatID)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		c.refund(chat)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve will reserve a token in the global bucket and the bucket
+// of the chat, and returns the amount of time the request has to wait
+// before being sent, and the bucket of the chat (if any).
+func (c *Client) reserve(chatID string) (time.Duration, *bucket) {
+	now := time.Now()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Sub(c.lastCleanup) > cleanupInterval {
+		c.cleanup(now)
+	}
+
+	delay := c.global.reserve(now)
+	if chatID == "" {
+		return delay, nil
+	}
+
+	b := c.chats[chatID]
+	if b == nil {
+		if isGroupChatID(chatID) {
+			b = newBucket(float64(c.config.GroupPerMinute), float64(c.config.GroupPerMinute)/60)
+		} else {
+			b = newBucket(float64(c.config.PrivatePerSecond), float64(c.config.PrivatePerSecond))
+		}
+		c.chats[chatID] = b
+	}
+
+	if chatDelay := b.reserve(now); chatDelay > delay {
+		delay = chatDelay
+	}
+
+	return delay, b
+}
+
+// refund will give back the tokens reserved by a canceled request to
+// the global bucket and the bucket of its chat (if any).
+func (c *Client) refund(chat *bucket) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.global.refund()
+	if chat != nil {
+		chat.refund()
+	}
+}
+
+// cleanup will remove the buckets of the chats which are full, as they
+// are the same as new buckets.
+func (c *Client) cleanup(now time.Time) {
+	for chatID, b := range c.chats {
+		b.refill(now)
+		if b.tokens >= b.capacity {
+			delete(c.chats, chatID)
+		}
+	}
+
+	c.lastCleanup = now
+}
+
+//---------------------------------------------------------
+
+// Start will start the workers of the queue.
+func (q *Queue) Start() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.isRunning {
+		return
+	}
+
+	q.isRunning = true
+	q.jobs = make(chan Job, q.config.Size)
+	q.stop = make(chan struct{})
+	for i := 0; i < q.config.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker(q.jobs, q.stop)
+	}
+}
+
+// Stop will stop the workers of the queue and waits for them to finish
+// their current jobs; the pending jobs are dropped.
+func (q *Queue) Stop() {
+	q.mutex.Lock()
+	if !q.isRunning {
+		q.mutex.Unlock()
+		return
+	}
+
+	q.isRunning = false
+	close(q.stop)
+	q.mutex.Unlock()
+
+	q.wg.Wait()
+	atomic.StoreInt64(&q.depth, 0)
+}
+
+// Enqueue will add the job to the queue; it returns `ErrQueueFull` if
+// the queue has no free space.
+func (q *Queue) Enqueue(job Job) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.isRunning {
+		return ErrQueueStopped
+	}
+
+	select {
+	case q.jobs <- job:
+		atomic.AddInt64(&q.depth, 1)
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Depth returns the number of the jobs waiting in the queue.
+func (q *Queue) Depth() int64 {
+	return atomic.LoadInt64(&q.depth)
+}
+
+// Stats returns the metrics of the queue.
+func (q *Queue) Stats() QueueStats {
+	return QueueStats{
+		Depth:   atomic.LoadInt64(&q.depth),
+		Sent:    atomic.LoadInt64(&q.sent),
+		Retried: atomic.LoadInt64(&q.retried),
+		Failed:  atomic.LoadInt64(&q.failed),
+	}
+}
+
+// worker runs the jobs of the queue until the queue is stopped.
+func (q *Queue) worker(jobs <-chan Job, stop <-chan struct{}) {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case job := <-jobs:
+			err := q.run(job, stop)
+			atomic.AddInt64(&q.depth, -1)
+			if err == nil {
+				atomic.AddInt64(&q.sent, 1)
+				continue
+			}
+
+			atomic.AddInt64(&q.failed, 1)
+			if q.config.OnError != nil {
+				q.config.OnError(err)
+			}
+		}
+	}
+}
+
+// run will run the job, retrying it after the retry_after duration
+// of the 429 errors.
+func (q *Queue) run(job Job, stop <-chan struct{}) error {
+	for i := 0; ; i++ {
+		err := job(q.bot)
+		wait, ok := RetryAfter(err)
+		if !ok || i >= q.config.MaxRetries {
+			return err
+		}
+
+		atomic.AddInt64(&q.retried, 1)
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+//---------------------------------------------------------
+
+// refill adds the tokens generated since the last refill to the bucket.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// reserve takes a token from the bucket and returns the amount of time
+// needed for that token to become available.
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund gives back a reserved token to the bucket.
+func (b *bucket) refund() {
+	b.tokens++
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+//---------------------------------------------------------
@@ -0,0 +1,117 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package outbound
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// Client is a `gotgbot.BotClient` which wraps another client and limits
+// the outgoing requests, so they don't exceed the server-side limits of
+// telegram. Requests which exceed the limits are delayed (not dropped)
+// until they can be sent.
+type Client struct {
+	gotgbot.BotClient
+
+	mutex *sync.Mutex
+
+	// global is the bucket shared by all of the limited requests.
+	global *bucket
+
+	// chats is a map of the buckets of each chat with the chat_id
+	// parameter of the requests as its key.
+	chats map[string]*bucket
+
+	config      Config
+	lastCleanup time.Time
+}
+
+// Config is the configuration of the outbound limiter.
+type Config struct {
+	// GlobalPerSecond is the maximum number of limited requests sent
+	// per second in total.
+	GlobalPerSecond int
+
+	// GroupPerMinute is the maximum number of limited requests sent to
+	// a single group (or channel) per minute.
+	GroupPerMinute int
+
+	// PrivatePerSecond is the maximum number of limited requests sent
+	// to a single private chat per second.
+	PrivatePerSecond int
+
+	// IsLimitedMethod decides whether a method should be limited or not;
+	// if it's nil, `IsSendingMethod` will be used.
+	IsLimitedMethod func(method string) bool
+}
+
+// Job is a function which sends one or more requests to telegram
+// using the given bot. returning an error which contains a 429
+// `retry_after` parameter will make the queue retry the job later.
+type Job func(b *gotgbot.Bot) error
+
+// Queue is a send queue which runs the jobs in a few worker goroutines,
+// retrying them automatically when telegram responds with 429 (too
+// many requests). Using it alongside of the outbound `Client` makes
+// firing lots of actions (delete/mute/warn) at once safe.
+type Queue struct {
+	bot    *gotgbot.Bot
+	jobs   chan Job
+	stop   chan struct{}
+	wg     *sync.WaitGroup
+	config QueueConfig
+
+	// these fields are accessed atomically.
+	depth   int64
+	sent    int64
+	retried int64
+	failed  int64
+
+	mutex     *sync.Mutex
+	isRunning bool
+}
+
+// QueueConfig is the configuration of the send queue.
+type QueueConfig struct {
+	// Size is the maximum number of pending jobs in the queue.
+	Size int
+
+	// Workers is the number of goroutines running the jobs.
+	Workers int
+
+	// MaxRetries is the maximum number of retries of a job after 429
+	// responses.
+	MaxRetries int
+
+	// OnError is called when a job fails permanently; it can be nil.
+	OnError func(err error)
+}
+
+// QueueStats contains the metrics of a send queue.
+type QueueStats struct {
+	// Depth is the number of jobs waiting in the queue (including the
+	// jobs waiting for their retry_after time).
+	Depth int64
+
+	Sent    int64
+	Retried int64
+	Failed  int64
+}
+
+// bucket is a simple token bucket.
+type bucket struct {
+	// tokens is the available tokens in the bucket; it can be negative
+	// when the future tokens are already reserved.
+	tokens   float64
+	capacity float64
+
+	// rate is the number of tokens added to the bucket per second.
+	rate float64
+	last time.Time
+}
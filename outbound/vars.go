@@ -0,0 +1,13 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package outbound
+
+import "errors"
+
+var (
+	ErrQueueFull    = errors.New("outbound: send queue is full")
+	ErrQueueStopped = errors.New("outbound: send queue is not running")
+)
@@ -0,0 +1,19 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package redisstore
+
+import "time"
+
+const (
+	DefaultPrefix  = "ratelimiter:"
+	DefaultChannel = "ratelimiter:events"
+	DefaultTimeout = 5 * time.Second
+)
+
+const (
+	// scanCount is the count hint of the SCAN commands.
+	scanCount = 256
+)
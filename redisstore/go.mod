@@ -0,0 +1,17 @@
+module github.com/ALiwoto/ratelimiter/redisstore
+
+go 1.19
+
+require (
+	github.com/ALiwoto/ratelimiter v0.0.0-20261015214017-28dfd57d3b70
+	github.com/redis/go-redis/v9 v9.7.0
+)
+
+require (
+	github.com/PaulSonOfLars/gotgbot/v2 v2.0.0-rc.26 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/ALiwoto/ratelimiter => ../
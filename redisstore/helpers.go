@@ -0,0 +1,53 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package redisstore
+
+import (
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewStorage creates a new redis storage using the given client; all of
+// the keys will be prefixed with the given prefix (`DefaultPrefix` is
+// used if it's empty).
+func NewStorage(client redis.UniversalClient, prefix string) *Storage {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	return &Storage{
+		client:  client,
+		prefix:  prefix,
+		Timeout: DefaultTimeout,
+	}
+}
+
+// NewBus creates a new redis event bus using the given client and pub/sub
+// channel (`DefaultChannel` is used if it's empty).
+func NewBus(client redis.UniversalClient, channel string) *Bus {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+
+	return &Bus{
+		client:  client,
+		channel: channel,
+		Timeout: DefaultTimeout,
+	}
+}
+
+// escapePattern escapes the special characters of the glob-style
+// patterns used by redis.
+func escapePattern(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		"*", `\*`,
+		"?", `\?`,
+		"[", `\[`,
+		"]", `\]`,
+	).Replace(value)
+}
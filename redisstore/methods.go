@@ -0,0 +1,107 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/ALiwoto/ratelimiter/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+//---------------------------------------------------------
+
+// Get returns the value of the key.
+func (s *Storage) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, storage.ErrNotFound
+	}
+
+	return value, err
+}
+
+// Set will set the value of the key.
+func (s *Storage) Set(key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	return s.client.Set(ctx, s.prefix+key, value, 0).Err()
+}
+
+// Delete will remove the key.
+func (s *Storage) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+// Keys returns all of the keys which start with the given prefix.
+func (s *Storage) Keys(prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	var keys []string
+	pattern := escapePattern(s.prefix+prefix) + "*"
+	iter := s.client.Scan(ctx, 0, pattern, scanCount).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), s.prefix))
+	}
+
+	return keys, iter.Err()
+}
+
+//---------------------------------------------------------
+
+// Publish will publish the event to the pub/sub channel of the bus.
+func (b *Bus) Publish(event *core.SyncEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.Timeout)
+	defer cancel()
+
+	return b.client.Publish(ctx, b.channel, payload).Err()
+}
+
+// Subscribe will subscribe to the pub/sub channel of the bus and calls
+// the handler for each received event, until the returned function is
+// called.
+func (b *Bus) Subscribe(handler func(event *core.SyncEvent)) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.Timeout)
+	defer cancel()
+
+	sub := b.client.Subscribe(context.Background(), b.channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+
+	go func() {
+		for msg := range sub.Channel() {
+			event := new(core.SyncEvent)
+			if json.Unmarshal([]byte(msg.Payload), event) == nil {
+				handler(event)
+			}
+		}
+	}()
+
+	return func() {
+		_ = sub.Close()
+	}, nil
+}
+
+//---------------------------------------------------------
@@ -0,0 +1,392 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/ALiwoto/ratelimiter/redisstore"
+	"github.com/ALiwoto/ratelimiter/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in-memory redis server which supports the commands
+// used by the storage and the bus; SCAN returns two keys per page, so
+// the iteration over the pages is exercised as well.
+type fakeRedis struct {
+	listener net.Listener
+
+	mutex       sync.Mutex
+	data        map[string]string
+	subscribers map[*fakeConn]map[string]bool
+}
+
+// fakeConn is a connection of the fake server; the messages of the
+// subscriptions are written to it by the other connections.
+type fakeConn struct {
+	conn  net.Conn
+	mutex sync.Mutex
+}
+
+func (c *fakeConn) write(reply string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	_, _ = io.WriteString(c.conn, reply)
+}
+
+func bulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+func array(values ...string) string {
+	return "*" + strconv.Itoa(len(values)) + "\r\n" + strings.Join(values, "")
+}
+
+func integer(value int) string {
+	return ":" + strconv.Itoa(value) + "\r\n"
+}
+
+// newFakeRedis starts a fake redis server, and returns a client
+// connected to it.
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &fakeRedis{
+		listener:    listener,
+		data:        make(map[string]string),
+		subscribers: make(map[*fakeConn]map[string]bool),
+	}
+	go s.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+
+	client := redis.NewClient(&redis.Options{
+		Addr:             listener.Addr().String(),
+		Protocol:         2,
+		DisableIndentity: true,
+	})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return s, client
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(&fakeConn{conn: conn})
+	}
+}
+
+func (s *fakeRedis) handle(c *fakeConn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.subscribers, c)
+		s.mutex.Unlock()
+		_ = c.conn.Close()
+	}()
+
+	r := bufio.NewReader(c.conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		c.write(s.execute(c, args))
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return nil, errors.New("unexpected command: " + line)
+	}
+
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
+
+func (s *fakeRedis) execute(c *fakeConn, args []string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		if s.subscribers[c] != nil {
+			return array(bulk("pong"), bulk(""))
+		}
+
+		return "+PONG\r\n"
+	case "GET":
+		value, ok := s.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+
+		return bulk(value)
+	case "SET":
+		s.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				deleted++
+			}
+		}
+
+		return integer(deleted)
+	case "SCAN":
+		return s.scan(args)
+	case "SUBSCRIBE":
+		if s.subscribers[c] == nil {
+			s.subscribers[c] = make(map[string]bool)
+		}
+
+		var replies []string
+		for _, channel := range args[1:] {
+			s.subscribers[c][channel] = true
+			replies = append(replies, array(bulk("subscribe"), bulk(channel), integer(len(s.subscribers[c]))))
+		}
+
+		return strings.Join(replies, "")
+	case "PUBLISH":
+		received := 0
+		for sub, channels := range s.subscribers {
+			if channels[args[1]] {
+				go sub.write(array(bulk("message"), bulk(args[1]), bulk(args[2])))
+				received++
+			}
+		}
+
+		return integer(received)
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+// scan implements the SCAN command with a MATCH pattern; the cursor is
+// the index of the next key in the sorted keys.
+func (s *fakeRedis) scan(args []string) string {
+	cursor, _ := strconv.Atoi(args[1])
+	pattern := "*"
+	for i := 2; i+1 < len(args); i += 2 {
+		if strings.EqualFold(args[i], "MATCH") {
+			pattern = args[i+1]
+		}
+	}
+
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var matched []string
+	next := cursor
+	for ; next < len(keys) && next < cursor+2; next++ {
+		if ok, _ := path.Match(pattern, keys[next]); ok {
+			matched = append(matched, bulk(keys[next]))
+		}
+	}
+
+	if next >= len(keys) {
+		next = 0
+	}
+
+	return array(bulk(strconv.Itoa(next)), array(matched...))
+}
+
+func TestStorage(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := redisstore.NewStorage(client, "")
+	other := redisstore.NewStorage(client, "other:")
+
+	if _, err := s.Get("settings:1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+
+	for i := 1; i <= 5; i++ {
+		if err := s.Set(fmt.Sprintf("settings:%d", i), []byte("value")); err != nil {
+			t.Fatalf("failed to set the value: %v", err)
+		}
+	}
+
+	if err := s.Set("status:1", []byte("status")); err != nil {
+		t.Fatalf("failed to set the value: %v", err)
+	}
+
+	if err := other.Set("settings:6", []byte("other")); err != nil {
+		t.Fatalf("failed to set the value: %v", err)
+	}
+
+	value, err := s.Get("settings:1")
+	if err != nil || string(value) != "value" {
+		t.Errorf("unexpected value: %q, %v", value, err)
+	}
+
+	// the keys are scanned over several pages, without the keys of the
+	// other prefixes.
+	keys, err := s.Keys("settings:")
+	sort.Strings(keys)
+	if err != nil || strings.Join(keys, ",") != "settings:1,settings:2,settings:3,settings:4,settings:5" {
+		t.Errorf("unexpected keys: %v, %v", keys, err)
+	}
+
+	if err := s.Delete("settings:1"); err != nil {
+		t.Fatalf("failed to delete the value: %v", err)
+	}
+
+	if _, err := s.Get("settings:1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("the deleted value should not be found, got: %v", err)
+	}
+
+	if _, err := other.Get("settings:6"); err != nil {
+		t.Errorf("the values of the other prefixes should be kept: %v", err)
+	}
+}
+
+func TestStorageEscapesPatterns(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := redisstore.NewStorage(client, "[bot]*")
+	other := redisstore.NewStorage(client, "[bot]x")
+
+	_ = s.Set("a?:1", []byte("value"))
+	_ = s.Set("ab:1", []byte("value"))
+	_ = other.Set("a?:2", []byte("value"))
+
+	// the glob characters of the prefixes are matched literally.
+	keys, err := s.Keys("a?")
+	if err != nil || len(keys) != 1 || keys[0] != "a?:1" {
+		t.Errorf("unexpected keys: %v, %v", keys, err)
+	}
+}
+
+func TestBus(t *testing.T) {
+	_, client := newFakeRedis(t)
+	first := redisstore.NewBus(client, "")
+	second := redisstore.NewBus(client, "")
+
+	received := make(chan *core.SyncEvent, 10)
+	unsubscribe, err := second.Subscribe(func(event *core.SyncEvent) {
+		received <- event
+	})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	err = first.Publish(&core.SyncEvent{Type: core.SyncLimit, Key: 1, Origin: "first"})
+	if err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+
+	select {
+	case event := <-received:
+		if event.Type != core.SyncLimit || event.Key != 1 || event.Origin != "first" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the event hasn't been received")
+	}
+
+	unsubscribe()
+	time.Sleep(50 * time.Millisecond)
+	_ = first.Publish(&core.SyncEvent{Type: core.SyncUnlimit, Key: 1, Origin: "first"})
+	select {
+	case event := <-received:
+		t.Errorf("the events should not be received after unsubscribing: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBusLimiters(t *testing.T) {
+	_, client := newFakeRedis(t)
+	newSynced := func() *ratelimiter.Limiter {
+		l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+			ConsiderUser: true,
+			Standalone:   true,
+		})
+		l.SetEventBus(redisstore.NewBus(client, ""))
+		if err := l.Start(); err != nil {
+			t.Fatalf("failed to start the limiter: %v", err)
+		}
+
+		return l
+	}
+
+	first, second := newSynced(), newSynced()
+	defer first.Stop()
+	defer second.Stop()
+
+	limited := func(l *ratelimiter.Limiter) bool {
+		status := l.GetStatus(1)
+		return status != nil && status.IsLimited()
+	}
+
+	// the state changes are applied by the other instances
+	// asynchronously.
+	first.Limit(1)
+	deadline := time.Now().Add(time.Second)
+	for !limited(second) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if !limited(second) {
+		t.Fatal("the limit should be applied to the other instance")
+	}
+
+	second.Unlimit(1)
+	deadline = time.Now().Add(time.Second)
+	for limited(first) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if limited(first) {
+		t.Error("the unlimit should be applied to the other instance")
+	}
+}
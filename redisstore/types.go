@@ -0,0 +1,38 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package redisstore
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Storage is a `storage.Storage` backed by redis, which can be shared
+// between multiple instances of the bot.
+type Storage struct {
+	client redis.UniversalClient
+
+	// prefix is prepended to all of the keys, so multiple limiters (or
+	// bots) can share a single redis database.
+	prefix string
+
+	// Timeout is the timeout of each redis command.
+	Timeout time.Duration
+}
+
+// Bus is a `core.EventBus` backed by redis pub/sub. It's used for
+// propagating the limit, unlimit and custom ignore events between the
+// bot workers, so the local state of each instance stays consistent
+// with the others. See `ratelimiter.Limiter.SetEventBus` for the
+// consistency model.
+type Bus struct {
+	client  redis.UniversalClient
+	channel string
+
+	// Timeout is the timeout of each publish command.
+	Timeout time.Duration
+}
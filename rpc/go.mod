@@ -0,0 +1,21 @@
+module github.com/ALiwoto/ratelimiter/rpc
+
+go 1.19
+
+require (
+	github.com/ALiwoto/ratelimiter v0.0.0-20261015214017-28dfd57d3b70
+	google.golang.org/grpc v1.57.2
+	google.golang.org/protobuf v1.31.0
+)
+
+require (
+	github.com/PaulSonOfLars/gotgbot/v2 v2.0.0-rc.26 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.9.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230525234030-28d5490b6b19 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/ALiwoto/ratelimiter => ../
@@ -0,0 +1,43 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package rpc
+
+import (
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/ALiwoto/ratelimiter/rpc/pb"
+	"google.golang.org/grpc"
+)
+
+// NewServer creates a new rpc server wrapping the given limiter.
+func NewServer(limiter *ratelimiter.Limiter) *Server {
+	return &Server{
+		limiter: limiter,
+	}
+}
+
+// Register will create a new rpc server wrapping the given limiter and
+// registers it on the grpc server.
+func Register(s *grpc.Server, limiter *ratelimiter.Limiter) *Server {
+	server := NewServer(limiter)
+	pb.RegisterLimiterServiceServer(s, server)
+	return server
+}
+
+// toResult converts the result of the core limiter to its protobuf
+// representation.
+func toResult(r core.Result) pb.Result {
+	switch r {
+	case core.ResultExempt:
+		return pb.Result_RESULT_EXEMPT
+	case core.ResultLimited, core.ResultDropped:
+		return pb.Result_RESULT_LIMITED
+	case core.ResultIgnored:
+		return pb.Result_RESULT_IGNORED
+	default:
+		return pb.Result_RESULT_ALLOWED
+	}
+}
@@ -0,0 +1,68 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package rpc
+
+import (
+	"context"
+
+	"github.com/ALiwoto/ratelimiter/rpc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//---------------------------------------------------------
+
+// Check will check a request of the key, consuming `cost` units of its
+// quota (one unit if it's zero).
+func (s *Server) Check(ctx context.Context, req *pb.CheckRequest) (*pb.CheckResponse, error) {
+	if req.Cost < 0 {
+		return nil, status.Error(codes.InvalidArgument, "cost cannot be negative")
+	}
+
+	d := s.limiter.AllowID(req.Key, int(req.Cost))
+	return &pb.CheckResponse{
+		Result:       toResult(d.Result),
+		Allowed:      d.IsAllowed(),
+		Count:        int32(d.Count),
+		MaxCount:     int32(d.MaxCount),
+		NewlyLimited: d.NewlyLimited,
+	}, nil
+}
+
+// GetStatus returns the status of the key without changing it.
+func (s *Server) GetStatus(ctx context.Context, req *pb.GetStatusRequest) (*pb.GetStatusResponse, error) {
+	st := s.limiter.GetStatusSnapshot(req.Key)
+	if st == nil {
+		return &pb.GetStatusResponse{}, nil
+	}
+
+	return &pb.GetStatusResponse{
+		Tracked:       true,
+		Limited:       st.Limited,
+		CustomLimited: st.CustomIgnore != nil,
+		Count:         int32(st.Count),
+		LastUnix:      st.LastSeen.Unix(),
+	}, nil
+}
+
+// Limit will limit the key manually.
+func (s *Server) Limit(ctx context.Context, req *pb.LimitRequest) (*pb.LimitResponse, error) {
+	s.limiter.Limit(req.Key)
+	return &pb.LimitResponse{}, nil
+}
+
+// Unlimit will free the key from its limitation.
+func (s *Server) Unlimit(ctx context.Context, req *pb.UnlimitRequest) (*pb.UnlimitResponse, error) {
+	s.limiter.Unlimit(req.Key)
+	return &pb.UnlimitResponse{}, nil
+}
+
+// ListLimited returns the keys which are currently limited.
+func (s *Server) ListLimited(ctx context.Context, req *pb.ListLimitedRequest) (*pb.ListLimitedResponse, error) {
+	return &pb.ListLimitedResponse{
+		Keys: s.limiter.ListLimited(),
+	}, nil
+}
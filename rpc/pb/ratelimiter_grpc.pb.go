@@ -0,0 +1,267 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: ratelimiter.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	LimiterService_Check_FullMethodName       = "/ratelimiter.v1.LimiterService/Check"
+	LimiterService_GetStatus_FullMethodName   = "/ratelimiter.v1.LimiterService/GetStatus"
+	LimiterService_Limit_FullMethodName       = "/ratelimiter.v1.LimiterService/Limit"
+	LimiterService_Unlimit_FullMethodName     = "/ratelimiter.v1.LimiterService/Unlimit"
+	LimiterService_ListLimited_FullMethodName = "/ratelimiter.v1.LimiterService/ListLimited"
+)
+
+// LimiterServiceClient is the client API for LimiterService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LimiterServiceClient interface {
+	// Check checks a request of the key, consuming its quota.
+	Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckResponse, error)
+	// GetStatus returns the status of the key without changing it.
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error)
+	// Limit limits the key manually.
+	Limit(ctx context.Context, in *LimitRequest, opts ...grpc.CallOption) (*LimitResponse, error)
+	// Unlimit frees the key from its limitation.
+	Unlimit(ctx context.Context, in *UnlimitRequest, opts ...grpc.CallOption) (*UnlimitResponse, error)
+	// ListLimited returns the keys which are currently limited.
+	ListLimited(ctx context.Context, in *ListLimitedRequest, opts ...grpc.CallOption) (*ListLimitedResponse, error)
+}
+
+type limiterServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLimiterServiceClient(cc grpc.ClientConnInterface) LimiterServiceClient {
+	return &limiterServiceClient{cc}
+}
+
+func (c *limiterServiceClient) Check(ctx context.Context, in *CheckRequest, opts ...grpc.CallOption) (*CheckResponse, error) {
+	out := new(CheckResponse)
+	err := c.cc.Invoke(ctx, LimiterService_Check_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *limiterServiceClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	out := new(GetStatusResponse)
+	err := c.cc.Invoke(ctx, LimiterService_GetStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *limiterServiceClient) Limit(ctx context.Context, in *LimitRequest, opts ...grpc.CallOption) (*LimitResponse, error) {
+	out := new(LimitResponse)
+	err := c.cc.Invoke(ctx, LimiterService_Limit_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *limiterServiceClient) Unlimit(ctx context.Context, in *UnlimitRequest, opts ...grpc.CallOption) (*UnlimitResponse, error) {
+	out := new(UnlimitResponse)
+	err := c.cc.Invoke(ctx, LimiterService_Unlimit_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *limiterServiceClient) ListLimited(ctx context.Context, in *ListLimitedRequest, opts ...grpc.CallOption) (*ListLimitedResponse, error) {
+	out := new(ListLimitedResponse)
+	err := c.cc.Invoke(ctx, LimiterService_ListLimited_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LimiterServiceServer is the server API for LimiterService service.
+// All implementations must embed UnimplementedLimiterServiceServer
+// for forward compatibility
+type LimiterServiceServer interface {
+	// Check checks a request of the key, consuming its quota.
+	Check(context.Context, *CheckRequest) (*CheckResponse, error)
+	// GetStatus returns the status of the key without changing it.
+	GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error)
+	// Limit limits the key manually.
+	Limit(context.Context, *LimitRequest) (*LimitResponse, error)
+	// Unlimit frees the key from its limitation.
+	Unlimit(context.Context, *UnlimitRequest) (*UnlimitResponse, error)
+	// ListLimited returns the keys which are currently limited.
+	ListLimited(context.Context, *ListLimitedRequest) (*ListLimitedResponse, error)
+	mustEmbedUnimplementedLimiterServiceServer()
+}
+
+// UnimplementedLimiterServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedLimiterServiceServer struct {
+}
+
+func (UnimplementedLimiterServiceServer) Check(context.Context, *CheckRequest) (*CheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Check not implemented")
+}
+func (UnimplementedLimiterServiceServer) GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedLimiterServiceServer) Limit(context.Context, *LimitRequest) (*LimitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Limit not implemented")
+}
+func (UnimplementedLimiterServiceServer) Unlimit(context.Context, *UnlimitRequest) (*UnlimitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unlimit not implemented")
+}
+func (UnimplementedLimiterServiceServer) ListLimited(context.Context, *ListLimitedRequest) (*ListLimitedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLimited not implemented")
+}
+func (UnimplementedLimiterServiceServer) mustEmbedUnimplementedLimiterServiceServer() {}
+
+// UnsafeLimiterServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LimiterServiceServer will
+// result in compilation errors.
+type UnsafeLimiterServiceServer interface {
+	mustEmbedUnimplementedLimiterServiceServer()
+}
+
+func RegisterLimiterServiceServer(s grpc.ServiceRegistrar, srv LimiterServiceServer) {
+	s.RegisterService(&LimiterService_ServiceDesc, srv)
+}
+
+func _LimiterService_Check_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_Check_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).Check(ctx, req.(*CheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LimiterService_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LimiterService_Limit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).Limit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_Limit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).Limit(ctx, req.(*LimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LimiterService_Unlimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).Unlimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_Unlimit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).Unlimit(ctx, req.(*UnlimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LimiterService_ListLimited_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLimitedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LimiterServiceServer).ListLimited(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LimiterService_ListLimited_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LimiterServiceServer).ListLimited(ctx, req.(*ListLimitedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// LimiterService_ServiceDesc is the grpc.ServiceDesc for LimiterService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LimiterService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimiter.v1.LimiterService",
+	HandlerType: (*LimiterServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Check",
+			Handler:    _LimiterService_Check_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _LimiterService_GetStatus_Handler,
+		},
+		{
+			MethodName: "Limit",
+			Handler:    _LimiterService_Limit_Handler,
+		},
+		{
+			MethodName: "Unlimit",
+			Handler:    _LimiterService_Unlimit_Handler,
+		},
+		{
+			MethodName: "ListLimited",
+			Handler:    _LimiterService_ListLimited_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ratelimiter.proto",
+}
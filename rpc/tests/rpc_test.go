@@ -0,0 +1,130 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/rpc"
+	"github.com/ALiwoto/ratelimiter/rpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newClient serves the limiter over an in-memory connection, and returns
+// a client connected to it.
+func newClient(t *testing.T, l *ratelimiter.Limiter) pb.LimiterServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	rpc.Register(s, l)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial the server: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return pb.NewLimiterServiceClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		Standalone:     true,
+		MessageCount:   3,
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	client := newClient(t, l)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 1; i <= 3; i++ {
+		resp, err := client.Check(ctx, &pb.CheckRequest{Key: 1})
+		if err != nil {
+			t.Fatalf("failed to check the key: %v", err)
+		}
+
+		if !resp.Allowed || resp.Result != pb.Result_RESULT_ALLOWED || resp.Count != int32(i) || resp.MaxCount != 3 {
+			t.Errorf("request %d should be allowed: %+v", i, resp)
+		}
+	}
+
+	resp, err := client.Check(ctx, &pb.CheckRequest{Key: 1})
+	if err != nil {
+		t.Fatalf("failed to check the key: %v", err)
+	}
+
+	if resp.Allowed || resp.Result != pb.Result_RESULT_LIMITED || !resp.NewlyLimited {
+		t.Errorf("the key should be limited: %+v", resp)
+	}
+
+	_, err = client.Check(ctx, &pb.CheckRequest{Key: 1, Cost: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("the negative cost should be rejected, got: %v", err)
+	}
+
+	st, err := client.GetStatus(ctx, &pb.GetStatusRequest{Key: 1})
+	if err != nil {
+		t.Fatalf("failed to get the status: %v", err)
+	}
+
+	if !st.Tracked || !st.Limited || st.LastUnix == 0 {
+		t.Errorf("unexpected status: %+v", st)
+	}
+
+	st, err = client.GetStatus(ctx, &pb.GetStatusRequest{Key: 2})
+	if err != nil || st.Tracked {
+		t.Errorf("the unknown keys should not be tracked: %+v, %v", st, err)
+	}
+
+	if _, err = client.Limit(ctx, &pb.LimitRequest{Key: 2}); err != nil {
+		t.Fatalf("failed to limit the key: %v", err)
+	}
+
+	list, err := client.ListLimited(ctx, &pb.ListLimitedRequest{})
+	if err != nil {
+		t.Fatalf("failed to list the limited keys: %v", err)
+	}
+
+	if len(list.Keys) != 2 {
+		t.Errorf("both of the keys should be limited: %v", list.Keys)
+	}
+
+	if _, err = client.Unlimit(ctx, &pb.UnlimitRequest{Key: 1}); err != nil {
+		t.Fatalf("failed to unlimit the key: %v", err)
+	}
+
+	limited := func(key int64) bool {
+		status := l.GetStatus(key)
+		return status != nil && status.IsLimited()
+	}
+
+	if !limited(2) || limited(1) {
+		t.Error("the changes should be applied to the limiter")
+	}
+}
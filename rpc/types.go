@@ -0,0 +1,21 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package rpc
+
+import (
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/rpc/pb"
+)
+
+// Server is the gRPC server of the limiter. It implements
+// `pb.LimiterServiceServer` on top of a `ratelimiter.Limiter`, so the
+// sidecar services (or other bots) can query and manipulate the flood
+// state of the bot over the network.
+type Server struct {
+	pb.UnimplementedLimiterServiceServer
+
+	limiter *ratelimiter.Limiter
+}
@@ -0,0 +1,45 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package storage
+
+import (
+	"net/url"
+	"os"
+	"sync"
+)
+
+// NewMemoryStorage creates a new empty `MemoryStorage`.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		mutex:  new(sync.RWMutex),
+		values: make(map[string][]byte),
+	}
+}
+
+// NewFileStorage creates a new `FileStorage` which stores its values
+// in the given directory. the directory will be created if it doesn't
+// exist.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileStorage{
+		mutex: new(sync.RWMutex),
+		dir:   dir,
+	}, nil
+}
+
+// encodeKey converts a key to a string which can be safely used as a
+// file name.
+func encodeKey(key string) string {
+	return url.QueryEscape(key)
+}
+
+// decodeKey converts a file name back to its original key.
+func decodeKey(name string) (string, error) {
+	return url.QueryUnescape(name)
+}
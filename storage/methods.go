@@ -0,0 +1,137 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//---------------------------------------------------------
+
+// Get returns the value of the key.
+func (s *MemoryStorage) Get(key string) ([]byte, error) {
+	s.mutex.RLock()
+	value, ok := s.values[key]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), value...), nil
+}
+
+// Set will set the value of the key.
+func (s *MemoryStorage) Set(key string, value []byte) error {
+	s.mutex.Lock()
+	s.values[key] = append([]byte(nil), value...)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Delete will remove the key from the memory.
+func (s *MemoryStorage) Delete(key string) error {
+	s.mutex.Lock()
+	delete(s.values, key)
+	s.mutex.Unlock()
+
+	return nil
+}
+
+// Keys returns all of the keys which start with the given prefix.
+func (s *MemoryStorage) Keys(prefix string) ([]string, error) {
+	var keys []string
+	s.mutex.RLock()
+	for key := range s.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	s.mutex.RUnlock()
+
+	return keys, nil
+}
+
+//---------------------------------------------------------
+
+// Get returns the value of the key by reading its file.
+func (s *FileStorage) Get(key string) ([]byte, error) {
+	s.mutex.RLock()
+	value, err := os.ReadFile(s.path(key))
+	s.mutex.RUnlock()
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return value, err
+}
+
+// Set will write the value of the key to its file. The value is written
+// to a temporary file first, so a crash won't leave a half-written file.
+func (s *FileStorage) Set(key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tmp := s.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, value, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path(key))
+}
+
+// Delete will remove the file of the key.
+func (s *FileStorage) Delete(key string) error {
+	s.mutex.Lock()
+	err := os.Remove(s.path(key))
+	s.mutex.Unlock()
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Keys returns all of the keys which start with the given prefix.
+func (s *FileStorage) Keys(prefix string) ([]string, error) {
+	s.mutex.RLock()
+	entries, err := os.ReadDir(s.dir)
+	s.mutex.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+
+		key, err := decodeKey(entry.Name())
+		if err != nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// path returns the path of the file of the key.
+func (s *FileStorage) path(key string) string {
+	return filepath.Join(s.dir, encodeKey(key))
+}
+
+//---------------------------------------------------------
@@ -0,0 +1,41 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package storage
+
+import "sync"
+
+// Storage is the interface of the storage backends used by the limiter
+// for persisting its data. Implementations should be safe for
+// concurrent use.
+type Storage interface {
+	// Get returns the value of the key; it should return `ErrNotFound`
+	// if the key doesn't exist.
+	Get(key string) ([]byte, error)
+
+	// Set will set the value of the key, overwriting the old value.
+	Set(key string, value []byte) error
+
+	// Delete will remove the key; deleting a non-existing key is not
+	// an error.
+	Delete(key string) error
+
+	// Keys returns all of the keys which start with the given prefix.
+	Keys(prefix string) ([]string, error)
+}
+
+// MemoryStorage is a `Storage` which keeps the values in the memory.
+// it's mostly useful for tests, as its data won't survive restarts.
+type MemoryStorage struct {
+	mutex  *sync.RWMutex
+	values map[string][]byte
+}
+
+// FileStorage is a `Storage` which keeps each value in a separate file
+// inside of a directory.
+type FileStorage struct {
+	mutex *sync.RWMutex
+	dir   string
+}
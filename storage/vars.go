@@ -0,0 +1,12 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package storage
+
+import "errors"
+
+var (
+	ErrNotFound = errors.New("storage: key not found")
+)
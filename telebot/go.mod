@@ -0,0 +1,10 @@
+module github.com/ALiwoto/ratelimiter/telebot
+
+go 1.19
+
+require (
+	github.com/ALiwoto/ratelimiter v0.0.0-20261015214017-28dfd57d3b70
+	gopkg.in/telebot.v3 v3.3.8
+)
+
+replace github.com/ALiwoto/ratelimiter => ../
@@ -0,0 +1,61 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package telebot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+	tele "gopkg.in/telebot.v3"
+)
+
+// NewLimiter creates a new telebot `Limiter` with the given config.
+// pass nil to use the default configuration.
+// zero durations and message count in the config will be replaced with
+// their default values (such as `core.DefaultTimeout`).
+func NewLimiter(config *Config) *Limiter {
+	if config == nil {
+		config = &Config{
+			ConsiderUser:      true,
+			ConsiderCallbacks: true,
+			IgnoreMediaGroup:  true,
+		}
+	}
+
+	return &Limiter{
+		mutex: new(sync.RWMutex),
+		core: core.NewLimiter(core.Profile{
+			Timeout:        valueOrDefault(config.Timeout, core.DefaultTimeout),
+			PunishmentTime: valueOrDefault(config.PunishmentTime, core.DefaultPunishmentTime),
+			MessageCount:   valueOrDefault(config.MessageCount, core.DefaultMessageCount),
+		}),
+		maxTimeout:        valueOrDefault(config.MaxTimeout, core.DefaultMaxTimeout),
+		ConsiderUser:      config.ConsiderUser,
+		ConsiderCallbacks: config.ConsiderCallbacks,
+		IgnoreMediaGroup:  config.IgnoreMediaGroup,
+		IsStrict:          config.IsStrict,
+	}
+}
+
+// valueOrDefault returns the default value if the value is zero.
+func valueOrDefault[T time.Duration | int](value, defaultValue T) T {
+	if value == 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+// runTriggers will run the triggers with the given context.
+// this function should be called in a separate goroutine.
+func runTriggers(triggers []tele.HandlerFunc, c tele.Context) {
+	for _, trigger := range triggers {
+		if trigger != nil {
+			_ = trigger(c)
+		}
+	}
+}
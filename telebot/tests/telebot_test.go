@@ -0,0 +1,162 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/telebot"
+	tele "gopkg.in/telebot.v3"
+)
+
+// newBot returns an offline bot which runs its handlers synchronously,
+// and the counter of the text messages handled by it.
+func newBot(t *testing.T, l *telebot.Limiter) (*tele.Bot, *atomic.Int32) {
+	b, err := tele.NewBot(tele.Settings{Offline: true, Synchronous: true})
+	if err != nil {
+		t.Fatalf("failed to create the bot: %v", err)
+	}
+
+	handled := new(atomic.Int32)
+	b.Use(l.Middleware())
+	b.Handle(tele.OnText, func(c tele.Context) error {
+		handled.Add(1)
+		return nil
+	})
+	b.Handle(&tele.InlineButton{Unique: "button"}, func(c tele.Context) error {
+		handled.Add(1)
+		return nil
+	})
+
+	return b, handled
+}
+
+func textUpdate(userID, chatID int64) tele.Update {
+	return tele.Update{
+		Message: &tele.Message{
+			Text:   "hello",
+			Sender: &tele.User{ID: userID},
+			Chat:   &tele.Chat{ID: chatID, Type: tele.ChatSuperGroup},
+		},
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l := telebot.NewLimiter(&telebot.Config{
+		ConsiderUser:   true,
+		MessageCount:   3,
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+	})
+	l.Start()
+	defer l.Stop()
+
+	limited := make(chan int64, 1)
+	l.SetTriggerFuncs(func(c tele.Context) error {
+		limited <- c.Sender().ID
+		return nil
+	})
+
+	b, handled := newBot(t, l)
+	for i := 0; i < 5; i++ {
+		b.ProcessUpdate(textUpdate(1, -100))
+	}
+
+	if n := handled.Load(); n != 3 {
+		t.Errorf("only the allowed updates should reach the handler, got %d", n)
+	}
+
+	select {
+	case id := <-limited:
+		if id != 1 {
+			t.Errorf("the trigger should be called for the limited user, got %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the trigger hasn't been called")
+	}
+
+	if status := l.GetStatus(1); status == nil || !status.IsLimited() {
+		t.Error("the user should be limited")
+	}
+
+	// the other users of the same chat are not affected.
+	handled.Store(0)
+	b.ProcessUpdate(textUpdate(2, -100))
+	if handled.Load() != 1 {
+		t.Error("the updates of the other users should be handled")
+	}
+
+	l.Unlimit(1)
+	handled.Store(0)
+	b.ProcessUpdate(textUpdate(1, -100))
+	if handled.Load() != 1 {
+		t.Error("the updates of the unlimited user should be handled")
+	}
+
+	// the stopped limiter lets all of the updates pass.
+	l.Stop()
+	handled.Store(0)
+	for i := 0; i < 5; i++ {
+		b.ProcessUpdate(textUpdate(1, -100))
+	}
+
+	if handled.Load() != 5 {
+		t.Errorf("all of the updates should be handled while stopped, got %d", handled.Load())
+	}
+}
+
+func TestMiddlewareFilters(t *testing.T) {
+	l := telebot.NewLimiter(&telebot.Config{
+		MessageCount:     1,
+		IgnoreMediaGroup: true,
+		Timeout:          time.Minute,
+		PunishmentTime:   time.Minute,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.AddExceptionID(10)
+	b, handled := newBot(t, l)
+
+	// without ConsiderUser, the chat is limited as a whole.
+	b.ProcessUpdate(textUpdate(1, -100))
+	b.ProcessUpdate(textUpdate(2, -100))
+	if handled.Load() != 1 {
+		t.Errorf("the chat should be limited, got %d handled updates", handled.Load())
+	}
+
+	// the exceptions and the albums are not checked.
+	handled.Store(0)
+	for i := 0; i < 3; i++ {
+		b.ProcessUpdate(textUpdate(10, -200))
+
+		album := textUpdate(3, -300)
+		album.Message.AlbumID = "album"
+		b.ProcessUpdate(album)
+	}
+
+	if handled.Load() != 6 {
+		t.Errorf("the exceptions and the albums should be handled, got %d", handled.Load())
+	}
+
+	// the callback queries are not checked without ConsiderCallbacks.
+	handled.Store(0)
+	for i := 0; i < 3; i++ {
+		b.ProcessUpdate(tele.Update{
+			Callback: &tele.Callback{
+				Sender:  &tele.User{ID: 4},
+				Message: &tele.Message{Chat: &tele.Chat{ID: -400}},
+				Data:    "\fbutton",
+			},
+		})
+	}
+
+	if handled.Load() != 3 {
+		t.Errorf("the callback queries should be handled, got %d", handled.Load())
+	}
+}
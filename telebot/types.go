@@ -0,0 +1,70 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package telebot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Filter is a function which checks a telebot context.
+type Filter func(c tele.Context) bool
+
+// Limiter is a rate limiter for telebot bots, which can be used as a
+// middleware. It has the same exceptions, triggers and punishment
+// semantics as the gotgbot limiter of this library.
+type Limiter struct {
+	mutex *sync.RWMutex
+
+	// core is the framework-agnostic limiter which keeps track of
+	// the status of each user (or chat).
+	core *core.Limiter
+
+	// stop is closed when the limiter is stopped.
+	stop chan struct{}
+
+	isEnabled bool
+
+	triggers     []tele.HandlerFunc
+	exceptions   []Filter
+	conditions   []Filter
+	exceptionIDs []int64
+
+	// maxTimeout is the interval of clearing the old statuses from
+	// the memory.
+	maxTimeout time.Duration
+
+	// IgnoreMediaGroup should be set to true when we have to ignore
+	// album messages and don't check them at all.
+	IgnoreMediaGroup bool
+
+	// IsStrict will tell the limiter whether it should act more strict
+	// or not; see the gotgbot limiter for more information.
+	IsStrict bool
+
+	// ConsiderUser will be true when the limiter needs to use the
+	// sender's ID as the key; otherwise the chat's ID will be used.
+	ConsiderUser bool
+
+	// ConsiderCallbacks should be set to true if the callback queries
+	// have to be checked by the limiter as well.
+	ConsiderCallbacks bool
+}
+
+// Config is the config type of the telebot limiter.
+type Config struct {
+	ConsiderUser      bool
+	ConsiderCallbacks bool
+	IgnoreMediaGroup  bool
+	IsStrict          bool
+	Timeout           time.Duration
+	PunishmentTime    time.Duration
+	MaxTimeout        time.Duration
+	MessageCount      int
+}
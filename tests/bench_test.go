@@ -0,0 +1,70 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+func BenchmarkCoreAllow(b *testing.B) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Second,
+		PunishmentTime: time.Second,
+		MessageCount:   1 << 30,
+	})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Allow(int64(i%1024), 1)
+	}
+}
+
+func BenchmarkCoreChurn(b *testing.B) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Nanosecond,
+		PunishmentTime: time.Second,
+		MessageCount:   1 << 30,
+	})
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Allow(int64(i%1024), 1)
+		if i%1024 == 1023 {
+			l.Sweep()
+		}
+	}
+}
+
+// BenchmarkCheck measures the hot path of the handler, where the context
+// is already created by the dispatcher.
+func BenchmarkCheck(b *testing.B) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1 << 30,
+	})
+	l.Start()
+	defer l.Stop()
+
+	ctx := ext.NewContext(&gotgbot.Update{
+		Message: &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: 1},
+		},
+	}, nil)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Check(ctx)
+	}
+}
@@ -0,0 +1,1781 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+func TestCheckMessage(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 2,
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if d := l.CheckMessage(msg(1)); !d.IsAllowed() || d.Count != i+1 {
+			t.Fatalf("message %d should be allowed: %+v", i, d)
+		}
+	}
+
+	if d := l.CheckMessage(msg(1)); d.IsAllowed() || !d.NewlyLimited {
+		t.Fatalf("the user should be newly limited: %+v", d)
+	}
+
+	l.AddExceptionID(2)
+	for i := 0; i < 3; i++ {
+		if d := l.CheckMessage(msg(2)); d.Result != core.ResultExempt {
+			t.Fatalf("the exception should be exempt: %+v", d)
+		}
+	}
+}
+
+func TestRoleProfiles(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 5,
+		RoleProfiles: map[ratelimiter.Role]*ratelimiter.LimitProfile{
+			ratelimiter.RoleRestricted: {
+				Timeout:        time.Minute,
+				PunishmentTime: time.Minute,
+				MessageCount:   1,
+			},
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.SetRole(-100, 1, ratelimiter.RoleRestricted)
+	msg := &gotgbot.Message{
+		Text: "hello",
+		Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From: &gotgbot.User{Id: 1},
+	}
+
+	l.CheckMessage(msg)
+	if d := l.CheckMessage(msg); d.IsAllowed() || d.MaxCount != 1 {
+		t.Errorf("the restricted user should be limited by its role profile: %+v", d)
+	}
+}
+
+func TestCountedTypes(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+		CountedTypes: ratelimiter.TypeText | ratelimiter.TypeSticker,
+	})
+	l.Start()
+	defer l.Stop()
+
+	photo := &gotgbot.Message{
+		Chat:  gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From:  &gotgbot.User{Id: 1},
+		Photo: []gotgbot.PhotoSize{{FileId: "photo"}},
+	}
+
+	for i := 0; i < 3; i++ {
+		if d := l.CheckMessage(photo); d.Result != core.ResultExempt {
+			t.Fatalf("photos should not be counted: %+v", d)
+		}
+	}
+
+	// the exported fields are taken by Start.
+	l.Stop()
+	l.CountCaptions = true
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	photo.Caption = "buy followers"
+	l.CheckMessage(photo)
+	if d := l.CheckMessage(photo); d.IsAllowed() {
+		t.Errorf("photos with caption should be counted: %+v", d)
+	}
+
+	types, err := ratelimiter.ParseMessageType("text", "media")
+	if err != nil || types&ratelimiter.TypePhoto == 0 || types&ratelimiter.TypeDice != 0 {
+		t.Errorf("unexpected parsed types: %b, %v", types, err)
+	}
+
+	if _, err = ratelimiter.ParseMessageType("unknown"); err == nil {
+		t.Error("unknown types should not be parsed")
+	}
+}
+
+func TestServicePolicy(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:  true,
+		MessageCount:  1,
+		ServicePolicy: ratelimiter.ServiceDetect,
+		JoinFloodProfile: &ratelimiter.LimitProfile{
+			Timeout:        time.Minute,
+			PunishmentTime: time.Minute,
+			MessageCount:   3,
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	join := &gotgbot.Message{
+		Chat:           gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From:           &gotgbot.User{Id: 1},
+		NewChatMembers: []gotgbot.User{{Id: 2}, {Id: 3}},
+	}
+
+	for i := 0; i < 2; i++ {
+		if d := l.CheckMessage(join); d.Result != core.ResultExempt {
+			t.Fatalf("service messages should not be counted: %+v", d)
+		}
+	}
+
+	if !l.IsJoinFlooding(-100) {
+		t.Error("the join flood should be detected")
+	}
+
+	l.SetServicePolicy(ratelimiter.ServiceCount)
+	l.CheckMessage(join)
+	if d := l.CheckMessage(join); d.IsAllowed() {
+		t.Errorf("service messages should be counted: %+v", d)
+	}
+}
+
+func TestChannelPosts(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:    true,
+		ConsiderChannel: true,
+		MessageCount:    5,
+		ChannelPostProfile: &ratelimiter.LimitProfile{
+			Timeout:        time.Minute,
+			PunishmentTime: time.Minute,
+			MessageCount:   1,
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	channel := gotgbot.Chat{Id: -1001, Type: "channel"}
+	post := &gotgbot.Update{
+		ChannelPost: &gotgbot.Message{
+			Text:       "news",
+			Chat:       channel,
+			SenderChat: &channel,
+		},
+	}
+
+	l.Check(ext.NewContext(post, nil))
+	if d := l.Check(ext.NewContext(post, nil)); d.IsAllowed() || d.MaxCount != 1 {
+		t.Fatalf("the channel should be limited by the channel post profile: %+v", d)
+	}
+
+	if status := l.GetStatus(channel.Id); status == nil || !status.IsLimited() {
+		t.Errorf("the channel posts should be keyed by the channel id: %+v", status)
+	}
+}
+
+func TestCallbackProfile(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		ConsiderInline: true,
+		MessageCount:   1,
+		CallbackProfile: &ratelimiter.LimitProfile{
+			Timeout:        time.Minute,
+			PunishmentTime: time.Minute,
+			MessageCount:   3,
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	press := &gotgbot.Update{
+		CallbackQuery: &gotgbot.CallbackQuery{
+			Id:   "1",
+			From: gotgbot.User{Id: 1},
+			Data: "page:2",
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		if d := l.Check(ext.NewContext(press, nil)); !d.IsAllowed() || d.MaxCount != 3 {
+			t.Fatalf("press %d should be allowed by the callback profile: %+v", i, d)
+		}
+	}
+
+	if d := l.Check(ext.NewContext(press, nil)); d.IsAllowed() {
+		t.Fatalf("the user should be limited by the callback profile: %+v", d)
+	}
+
+	// the punishment is shared with the messages of the user.
+	msg := &gotgbot.Message{
+		Text: "hello",
+		Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From: &gotgbot.User{Id: 1},
+	}
+	if d := l.CheckMessage(msg); d.IsAllowed() {
+		t.Errorf("the messages of the limited user should be limited: %+v", d)
+	}
+}
+
+func TestCallbackDebounce(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:     true,
+		ConsiderInline:   true,
+		MessageCount:     3,
+		CallbackDebounce: time.Minute,
+	})
+	l.Start()
+	defer l.Stop()
+
+	press := func(userID int64, data string) ratelimiter.Decision {
+		return l.Check(ext.NewContext(&gotgbot.Update{
+			CallbackQuery: &gotgbot.CallbackQuery{
+				Id:   "1",
+				From: gotgbot.User{Id: userID},
+				Data: data,
+			},
+		}, nil))
+	}
+
+	if d := press(1, "like"); !d.IsAllowed() || d.Count != 1 {
+		t.Fatalf("the first press should be allowed: %+v", d)
+	}
+
+	for i := 0; i < 5; i++ {
+		if d := press(1, "like"); d.Result != core.ResultDropped {
+			t.Fatalf("the repeated press %d should be dropped: %+v", i, d)
+		}
+	}
+
+	if d := press(1, "dislike"); !d.IsAllowed() || d.Count != 2 {
+		t.Errorf("the dropped presses shouldn't count toward the quota: %+v", d)
+	}
+
+	if d := press(2, "like"); !d.IsAllowed() {
+		t.Errorf("the presses of the other users shouldn't be dropped: %+v", d)
+	}
+
+	if c := l.Config(); time.Duration(c.CallbackDebounce) != time.Minute {
+		t.Errorf("the debounce should be exported: %v", c.CallbackDebounce)
+	}
+}
+
+func TestUpdateProfiles(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 5,
+		UpdateProfiles: map[ratelimiter.UpdateType]*ratelimiter.LimitProfile{
+			ratelimiter.UpdateReaction: {
+				Timeout:        time.Minute,
+				PunishmentTime: time.Minute,
+				MessageCount:   2,
+			},
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	chat := gotgbot.Chat{Id: -100, Type: "supergroup"}
+	reaction := &gotgbot.Update{
+		MessageReaction: &gotgbot.MessageReactionUpdated{
+			Chat:      chat,
+			MessageId: 1,
+			User:      &gotgbot.User{Id: 1},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		if d := l.Check(ext.NewContext(reaction, nil)); !d.IsAllowed() || d.MaxCount != 2 {
+			t.Fatalf("reaction %d should be allowed by the reaction profile: %+v", i, d)
+		}
+	}
+
+	if d := l.Check(ext.NewContext(reaction, nil)); d.IsAllowed() {
+		t.Fatalf("the user should be limited by the reaction profile: %+v", d)
+	}
+
+	msg := &gotgbot.Message{Text: "hello", Chat: chat, From: &gotgbot.User{Id: 1}}
+	if d := l.CheckMessage(msg); d.IsAllowed() {
+		t.Errorf("the punishment should be shared with the messages: %+v", d)
+	}
+
+	inline := &gotgbot.Update{
+		InlineQuery: &gotgbot.InlineQuery{Id: "1", From: gotgbot.User{Id: 2}},
+	}
+	if d := l.Check(ext.NewContext(inline, nil)); d.Result != core.ResultExempt {
+		t.Errorf("inline queries without a profile shouldn't be checked: %+v", d)
+	}
+
+	c := l.Config()
+	if c.UpdateProfiles[ratelimiter.UpdateReaction] == nil {
+		t.Errorf("the update profiles should be exported: %+v", c.UpdateProfiles)
+	}
+
+	c.UpdateProfiles["typing"] = c.UpdateProfiles[ratelimiter.UpdateReaction]
+	if err := c.Validate(); err == nil {
+		t.Error("unknown update types should be invalid")
+	}
+}
+
+func TestReactionCountAndBoosts(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		UpdateProfiles: map[ratelimiter.UpdateType]*ratelimiter.LimitProfile{
+			ratelimiter.UpdateReactionCount: ratelimiter.DefaultReactionCountProfile,
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	chat := gotgbot.Chat{Id: -100, Type: "supergroup"}
+	counts := &gotgbot.Update{
+		MessageReactionCount: &gotgbot.MessageReactionCountUpdated{
+			Chat:      chat,
+			MessageId: 1,
+		},
+	}
+
+	if d := l.Check(ext.NewContext(counts, nil)); !d.IsAllowed() {
+		t.Fatalf("the first reaction count of the chat should be allowed: %+v", d)
+	}
+
+	if d := l.Check(ext.NewContext(counts, nil)); d.IsAllowed() {
+		t.Fatalf("the reaction counts of the chat should be throttled: %+v", d)
+	}
+
+	boost := &gotgbot.Update{
+		ChatBoost: &gotgbot.ChatBoostUpdated{
+			Chat: chat,
+			Boost: gotgbot.ChatBoost{
+				BoostId: "1",
+				Source:  gotgbot.ChatBoostSourcePremium{User: gotgbot.User{Id: 1}},
+			},
+		},
+	}
+	if d := l.Check(ext.NewContext(boost, nil)); d.Result != core.ResultExempt {
+		t.Fatalf("the boosts without a profile shouldn't be checked: %+v", d)
+	}
+
+	l.SetUpdateProfile(ratelimiter.UpdateBoost, ratelimiter.DefaultBoostProfile)
+	if d := l.Check(ext.NewContext(boost, nil)); !d.IsAllowed() {
+		t.Fatalf("the first boost of the user should be allowed: %+v", d)
+	}
+
+	if d := l.Check(ext.NewContext(boost, nil)); d.IsAllowed() {
+		t.Errorf("the boosts of the user should be throttled: %+v", d)
+	}
+
+	if c := l.Config(); c.Validate() != nil || c.UpdateProfiles[ratelimiter.UpdateBoost] == nil {
+		t.Errorf("the new update types should be valid in the config: %+v", c.UpdateProfiles)
+	}
+}
+
+func TestCommandProfile(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   3,
+		CommandProfile: &ratelimiter.LimitProfile{Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 1},
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(text string) *gotgbot.Message {
+		m := &gotgbot.Message{
+			Text: text,
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: 1},
+		}
+		if strings.HasPrefix(text, "/") {
+			m.Entities = []gotgbot.MessageEntity{{Type: "bot_command", Length: int64(len(text))}}
+		}
+
+		return m
+	}
+
+	if d := l.CheckMessage(msg("/start")); !d.IsAllowed() || d.MaxCount != 1 {
+		t.Fatalf("the first command should be allowed by the command profile: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg("/start")); d.IsAllowed() {
+		t.Fatalf("the commands should be limited by the command profile: %+v", d)
+	}
+
+	for i := 0; i < 3; i++ {
+		if d := l.CheckMessage(msg("hello")); !d.IsAllowed() {
+			t.Fatalf("message %d should not be penalized by the commands: %+v", i, d)
+		}
+	}
+
+	if d := l.CheckMessage(msg("hello")); d.IsAllowed() {
+		t.Fatalf("the messages should be limited by the default profile: %+v", d)
+	}
+
+	if status := l.GetStatus(ratelimiter.CommandKey(1)); status == nil || !status.IsLimited() {
+		t.Errorf("the commands should be kept by the command key: %+v", status)
+	}
+
+	l.Unlimit(ratelimiter.CommandKey(1))
+	if d := l.CheckMessage(msg("/help")); !d.IsAllowed() {
+		t.Errorf("the commands should be allowed after unlimiting the command key: %+v", d)
+	}
+
+	if c := l.Config(); c.CommandProfile == nil || c.CommandProfile.MessageCount != 1 {
+		t.Errorf("the command profile should be exported: %+v", c.CommandProfile)
+	}
+}
+
+func TestBusinessMessages(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:     true,
+		ConsiderBusiness: true,
+		MessageCount:     1,
+	})
+	l.Start()
+	defer l.Stop()
+
+	message := func(connection string) *gotgbot.Update {
+		return &gotgbot.Update{
+			BusinessMessage: &gotgbot.Message{
+				Text:                 "hi",
+				Chat:                 gotgbot.Chat{Id: 1, Type: "private"},
+				From:                 &gotgbot.User{Id: 1},
+				BusinessConnectionId: connection,
+			},
+		}
+	}
+
+	l.Check(ext.NewContext(message("a"), nil))
+	if d := l.Check(ext.NewContext(message("a"), nil)); d.IsAllowed() {
+		t.Fatalf("the user should be limited in the first conversation: %+v", d)
+	}
+
+	if d := l.Check(ext.NewContext(message("b"), nil)); !d.IsAllowed() {
+		t.Errorf("other conversations of the user should not be affected: %+v", d)
+	}
+
+	if status := l.GetStatus(ratelimiter.BusinessKey("a", 1)); status == nil || !status.IsLimited() {
+		t.Errorf("unexpected status of the business key: %+v", status)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := ratelimiter.NewRegistry()
+	commands, err := r.NewLimiter("commands", nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create the limiter: %v", err)
+	}
+
+	if _, err = r.NewLimiter("commands", nil, nil); err != ratelimiter.ErrLimiterExists {
+		t.Errorf("duplicate names should not be allowed, got: %v", err)
+	}
+
+	if err = r.Register("callbacks", ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{Standalone: true})); err != nil {
+		t.Fatalf("failed to register the limiter: %v", err)
+	}
+
+	r.StartAll()
+	defer r.StopAll()
+
+	if r.Get("commands") != commands || len(r.Names()) != 2 {
+		t.Fatalf("unexpected registry: %v", r.Names())
+	}
+
+	commands.Limit(1)
+	commands.Limit(2)
+	m := r.Metrics()
+	if m.Limited != 2 || !m.Limiters["callbacks"].Enabled {
+		t.Errorf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestClone(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	l.AddExceptionID(2)
+	l.Start()
+	defer l.Stop()
+
+	l.Limit(1)
+	c := l.Clone()
+	c.Start()
+	defer c.Stop()
+
+	if c.GetStatus(1) != nil {
+		t.Error("the runtime state should not be cloned")
+	}
+
+	if !c.IsInExceptionList(2) || c.GetCore().GetProfile().MessageCount != 1 {
+		t.Error("the configuration should be cloned")
+	}
+
+	c.AddExceptionID(3)
+	if l.IsInExceptionList(3) {
+		t.Error("the clone should not share its configuration with the original")
+	}
+}
+
+func TestScoreFunc(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 5,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.SetScoreFunc(func(ctx *ext.Context) float64 {
+		if ctx.EffectiveMessage.Text == "spam" {
+			return 1
+		}
+		return 0
+	})
+
+	msg := func(userID int64, text string) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: text,
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	if d := l.CheckMessage(msg(1, "hello")); !d.IsAllowed() || d.Count != 1 {
+		t.Fatalf("normal messages should be allowed: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(2, "spam")); d.IsAllowed() || !d.NewlyLimited {
+		t.Fatalf("spam should limit the user immediately: %+v", d)
+	}
+
+	l.SetScoreThreshold(ratelimiter.DefaultScoreThreshold, 2)
+	l.CheckMessage(msg(3, "hello"))
+	if d := l.CheckMessage(msg(3, "spam")); !d.IsAllowed() || d.Count != 4 {
+		t.Fatalf("spam should consume the extra cost: %+v", d)
+	}
+}
+
+func TestCostFunc(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 5,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.SetCostFunc(func(ctx *ext.Context) int {
+		return len(ctx.EffectiveMessage.Text) / 100
+	})
+
+	msg := func(text string) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: text,
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: 1},
+		}
+	}
+
+	if d := l.CheckMessage(msg("hello")); d.Count != 1 {
+		t.Fatalf("short messages should cost 1 unit: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(strings.Repeat("a", 300))); !d.IsAllowed() || d.Count != 4 {
+		t.Fatalf("long messages should cost more: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(strings.Repeat("a", 200))); d.IsAllowed() || !d.NewlyLimited {
+		t.Fatalf("the user should be limited: %+v", d)
+	}
+
+	// the first message of a user costing more than the quota is limited.
+	first := msg(strings.Repeat("a", 600))
+	first.From = &gotgbot.User{Id: 2}
+	if d := l.CheckMessage(first); d.IsAllowed() || !d.NewlyLimited {
+		t.Errorf("the first message should be limited: %+v", d)
+	}
+}
+
+func TestDecisionHook(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.SetDecisionHook(func(ctx *ext.Context, proposed ratelimiter.Decision) ratelimiter.Decision {
+		switch ctx.EffectiveMessage.Text {
+		case "/cancel":
+			proposed.Result = core.ResultAllowed
+		case "blocked":
+			proposed.Result = core.ResultLimited
+		}
+		return proposed
+	})
+
+	msg := func(userID int64, text string) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: text,
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if d := l.CheckMessage(msg(1, "/cancel")); !d.IsAllowed() || d.NewlyLimited {
+			t.Fatalf("the hook should veto the limit: %+v", d)
+		}
+	}
+
+	if l.GetStatus(1).IsLimited() {
+		t.Fatal("the vetoed limit should not be kept")
+	}
+
+	if d := l.CheckMessage(msg(2, "blocked")); d.IsAllowed() || !d.NewlyLimited {
+		t.Fatalf("the hook should force the limit: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(2, "hello")); d.IsAllowed() {
+		t.Fatalf("the forced limit should be kept: %+v", d)
+	}
+}
+
+func TestConcurrentExceptions(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1 << 20,
+	})
+	l.Start()
+	defer l.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(0); i < 1000; i++ {
+			l.AddExceptionID(i)
+			l.AddCondition(func(msg *gotgbot.Message) bool { return true })
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		l.CheckMessage(&gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: 5000},
+		})
+	}
+	<-done
+
+	if !l.IsInExceptionList(999) {
+		t.Error("all of the exception ids should be added")
+	}
+}
+
+func TestScopedExceptions(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.AddScopedException(-100, 1)
+	msg := func(chatID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: chatID, Type: "supergroup"},
+			From: &gotgbot.User{Id: 1},
+		}
+	}
+
+	if d := l.CheckMessage(msg(-100)); d.Result != core.ResultExempt {
+		t.Fatalf("the user should be exempt in the scoped chat: %+v", d)
+	}
+
+	l.CheckMessage(msg(-200))
+	if d := l.CheckMessage(msg(-200)); d.IsAllowed() {
+		t.Fatalf("the user should not be exempt in the other chats: %+v", d)
+	}
+
+	l.RemoveScopedException(-100, 1)
+	if l.IsScopedException(-100, 1) || len(l.ListScopedExceptions()) != 0 {
+		t.Error("the scoped exception should be removed")
+	}
+}
+
+func TestExceptionRules(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	l.Start()
+	defer l.Stop()
+
+	if err := l.AddExceptionRule(ratelimiter.ExceptionRule{}); err == nil {
+		t.Error("empty rules should be rejected")
+	}
+
+	err := l.AddExceptionRule(
+		ratelimiter.ExceptionRule{Username: "@*_Admin"},
+		ratelimiter.ExceptionRule{Premium: true, LanguageCode: "en"},
+	)
+	if err != nil {
+		t.Fatalf("failed to add the rules: %v", err)
+	}
+
+	msg := func(u *gotgbot.User) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: u,
+		}
+	}
+
+	exempt := []*gotgbot.User{
+		{Id: 1, Username: "group_admin"},
+		{Id: 2, IsPremium: true, LanguageCode: "EN"},
+	}
+	for _, u := range exempt {
+		if d := l.CheckMessage(msg(u)); d.Result != core.ResultExempt {
+			t.Errorf("%+v should be exempt: %+v", u, d)
+		}
+	}
+
+	normal := &gotgbot.User{Id: 3, Username: "admin", IsPremium: true, LanguageCode: "fa"}
+	if d := l.CheckMessage(msg(normal)); d.Result == core.ResultExempt {
+		t.Errorf("%+v should not be exempt: %+v", normal, d)
+	}
+}
+
+func TestOptIn(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+		OptIn:        true,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.Enroll(-200)
+	msg := func(chatID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: chatID, Type: "supergroup"},
+			From: &gotgbot.User{Id: 1},
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if d := l.CheckMessage(msg(-100)); d.Result != core.ResultExempt || d.Count != 0 {
+			t.Fatalf("the updates of the other chats should not be checked: %+v", d)
+		}
+	}
+
+	l.CheckMessage(msg(-200))
+	if d := l.CheckMessage(msg(-200)); d.IsAllowed() {
+		t.Fatalf("the enrolled chat should be limited: %+v", d)
+	}
+}
+
+func TestAllowedCommands(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:      true,
+		ConsiderUser:    true,
+		MessageCount:    1,
+		AllowedCommands: []string{"/Cancel", "help"},
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(text string) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: text,
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: 1},
+		}
+	}
+
+	l.CheckMessage(msg("hello"))
+	if d := l.CheckMessage(msg("/start")); d.IsAllowed() {
+		t.Fatalf("the other commands should be limited: %+v", d)
+	}
+
+	for _, text := range []string{"/cancel", "/CANCEL@my_bot now", "/help"} {
+		if d := l.CheckMessage(msg(text)); !d.IsAllowed() {
+			t.Errorf("%q should be allowed for the limited users: %+v", text, d)
+		}
+	}
+
+	if names := l.GetAllowedCommands(); len(names) != 2 || names[0] != "cancel" {
+		t.Errorf("unexpected allowed commands: %v", names)
+	}
+}
+
+func TestChatStats(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:     true,
+		ConsiderUser:   true,
+		MessageCount:   2,
+		StatsRetention: time.Hour,
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		l.CheckMessage(msg(1))
+		l.CheckMessage(msg(2))
+	}
+	l.CheckMessage(msg(3))
+
+	stats := l.ChatStats(-100)
+	if stats == nil {
+		t.Fatal("the chat should have statistics")
+	}
+
+	if stats.Messages != 9 || stats.Blocked != 4 || stats.LimitedUsers != 2 {
+		t.Errorf("unexpected chat statistics: %+v", stats)
+	}
+
+	if l.ChatStats(-200) != nil {
+		t.Error("an unknown chat shouldn't have statistics")
+	}
+
+	l.SetStatsRetention(0)
+	if l.ChatStats(-100) != nil {
+		t.Error("the statistics should be disabled")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2024, time.January, 31, 10, 30, 15, 0, time.UTC)
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"@hourly", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", base.Add(90 * time.Minute)},
+		{"*/20 9-17 * * *", time.Date(2024, time.January, 31, 10, 40, 0, 0, time.UTC)},
+		{"0 9 * * 1", time.Date(2024, time.February, 5, 9, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		s, err := ratelimiter.ParseSchedule(c.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", c.spec, err)
+		}
+
+		if next := s.Next(base); !next.Equal(c.next) {
+			t.Errorf("%q: expected %v, got %v", c.spec, c.next, next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "@every 1ms", "5-1 * * * *"} {
+		if _, err := ratelimiter.ParseSchedule(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	l.Start()
+	defer l.Stop()
+
+	err := l.SetReport(&ratelimiter.ReportConfig{
+		Bot:      &gotgbot.Bot{},
+		ChatID:   -100,
+		Schedule: "@daily",
+		TopCount: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := func(userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		l.CheckMessage(msg(1))
+	}
+	l.CheckMessage(msg(2))
+	l.CheckMessage(msg(2))
+
+	r := l.GetReport()
+	if r.Messages != 5 || r.Blocked != 3 || r.Limits != 2 || r.Offenders != 2 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+
+	if len(r.TopOffenders) != 1 || r.TopOffenders[0].Key != 1 {
+		t.Errorf("unexpected top offenders: %+v", r.TopOffenders)
+	}
+
+	if text := ratelimiter.FormatReport(r); !strings.Contains(text, "Blocked: 3") {
+		t.Errorf("unexpected report text: %q", text)
+	}
+
+	if err := l.SetReport(nil); err != nil || l.GetReport() != nil {
+		t.Error("the reports should be disabled")
+	}
+}
+
+func TestChatScopes(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+		ChatScopes:   ratelimiter.ScopeGroups,
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(chat gotgbot.Chat) *gotgbot.Message {
+		return &gotgbot.Message{Text: "hello", Chat: chat, From: &gotgbot.User{Id: 1}}
+	}
+
+	private := gotgbot.Chat{Id: 1, Type: "private"}
+	for i := 0; i < 3; i++ {
+		if d := l.CheckMessage(msg(private)); d.Result != core.ResultExempt {
+			t.Fatalf("the private chats should be out of the scope: %+v", d)
+		}
+	}
+
+	group := gotgbot.Chat{Id: -100, Type: "group"}
+	if d := l.CheckMessage(msg(group)); !d.IsAllowed() || d.Count != 1 {
+		t.Fatalf("the groups should be in the scope: %+v", d)
+	}
+
+	c := l.Config()
+	if len(c.ChatScopes) != 1 || c.ChatScopes[0] != "groups" {
+		t.Errorf("the chat scopes should be exported: %v", c.ChatScopes)
+	}
+
+	c.ChatScopes = []string{"bots"}
+	if err := c.Validate(); err == nil {
+		t.Error("unknown chat scopes should be invalid")
+	}
+
+	l.SetChatScopes()
+	other := &gotgbot.Message{Text: "hello", Chat: private, From: &gotgbot.User{Id: 2}}
+	if d := l.CheckMessage(other); !d.IsAllowed() {
+		t.Errorf("all of the chats should be considered by default: %+v", d)
+	}
+}
+
+func TestDiscussionGroups(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:       true,
+		MessageCount:       5,
+		ExemptAutoForwards: true,
+		CommentProfile: &ratelimiter.LimitProfile{
+			Timeout:        time.Minute,
+			PunishmentTime: time.Minute,
+			MessageCount:   1,
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	group := gotgbot.Chat{Id: -100, Type: "supergroup"}
+	channel := &gotgbot.Chat{Id: -200, Type: "channel"}
+	post := &gotgbot.Message{
+		MessageId:          10,
+		Text:               "a new post",
+		Chat:               group,
+		SenderChat:         channel,
+		From:               &gotgbot.User{Id: 777000},
+		IsAutomaticForward: true,
+	}
+
+	for i := 0; i < 10; i++ {
+		if d := l.CheckMessage(post); d.Result != core.ResultExempt {
+			t.Fatalf("the automatic forwards should be exempt: %+v", d)
+		}
+	}
+
+	comment := &gotgbot.Message{
+		Text:            "first!",
+		Chat:            group,
+		From:            &gotgbot.User{Id: 1},
+		MessageThreadId: 10,
+		ReplyToMessage:  post,
+	}
+	if !ratelimiter.IsDiscussionComment(comment) {
+		t.Fatal("a reply to the automatic forward should be a comment")
+	}
+
+	if d := l.CheckMessage(comment); !d.IsAllowed() || d.MaxCount != 1 {
+		t.Fatalf("the comment profile should be applied: %+v", d)
+	}
+
+	// a reply to another comment of the thread.
+	reply := &gotgbot.Message{
+		Text:            "second!",
+		Chat:            group,
+		From:            &gotgbot.User{Id: 2},
+		MessageThreadId: 10,
+		ReplyToMessage:  comment,
+	}
+	if !l.IsComment(reply) {
+		t.Fatal("the replies in the thread of the post should be comments")
+	}
+
+	if d := l.CheckMessage(reply); !d.IsAllowed() || d.MaxCount != 1 {
+		t.Fatalf("the comment profile should be applied: %+v", d)
+	}
+
+	if d := l.CheckMessage(reply); d.IsAllowed() {
+		t.Errorf("the commenters should still be limited: %+v", d)
+	}
+}
+
+func TestCountAlbumsOnce(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:    true,
+		MessageCount:    2,
+		CountAlbumsOnce: true,
+	})
+	l.Start()
+	defer l.Stop()
+
+	photo := func(groupID string) *gotgbot.Message {
+		return &gotgbot.Message{
+			Photo:        []gotgbot.PhotoSize{{FileId: "photo"}},
+			MediaGroupId: groupID,
+			Chat:         gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From:         &gotgbot.User{Id: 1},
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if d := l.CheckMessage(photo("album1")); !d.IsAllowed() || d.Count != 1 {
+			t.Fatalf("the album should be counted once (photo %d): %+v", i, d)
+		}
+	}
+
+	if d := l.CheckMessage(photo("album2")); !d.IsAllowed() || d.Count != 2 {
+		t.Fatalf("another album should be counted: %+v", d)
+	}
+
+	if d := l.CheckMessage(photo("album3")); d.IsAllowed() {
+		t.Fatalf("the user should be limited by the third album: %+v", d)
+	}
+
+	if d := l.CheckMessage(photo("album3")); d.IsAllowed() {
+		t.Errorf("the rest of the album should be blocked as well: %+v", d)
+	}
+}
+
+func TestSlowMode(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 10,
+	})
+	l.Start()
+	defer l.Stop()
+
+	err := l.SetChatSettings(&ratelimiter.ChatSettings{
+		ChatID:   -100,
+		SlowMode: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to set the chat settings: %v", err)
+	}
+
+	msg := func(chatID, userID int64) *ext.Context {
+		return ext.NewContext(&gotgbot.Update{Message: &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: chatID, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}}, nil)
+	}
+
+	if d := l.Check(msg(-100, 1)); !d.IsAllowed() {
+		t.Fatalf("the first message should be allowed: %+v", d)
+	}
+
+	ctx := msg(-100, 1)
+	if d := l.Check(ctx); d.Result != core.ResultDropped || !ratelimiter.IsSlowed(ctx) {
+		t.Fatalf("the second message should be ignored by the slow mode: %+v", d)
+	}
+
+	if d := l.Check(msg(-100, 2)); !d.IsAllowed() {
+		t.Errorf("the slow mode should be per user: %+v", d)
+	}
+
+	if d := l.Check(msg(-200, 1)); !d.IsAllowed() {
+		t.Errorf("the slow mode should be per chat: %+v", d)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if d := l.Check(msg(-100, 1)); !d.IsAllowed() {
+		t.Errorf("the message should be allowed after the slow mode interval: %+v", d)
+	}
+}
+
+func TestRaid(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:      true,
+		MessageCount:      10,
+		ProbationProfile:  &ratelimiter.LimitProfile{Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 1},
+		ProbationDuration: time.Hour,
+		Raid: &ratelimiter.RaidConfig{
+			Joins:    4,
+			Lockdown: true,
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	err := l.SetLockdown(&ratelimiter.LockdownConfig{Threshold: 100})
+	if err != nil {
+		t.Fatalf("failed to set the lockdown: %v", err)
+	}
+
+	join := func(users ...int64) *gotgbot.Message {
+		msg := &gotgbot.Message{
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: users[0]},
+		}
+
+		for _, id := range users {
+			msg.NewChatMembers = append(msg.NewChatMembers, gotgbot.User{Id: id})
+		}
+
+		return msg
+	}
+
+	l.CheckMessage(join(1, 2))
+	l.CheckMessage(join(1, 2))
+	l.CheckMessage(join(3))
+	if l.IsRaided(-100) || l.IsLockedDown(-100) {
+		t.Fatal("the repeated joins should not be counted")
+	}
+
+	l.CheckMessage(join(4))
+	if !l.IsRaided(-100) || !l.IsLockedDown(-100) {
+		t.Fatal("the raid should be detected and the chat should be locked down")
+	}
+
+	for id := int64(1); id <= 4; id++ {
+		if !l.IsOnProbation(-100, id) {
+			t.Errorf("the raider %d should be on probation", id)
+		}
+	}
+
+	if l.IsRaided(-200) {
+		t.Error("the other chats should not be raided")
+	}
+
+	l.SetRaid(nil)
+	if l.IsRaided(-100) || l.GetRaid() != nil {
+		t.Error("the raid detection should be disabled")
+	}
+}
+
+func TestChatState(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 10,
+	})
+	l.Start()
+	defer l.Stop()
+
+	err := l.SetLockdown(&ratelimiter.LockdownConfig{Threshold: 1, Cooldown: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to set the lockdown: %v", err)
+	}
+
+	msg := func(userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	l.RaiseSlowMode(-100, time.Minute, 100*time.Millisecond)
+	l.CheckMessage(msg(1))
+	if d := l.CheckMessage(msg(1)); d.IsAllowed() {
+		t.Fatalf("the raised slow mode should slow the user down: %+v", d)
+	}
+
+	state := l.ChatState(-100)
+	if !state.Until[ratelimiter.StateLockdown].After(time.Now()) ||
+		state.Until[ratelimiter.StateSlowMode].IsZero() || state.SlowMode != time.Minute {
+		t.Fatalf("the chat should be locked down with a raised slow mode: %+v", state)
+	}
+
+	if !l.LiftLockdown(-100) || l.IsLockedDown(-100) {
+		t.Fatal("the lockdown should be lifted")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if state = l.ChatState(-100); len(state.Until) != 0 || state.SlowMode != 0 {
+		t.Errorf("the states of the chat should be reversed: %+v", state)
+	}
+
+	if d := l.CheckMessage(msg(2)); !d.IsAllowed() {
+		t.Errorf("the chat should not be slowed down anymore: %+v", d)
+	}
+
+	if l.EndChatState(-100, ratelimiter.StateRaid) {
+		t.Error("the chat should not be raided")
+	}
+}
+
+func TestRiskScoring(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 4,
+		Risk: &ratelimiter.RiskConfig{
+			NewAccount: 1,
+			NoUsername: 1,
+			Premium:    -1,
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(user *gotgbot.User) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: user,
+		}
+	}
+
+	risky := &gotgbot.User{Id: ratelimiter.DefaultRiskNewAccountID + 1}
+	if score := l.GetRiskScore(risky); score != 2 {
+		t.Fatalf("the score of the risky user should be 2, got %d", score)
+	}
+
+	if d := l.CheckMessage(msg(risky)); !d.IsAllowed() || d.Count != 3 {
+		t.Fatalf("the message of the risky user should cost 3: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(risky)); d.IsAllowed() || !d.NewlyLimited {
+		t.Fatalf("the risky user should be limited: %+v", d)
+	}
+
+	premium := &gotgbot.User{Id: 1, IsPremium: true}
+	if d := l.CheckMessage(msg(premium)); !d.IsAllowed() || d.Count != 1 {
+		t.Fatalf("the cost should not drop below 1: %+v", d)
+	}
+
+	l.SetRisk(&ratelimiter.RiskConfig{NoUsername: 2, LowerThreshold: true})
+	user := &gotgbot.User{Id: 2}
+	for i := 0; i < 2; i++ {
+		if d := l.CheckMessage(msg(user)); !d.IsAllowed() {
+			t.Fatalf("message %d should be allowed: %+v", i, d)
+		}
+	}
+
+	if d := l.CheckMessage(msg(user)); d.IsAllowed() {
+		t.Fatalf("the lowered threshold should limit the user: %+v", d)
+	}
+
+	l.SetRisk(nil)
+	if l.GetRisk() != nil || l.GetRiskScore(risky) != 0 {
+		t.Error("the heuristics should be disabled")
+	}
+}
+
+func TestGlobalProfile(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		MessageCount:  5,
+		GlobalProfile: &ratelimiter.LimitProfile{Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 3},
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(chatID, userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: chatID, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	for chatID := int64(-1); chatID >= -3; chatID-- {
+		if d := l.CheckMessage(msg(chatID, 1)); !d.IsAllowed() {
+			t.Fatalf("the message in chat %d should be allowed: %+v", chatID, d)
+		}
+	}
+
+	if d := l.CheckMessage(msg(-4, 1)); d.IsAllowed() || !l.IsGloballyLimited(1) {
+		t.Fatalf("the user should be limited across the chats: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(-5, 2)); !d.IsAllowed() || l.IsGloballyLimited(2) {
+		t.Fatalf("the other users should not be limited: %+v", d)
+	}
+
+	l.SetGlobalProfile(nil)
+	if l.GetGlobalProfile() != nil || l.IsGloballyLimited(1) {
+		t.Error("the global tracking should be disabled")
+	}
+}
+
+func TestEscalationPolicy(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        time.Second,
+		PunishmentTime: time.Second,
+	})
+	l.SetEscalationPolicy(&ratelimiter.LinearEscalation{
+		Base: time.Minute,
+		Step: time.Minute,
+		Max:  3 * time.Minute,
+	}, 0)
+	l.Start()
+	defer l.Stop()
+
+	msg := &gotgbot.Message{
+		Text: "hello",
+		Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From: &gotgbot.User{Id: 1},
+	}
+
+	for _, expected := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		l.CheckMessage(msg)
+		if d := l.CheckMessage(msg); !d.NewlyLimited {
+			t.Fatalf("the user should be limited: %+v", d)
+		}
+
+		snapshot := l.GetStatusSnapshot(1)
+		if snapshot == nil || snapshot.LimitedUntil == nil {
+			t.Fatal("the user should have a punishment")
+		}
+
+		// the punishment starts after the timeout.
+		remaining := time.Until(*snapshot.LimitedUntil) - time.Second
+		if remaining < expected-time.Second || remaining > expected {
+			t.Errorf("the punishment should be %v, got %v", expected, remaining)
+		}
+
+		if d := l.CheckMessage(msg); d.IsAllowed() {
+			t.Errorf("the escalated punishment should be kept: %+v", d)
+		}
+
+		l.Unlimit(1)
+	}
+
+	if violations := l.GetViolations(1); len(violations) != 4 || violations[0].ChatID != -100 {
+		t.Errorf("unexpected violations: %+v", violations)
+	}
+
+	l.ClearViolations(1)
+	if len(l.GetViolations(1)) != 0 {
+		t.Error("the violations should be cleared")
+	}
+
+	exp := &ratelimiter.ExponentialEscalation{
+		Base:    time.Minute,
+		Actions: []ratelimiter.Action{&ratelimiter.WarnAction{}, &ratelimiter.MuteAction{}},
+	}
+	e := exp.Escalate(make([]ratelimiter.Incident, 3))
+	if e.Duration != 4*time.Minute {
+		t.Errorf("the third violation should be punished for 4 minutes: %v", e.Duration)
+	}
+
+	if _, ok := e.Action.(*ratelimiter.MuteAction); !ok {
+		t.Errorf("the last action should be taken: %T", e.Action)
+	}
+}
+
+func TestUpdateExceptions(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		ConsiderInline: true,
+		MessageCount:   5,
+		UpdateProfiles: map[ratelimiter.UpdateType]*ratelimiter.LimitProfile{
+			ratelimiter.UpdateInline:   {Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 5},
+			ratelimiter.UpdateReaction: {Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 5},
+		},
+	})
+	l.AddCallbackException(func(cq *gotgbot.CallbackQuery) bool {
+		return strings.HasPrefix(cq.Data, "menu:")
+	})
+	l.AddInlineException(func(iq *gotgbot.InlineQuery) bool {
+		return iq.Query == ""
+	})
+	l.AddReactionException(func(mr *gotgbot.MessageReactionUpdated) bool {
+		return mr.Chat.Id == -200
+	})
+	l.Start()
+	defer l.Stop()
+
+	updates := map[string]*gotgbot.Update{
+		"callback": {CallbackQuery: &gotgbot.CallbackQuery{Id: "1", From: gotgbot.User{Id: 1}, Data: "menu:main"}},
+		"inline":   {InlineQuery: &gotgbot.InlineQuery{Id: "1", From: gotgbot.User{Id: 1}}},
+		"reaction": {MessageReaction: &gotgbot.MessageReactionUpdated{
+			Chat: gotgbot.Chat{Id: -200, Type: "supergroup"},
+			User: &gotgbot.User{Id: 1},
+		}},
+	}
+
+	for name, u := range updates {
+		if d := l.Check(ext.NewContext(u, nil)); d.Result != core.ResultExempt {
+			t.Errorf("the %s update should be exempted by its filter: %+v", name, d)
+		}
+	}
+
+	press := &gotgbot.Update{CallbackQuery: &gotgbot.CallbackQuery{Id: "2", From: gotgbot.User{Id: 1}, Data: "buy"}}
+	if d := l.Check(ext.NewContext(press, nil)); d.Result == core.ResultExempt {
+		t.Errorf("the other callback queries should be checked: %+v", d)
+	}
+
+	l.ClearAllExceptions()
+	if len(l.GetCallbackExceptions()) != 0 || len(l.GetInlineExceptions()) != 0 ||
+		len(l.GetReactionExceptions()) != 0 {
+		t.Error("all of the exception filters should be cleared")
+	}
+
+	if d := l.Check(ext.NewContext(updates["callback"], nil)); d.Result == core.ResultExempt {
+		t.Errorf("the cleared filters should not be applied: %+v", d)
+	}
+}
+
+func TestUpdateConditions(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		ConsiderInline: true,
+		MessageCount:   5,
+		UpdateProfiles: map[ratelimiter.UpdateType]*ratelimiter.LimitProfile{
+			ratelimiter.UpdateInline: {Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 5},
+		},
+	})
+	l.AddCallbackCondition(func(cq *gotgbot.CallbackQuery) bool {
+		return strings.HasPrefix(cq.Data, "vote:")
+	})
+	l.AddInlineCondition(func(iq *gotgbot.InlineQuery) bool {
+		return iq.Query != ""
+	})
+	l.AddContextCondition(func(ctx *ext.Context) bool {
+		return ctx.EffectiveUser == nil || ctx.EffectiveUser.Id != 2
+	})
+	l.Start()
+	defer l.Stop()
+
+	check := func(u *gotgbot.Update) core.Result {
+		return l.Check(ext.NewContext(u, nil)).Result
+	}
+
+	if r := check(&gotgbot.Update{CallbackQuery: &gotgbot.CallbackQuery{Id: "1", From: gotgbot.User{Id: 1}, Data: "page:2"}}); r != core.ResultExempt {
+		t.Errorf("the callback queries which don't meet the condition should not be checked: %v", r)
+	}
+
+	if r := check(&gotgbot.Update{CallbackQuery: &gotgbot.CallbackQuery{Id: "2", From: gotgbot.User{Id: 1}, Data: "vote:1"}}); r == core.ResultExempt {
+		t.Errorf("the callback queries which meet the condition should be checked: %v", r)
+	}
+
+	if r := check(&gotgbot.Update{InlineQuery: &gotgbot.InlineQuery{Id: "1", From: gotgbot.User{Id: 1}}}); r != core.ResultExempt {
+		t.Errorf("the empty inline queries should not be checked: %v", r)
+	}
+
+	msg := &gotgbot.Message{Text: "hello", Chat: gotgbot.Chat{Id: -100, Type: "supergroup"}, From: &gotgbot.User{Id: 2}}
+	if d := l.CheckMessage(msg); d.Result != core.ResultExempt {
+		t.Errorf("the updates which don't meet the context condition should not be checked: %+v", d)
+	}
+
+	l.ClearAllConditions()
+	if d := l.CheckMessage(msg); d.Result == core.ResultExempt {
+		t.Errorf("the cleared conditions should not be applied: %+v", d)
+	}
+}
+
+func TestTopicExceptions(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:     true,
+		ConsiderUser:   true,
+		ConsiderInline: true,
+		MessageCount:   1,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.AddTopicException(-100, 5)
+	msg := func(threadID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text:            "hello",
+			Chat:            gotgbot.Chat{Id: -100, Type: "supergroup", IsForum: true},
+			From:            &gotgbot.User{Id: 1},
+			IsTopicMessage:  true,
+			MessageThreadId: threadID,
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if d := l.CheckMessage(msg(5)); d.Result != core.ResultExempt {
+			t.Fatalf("the messages of the exempted topic should not be checked: %+v", d)
+		}
+	}
+
+	press := &gotgbot.Update{CallbackQuery: &gotgbot.CallbackQuery{
+		Id:      "1",
+		From:    gotgbot.User{Id: 1},
+		Message: *msg(5),
+		Data:    "page:2",
+	}}
+	if d := l.Check(ext.NewContext(press, nil)); d.Result != core.ResultExempt {
+		t.Errorf("the buttons of the exempted topic should not be checked: %+v", d)
+	}
+
+	l.CheckMessage(msg(6))
+	if d := l.CheckMessage(msg(6)); d.IsAllowed() {
+		t.Fatalf("the other topics should be checked: %+v", d)
+	}
+
+	c := l.Config()
+	if len(c.TopicExceptions) != 1 || c.TopicExceptions[0].ThreadID != 5 {
+		t.Errorf("the topic exceptions should be exported: %+v", c.TopicExceptions)
+	}
+
+	l.RemoveTopicException(-100, 5)
+	if l.IsTopicException(-100, 5) || len(l.ListTopicExceptions()) != 0 {
+		t.Error("the topic exception should be removed")
+	}
+}
+
+func TestCheckStats(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 2,
+	})
+	l.Start()
+	defer l.Stop()
+
+	if latency, _ := l.CheckStats(); latency.Samples != 0 {
+		t.Fatalf("no checks should have been recorded: %+v", latency)
+	}
+
+	msg := &gotgbot.Message{
+		Text: "hello",
+		Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From: &gotgbot.User{Id: 1},
+	}
+	for i := 0; i < 4; i++ {
+		l.CheckMessage(msg)
+	}
+
+	// an update which is not checked by the limiter at all.
+	l.Check(ext.NewContext(&gotgbot.Update{}, nil))
+
+	latency, decisions := l.CheckStats()
+	if latency.Samples != 5 || latency.P50 > latency.P99 || latency.P99 > latency.Max {
+		t.Errorf("unexpected latency percentiles: %+v", latency)
+	}
+
+	if decisions.Allowed != 2 || decisions.Limited != 2 || decisions.Exempt != 1 ||
+		decisions.ExemptPercent != 20 {
+		t.Errorf("unexpected decision distribution: %+v", decisions)
+	}
+
+	if m := l.Metrics(); m.Latency.Samples != 5 || m.Decisions.Limited != 2 {
+		t.Errorf("the metrics should contain the check statistics: %+v", m)
+	}
+}
+
+func TestRateInfo(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:     true,
+		ConsiderUser:   true,
+		MessageCount:   3,
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+	})
+	l.Start()
+	defer l.Stop()
+
+	if info := l.Info(1); info.Limit != 3 || info.Remaining != 3 || !info.ResetAt.IsZero() {
+		t.Fatalf("an unknown user should have the full quota: %+v", info)
+	}
+
+	msg := &gotgbot.Message{
+		Text: "hello",
+		Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From: &gotgbot.User{Id: 1},
+	}
+	l.CheckMessage(msg)
+	l.CheckMessage(msg)
+
+	info := l.Info(1)
+	if info.Remaining != 1 || info.RetryAfter != 0 || time.Until(info.ResetAt) > time.Minute {
+		t.Errorf("unexpected quota information: %+v", info)
+	}
+
+	l.CheckMessage(msg)
+	l.CheckMessage(msg)
+
+	info = l.Info(1)
+	if info.Remaining != 0 || info.RetryAfter <= time.Minute || info.RetryAfter > 2*time.Minute {
+		t.Errorf("the limited user shouldn't have any quota: %+v", info)
+	}
+
+	headers := info.Headers()
+	if headers["X-RateLimit-Limit"] != "3" || headers["X-RateLimit-Remaining"] != "0" ||
+		headers["Retry-After"] != "120" || headers["X-RateLimit-Reset"] == "" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+}
+
+func TestTierProfileSweep(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:    true,
+		Timeout:         100 * time.Millisecond,
+		PunishmentTime:  time.Minute,
+		MessageCount:    5,
+		CheckerInterval: time.Second,
+		TierProfiles: map[ratelimiter.Tier]*ratelimiter.LimitProfile{
+			ratelimiter.TierVIP: {
+				Timeout:        time.Minute,
+				PunishmentTime: time.Minute,
+				MessageCount:   2,
+			},
+		},
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	l.SetTier(1, ratelimiter.TierVIP)
+	msg := &gotgbot.Message{
+		Text: "hello",
+		Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From: &gotgbot.User{Id: 1},
+	}
+
+	for i := 0; i < 2; i++ {
+		if d := l.CheckMessage(msg); !d.IsAllowed() || d.Count != i+1 {
+			t.Fatalf("message %d should be allowed: %+v", i, d)
+		}
+
+		// a sweep runs after the default timeout, but the window of the
+		// tier profile hasn't ended yet.
+		time.Sleep(1200 * time.Millisecond)
+	}
+
+	if d := l.CheckMessage(msg); d.IsAllowed() || !d.NewlyLimited {
+		t.Errorf("the user should be limited by the window of its tier: %+v", d)
+	}
+}
+
+func TestProbation(t *testing.T) {
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser:      true,
+		Timeout:           100 * time.Millisecond,
+		PunishmentTime:    time.Minute,
+		MessageCount:      5,
+		CheckerInterval:   time.Second,
+		ProbationProfile:  &ratelimiter.LimitProfile{Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 2},
+		ProbationDuration: time.Hour,
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	msg := func(userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "hello",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	join := msg(3)
+	join.Text = ""
+	join.NewChatMembers = []gotgbot.User{{Id: 3}}
+	l.CheckMessage(join)
+	if !l.IsOnProbation(-100, 3) || l.IsOnProbation(-100, 2) {
+		t.Fatal("only the newly joined members should be on probation")
+	}
+
+	if l.IsOnProbation(-200, 3) {
+		t.Error("the member should only be on probation in the chat they have joined")
+	}
+
+	l.RemoveProbation(-100, 3)
+	if l.IsOnProbation(-100, 3) {
+		t.Error("the removed member should graduate from probation")
+	}
+
+	l.AddProbation(-200, 1)
+	if d := l.CheckMessage(msg(1)); d.MaxCount != 5 {
+		t.Fatalf("the probation of the other chats should not be applied: %+v", d)
+	}
+	l.Unlimit(1)
+
+	l.AddProbation(-100, 1)
+	for i := 0; i < 2; i++ {
+		if d := l.CheckMessage(msg(1)); !d.IsAllowed() || d.MaxCount != 2 {
+			t.Fatalf("message %d should be allowed by the probation profile: %+v", i, d)
+		}
+
+		if d := l.CheckMessage(msg(2)); !d.IsAllowed() || d.MaxCount != 5 {
+			t.Fatalf("message %d of the old member should use the default profile: %+v", i, d)
+		}
+
+		if i == 0 {
+			// the window of the probation profile outlives the sweeps.
+			time.Sleep(1200 * time.Millisecond)
+		}
+	}
+
+	if d := l.CheckMessage(msg(1)); d.IsAllowed() || !d.NewlyLimited {
+		t.Errorf("the member on probation should be limited: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(2)); !d.IsAllowed() {
+		t.Errorf("the old member should not be limited: %+v", d)
+	}
+}
@@ -0,0 +1,333 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+const testYamlConfig = `
+timeout: 6s
+message_count: 10
+is_strict: true
+limit_channel_senders: true
+exception_ids: [1, 2]
+chats:
+  - chat_id: -100
+    disabled: true
+  - chat_id: -200
+    punishment_time: 2m
+`
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(testYamlConfig), 0o644); err != nil {
+		t.Fatalf("failed to write the config file: %v", err)
+	}
+
+	c, err := ratelimiter.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load the config file: %v", err)
+	}
+
+	if time.Duration(c.Timeout) != 6*time.Second || c.MessageCount != 10 || !c.IsStrict ||
+		!c.LimitChannelSenders {
+		t.Errorf("unexpected config values: %+v", c)
+	}
+
+	if time.Duration(c.PunishmentTime) != ratelimiter.DefaultPunishmentTime {
+		t.Errorf("missing fields should have the default values, got: %v", c.PunishmentTime)
+	}
+
+	if len(c.Chats) != 2 || time.Duration(c.Chats[1].PunishmentTime) != 2*time.Minute {
+		t.Errorf("unexpected chat overrides: %+v", c.Chats)
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 7,
+		MaxTimeout:   time.Hour,
+		CountedTypes: ratelimiter.TypeText | ratelimiter.TypePhoto,
+		Propagation:  ratelimiter.PropagationAnnotate,
+		StopPolicy:   ratelimiter.StopRetain,
+		TierProfiles: map[ratelimiter.Tier]*ratelimiter.LimitProfile{
+			ratelimiter.TierVIP: {
+				Timeout:        time.Minute,
+				PunishmentTime: time.Minute,
+				MessageCount:   30,
+			},
+		},
+	})
+	l.AddExceptionID(1)
+
+	data, err := json.Marshal(l.Config())
+	if err != nil {
+		t.Fatalf("failed to marshal the config: %v", err)
+	}
+
+	var c ratelimiter.Config
+	if err = json.Unmarshal(data, &c); err != nil {
+		t.Fatalf("failed to unmarshal the config: %v", err)
+	}
+
+	other := ratelimiter.NewLimiter(nil, nil)
+	if err = other.ApplyConfig(c); err != nil {
+		t.Fatalf("failed to apply the config: %v", err)
+	}
+
+	if !reflect.DeepEqual(l.Config(), other.Config()) {
+		t.Errorf("the configs should be equal:\n%+v\n%+v", l.Config(), other.Config())
+	}
+
+	c.MessageCount = 0
+	if err = other.ApplyConfig(c); err == nil || other.Config().MessageCount != 7 {
+		t.Error("invalid configs should not be applied")
+	}
+}
+
+func TestCheckerInterval(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		MaxTimeout: time.Hour,
+	})
+	if l.GetCheckerInterval() != time.Hour {
+		t.Errorf("the max cache duration should be used by default: %v", l.GetCheckerInterval())
+	}
+
+	l.SetCheckerInterval(time.Minute)
+	if l.GetCheckerInterval() != time.Minute {
+		t.Errorf("unexpected checker interval: %v", l.GetCheckerInterval())
+	}
+
+	c := l.Config()
+	if time.Duration(c.CheckerInterval) != time.Minute || time.Duration(c.MaxTimeout) != time.Hour {
+		t.Errorf("the checker interval should be independent: %+v", c)
+	}
+
+	l.SetCheckerInterval(time.Millisecond)
+	if err := l.Validate(); err == nil {
+		t.Error("checker intervals below a second should be invalid")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("RATELIMITER_TIMEOUT", "4s")
+	t.Setenv("RATELIMITER_PUNISHMENT_TIME", "2m30s")
+	t.Setenv("RATELIMITER_MESSAGE_COUNT", "12")
+	t.Setenv("RATELIMITER_IS_STRICT", "true")
+	t.Setenv("RATELIMITER_CONSIDER_USER", "false")
+	t.Setenv("RATELIMITER_EXCEPTION_IDS", "1, 2,-1003")
+	t.Setenv("RATELIMITER_COUNTED_TYPES", "text,photo")
+	t.Setenv("RATELIMITER_REPLY_TEXT", "slow down")
+
+	c, err := ratelimiter.ConfigFromEnv("ratelimiter")
+	if err != nil {
+		t.Fatalf("failed to load the config from the environment: %v", err)
+	}
+
+	if time.Duration(c.Timeout) != 4*time.Second || time.Duration(c.PunishmentTime) != 150*time.Second ||
+		c.MessageCount != 12 || !c.IsStrict || c.ConsiderUser {
+		t.Errorf("unexpected config values: %+v", c)
+	}
+
+	if !reflect.DeepEqual(c.ExceptionIDs, []int64{1, 2, -1003}) ||
+		!reflect.DeepEqual(c.CountedTypes, []string{"text", "photo"}) {
+		t.Errorf("unexpected lists: %v, %v", c.ExceptionIDs, c.CountedTypes)
+	}
+
+	if c.Reply == nil || c.Reply.Text != "slow down" {
+		t.Errorf("unexpected reply config: %+v", c.Reply)
+	}
+
+	if c.ProbationProfile != nil {
+		t.Error("the nested configs without any variable should be nil")
+	}
+
+	if time.Duration(c.MaxTimeout) != ratelimiter.DefaultConfig.MaxTimeout {
+		t.Errorf("missing fields should have the default values, got: %v", c.MaxTimeout)
+	}
+
+	if err = c.Validate(); err != nil {
+		t.Errorf("the config should be valid: %v", err)
+	}
+
+	t.Setenv("RATELIMITER_MESSAGE_COUNT", "many")
+	if _, err = ratelimiter.ConfigFromEnv("RATELIMITER_"); !errors.Is(err, ratelimiter.ErrInvalidEnv) {
+		t.Errorf("invalid variables should return ErrInvalidEnv, got: %v", err)
+	}
+}
+
+func TestApplyConfigWhileRunning(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-client.requests:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 3,
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	strict := l.Config()
+	strict.OptIn = true
+	strict.IsStrict = true
+	strict.ServicePolicy = ratelimiter.ServiceIgnore
+	strict.Propagation = ratelimiter.PropagationAnnotate
+	strict.ExemptAutoForwards = true
+	strict.MessageCount = 1
+	strict.Enrolled = []int64{-100}
+	relaxed := l.Config()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				msg := &gotgbot.Message{
+					MessageId: int64(i),
+					Text:      "hello",
+					Chat:      gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From:      &gotgbot.User{Id: int64(i%7 + 1)},
+				}
+				if i%3 == 0 {
+					msg.Text = ""
+					msg.NewChatMembers = []gotgbot.User{{Id: msg.From.Id}}
+				}
+
+				_ = d.ProcessUpdate(bot, &gotgbot.Update{Message: msg}, nil)
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			c := relaxed
+			if i%2 == 0 {
+				c = strict
+			}
+
+			if err := l.ApplyConfig(c); err != nil {
+				t.Errorf("failed to apply the config: %v", err)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if !reflect.DeepEqual(l.Config(), relaxed) {
+		t.Errorf("the last applied config should be kept:\n%+v\n%+v", l.Config(), relaxed)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   ratelimiter.LimiterConfig
+		setup    func(l *ratelimiter.Limiter)
+		expected error
+	}{
+		{
+			name:     "zero message count",
+			setup:    func(l *ratelimiter.Limiter) { l.SetMaxMessageCount(0) },
+			expected: ratelimiter.ErrInvalidMessageCount,
+		},
+		{
+			name:     "negative timeout",
+			setup:    func(l *ratelimiter.Limiter) { l.SetFloodWaitTime(-time.Second) },
+			expected: ratelimiter.ErrInvalidTimeout,
+		},
+		{
+			name:     "short strict punishment",
+			config:   ratelimiter.LimiterConfig{IsStrict: true, Timeout: time.Minute, PunishmentTime: time.Second},
+			expected: ratelimiter.ErrInvalidPunishment,
+		},
+		{
+			name:     "short max timeout",
+			config:   ratelimiter.LimiterConfig{MaxTimeout: 500 * time.Millisecond},
+			expected: ratelimiter.ErrInvalidMaxTimeout,
+		},
+		{
+			name:     "short checker interval",
+			config:   ratelimiter.LimiterConfig{CheckerInterval: 10 * time.Millisecond},
+			expected: ratelimiter.ErrInvalidInterval,
+		},
+		{
+			name:     "negative throttle",
+			setup:    func(l *ratelimiter.Limiter) { l.SetThrottle(-time.Second) },
+			expected: ratelimiter.ErrInvalidThrottle,
+		},
+		{
+			name:     "warn threshold above one",
+			setup:    func(l *ratelimiter.Limiter) { l.SetWarnThreshold(1.5) },
+			expected: ratelimiter.ErrInvalidWarning,
+		},
+		{
+			name:   "short punishment without strict mode",
+			config: ratelimiter.LimiterConfig{Timeout: time.Minute, PunishmentTime: time.Second},
+		},
+	}
+
+	for _, c := range cases {
+		config := c.config
+		config.Standalone = true
+		l := ratelimiter.NewLimiter(nil, &config)
+		if c.setup != nil {
+			c.setup(l)
+		}
+
+		before := l.Config()
+		err := l.Validate()
+		if c.expected == nil {
+			if err != nil {
+				t.Errorf("%s: the config should be valid: %v", c.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%s: expected %v, got: %v", c.name, c.expected, err)
+		}
+
+		if err = l.Start(); !errors.Is(err, c.expected) || l.IsEnabled() {
+			l.Stop()
+			t.Errorf("%s: the limiter should not be started: %v", c.name, err)
+		}
+
+		// the invalid values are reported, not fixed silently.
+		if after := l.Config(); !reflect.DeepEqual(before, after) {
+			t.Errorf("%s: the config should not be changed: %+v", c.name, after)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+)
+
+func TestCoreAllow(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   3,
+	})
+
+	for i := 0; i < 3; i++ {
+		if d := l.Allow(1, 1); !d.IsAllowed() {
+			t.Fatalf("request %d should be allowed: %+v", i, d)
+		}
+	}
+
+	d := l.Allow(1, 1)
+	if d.IsAllowed() || !d.NewlyLimited {
+		t.Fatalf("the key should be newly limited: %+v", d)
+	}
+
+	if d = l.Allow(1, 1); d.Result != core.ResultLimited || d.NewlyLimited {
+		t.Errorf("the key should remain limited: %+v", d)
+	}
+
+	if d = l.Allow(2, 1); !d.IsAllowed() {
+		t.Errorf("other keys should not be affected: %+v", d)
+	}
+
+	if !l.Unlimit(1) {
+		t.Error("Unlimit should report the key was limited")
+	}
+
+	if d = l.Allow(1, 1); !d.IsAllowed() {
+		t.Errorf("the key should be allowed after unlimit: %+v", d)
+	}
+}
+
+func TestCoreThrottle(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   1,
+		Throttle:       50 * time.Millisecond,
+	})
+
+	if d := l.Allow(1, 1); !d.IsAllowed() {
+		t.Fatalf("the first request should be allowed: %+v", d)
+	}
+
+	for i := 0; i < 5; i++ {
+		if d := l.Allow(1, 1); d.Result != core.ResultDropped || d.NewlyLimited {
+			t.Fatalf("request %d should be dropped without punishment: %+v", i, d)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if d := l.Allow(1, 1); !d.IsAllowed() {
+		t.Errorf("the key should be allowed after the throttle interval: %+v", d)
+	}
+
+	if d := l.AllowRequest(1, &core.Request{Cost: 1, Exempt: true}); !d.IsAllowed() {
+		t.Errorf("the exempt requests shouldn't be throttled: %+v", d)
+	}
+
+	// the forced limits are applied to the new keys too.
+	if d := l.AllowRequest(2, &core.Request{Cost: 1, Limit: true}); d.Result != core.ResultLimited || !d.NewlyLimited {
+		t.Errorf("the new key should be limited: %+v", d)
+	}
+}
+
+func TestCoreFirstRequest(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   3,
+	})
+
+	// a single request costing more than the whole quota is limited, even
+	// if it's the first request of the key.
+	if d := l.Allow(1, 4); d.IsAllowed() || !d.NewlyLimited || d.Count != 4 {
+		t.Errorf("the first request should be limited: %+v", d)
+	}
+
+	if d := l.Allow(2, 3); !d.IsAllowed() || d.Count != 3 {
+		t.Errorf("the first request within the quota should be allowed: %+v", d)
+	}
+}
+
+func TestCoreMaxPunishment(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   1,
+	})
+
+	r := &core.Request{
+		Cost:          1,
+		Strict:        true,
+		MaxPunishment: 50 * time.Millisecond,
+	}
+
+	l.AllowRequest(1, r)
+	if d := l.AllowRequest(1, r); !d.NewlyLimited {
+		t.Fatalf("the key should be limited: %+v", d)
+	}
+
+	if d := l.AllowRequest(1, r); d.IsAllowed() || !d.Capped {
+		t.Fatalf("the punishment of the key should be capped: %+v", d)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if d := l.AllowRequest(1, r); !d.Released || !d.Capped {
+		t.Errorf("the key should be released by the cap: %+v", d)
+	}
+}
+
+func TestCorePartialReset(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        50 * time.Millisecond,
+		PunishmentTime: time.Minute,
+		MessageCount:   1,
+	})
+
+	r := &core.Request{Cost: 1, Strict: true, PartialReset: true}
+	l.AllowRequest(1, r)
+	l.AllowRequest(1, r)
+
+	until := *l.GetSnapshot(1).LimitedUntil
+
+	// only the flood wait time starts over in the strict mode, and it's
+	// already included in the remaining punishment.
+	time.Sleep(10 * time.Millisecond)
+	if d := l.AllowRequest(1, r); d.IsAllowed() {
+		t.Fatalf("the key should still be limited: %+v", d)
+	}
+
+	snapshot := l.GetSnapshot(1)
+	if snapshot.LimitedUntil == nil || !snapshot.LimitedUntil.Equal(until) {
+		t.Errorf("the punishment shouldn't be extended by the partial reset: %+v", snapshot)
+	}
+}
+
+func TestCoreCustomIgnore(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   10,
+	})
+
+	l.AddCustomIgnore(1, 0, false)
+	if d := l.Allow(1, 1); d.Result != core.ResultIgnored {
+		t.Errorf("the key should be ignored: %+v", d)
+	}
+
+	if d := l.AllowRequest(1, &core.Request{Cost: 1, Exempt: true}); d.Result != core.ResultExempt {
+		t.Errorf("exempt requests should pass the custom ignore: %+v", d)
+	}
+
+	if l.Sweep() != 0 {
+		t.Error("custom ignored keys should not be swept")
+	}
+
+	l.RemoveCustomIgnore(1)
+	if d := l.Allow(1, 1); !d.IsAllowed() {
+		t.Errorf("the key should be allowed after removing the ignore: %+v", d)
+	}
+}
+
+func TestCoreSweepEntries(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        10 * time.Millisecond,
+		PunishmentTime: 10 * time.Millisecond,
+		MessageCount:   1,
+	})
+
+	l.Allow(1, 1)
+	if d := l.Allow(1, 1); !d.NewlyLimited {
+		t.Fatalf("the key should be limited: %+v", d)
+	}
+
+	l.Allow(2, 1)
+	time.Sleep(30 * time.Millisecond)
+
+	result := l.SweepEntries()
+	if len(result.Released) != 1 || result.Released[0] != 1 {
+		t.Errorf("the punishment of the key should be released: %v", result.Released)
+	}
+
+	status, ok := result.Evicted[1]
+	if len(result.Evicted) != 2 || !ok || status.IsLimited() {
+		t.Errorf("both of the keys should be evicted: %v", result.Evicted)
+	}
+
+	if l.Len() != 0 {
+		t.Errorf("the limiter should be empty, got %d", l.Len())
+	}
+}
+
+func TestCoreCustomIgnoreExpiry(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   10,
+	})
+
+	l.AddCustomIgnores([]int64{1, 2}, 10*time.Millisecond, false)
+	if list := l.ListCustomIgnores(); len(list) != 2 || list[0].Key != 1 {
+		t.Fatalf("unexpected custom ignores: %+v", list)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	result := l.SweepEntries()
+	if len(result.ExpiredIgnores) != 2 || len(l.ListCustomIgnores()) != 0 {
+		t.Errorf("the custom ignores should be expired: %+v", result.ExpiredIgnores)
+	}
+}
+
+func TestCoreSnapshot(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   1,
+	})
+
+	if l.GetSnapshot(1) != nil {
+		t.Error("the untracked keys should not have any snapshot")
+	}
+
+	l.Allow(1, 1)
+	l.Allow(1, 1)
+	l.AddCustomIgnore(1, time.Hour, false)
+
+	s := l.GetSnapshot(1)
+	if s == nil || !s.Limited || s.Count != 2 || s.LimitedUntil == nil ||
+		s.LimitedUntil.Sub(s.LastSeen) != 2*time.Minute || s.CustomIgnore == nil {
+		t.Fatalf("unexpected snapshot: %+v", s)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("failed to marshal the snapshot: %v", err)
+	}
+
+	var decoded core.StatusSnapshot
+	if err = json.Unmarshal(data, &decoded); err != nil || decoded.Key != 1 ||
+		decoded.CustomIgnore.Duration != time.Hour {
+		t.Errorf("the snapshot should survive a round trip: %s", data)
+	}
+}
+
+func TestCoreMaxEntries(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   3,
+	})
+
+	l.Allow(1, 1)
+	l.Allow(2, 1)
+	l.Limit(3)
+	l.SetMaxEntries(3)
+
+	// key 1 becomes the most recently used one.
+	l.Allow(1, 1)
+	l.Allow(4, 1)
+	if l.Len() != 3 || l.GetStatus(2) != nil {
+		t.Fatalf("the least recently used key should be evicted, len: %d", l.Len())
+	}
+
+	l.Allow(5, 1)
+	if l.GetStatus(3) == nil || !l.GetStatus(3).IsLimited() {
+		t.Fatal("the limited keys should never be evicted")
+	}
+
+	if l.GetStatus(1) != nil || l.Evictions() != 2 {
+		t.Fatalf("the next least recently used key should be evicted: %d", l.Evictions())
+	}
+
+	l.SetMaxEntries(0)
+	for key := int64(10); key < 20; key++ {
+		l.Allow(key, 1)
+	}
+
+	if l.Len() != 13 || l.GetMaxEntries() != 0 {
+		t.Errorf("the keys should not be capped anymore, len: %d", l.Len())
+	}
+}
+
+func TestCoreConcurrentKeys(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   100,
+	})
+
+	var wg sync.WaitGroup
+	for worker := int64(0); worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int64) {
+			defer wg.Done()
+			for key := worker * 1000; key < worker*1000+500; key++ {
+				l.Allow(key, 1)
+				l.Allow(key, 1)
+			}
+
+			l.Sweep()
+		}(worker)
+	}
+	wg.Wait()
+
+	if l.Len() != 8*500 {
+		t.Fatalf("all of the keys should be tracked, got %d", l.Len())
+	}
+
+	for worker := int64(0); worker < 8; worker++ {
+		if s := l.GetStatus(worker * 1000); s == nil || s.GetCount() != 2 {
+			t.Errorf("the requests of key %d should be counted: %+v", worker*1000, s)
+		}
+	}
+
+	l.Clear()
+	if l.Len() != 0 || len(l.ListLimited()) != 0 {
+		t.Error("the limiter should be cleared")
+	}
+}
+
+func TestCoreUpdateProfile(t *testing.T) {
+	l := core.NewLimiter(core.Profile{
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		MessageCount:   3,
+	})
+
+	l.Allow(1, 1)
+	l.Allow(1, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			l.UpdateProfile(func(p *core.Profile) {
+				p.MessageCount = 2
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			l.UpdateProfile(func(p *core.Profile) {
+				p.Throttle = time.Second
+			})
+		}()
+	}
+	wg.Wait()
+
+	p := l.GetProfile()
+	if p.MessageCount != 2 || p.Throttle != time.Second || p.Timeout != time.Minute {
+		t.Fatalf("the concurrent updates should not be lost: %+v", p)
+	}
+
+	l.UpdateProfile(func(p *core.Profile) {
+		p.Throttle = 0
+	})
+
+	// the counter of the current window is kept, so the key is limited
+	// by its next request with the lowered count.
+	if l.Allow(1, 1).IsAllowed() {
+		t.Error("the key should be limited with the new message count")
+	}
+}
@@ -0,0 +1,185 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/outbound"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+)
+
+// countingClient is a bot client which counts the sendMessage and the
+// deleteMessage requests.
+type countingClient struct {
+	gotgbot.BaseBotClient
+	sent    atomic.Int32
+	deleted atomic.Int32
+}
+
+func (c *countingClient) RequestWithContext(ctx context.Context, token string, method string,
+	params map[string]string, data map[string]gotgbot.NamedReader, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	switch method {
+	case "sendMessage":
+		c.sent.Add(1)
+		return json.RawMessage(`{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}`), nil
+	case "deleteMessage":
+		c.deleted.Add(1)
+	}
+
+	return json.RawMessage(`true`), nil
+}
+
+func TestOutboundClient(t *testing.T) {
+	inner := new(countingClient)
+	bot := &gotgbot.Bot{BotClient: inner}
+	outbound.Wrap(bot, &outbound.Config{GlobalPerSecond: 100, PrivatePerSecond: 2})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := bot.SendMessage(1, "hello", nil); err != nil {
+			t.Fatalf("failed to send the message: %v", err)
+		}
+	}
+
+	// the third message waits for a token of the private chat.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("the messages should be delayed, took %v", elapsed)
+	}
+
+	// the methods which don't send messages are not limited.
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		_, _ = bot.DeleteMessage(1, 1, nil)
+	}
+
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("the other methods should not be delayed, took %v", elapsed)
+	}
+
+	if inner.sent.Load() != 3 || inner.deleted.Load() != 10 {
+		t.Errorf("all of the requests should be sent: %d, %d", inner.sent.Load(), inner.deleted.Load())
+	}
+}
+
+func TestOutboundWaitCancel(t *testing.T) {
+	c := outbound.NewClient(new(countingClient), &outbound.Config{
+		GlobalPerSecond:  2,
+		PrivatePerSecond: 2,
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := c.Wait(context.Background(), "1"); err != nil {
+			t.Fatalf("the first requests should not wait: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Wait(ctx, "1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	// the canceled requests don't keep their tokens, so the next request
+	// only waits for a single token (half a second), not three of them.
+	for i := 0; i < 2; i++ {
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := c.Wait(ctx, "1")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+		}
+	}
+
+	start := time.Now()
+	if err := c.Wait(context.Background(), "1"); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("the canceled reservations should be refunded, waited %v", elapsed)
+	}
+}
+
+func TestOutboundQueue(t *testing.T) {
+	tooMany := &gotgbot.TelegramError{
+		Code:           429,
+		ResponseParams: &gotgbot.ResponseParameters{RetryAfter: 1},
+	}
+
+	failed := make(chan error, 10)
+	q := outbound.NewQueue(&gotgbot.Bot{}, &outbound.QueueConfig{
+		Size:       1,
+		Workers:    1,
+		MaxRetries: 1,
+		OnError:    func(err error) { failed <- err },
+	})
+
+	if err := q.Enqueue(func(b *gotgbot.Bot) error { return nil }); !errors.Is(err, outbound.ErrQueueStopped) {
+		t.Errorf("expected ErrQueueStopped, got: %v", err)
+	}
+
+	q.Start()
+	defer q.Stop()
+
+	// the job is retried after the retry_after duration of the 429 error.
+	var attempts atomic.Int32
+	done := make(chan time.Time, 1)
+	start := time.Now()
+	_ = q.Enqueue(func(b *gotgbot.Bot) error {
+		if attempts.Add(1) == 1 {
+			return tooMany
+		}
+
+		done <- time.Now()
+		return nil
+	})
+
+	select {
+	case at := <-done:
+		if at.Sub(start) < time.Second {
+			t.Errorf("the job should be retried after retry_after, retried after %v", at.Sub(start))
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the job hasn't been retried")
+	}
+
+	// the other errors are not retried, and are passed to OnError.
+	_ = q.Enqueue(func(b *gotgbot.Bot) error { return errors.New("bad request") })
+	select {
+	case err := <-failed:
+		if _, ok := outbound.RetryAfter(err); ok {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the failed job should be reported")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for q.Depth() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := q.Stats()
+	if stats.Sent != 1 || stats.Retried != 1 || stats.Failed != 1 || stats.Depth != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// stopping the queue doesn't wait for the retry_after duration.
+	_ = q.Enqueue(func(b *gotgbot.Bot) error { return tooMany })
+	time.Sleep(50 * time.Millisecond)
+
+	start = time.Now()
+	q.Stop()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the queue should be stopped without waiting for the retry, took %v", elapsed)
+	}
+}
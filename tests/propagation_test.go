@@ -0,0 +1,1692 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/outbound"
+	"github.com/ALiwoto/ratelimiter/storage"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters/message"
+)
+
+// runPropagation sends 3 messages to a limiter allowing only one message
+// and returns the amount of times the handlers of the same group and
+// the later group have received them, and the amount of limited messages
+// received by the later group.
+func runPropagation(t *testing.T, p ratelimiter.Propagation) (sameGroup, laterGroup int, limited int) {
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:  true,
+		MessageCount:  1,
+		HandlerGroups: []int{0},
+		Propagation:   p,
+	})
+	l.Start()
+	defer l.Stop()
+
+	d.AddHandlerToGroup(handlers.NewMessage(message.All, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		sameGroup++
+		return nil
+	}), 0)
+	d.AddHandlerToGroup(handlers.NewMessage(message.All, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		laterGroup++
+		if ratelimiter.IsLimited(ctx) {
+			limited++
+			if info := ratelimiter.GetLimitInfo(ctx); info == nil || info.Key != 1 {
+				t.Errorf("unexpected limit info: %+v", info)
+			}
+		}
+		return nil
+	}), 1)
+
+	for i := 0; i < 3; i++ {
+		err := d.ProcessUpdate(nil, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	return sameGroup, laterGroup, limited
+}
+
+func TestPropagation(t *testing.T) {
+	if same, later, _ := runPropagation(t, ratelimiter.PropagationEndGroups); same != 1 || later != 1 {
+		t.Errorf("end groups: unexpected calls: %d, %d", same, later)
+	}
+
+	if same, later, _ := runPropagation(t, ratelimiter.PropagationEndGroup); same != 1 || later != 3 {
+		t.Errorf("end group: unexpected calls: %d, %d", same, later)
+	}
+
+	same, later, limited := runPropagation(t, ratelimiter.PropagationAnnotate)
+	if same != 3 || later != 3 || limited != 2 {
+		t.Errorf("annotate: unexpected calls: %d, %d, %d", same, later, limited)
+	}
+}
+
+func TestPaymentQueries(t *testing.T) {
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderPayments: true,
+		HandlerGroups:    []int{0},
+	})
+	l.Start()
+	defer l.Stop()
+
+	var handled int
+	d.AddHandlerToGroup(handlers.NewPreCheckoutQuery(nil, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		handled++
+		return nil
+	}), 1)
+
+	for i := 0; i < ratelimiter.DefaultPaymentCount+2; i++ {
+		err := d.ProcessUpdate(nil, &gotgbot.Update{
+			PreCheckoutQuery: &gotgbot.PreCheckoutQuery{
+				Id:   "query",
+				From: gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	if handled != ratelimiter.DefaultPaymentCount {
+		t.Errorf("only %d payment queries should be handled, got %d",
+			ratelimiter.DefaultPaymentCount, handled)
+	}
+}
+
+func TestScopeByBot(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+		ScopeByBot:   true,
+	})
+	l.Start()
+	defer l.Stop()
+
+	first, second := ext.NewDispatcher(nil), ext.NewDispatcher(nil)
+	l.AttachTo(first)
+	l.AttachTo(second)
+
+	send := func(d *ext.Dispatcher, botID int64) {
+		err := d.ProcessUpdate(&gotgbot.Bot{User: gotgbot.User{Id: botID}}, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	send(first, 10)
+	send(first, 10)
+	send(second, 20)
+
+	if status := l.GetStatus(ratelimiter.BotKey(10, 1)); status == nil || !status.IsLimited() {
+		t.Errorf("the user should be limited for the first bot: %+v", status)
+	}
+
+	if status := l.GetStatus(ratelimiter.BotKey(20, 1)); status == nil || status.IsLimited() {
+		t.Errorf("the user should not be limited for the second bot: %+v", status)
+	}
+}
+
+func TestMentionProfile(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:     true,
+		ConsiderUser:   true,
+		MessageCount:   10,
+		MentionProfile: &ratelimiter.LimitProfile{Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 2},
+	})
+	l.Start()
+	defer l.Stop()
+
+	d := ext.NewDispatcher(nil)
+	l.AttachTo(d)
+
+	bot := &gotgbot.Bot{User: gotgbot.User{Id: 10, Username: "TestBot"}}
+	send := func(userID int64, msg *gotgbot.Message) {
+		msg.Chat = gotgbot.Chat{Id: -100, Type: "supergroup"}
+		msg.From = &gotgbot.User{Id: userID}
+		if err := d.ProcessUpdate(bot, &gotgbot.Update{Message: msg}, nil); err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		send(1, &gotgbot.Message{Text: "hello"})
+	}
+
+	send(1, &gotgbot.Message{
+		Text:     "@testbot help",
+		Entities: []gotgbot.MessageEntity{{Type: "mention", Offset: 0, Length: 8}},
+	})
+	send(1, &gotgbot.Message{
+		Text:           "and again",
+		ReplyToMessage: &gotgbot.Message{From: &bot.User},
+	})
+	if status := l.GetStatus(1); status == nil || status.IsLimited() {
+		t.Fatalf("the user should not be limited by the usual messages: %+v", status)
+	}
+
+	send(1, &gotgbot.Message{
+		Text:     "bot!",
+		Entities: []gotgbot.MessageEntity{{Type: "text_mention", Length: 3, User: &bot.User}},
+	})
+	if status := l.GetStatus(1); status == nil || !status.IsLimited() {
+		t.Errorf("the user should be limited by the mention profile: %+v", status)
+	}
+
+	send(2, &gotgbot.Message{
+		Text:     "@otherbot hi",
+		Entities: []gotgbot.MessageEntity{{Type: "mention", Offset: 0, Length: 9}},
+	})
+	send(2, &gotgbot.Message{Text: "hi", ReplyToMessage: &gotgbot.Message{From: &gotgbot.User{Id: 3}}})
+	send(2, &gotgbot.Message{Text: "hi"})
+	if status := l.GetStatus(2); status == nil || status.IsLimited() {
+		t.Errorf("the messages not mentioning the bot should not count as mentions: %+v", status)
+	}
+
+	if c := l.Config(); c.MentionProfile == nil || c.MentionProfile.MessageCount != 2 {
+		t.Errorf("the mention profile should be exported: %+v", c.MentionProfile)
+	}
+}
+
+func TestFlapping(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		Flapping:     &ratelimiter.FlappingConfig{Changes: 4, IgnoreDuration: time.Hour},
+	})
+	l.Start()
+	defer l.Stop()
+
+	d := ext.NewDispatcher(nil)
+	l.AttachTo(d)
+
+	flapped := make(chan int64, 2)
+	l.AppendFlappingTriggers(func(b *gotgbot.Bot, ctx *ext.Context) error {
+		flapped <- ctx.EffectiveUser.Id
+		return nil
+	})
+
+	member := func(userID int64, joined bool) {
+		user := gotgbot.User{Id: userID}
+		var old, current gotgbot.ChatMember = gotgbot.ChatMemberLeft{User: user}, gotgbot.ChatMemberMember{User: user}
+		if !joined {
+			old, current = current, old
+		}
+
+		err := d.ProcessUpdate(&gotgbot.Bot{}, &gotgbot.Update{
+			ChatMember: &gotgbot.ChatMemberUpdated{
+				Chat:          gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From:          user,
+				OldChatMember: old,
+				NewChatMember: current,
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	member(1, true)
+	member(1, false)
+	member(1, true)
+	member(2, true)
+	member(2, false)
+	if len(l.ListCustomIgnores()) != 0 {
+		t.Fatal("the users should not be flapping yet")
+	}
+
+	member(1, false)
+	ignores := l.ListCustomIgnores()
+	if len(ignores) != 1 || ignores[0].Key != 1 {
+		t.Fatalf("the flapping user should be ignored: %+v", ignores)
+	}
+
+	select {
+	case id := <-flapped:
+		if id != 1 {
+			t.Errorf("the triggers should be run for the flapping user, got %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the flapping triggers haven't been run")
+	}
+
+	if c := l.Config(); c.Flapping == nil || c.Flapping.Changes != 4 ||
+		time.Duration(c.Flapping.Window) != ratelimiter.DefaultFlappingWindow {
+		t.Errorf("the flapping config should be exported: %+v", c.Flapping)
+	}
+}
+
+func TestDelayMode(t *testing.T) {
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        100 * time.Millisecond,
+		PunishmentTime: 50 * time.Millisecond,
+		HandlerGroups:  []int{0},
+		Delay:          &ratelimiter.DelayConfig{MaxDepth: 2},
+	})
+	l.Start()
+	defer l.Stop()
+
+	handled := make(chan string, 4)
+	d.AddHandlerToGroup(handlers.NewMessage(message.All, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		handled <- ctx.EffectiveMessage.Text
+		return nil
+	}), 1)
+
+	for _, text := range []string{"a", "b", "c", "d"} {
+		err := d.ProcessUpdate(&gotgbot.Bot{}, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: text,
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	if n := l.DelayedCount(1); n != 2 {
+		t.Fatalf("expected 2 delayed updates, got %d", n)
+	}
+
+	var texts []string
+	timeout := time.After(2 * time.Second)
+	for len(texts) < 3 {
+		select {
+		case text := <-handled:
+			texts = append(texts, text)
+		case <-timeout:
+			t.Fatalf("the delayed updates should be handled: %v", texts)
+		}
+	}
+
+	if strings.Join(texts, "") != "abc" {
+		t.Errorf("unexpected handled updates: %v", texts)
+	}
+}
+
+func TestDelayModeDispatchers(t *testing.T) {
+	first, second := ext.NewDispatcher(nil), ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(first, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        100 * time.Millisecond,
+		PunishmentTime: 50 * time.Millisecond,
+		HandlerGroups:  []int{0},
+		Delay:          &ratelimiter.DelayConfig{MaxDepth: 2},
+	})
+	l.AttachTo(second)
+	l.Start()
+	defer l.Stop()
+
+	handled := make(chan string, 4)
+	for name, d := range map[string]*ext.Dispatcher{"first": first, "second": second} {
+		name := name
+		d.AddHandlerToGroup(handlers.NewMessage(message.All, func(b *gotgbot.Bot, ctx *ext.Context) error {
+			handled <- name + ":" + ctx.EffectiveMessage.Text
+			return nil
+		}), 1)
+	}
+
+	send := func(userID int64, texts ...string) {
+		for _, text := range texts {
+			err := second.ProcessUpdate(&gotgbot.Bot{}, &gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: text,
+					Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From: &gotgbot.User{Id: userID},
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to process the update: %v", err)
+			}
+		}
+	}
+
+	// the delayed update is re-injected into the dispatcher it has been
+	// received from, not into the first one.
+	send(1, "a", "b")
+	for _, expected := range []string{"second:a", "second:b"} {
+		select {
+		case text := <-handled:
+			if text != expected {
+				t.Errorf("expected %s, got %s", expected, text)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s hasn't been handled", expected)
+		}
+	}
+
+	// the delayed updates of a detached dispatcher are dropped.
+	send(2, "c", "d")
+	<-handled
+	if n := l.DelayedCount(2); n != 1 {
+		t.Fatalf("expected 1 delayed update, got %d", n)
+	}
+
+	l.Detach(second)
+	select {
+	case text := <-handled:
+		t.Errorf("the update should be dropped, got %s", text)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if n := l.DelayedCount(2); n != 0 {
+		t.Errorf("the queue should be drained, got %d", n)
+	}
+
+	// the updates gated by a bare processor can't be re-injected, so
+	// they are limited as usual.
+	gated := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 1,
+		Timeout:      time.Minute,
+		Delay:        &ratelimiter.DelayConfig{MaxDepth: 2},
+	})
+	triggered := make(chan struct{}, 1)
+	gated.AddTrigger(ratelimiter.Trigger{
+		Response: func(b *gotgbot.Bot, ctx *ext.Context) error {
+			triggered <- struct{}{}
+			return nil
+		},
+	})
+	gated.Start()
+	defer gated.Stop()
+
+	processed := ext.NewDispatcher(&ext.DispatcherOpts{
+		Processor: ratelimiter.NewLimiterProcessor(gated, nil),
+	})
+	for i := 0; i < 2; i++ {
+		err := processed.ProcessUpdate(&gotgbot.Bot{}, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 3},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	if n := gated.DelayedCount(3); n != 0 {
+		t.Errorf("the update should not be delayed, got %d", n)
+	}
+
+	select {
+	case <-triggered:
+	case <-time.After(time.Second):
+		t.Error("the triggers should be run for the limited update")
+	}
+}
+
+func TestWarnThreshold(t *testing.T) {
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:  true,
+		MessageCount:  5,
+		WarnThreshold: 0.6,
+		HandlerGroups: []int{0},
+	})
+	l.Start()
+	defer l.Stop()
+
+	var warned []int
+	received := 0
+	d.AddHandlerToGroup(handlers.NewMessage(message.All, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		received++
+		if ratelimiter.IsWarned(ctx) {
+			warned = append(warned, received)
+		}
+		return nil
+	}), 1)
+
+	for i := 0; i < 7; i++ {
+		err := d.ProcessUpdate(nil, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	if received != 5 || len(warned) != 1 || warned[0] != 3 {
+		t.Errorf("the user should be warned once on the 3rd message: %d, %v", received, warned)
+	}
+
+	l.SetWarnThreshold(2)
+	if err := l.Validate(); err == nil {
+		t.Error("a warning threshold above 1 should be rejected")
+	}
+}
+
+// recordingClient is a bot client which records the requests sent to the
+// bot api instead of sending them.
+type recordingClient struct {
+	gotgbot.BaseBotClient
+	requests chan map[string]string
+
+	// member is the response of the getChatMember requests, if it's set.
+	member string
+}
+
+func (c *recordingClient) RequestWithContext(ctx context.Context, token string, method string,
+	params map[string]string, data map[string]gotgbot.NamedReader, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	params["method"] = method
+	c.requests <- params
+	switch method {
+	case "sendMessage":
+		return json.RawMessage(`{"message_id":1,"date":0,"chat":{"id":-100,"type":"supergroup"}}`), nil
+	case "getChatMember":
+		if c.member != "" {
+			return json.RawMessage(c.member), nil
+		}
+
+		return json.RawMessage(`true`), nil
+	case "getChat":
+		return json.RawMessage(`{"id":-100,"type":"supergroup","accent_color_id":0,"max_reaction_count":0,` +
+			`"permissions":{"can_send_messages":true}}`), nil
+	default:
+		return json.RawMessage(`true`), nil
+	}
+}
+
+// newRecordingBot returns a bot which records its requests in the
+// returned client.
+func newRecordingBot(t *testing.T) (*gotgbot.Bot, *recordingClient) {
+	client := &recordingClient{requests: make(chan map[string]string, 10)}
+	bot, err := gotgbot.NewBot("1:fake", &gotgbot.BotOpts{
+		BotClient:         client,
+		DisableTokenCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create the bot: %v", err)
+	}
+
+	return bot, client
+}
+
+func TestReply(t *testing.T) {
+	bot, client := newRecordingBot(t)
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:  true,
+		MessageCount:  2,
+		WarnThreshold: 1,
+		Reply: &ratelimiter.ReplyConfig{
+			Text:     "{mention} in {chat}: wait {remaining}",
+			WarnText: "{user}, {remaining} left",
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	for i := 0; i < 3; i++ {
+		err := d.ProcessUpdate(bot, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				MessageId: int64(i + 1),
+				Text:      "hello",
+				Chat:      gotgbot.Chat{Id: -100, Type: "supergroup", Title: "<group>"},
+				From:      &gotgbot.User{Id: 1, FirstName: "John"},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	// the replies are sent concurrently.
+	var texts []string
+	for i := 0; i < 2; i++ {
+		select {
+		case params := <-client.requests:
+			if params["method"] != "sendMessage" || params["parse_mode"] != gotgbot.ParseModeHTML {
+				t.Errorf("unexpected reply: %v", params)
+			}
+			texts = append(texts, params["text"])
+		case <-time.After(time.Second):
+			t.Fatalf("the replies haven't been sent: %v", texts)
+		}
+	}
+
+	joined := strings.Join(texts, "\n")
+	if !strings.Contains(joined, "John, 0 left") ||
+		!strings.Contains(joined, `<a href="tg://user?id=1">John</a> in &lt;group&gt;: wait 4m`) {
+		t.Errorf("unexpected replies: %q", texts)
+	}
+}
+
+func TestVoiceProfile(t *testing.T) {
+	bot, client := newRecordingBot(t)
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:     true,
+		MessageCount:     10,
+		VoiceProfile:     &ratelimiter.LimitProfile{Timeout: time.Minute, PunishmentTime: time.Minute, MessageCount: 2},
+		DeleteVoiceFlood: true,
+	})
+	l.Start()
+	defer l.Stop()
+
+	send := func(id int64, msg *gotgbot.Message) {
+		msg.MessageId = id
+		msg.Chat = gotgbot.Chat{Id: -100, Type: "supergroup"}
+		msg.From = &gotgbot.User{Id: 1}
+		if err := d.ProcessUpdate(bot, &gotgbot.Update{Message: msg}, nil); err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	send(1, &gotgbot.Message{Text: "hello"})
+	send(2, &gotgbot.Message{Voice: &gotgbot.Voice{FileId: "1"}})
+	send(3, &gotgbot.Message{VideoNote: &gotgbot.VideoNote{FileId: "2"}})
+	if status := l.GetStatus(1); status == nil || status.IsLimited() {
+		t.Fatalf("the user should not be limited within the voice quota: %+v", status)
+	}
+
+	send(4, &gotgbot.Message{Voice: &gotgbot.Voice{FileId: "3"}})
+	if status := l.GetStatus(1); status == nil || !status.IsLimited() {
+		t.Fatalf("the user should be limited by the voice profile: %+v", status)
+	}
+
+	select {
+	case params := <-client.requests:
+		if params["method"] != "deleteMessage" || params["message_id"] != "4" {
+			t.Errorf("the voice flood should be deleted: %v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the voice flood hasn't been deleted")
+	}
+
+	if c := l.Config(); c.VoiceProfile == nil || !c.DeleteVoiceFlood {
+		t.Errorf("the voice profile should be exported: %+v", c.VoiceProfile)
+	}
+}
+
+func TestBundles(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+		Reply:        &ratelimiter.ReplyConfig{},
+		Bundles: map[string]*ratelimiter.Bundle{
+			"DE": {LimitText: "{user}, langsam!"},
+		},
+	})
+	l.Start()
+	defer l.Stop()
+
+	expected := map[int64]string{
+		1: "Alice, langsam!",
+		2: "estás enviando mensajes demasiado rápido",
+		3: "you are sending messages too fast",
+	}
+	languages := map[int64]string{1: "de-AT", 2: "es", 3: "xx"}
+	for id, lang := range languages {
+		for i := 0; i < 2; i++ {
+			err := d.ProcessUpdate(bot, &gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: "hello",
+					Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From: &gotgbot.User{Id: id, FirstName: "Alice", LanguageCode: lang},
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to process the update: %v", err)
+			}
+		}
+
+		select {
+		case params := <-client.requests:
+			if !strings.Contains(params["text"], expected[id]) {
+				t.Errorf("unexpected reply for %q: %q", lang, params["text"])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the reply for %q hasn't been sent", lang)
+		}
+	}
+
+	if l.GetBundle("de") == nil {
+		t.Error("the language codes of the bundles should be case-insensitive")
+	}
+}
+
+func TestPunishment(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	d := ext.NewDispatcher(nil)
+	store := storage.NewMemoryStorage()
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        20 * time.Millisecond,
+		PunishmentTime: 30 * time.Millisecond,
+	})
+	l.SetStorage(store)
+	if err := l.SetPunishment(&ratelimiter.PunishmentConfig{Bot: bot, Type: ratelimiter.PunishmentMute}); err != nil {
+		t.Fatalf("failed to set the punishment: %v", err)
+	}
+	l.Start()
+	defer l.Stop()
+
+	for i := 0; i < 2; i++ {
+		err := d.ProcessUpdate(bot, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	// the current restrictions of the user are looked up first, then the
+	// user is muted, and the restriction is lifted when the punishment is
+	// over; telegram is asked to lift it too, in case the bot is down.
+	if params := nextRequest(t, client); params["method"] != "getChatMember" {
+		t.Errorf("unexpected request: %v", params)
+	}
+
+	params := nextRequest(t, client)
+	if params["method"] != "restrictChatMember" || params["permissions"] != "{}" {
+		t.Errorf("unexpected request: %v", params)
+	}
+
+	if until, _ := strconv.ParseInt(params["until_date"], 10, 64); until < time.Now().Add(29*time.Second).Unix() {
+		t.Errorf("the until date should be at least 30 seconds later: %v", params["until_date"])
+	}
+
+	params = nextRequest(t, client)
+	if params["method"] != "restrictChatMember" || !strings.Contains(params["permissions"], `"can_send_messages":true`) ||
+		!strings.Contains(params["permissions"], `"can_send_photos":true`) {
+		t.Errorf("unexpected request: %v", params)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if keys, _ := store.Keys("action:"); len(keys) != 0 || len(l.ScheduledActions()) != 0 {
+		t.Errorf("the reverted action should be removed: %v", keys)
+	}
+
+	// the persisted actions survive the restarts.
+	_ = store.Set("action:-100:2", []byte(`{"key":2,"chat_id":-100,"user_id":2,"action":"unban","at":"2100-01-01T00:00:00Z"}`))
+	l.Stop()
+	l.Start()
+	if actions := l.ScheduledActions(); len(actions) != 1 || actions[0].Action != ratelimiter.AuditUnban {
+		t.Errorf("the persisted action should be loaded: %+v", actions)
+	}
+
+	if err := l.SetPunishment(&ratelimiter.PunishmentConfig{Type: ratelimiter.PunishmentBan}); err == nil {
+		t.Error("a punishment without a bot should be rejected")
+	}
+}
+
+func TestPunishmentRestoresRestrictions(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	client.member = `{"status":"restricted","user":{"id":1,"is_bot":false,"first_name":"John"},` +
+		`"is_member":true,"can_send_messages":true,"until_date":0}`
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        20 * time.Millisecond,
+		PunishmentTime: 30 * time.Millisecond,
+	})
+	if err := l.SetPunishment(&ratelimiter.PunishmentConfig{Bot: bot, Type: ratelimiter.PunishmentMute}); err != nil {
+		t.Fatalf("failed to set the punishment: %v", err)
+	}
+	l.Start()
+	defer l.Stop()
+
+	for i := 0; i < 2; i++ {
+		_ = d.ProcessUpdate(bot, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+	}
+
+	for _, method := range []string{"getChatMember", "restrictChatMember"} {
+		if params := nextRequest(t, client); params["method"] != method {
+			t.Errorf("unexpected request: %v", params)
+		}
+	}
+
+	// the user could only send text messages before, so the reversal
+	// must not grant them the other permissions.
+	params := nextRequest(t, client)
+	if params["method"] != "restrictChatMember" || params["permissions"] != `{"can_send_messages":true}` ||
+		params["use_independent_chat_permissions"] != "true" {
+		t.Errorf("the previous restrictions should be restored: %v", params)
+	}
+}
+
+// nextRequest returns the next request sent to the recording client.
+func nextRequest(t *testing.T, client *recordingClient) map[string]string {
+	t.Helper()
+
+	select {
+	case params := <-client.requests:
+		return params
+	case <-time.After(time.Second):
+		t.Fatal("the request hasn't been sent")
+		return nil
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	newChallenged := func(considerUser bool) (*ext.Dispatcher, *ratelimiter.Limiter, *gotgbot.Bot, *recordingClient) {
+		bot, client := newRecordingBot(t)
+		d := ext.NewDispatcher(nil)
+		l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+			ConsiderUser:   considerUser,
+			MessageCount:   1,
+			Timeout:        time.Minute,
+			PunishmentTime: time.Minute,
+			Challenge: &ratelimiter.ChallengeConfig{
+				Text:    "100% human? press {choice}",
+				Choices: []string{"a", "b", "c"},
+			},
+		})
+		if err := l.Start(); err != nil {
+			t.Fatalf("failed to start the limiter: %v", err)
+		}
+
+		return d, l, bot, client
+	}
+
+	send := func(d *ext.Dispatcher, bot *gotgbot.Bot, userID int64) {
+		err := d.ProcessUpdate(bot, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: userID},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	press := func(d *ext.Dispatcher, bot *gotgbot.Bot, userID int64, data string) {
+		err := d.ProcessUpdate(bot, &gotgbot.Update{
+			CallbackQuery: &gotgbot.CallbackQuery{
+				Id:   "1",
+				From: gotgbot.User{Id: userID},
+				Data: data,
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the callback query: %v", err)
+		}
+	}
+
+	limited := func(l *ratelimiter.Limiter, key int64) bool {
+		status := l.GetStatus(key)
+		return status != nil && status.IsLimited()
+	}
+
+	d, l, bot, client := newChallenged(true)
+	send(d, bot, 1)
+	send(d, bot, 1)
+
+	params := nextRequest(t, client)
+	if params["method"] != "sendMessage" {
+		t.Fatalf("the challenge should be sent: %v", params)
+	}
+
+	markup := new(gotgbot.InlineKeyboardMarkup)
+	if err := json.Unmarshal([]byte(params["reply_markup"]), markup); err != nil {
+		t.Fatalf("failed to parse the buttons: %v", err)
+	}
+
+	var correct, wrong string
+	for _, button := range markup.InlineKeyboard[0] {
+		if params["text"] == "100% human? press "+button.Text {
+			correct = button.CallbackData
+		} else {
+			wrong = button.CallbackData
+		}
+	}
+
+	if correct == "" {
+		t.Fatalf("the text should contain the correct choice: %q", params["text"])
+	}
+
+	nonce, _, _ := strings.Cut(strings.TrimPrefix(correct, ratelimiter.ChallengeCallbackPrefix), ":")
+	if len(nonce) != 16 || strings.Trim(nonce, "0123456789abcdef") != "" {
+		t.Errorf("the nonce should be random hex: %q", nonce)
+	}
+
+	// the wrong answers and the other users don't unlimit the user.
+	press(d, bot, 1, wrong)
+	press(d, bot, 2, correct)
+	if !limited(l, 1) {
+		t.Error("the user should still be limited")
+	}
+
+	press(d, bot, 1, correct)
+	if limited(l, 1) {
+		t.Error("the user should be unlimited by solving the challenge")
+	}
+	l.Stop()
+
+	// without ConsiderUser, the key is the chat, so the challenge of one
+	// user can't unlimit all of the other users.
+	d, l, bot, client = newChallenged(false)
+	defer l.Stop()
+
+	send(d, bot, 1)
+	send(d, bot, 1)
+	if !limited(l, -100) {
+		t.Fatal("the chat should be limited")
+	}
+
+	select {
+	case params := <-client.requests:
+		t.Errorf("the challenge should not be sent for the chats: %v", params)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	press(d, bot, 1, ratelimiter.ChallengeCallbackPrefix+nonce+":0")
+	if !limited(l, -100) {
+		t.Error("the chat should still be limited")
+	}
+}
+
+func TestSendQueueBots(t *testing.T) {
+	first, firstClient := newRecordingBot(t)
+	second, secondClient := newRecordingBot(t)
+
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:     true,
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        time.Minute,
+		PunishmentTime: time.Minute,
+		Challenge: &ratelimiter.ChallengeConfig{
+			Choices: []string{"a", "b"},
+		},
+	})
+
+	// the queue belongs to the first bot, but it's shared by both of them.
+	q := outbound.NewQueue(first, nil)
+	q.Start()
+	defer q.Stop()
+	l.SetSendQueue(q)
+
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	d := ext.NewDispatcher(nil)
+	l.AttachTo(d)
+	for i := 0; i < 2; i++ {
+		err := d.ProcessUpdate(second, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	if params := nextRequest(t, secondClient); params["method"] != "sendMessage" {
+		t.Errorf("the challenge should be sent by the second bot: %v", params)
+	}
+
+	select {
+	case params := <-firstClient.requests:
+		t.Errorf("the first bot should not send the requests of the second bot: %v", params)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if stats := q.Stats(); stats.Sent != 1 {
+		t.Errorf("the challenge should be sent through the queue: %+v", stats)
+	}
+}
+
+func TestAlerts(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        10 * time.Millisecond,
+		PunishmentTime: 10 * time.Millisecond,
+	})
+	err := l.SetAlert(&ratelimiter.AlertConfig{Bot: bot, ChatID: -200, Threshold: 2})
+	if err != nil {
+		t.Fatalf("failed to set the alerts: %v", err)
+	}
+	l.Start()
+	defer l.Stop()
+
+	process := func(u *gotgbot.Update) {
+		if err := d.ProcessUpdate(bot, u, nil); err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 3; j++ {
+			process(&gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: "hello",
+					Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From: &gotgbot.User{Id: 1, FirstName: "John"},
+				},
+			})
+		}
+
+		if i == 0 {
+			if incidents := l.GetIncidents(1); len(incidents) != 1 {
+				t.Fatalf("unexpected incidents: %+v", incidents)
+			}
+			time.Sleep(30 * time.Millisecond)
+		}
+	}
+
+	var markup gotgbot.InlineKeyboardMarkup
+	select {
+	case params := <-client.requests:
+		if params["chat_id"] != "-200" || !strings.Contains(params["text"], "John (1)") {
+			t.Errorf("unexpected alert: %v", params)
+		}
+		_ = json.Unmarshal([]byte(params["reply_markup"]), &markup)
+	case <-time.After(time.Second):
+		t.Fatal("the alert hasn't been sent")
+	}
+
+	if len(markup.InlineKeyboard) != 1 || len(markup.InlineKeyboard[0]) != 2 {
+		t.Fatalf("unexpected buttons: %+v", markup)
+	}
+
+	if status := l.GetStatus(1); status == nil || !status.IsLimited() {
+		t.Fatal("the offender should be limited")
+	}
+
+	pardon := markup.InlineKeyboard[0][1].CallbackData
+	process(&gotgbot.Update{
+		CallbackQuery: &gotgbot.CallbackQuery{
+			Id:   "1",
+			From: gotgbot.User{Id: 2},
+			Data: pardon,
+		},
+	})
+
+	if status := l.GetStatus(1); status != nil && status.IsLimited() {
+		t.Error("the offender should be pardoned")
+	}
+}
+
+func TestLockdown(t *testing.T) {
+	bot, client := newRecordingBot(t)
+
+	l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 10,
+	})
+	l.Start()
+	defer l.Stop()
+
+	err := l.SetLockdown(&ratelimiter.LockdownConfig{
+		Threshold: 3,
+		Window:    time.Minute,
+		Cooldown:  200 * time.Millisecond,
+		Bot:       bot,
+	})
+	if err != nil {
+		t.Fatalf("failed to set the lockdown: %v", err)
+	}
+
+	msg := func(userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			Text: "raid",
+			Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From: &gotgbot.User{Id: userID},
+		}
+	}
+
+	for i := int64(1); i <= 3; i++ {
+		if d := l.CheckMessage(msg(i)); !d.IsAllowed() {
+			t.Fatalf("message %d should be allowed: %+v", i, d)
+		}
+	}
+
+	if d := l.CheckMessage(msg(4)); d.IsAllowed() || !l.IsLockedDown(-100) {
+		t.Fatalf("the chat should be locked down: %+v", d)
+	}
+
+	if d := l.CheckMessage(msg(5)); d.IsAllowed() {
+		t.Errorf("the updates of the chat should be dropped: %+v", d)
+	}
+
+	expected := []string{"getChat", "setChatPermissions", "setChatPermissions"}
+	for i, method := range expected {
+		select {
+		case params := <-client.requests:
+			if params["method"] != method {
+				t.Fatalf("request %d should be %s: %v", i, method, params)
+			}
+
+			if i == 1 && strings.Contains(params["permissions"], "true") {
+				t.Errorf("the chat should be read-only: %v", params)
+			}
+
+			if i == 2 && !strings.Contains(params["permissions"], `"can_send_messages":true`) {
+				t.Errorf("the permissions of the chat should be restored: %v", params)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the %s request should be sent", method)
+		}
+	}
+
+	if l.IsLockedDown(-100) {
+		t.Error("the lockdown should be over after the cooldown")
+	}
+
+	if d := l.CheckMessage(msg(6)); !d.IsAllowed() {
+		t.Errorf("the chat should be unlocked: %+v", d)
+	}
+}
+
+// countingAction is an enforcement action which records its calls.
+type countingAction struct {
+	applied  chan *ratelimiter.LimitInfo
+	reverted chan *ratelimiter.LimitInfo
+}
+
+func (a *countingAction) Apply(b *gotgbot.Bot, ctx *ext.Context, info *ratelimiter.LimitInfo) error {
+	a.applied <- info
+	return nil
+}
+
+func (a *countingAction) Revert(b *gotgbot.Bot, info *ratelimiter.LimitInfo) error {
+	a.reverted <- info
+	return nil
+}
+
+func TestActions(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+
+	custom := &countingAction{
+		applied:  make(chan *ratelimiter.LimitInfo, 1),
+		reverted: make(chan *ratelimiter.LimitInfo, 1),
+	}
+	l.AppendActions(&ratelimiter.DeleteAction{}, custom)
+	l.Start()
+	defer l.Stop()
+
+	for i := int64(1); i <= 2; i++ {
+		err := d.ProcessUpdate(bot, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				MessageId: i,
+				Text:      "hello",
+				Chat:      gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From:      &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	select {
+	case params := <-client.requests:
+		if params["method"] != "deleteMessage" || params["message_id"] != "2" {
+			t.Errorf("the limited message should be deleted: %v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the limited message hasn't been deleted")
+	}
+
+	select {
+	case info := <-custom.applied:
+		if info.Key != 1 || info.ChatID != -100 || info.UserID != 1 || info.Until.IsZero() {
+			t.Errorf("unexpected limit info: %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the custom action hasn't been applied")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	l.Unlimit(1)
+	select {
+	case info := <-custom.reverted:
+		if info.UserID != 1 {
+			t.Errorf("unexpected limit info: %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the custom action hasn't been reverted")
+	}
+
+	l.ClearActions()
+	if len(l.GetActions()) != 0 {
+		t.Error("the actions should be cleared")
+	}
+}
+
+func TestBlockedSources(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 5,
+	})
+	l.AddBlockedSource(-1001)
+	l.AppendBlockedSourceActions(&ratelimiter.DeleteAction{})
+	l.Start()
+	defer l.Stop()
+
+	chat := gotgbot.Chat{Id: -100, Type: "supergroup"}
+	forward := func(userID int64, origin gotgbot.MessageOrigin) *gotgbot.Message {
+		return &gotgbot.Message{
+			MessageId:     userID,
+			Text:          "spam",
+			Chat:          chat,
+			From:          &gotgbot.User{Id: userID},
+			ForwardOrigin: origin,
+		}
+	}
+
+	other := gotgbot.MessageOriginChannel{Chat: gotgbot.Chat{Id: -1002, Type: "channel"}}
+	if dec := l.CheckMessage(forward(1, other)); !dec.IsAllowed() {
+		t.Fatalf("the forwards of the other channels should be allowed: %+v", dec)
+	}
+
+	blocked := gotgbot.MessageOriginChannel{Chat: gotgbot.Chat{Id: -1001, Type: "channel"}}
+	if dec := l.CheckMessage(forward(2, blocked)); !dec.NewlyLimited {
+		t.Fatalf("the sender of the blocked forward should be limited: %+v", dec)
+	}
+
+	err := d.ProcessUpdate(bot, &gotgbot.Update{Message: forward(3, blocked)}, nil)
+	if err != nil {
+		t.Fatalf("failed to process the update: %v", err)
+	}
+
+	select {
+	case params := <-client.requests:
+		if params["method"] != "deleteMessage" || params["message_id"] != "3" {
+			t.Errorf("the blocked forward should be deleted: %v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the blocked forward hasn't been deleted")
+	}
+
+	if s := l.GetStatusSnapshot(3); s == nil || !s.Limited {
+		t.Error("the sender of the blocked forward should be limited")
+	}
+
+	if c := l.Config(); len(c.BlockedSources) != 1 || c.BlockedSources[0] != -1001 {
+		t.Errorf("the blocked sources should be exported: %v", c.BlockedSources)
+	}
+
+	l.RemoveBlockedSource(-1001)
+	if l.IsBlockedSource(-1001) {
+		t.Error("the source should be unblocked")
+	}
+}
+
+func TestReputationProvider(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 5,
+	})
+
+	var lookups int32
+	l.SetReputationProvider(ratelimiter.ReputationFunc(func(userID int64) (ratelimiter.Reputation, error) {
+		atomic.AddInt32(&lookups, 1)
+		return ratelimiter.Reputation{Spammer: userID == 2, Reason: "cas"}, nil
+	}), 0)
+	l.AppendReputationActions(&ratelimiter.DeleteAction{})
+	l.Start()
+	defer l.Stop()
+
+	msg := func(userID int64) *gotgbot.Message {
+		return &gotgbot.Message{
+			MessageId: userID,
+			Text:      "hello",
+			Chat:      gotgbot.Chat{Id: -100, Type: "supergroup"},
+			From:      &gotgbot.User{Id: userID},
+		}
+	}
+
+	if dec := l.CheckMessage(msg(1)); !dec.IsAllowed() {
+		t.Fatalf("the users with a good reputation should be allowed: %+v", dec)
+	}
+
+	err := d.ProcessUpdate(bot, &gotgbot.Update{Message: msg(2)}, nil)
+	if err != nil {
+		t.Fatalf("failed to process the update: %v", err)
+	}
+
+	select {
+	case params := <-client.requests:
+		if params["method"] != "deleteMessage" || params["message_id"] != "2" {
+			t.Errorf("the message of the spammer should be deleted: %v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the message of the spammer hasn't been deleted")
+	}
+
+	if s := l.GetStatusSnapshot(2); s == nil || !s.Limited {
+		t.Error("the known spammer should be limited")
+	}
+
+	l.CheckMessage(msg(1))
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("the reputations should be cached, got %d lookups", n)
+	}
+
+	if r, _ := l.GetReputation(2); !r.Spammer || r.Reason != "cas" {
+		t.Errorf("the cached reputation should be returned: %+v", r)
+	}
+}
+
+func TestLimiterProcessor(t *testing.T) {
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	l.AttachProcessor(d)
+	if err := l.Start(); err != nil {
+		t.Fatalf("the limiter should be attached to the dispatcher: %v", err)
+	}
+	defer l.Stop()
+
+	// the handler runs before the group of the limiter would do.
+	var handled int
+	d.AddHandlerToGroup(handlers.NewMessage(message.All, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		handled++
+		return nil
+	}), -10)
+
+	for i := 0; i < 3; i++ {
+		err := d.ProcessUpdate(nil, &gotgbot.Update{
+			Message: &gotgbot.Message{
+				Text: "hello",
+				Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+				From: &gotgbot.User{Id: 1},
+			},
+		}, nil)
+		if err != nil {
+			t.Fatalf("failed to process the update: %v", err)
+		}
+	}
+
+	if handled != 1 {
+		t.Errorf("the limited updates shouldn't reach any group: %d", handled)
+	}
+}
+
+func TestDetach(t *testing.T) {
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+	})
+	other := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 100,
+	})
+	l.Start()
+	defer l.Stop()
+	other.Start()
+	defer other.Stop()
+
+	var handled int
+	d.AddHandlerToGroup(handlers.NewMessage(message.All, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		handled++
+		return nil
+	}), 1)
+
+	send := func(userID int64, n int) {
+		for i := 0; i < n; i++ {
+			err := d.ProcessUpdate(nil, &gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: "hello",
+					Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From: &gotgbot.User{Id: userID},
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to process the update: %v", err)
+			}
+		}
+	}
+
+	send(1, 3)
+	if handled != 1 {
+		t.Fatalf("the limiter should be attached: %d", handled)
+	}
+
+	if !l.Detach(d) || l.Detach(d) || len(l.GetDispatchers()) != 0 {
+		t.Fatal("the limiter should be detached exactly once")
+	}
+
+	handled = 0
+	send(2, 3)
+	if handled != 3 {
+		t.Errorf("the detached limiter shouldn't check the updates: %d", handled)
+	}
+
+	if s := other.GetStatus(2); s == nil {
+		t.Error("the handlers of the other limiters should be kept")
+	}
+
+	l.AttachTo(d)
+	l.AttachTo(d)
+	handled = 0
+	send(3, 3)
+	if handled != 1 || len(l.GetDispatchers()) != 1 {
+		t.Errorf("the limiter should be attached again: %d", handled)
+	}
+}
+
+func TestOrderedTriggers(t *testing.T) {
+	bot, _ := newRecordingBot(t)
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+		IsStrict:     true,
+	})
+	l.Start()
+	defer l.Stop()
+
+	ran := make(chan string, 10)
+	trigger := func(name string) handlers.Response {
+		return func(b *gotgbot.Bot, ctx *ext.Context) error {
+			ran <- name
+			return nil
+		}
+	}
+
+	l.AppendTriggerFunc(trigger("plain"))
+	l.AddTrigger(ratelimiter.Trigger{
+		Name:     "groups",
+		Response: trigger("groups"),
+		Filter:   ratelimiter.TriggerGroupsOnly,
+	})
+	l.AddTrigger(ratelimiter.Trigger{
+		Name:     "first",
+		Response: trigger("first"),
+		Priority: 10,
+		Filter:   ratelimiter.TriggerFirstOffense,
+		Stop:     true,
+	})
+	l.AddTrigger(ratelimiter.Trigger{
+		Name:     "extension",
+		Response: trigger("extension"),
+		Filter:   ratelimiter.TriggerStrictExtension,
+	})
+
+	if triggers := l.GetTriggers(); len(triggers) != 4 || triggers[0].Name != "first" {
+		t.Fatalf("the triggers should be sorted by their priority: %+v", triggers)
+	}
+
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			err := d.ProcessUpdate(bot, &gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: "hello",
+					Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From: &gotgbot.User{Id: 1},
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to process the update: %v", err)
+			}
+		}
+	}
+
+	expect := func(names ...string) {
+		t.Helper()
+		for _, name := range names {
+			select {
+			case current := <-ran:
+				if current != name {
+					t.Fatalf("expected the %q trigger, got %q", name, current)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("the %q trigger hasn't been run", name)
+			}
+		}
+
+		select {
+		case current := <-ran:
+			t.Fatalf("unexpected trigger: %q", current)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// the first offense stops the rest of the triggers.
+	send(2)
+	expect("first")
+
+	send(1)
+	expect("extension")
+
+	l.Unlimit(1)
+	send(2)
+	expect("plain", "groups")
+
+	if !l.RemoveTrigger("groups") || l.RemoveTrigger("groups") {
+		t.Error("the trigger should be removed exactly once")
+	}
+}
+
+func TestDelayedTriggers(t *testing.T) {
+	bot, _ := newRecordingBot(t)
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		MessageCount:   1,
+		Timeout:        50 * time.Millisecond,
+		PunishmentTime: 50 * time.Millisecond,
+	})
+	l.Start()
+	defer l.Stop()
+
+	ran := make(chan string, 10)
+	trigger := func(name string) handlers.Response {
+		return func(b *gotgbot.Bot, ctx *ext.Context) error {
+			ran <- name + ":" + strconv.FormatInt(ctx.EffectiveSender.Id(), 10)
+			return nil
+		}
+	}
+
+	l.AddTrigger(ratelimiter.Trigger{
+		Response: trigger("delayed"),
+		Delay:    100 * time.Millisecond,
+	})
+	l.AddTrigger(ratelimiter.Trigger{
+		Response: trigger("released"),
+		Filter:   ratelimiter.TriggerRelease,
+	})
+
+	send := func(userID int64, n int) {
+		for i := 0; i < n; i++ {
+			err := d.ProcessUpdate(bot, &gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: "hello",
+					Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From: &gotgbot.User{Id: userID},
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to process the update: %v", err)
+			}
+		}
+	}
+
+	// the pending triggers of the manually unlimited users are cancelled.
+	send(2, 2)
+	l.Unlimit(2)
+
+	send(1, 2)
+	select {
+	case name := <-ran:
+		t.Fatalf("the trigger shouldn't run before its delay: %s", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if name := <-ran; name != "delayed:1" {
+		t.Fatalf("unexpected trigger: %s", name)
+	}
+
+	// the punishment ends by the next message after the punishment time.
+	time.Sleep(20 * time.Millisecond)
+	send(1, 1)
+	select {
+	case name := <-ran:
+		if name != "released:1" {
+			t.Fatalf("unexpected trigger: %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the release trigger hasn't been run")
+	}
+
+	select {
+	case name := <-ran:
+		t.Errorf("the triggers of the unlimited user should be cancelled: %s", name)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestReplyTemplates(t *testing.T) {
+	bot, client := newRecordingBot(t)
+
+	dir := t.TempDir()
+	write := func(name, text string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatalf("failed to write the template: %v", err)
+		}
+	}
+	write("limit.tmpl", "{{.Mention}}, slow down in {{.Chat}}")
+	write("limit.es.tmpl", "{{.User}}, más despacio")
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		MessageCount: 1,
+		Reply:        &ratelimiter.ReplyConfig{Text: "{mention}: wait {remaining}"},
+	})
+	l.Start()
+	defer l.Stop()
+
+	w, err := l.WatchTemplates(dir, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to load the templates: %v", err)
+	}
+	defer w.Stop()
+
+	reply := func(userID int64, lang string) string {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			err := d.ProcessUpdate(bot, &gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: "hello",
+					Chat: gotgbot.Chat{Id: -100, Type: "supergroup", Title: "<group>"},
+					From: &gotgbot.User{Id: userID, FirstName: "John", LanguageCode: lang},
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to process the update: %v", err)
+			}
+		}
+
+		select {
+		case params := <-client.requests:
+			return params["text"]
+		case <-time.After(time.Second):
+			t.Fatal("the reply hasn't been sent")
+		}
+
+		return ""
+	}
+
+	if text := reply(1, "en"); text != `<a href="tg://user?id=1">John</a>, slow down in &lt;group&gt;` {
+		t.Errorf("unexpected reply: %q", text)
+	}
+
+	if text := reply(2, "es-ES"); text != "John, más despacio" {
+		t.Errorf("unexpected translated reply: %q", text)
+	}
+
+	write("limit.tmpl", "{{.User}}, wait {{.Remaining}}")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(filepath.Join(dir, "limit.tmpl"), future, future)
+	time.Sleep(100 * time.Millisecond)
+
+	if text := reply(3, ""); !strings.HasPrefix(text, "John, wait 4m") {
+		t.Errorf("the templates should be reloaded: %q", text)
+	}
+
+	write("notice.tmpl", "{{.User}}")
+	if err = l.LoadTemplates(dir); !errors.Is(err, ratelimiter.ErrInvalidTemplate) {
+		t.Errorf("an unknown template should be reported: %v", err)
+	}
+}
@@ -6,16 +6,24 @@
 package tests
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/outbound"
+	"github.com/ALiwoto/ratelimiter/storage"
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
@@ -134,3 +142,564 @@ func limitedTrigger(b *gotgbot.Bot, ctx *ext.Context) error {
 
 	return nil
 }
+
+func TestOnUnlimit(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, nil)
+	unlimited := make(chan string, 1)
+	l.OnUnlimit(func(key int64, action string) {
+		if key == 1 {
+			unlimited <- action
+		}
+	})
+
+	l.Limit(1)
+	l.Unlimit(1)
+
+	select {
+	case action := <-unlimited:
+		if action != ratelimiter.ActionManual {
+			t.Errorf("unexpected action: %s", action)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the unlimit callback should be called")
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, nil)
+	if err := l.Start(); !errors.Is(err, ratelimiter.ErrNoDispatcher) {
+		t.Errorf("a limiter without any dispatcher shouldn't be started: %v", err)
+	}
+
+	l.AttachTo(ext.NewDispatcher(nil))
+	l.SetMaxMessageCount(0)
+	if err := l.Start(); !errors.Is(err, ratelimiter.ErrInvalidMessageCount) {
+		t.Errorf("an invalid limiter shouldn't be started: %v", err)
+	}
+
+	l.SetMaxMessageCount(3)
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	if err := l.Start(); !errors.Is(err, ratelimiter.ErrAlreadyStarted) {
+		t.Errorf("a running limiter shouldn't be started again: %v", err)
+	}
+}
+
+func TestStopInterruptsChecker(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone: true,
+		MaxTimeout: time.Hour,
+	})
+	l.Start()
+
+	started := time.Now()
+	l.Stop()
+	if time.Since(started) > time.Second || !l.IsStopped() || l.IsEnabled() {
+		t.Fatalf("the limiter should be stopped immediately, took %v", time.Since(started))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l.StartContext(ctx)
+	if !l.IsEnabled() {
+		t.Fatal("the limiter should be started")
+	}
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for !l.IsStopped() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !l.IsStopped() {
+		t.Error("the limiter should be stopped when the context is done")
+	}
+}
+
+func TestExceptionIDs(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, nil)
+	l.AddExceptionID(3, 1, 2, 1)
+
+	set := l.GetExceptionIDs()
+	if set.Len() != 3 || !set.Contains(2) || set.Contains(4) {
+		t.Fatalf("unexpected exception ids: %v", set.List())
+	}
+
+	l.RemoveExceptionID(2)
+	if l.IsInExceptionList(2) {
+		t.Error("the removed id should not be an exception anymore")
+	}
+
+	if ids := set.List(); len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Errorf("the ids should be sorted: %v", ids)
+	}
+
+	l.ClearAllExceptionIDs()
+	if set.Len() != 0 || set.List() != nil {
+		t.Errorf("the set should be empty: %v", set.List())
+	}
+}
+
+func TestGetStatus(t *testing.T) {
+	var nilLimiter *ratelimiter.Limiter
+	if nilLimiter.GetStatus(1) != nil || new(ratelimiter.Limiter).GetStatus(1) != nil {
+		t.Error("the uninitialized limiters should not have any status")
+	}
+
+	l := ratelimiter.NewLimiter(nil, nil)
+	if l.GetStatus(1) != nil {
+		t.Error("the untracked ids should not have any status")
+	}
+
+	seeded := l.GetOrCreateStatus(1)
+	if seeded == nil || seeded.GetCount() != 0 || l.GetStatus(1) == nil {
+		t.Fatalf("the status should be created: %+v", seeded)
+	}
+
+	l.AllowID(1, 1)
+	if seeded.GetCount() != 0 || l.GetStatus(1).GetCount() != 1 {
+		t.Error("the returned statuses should be copies")
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := ratelimiter.NewFileAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := ratelimiter.NewLimiter(nil, nil)
+	l.AddAuditSink(sink)
+
+	l.Limit(1)
+	l.Unlimit(1)
+	l.AddExceptionID(2)
+	l.SetAsExceptionList([]int64{3})
+	l.AddCustomIgnore(4, time.Minute, false)
+	l.Audit(&ratelimiter.AuditRecord{
+		Action: ratelimiter.AuditMute,
+		Key:    5,
+		Actor:  "admin",
+	})
+
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record ratelimiter.AuditRecord
+		if err = json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+
+		if record.Time.IsZero() || record.Actor == "" {
+			t.Errorf("incomplete record: %+v", record)
+		}
+
+		actions = append(actions, record.Action+":"+strconv.FormatInt(record.Key, 10))
+	}
+
+	expected := "limit:1 unlimit:1 exempt:2 unexempt:2 exempt:3 custom_ignore:4 mute:5"
+	if got := strings.Join(actions, " "); got != expected {
+		t.Errorf("unexpected audit log:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+// eventCounter is an event notifier which only counts the events.
+type eventCounter struct {
+	events atomic.Int64
+}
+
+func (c *eventCounter) Notify(*ratelimiter.LimitEvent) error {
+	c.events.Add(1)
+	return nil
+}
+
+// TestConcurrentSetters changes the configuration of a running limiter
+// while the updates are being checked; it's meant to be run with -race.
+func TestConcurrentSetters(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-client.requests:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:   true,
+		ConsiderInline: true,
+		MessageCount:   3,
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	store := storage.NewMemoryStorage()
+	queue := outbound.NewQueue(bot, nil)
+	queue.Start()
+	defer queue.Stop()
+
+	profile := func(i int) *ratelimiter.LimitProfile {
+		if i%2 == 0 {
+			return nil
+		}
+
+		return &ratelimiter.LimitProfile{Timeout: time.Second, PunishmentTime: time.Second, MessageCount: i%5 + 1}
+	}
+
+	setters := []func(i int){
+		func(i int) { l.SetTextOnly(i%2 == 0) },
+		func(i int) { l.SetCountedTypes(ratelimiter.TypeText, ratelimiter.TypePhoto) },
+		func(i int) { l.SetChatScopes() },
+		func(i int) { l.SetFloodWaitTime(time.Duration(i%3+1) * time.Second) },
+		func(i int) { l.SetPunishmentDuration(time.Duration(i%3+1) * time.Second) },
+		func(i int) { l.SetMaxMessageCount(i%5 + 1) },
+		func(i int) { l.SetMaxPunishment(time.Duration(i%3) * time.Minute) },
+		func(i int) { l.SetWarnThreshold(float64(i%2) / 2) },
+		func(i int) { l.SetMaxCacheDuration(time.Duration(i%3+1) * time.Minute) },
+		func(i int) { l.SetCheckerInterval(time.Duration(i%3+1) * time.Second) },
+		func(i int) { l.SetProbation(profile(i), time.Minute) },
+		func(i int) { l.SetChannelSenderProfile(profile(i)) },
+		func(i int) { l.SetChannelPostProfile(profile(i)) },
+		func(i int) { l.SetCommentProfile(profile(i)) },
+		func(i int) { l.SetCommandProfile(profile(i)) },
+		func(i int) { l.SetCallbackProfile(profile(i)) },
+		func(i int) { l.SetJoinFloodProfile(profile(i)) },
+		func(i int) { l.SetPaymentProfile(profile(i)) },
+		func(i int) { l.SetGlobalProfile(profile(i)) },
+		func(i int) { l.SetMentionProfile(profile(i)) },
+		func(i int) { l.SetVoiceProfile(profile(i)) },
+		func(i int) { l.SetUpdateProfile(ratelimiter.UpdateEdit, profile(i)) },
+		func(i int) { l.SetTierProfile(ratelimiter.TierVIP, profile(i)) },
+		func(i int) { l.SetRoleProfile(ratelimiter.RoleMember, profile(i)) },
+		func(i int) { l.SetRoleCacheTime(time.Duration(i%3+1) * time.Minute) },
+		func(i int) { l.SetServicePolicy(ratelimiter.ServicePolicy(i % 3)) },
+		func(i int) { l.SetFlagOnly(i%2 == 0) },
+		func(i int) { l.SetDelay(&ratelimiter.DelayConfig{MaxDepth: i%3 + 1}) },
+		func(i int) { l.SetCallbackDebounce(time.Duration(i%2)*time.Second, "slow down") },
+		func(i int) { l.SetRaid(&ratelimiter.RaidConfig{Joins: i%3 + 2}) },
+		func(i int) { l.SetFlapping(&ratelimiter.FlappingConfig{Changes: i%3 + 2}) },
+		func(i int) { l.SetAdaptive(&ratelimiter.AdaptiveConfig{BaseRate: 10, MinFactor: 0.5, MaxFactor: 2}) },
+		func(i int) { l.SetRisk(&ratelimiter.RiskConfig{NoUsername: i % 3}) },
+		func(i int) { l.SetStatsRetention(time.Duration(i%2) * time.Hour) },
+		func(i int) { l.SetStatsHistory(time.Duration(i%2) * time.Hour) },
+		func(i int) { l.SetMaxEntries(i%2*100 + 100) },
+		func(i int) { l.SetScoreThreshold(float64(i%2)/2+0.5, 1) },
+		func(i int) { l.SetChallenge(&ratelimiter.ChallengeConfig{Choices: []string{"a", "b"}}) },
+		func(i int) { l.SetReply(&ratelimiter.ReplyConfig{Text: "wait {remaining}"}) },
+		func(i int) { l.SetBundle("es", &ratelimiter.Bundle{LimitText: "espera"}) },
+		func(i int) { l.AddNotifier(&eventCounter{}) },
+		func(i int) { l.ClearNotifiers() },
+		func(i int) { l.AddExceptionID(int64(i % 7)) },
+		func(i int) { l.RemoveExceptionID(int64(i % 7)) },
+		func(i int) { l.AddAllowedCommands("start") },
+		func(i int) { l.SetTier(int64(i%7), ratelimiter.TierVIP) },
+		func(i int) { l.AddProbation(-100, int64(i%7)) },
+		func(i int) {
+			if i%2 == 0 {
+				l.SetStorage(store)
+			} else {
+				l.SetStorage(nil)
+			}
+		},
+		func(i int) {
+			if i%2 == 0 {
+				l.SetSendQueue(queue)
+			} else {
+				l.SetSendQueue(nil)
+			}
+		},
+		func(i int) {
+			if i%2 == 0 {
+				l.SetEventBus(&memoryBus{})
+			} else {
+				l.SetEventBus(nil)
+			}
+		},
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				userID := int64(i%7 + 1)
+				update := &gotgbot.Update{
+					Message: &gotgbot.Message{
+						MessageId: int64(i),
+						Text:      "hello",
+						Chat:      gotgbot.Chat{Id: -100, Type: "supergroup"},
+						From:      &gotgbot.User{Id: userID},
+					},
+				}
+				switch i % 4 {
+				case 1:
+					update.Message.Text = ""
+					update.Message.NewChatMembers = []gotgbot.User{{Id: userID}}
+				case 2:
+					update = &gotgbot.Update{
+						CallbackQuery: &gotgbot.CallbackQuery{
+							Id:   strconv.Itoa(i),
+							From: gotgbot.User{Id: userID},
+							Data: "button",
+						},
+					}
+				case 3:
+					l.CheckMessage(update.Message)
+					continue
+				}
+
+				_ = d.ProcessUpdate(bot, update, nil)
+			}
+		}(w)
+	}
+
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				for _, set := range setters {
+					set(i + w)
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+}
+
+func TestRestartUnderLoad(t *testing.T) {
+	bot, client := newRecordingBot(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-client.requests:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:     true,
+		MessageCount:     2,
+		ProbationProfile: &ratelimiter.LimitProfile{Timeout: time.Second, PunishmentTime: time.Second, MessageCount: 1},
+		Challenge:        &ratelimiter.ChallengeConfig{Choices: []string{"a", "b"}},
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				userID := int64(i%5 + 1)
+				msg := &gotgbot.Message{
+					MessageId: int64(i),
+					Text:      "hello",
+					Chat:      gotgbot.Chat{Id: -100, Type: "supergroup"},
+					From:      &gotgbot.User{Id: userID},
+				}
+				switch i % 4 {
+				case 0:
+					msg.Text = ""
+					msg.NewChatMembers = []gotgbot.User{{Id: userID}}
+				case 1:
+					l.AddProbation(-100, userID)
+					l.IsOnProbation(-100, userID)
+					l.RemoveProbation(-100, userID)
+				case 2:
+					_ = d.ProcessUpdate(bot, &gotgbot.Update{
+						CallbackQuery: &gotgbot.CallbackQuery{
+							Id:   strconv.Itoa(i),
+							From: gotgbot.User{Id: userID},
+							Data: ratelimiter.ChallengeCallbackPrefix + "nonce:0",
+						},
+					}, nil)
+					continue
+				}
+
+				_ = d.ProcessUpdate(bot, &gotgbot.Update{Message: msg}, nil)
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			l.Stop()
+			if err := l.Start(); err != nil {
+				t.Errorf("failed to restart the limiter: %v", err)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if !l.IsEnabled() {
+		t.Error("the limiter should be running after the last restart")
+	}
+}
+
+func TestAdaptiveLimits(t *testing.T) {
+	d := ext.NewDispatcher(nil)
+	l := ratelimiter.NewLimiter(d, &ratelimiter.LimiterConfig{
+		ConsiderUser:    true,
+		MessageCount:    4,
+		Timeout:         time.Minute,
+		PunishmentTime:  time.Minute,
+		CheckerInterval: time.Second,
+		HandlerGroups:   []int{0},
+		Adaptive: &ratelimiter.AdaptiveConfig{
+			BaseRate:  600,
+			MinFactor: 0.5,
+			MaxFactor: 2,
+		},
+	})
+
+	var handled atomic.Int32
+	d.AddHandlerToGroup(handlers.NewMessage(nil, func(b *gotgbot.Bot, ctx *ext.Context) error {
+		handled.Add(1)
+		return nil
+	}), 1)
+
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	send := func(chatID, userID int64, n int) int32 {
+		handled.Store(0)
+		for i := 0; i < n; i++ {
+			err := d.ProcessUpdate(&gotgbot.Bot{}, &gotgbot.Update{
+				Message: &gotgbot.Message{
+					Text: "hello",
+					Chat: gotgbot.Chat{Id: chatID, Type: "supergroup"},
+					From: &gotgbot.User{Id: userID},
+				},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to process the update: %v", err)
+			}
+		}
+
+		return handled.Load()
+	}
+
+	// the busy chat gets way more messages than the base rate (10 messages
+	// per second), and the quiet one way less.
+	for userID := int64(100); userID < 140; userID++ {
+		send(-100, userID, 1)
+	}
+	send(-200, 100, 1)
+
+	if l.GetActivityFactor(-100) != 1 || l.GetActivityFactor(-200) != 1 {
+		t.Error("the limits should not be scaled before the activities are calculated")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for l.GetActivityFactor(-100) == 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if f := l.GetActivityFactor(-100); f != 2 {
+		t.Fatalf("the factor of the busy chat should be capped by the max factor, got %v", f)
+	}
+
+	if f := l.GetActivityFactor(-200); f != 0.5 {
+		t.Fatalf("the factor of the quiet chat should be capped by the min factor, got %v", f)
+	}
+
+	if n := send(-100, 1, 10); n != 8 {
+		t.Errorf("the users of the busy chat should get more headroom, got %d", n)
+	}
+
+	if n := send(-200, 2, 10); n != 2 {
+		t.Errorf("the users of the quiet chat should get less headroom, got %d", n)
+	}
+
+	if l.GetActivityFactor(-300) != 1 {
+		t.Error("the unknown chats should not be scaled")
+	}
+
+	l.SetAdaptive(nil)
+	if l.GetActivityFactor(-100) != 1 {
+		t.Error("the factors should be dropped with the adaptive limits")
+	}
+}
+
+func TestSweepStats(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser:    true,
+		Standalone:      true,
+		Timeout:         100 * time.Millisecond,
+		PunishmentTime:  100 * time.Millisecond,
+		MaxTimeout:      time.Second,
+		CheckerInterval: time.Second,
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	if stats := l.GetSweepStats(); stats.Sweeps != 0 || !stats.LastSweep.IsZero() {
+		t.Errorf("no sweep should be done yet: %+v", stats)
+	}
+
+	for id := int64(1); id <= 5; id++ {
+		l.AllowID(id, 1)
+	}
+
+	if n := l.CacheSize(); n != 5 {
+		t.Errorf("all of the keys should be cached, got %d", n)
+	}
+
+	// the keys are evicted by the first sweep after the max cache
+	// duration.
+	started := time.Now()
+	deadline := started.Add(4 * time.Second)
+	for l.GetSweepStats().Evicted == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := l.GetSweepStats()
+	if stats.Evicted != 5 || stats.Sweeps == 0 || stats.LastSweep.Before(started) || stats.LastDuration <= 0 {
+		t.Fatalf("unexpected sweep stats: %+v", stats)
+	}
+
+	if n := l.CacheSize(); n != 0 {
+		t.Errorf("the old keys should be evicted, got %d", n)
+	}
+
+	if m := l.Metrics(); m.Sweep != stats || m.Tracked != 0 {
+		t.Errorf("the metrics should contain the sweep stats: %+v", m)
+	}
+}
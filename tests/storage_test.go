@@ -0,0 +1,486 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/storage"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+)
+
+func TestFileStorage(t *testing.T) {
+	s, err := storage.NewFileStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create file storage: %v", err)
+	}
+
+	if _, err = s.Get("settings:-100"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+
+	if err = s.Set("settings:-100", []byte("value")); err != nil {
+		t.Fatalf("failed to set the value: %v", err)
+	}
+
+	keys, err := s.Keys("settings:")
+	if err != nil || len(keys) != 1 || keys[0] != "settings:-100" {
+		t.Errorf("unexpected keys: %v (%v)", keys, err)
+	}
+
+	if err = s.Delete("settings:-100"); err != nil {
+		t.Errorf("failed to delete the value: %v", err)
+	}
+}
+
+func TestChatSettingsPersistence(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	limiter := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		Storage: s,
+	})
+
+	err := limiter.SetChatSettings(&ratelimiter.ChatSettings{
+		ChatID:       -100,
+		MessageCount: 3,
+		Timeout:      time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to set chat settings: %v", err)
+	}
+
+	other := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+		Storage: s,
+	})
+	other.Start()
+	defer other.Stop()
+
+	settings := other.GetChatSettings(-100)
+	if settings == nil || settings.MessageCount != 3 || settings.Timeout != time.Minute {
+		t.Errorf("chat settings were not reloaded on start: %+v", settings)
+	}
+}
+
+func TestCustomIgnoresPersistence(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		Storage:      s,
+	})
+	l.AddCustomIgnores([]int64{2, 1}, time.Hour, true)
+	l.AddCustomIgnore(3, 0, false)
+	l.RemoveCustomIgnore(3)
+
+	other := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		Storage:      s,
+	})
+	other.Start()
+	defer other.Stop()
+
+	list := other.ListCustomIgnores()
+	if len(list) != 2 || list[0].Key != 1 || list[1].Key != 2 ||
+		list[0].Duration != time.Hour || !list[0].IgnoreExceptions {
+		t.Fatalf("the custom ignores should be restored: %+v", list)
+	}
+}
+
+func TestStopPolicy(t *testing.T) {
+	s := storage.NewMemoryStorage()
+	config := &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		Storage:      s,
+		StopPolicy:   ratelimiter.StopRetain,
+		Standalone:   true,
+	}
+	l := ratelimiter.NewLimiter(nil, config)
+	l.Start()
+	l.Limit(1)
+	l.Stop()
+
+	status := l.GetStatus(1)
+	if status == nil || !status.IsLimited() {
+		t.Fatalf("the status should be retained on stop: %+v", status)
+	}
+
+	// a new process restarting with the same storage.
+	other := ratelimiter.NewLimiter(nil, config)
+	other.Start()
+	defer other.Stop()
+
+	status = other.GetStatus(1)
+	if status == nil || !status.IsLimited() {
+		t.Fatalf("the status should be restored from the storage: %+v", status)
+	}
+
+	if _, err := s.Get("statuses"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("the flushed statuses should be removed after loading: %v", err)
+	}
+
+	l = ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		Standalone:   true,
+	})
+	l.Start()
+	l.Limit(1)
+	l.Stop()
+	if l.GetStatus(1) != nil {
+		t.Error("the statuses should be cleared with the default stop policy")
+	}
+
+	// the failure of flushing the retained statuses is reported.
+	l = ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		Storage:      brokenStorage{},
+		StopPolicy:   ratelimiter.StopRetain,
+		Standalone:   true,
+	})
+	l.Start()
+	l.Limit(1)
+	if err := l.Shutdown(); err == nil {
+		t.Error("the error of flushing the statuses should be returned")
+	}
+
+	if status := l.GetStatus(1); status == nil || !status.IsLimited() || l.IsEnabled() {
+		t.Errorf("the limiter should be stopped with its statuses retained: %+v", status)
+	}
+
+	if err := l.Shutdown(); err != nil {
+		t.Errorf("a stopped limiter should not be flushed again: %v", err)
+	}
+}
+
+// brokenStorage is a storage backend which is never reachable.
+type brokenStorage struct {
+	storage.Storage
+}
+
+func (brokenStorage) Get(string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (brokenStorage) Set(string, []byte) error {
+	return errors.New("connection refused")
+}
+
+func (brokenStorage) Keys(string) ([]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestHealth(t *testing.T) {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone: true,
+		Storage:    storage.NewMemoryStorage(),
+	})
+	if h := l.Health(); h.Healthy || h.Enabled || h.CheckerAlive {
+		t.Fatalf("a limiter which is not started shouldn't be healthy: %+v", h)
+	}
+
+	l.Start()
+	if h := l.Health(); !h.Healthy || !h.CheckerAlive || !h.StorageReachable {
+		t.Fatalf("a started limiter should be healthy: %+v", h)
+	}
+
+	l.SetStorage(brokenStorage{})
+	if h := l.Health(); h.Healthy || h.StorageReachable || h.StorageError == "" {
+		t.Errorf("an unreachable storage should be reported: %+v", h)
+	}
+
+	l.Stop()
+	if h := l.Health(); h.Healthy || h.CheckerAlive {
+		t.Errorf("a stopped limiter shouldn't be healthy: %+v", h)
+	}
+}
+
+func TestFederation(t *testing.T) {
+	shared := storage.NewMemoryStorage()
+	member := func(name string, trust *ratelimiter.TrustPolicy) *ratelimiter.Limiter {
+		l := ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+			ConsiderUser: true,
+		})
+
+		err := l.SetFederation(&ratelimiter.FederationConfig{
+			Storage: shared,
+			Name:    name,
+			Trust:   trust,
+		})
+		if err != nil {
+			t.Fatalf("failed to join the federation: %v", err)
+		}
+
+		return l
+	}
+
+	a := member("a", nil)
+	b := member("b", nil)
+	c := member("c", &ratelimiter.TrustPolicy{Members: []string{"b"}})
+
+	a.Limit(5)
+	if err := a.FederateBan(6, 0); err != nil {
+		t.Fatalf("failed to federate the ban: %v", err)
+	}
+
+	for _, l := range []*ratelimiter.Limiter{a, b, c} {
+		if err := l.SyncFederation(); err != nil {
+			t.Fatalf("failed to sync the federation: %v", err)
+		}
+	}
+
+	if !b.IsFederated(5) || !b.IsFederated(6) {
+		t.Fatal("the verdicts of the other members should be applied")
+	}
+
+	if b.GetCore().GetSnapshot(6).CustomIgnore == nil {
+		t.Error("the banned user should be ignored")
+	}
+
+	if c.IsFederated(5) || c.IsFederated(6) || a.IsFederated(5) {
+		t.Error("the untrusted and the own verdicts should not be applied")
+	}
+
+	a.Unlimit(5)
+	if err := a.RevokeVerdict(6); err != nil {
+		t.Fatalf("failed to revoke the verdict: %v", err)
+	}
+
+	_ = a.SyncFederation()
+	_ = b.SyncFederation()
+	if b.IsFederated(5) || b.IsFederated(6) {
+		t.Error("the revoked verdicts should be lifted")
+	}
+
+	if b.GetCore().GetSnapshot(6) != nil && b.GetCore().GetSnapshot(6).CustomIgnore != nil {
+		t.Error("the custom ignore of the revoked verdict should be removed")
+	}
+
+	err := a.SetFederation(&ratelimiter.FederationConfig{Storage: shared, Name: "a:b"})
+	if !errors.Is(err, ratelimiter.ErrInvalidFederation) {
+		t.Errorf("the invalid member name should be rejected: %v", err)
+	}
+}
+
+// faultyStorage is a storage whose key listing fails, or blocks until
+// the release channel is closed.
+type faultyStorage struct {
+	storage.Storage
+	release chan struct{}
+}
+
+func (s *faultyStorage) Keys(prefix string) ([]string, error) {
+	if s.release != nil {
+		<-s.release
+	}
+
+	return nil, errors.New("storage is down")
+}
+
+// eventRecorder is an event notifier which records the events sent to
+// it.
+type eventRecorder chan *ratelimiter.LimitEvent
+
+func (r eventRecorder) Notify(event *ratelimiter.LimitEvent) error {
+	r <- event
+	return nil
+}
+
+func TestFederationSync(t *testing.T) {
+	newMember := func(s storage.Storage, errs chan error) *ratelimiter.Limiter {
+		l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+			ConsiderUser: true,
+			Standalone:   true,
+		})
+
+		err := l.SetFederation(&ratelimiter.FederationConfig{
+			Storage:      s,
+			SyncInterval: 10 * time.Millisecond,
+			SyncTimeout:  50 * time.Millisecond,
+			OnError:      func(err error) { errs <- err },
+		})
+		if err != nil {
+			t.Fatalf("failed to join the federation: %v", err)
+		}
+
+		if err = l.Start(); err != nil {
+			t.Fatalf("failed to start the limiter: %v", err)
+		}
+
+		return l
+	}
+
+	// the errors of the background syncs are reported to the error
+	// handler and the notifiers.
+	errs := make(chan error, 10)
+	events := make(eventRecorder, 10)
+	l := newMember(&faultyStorage{Storage: storage.NewMemoryStorage()}, errs)
+	l.AddNotifier(events)
+
+	select {
+	case err := <-errs:
+		if err == nil || errors.Is(err, ratelimiter.ErrFederationTimeout) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the error of the sync hasn't been reported")
+	}
+
+	select {
+	case event := <-events:
+		if event.Type != ratelimiter.EventFederationError || event.Error == "" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the error of the sync hasn't been sent to the notifiers")
+	}
+	l.Stop()
+
+	// a stuck storage doesn't block the limiter, and its syncs time out.
+	release := make(chan struct{})
+	defer close(release)
+
+	errs = make(chan error, 10)
+	l = newMember(&faultyStorage{Storage: storage.NewMemoryStorage(), release: release}, errs)
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ratelimiter.ErrFederationTimeout) {
+			t.Errorf("expected ErrFederationTimeout, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the timeout of the sync hasn't been reported")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		l.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the limiter should be stopped without waiting for the stuck sync")
+	}
+
+	if len(errs) != 0 {
+		t.Error("the overlapping syncs should be skipped while the stuck one is running")
+	}
+}
+
+func TestLimitedImportExport(t *testing.T) {
+	newLimiter := func() *ratelimiter.Limiter {
+		return ratelimiter.NewLimiter(ext.NewDispatcher(nil), &ratelimiter.LimiterConfig{
+			ConsiderUser:   true,
+			Timeout:        time.Second,
+			PunishmentTime: time.Hour,
+		})
+	}
+
+	src := newLimiter()
+	src.Limit(1)
+	src.Limit(2)
+	src.GetCore().Allow(3, 1)
+
+	var buf bytes.Buffer
+	if err := src.ExportLimited(&buf); err != nil {
+		t.Fatalf("failed to export the limited users: %v", err)
+	}
+
+	var records []ratelimiter.LimitedRecord
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil || len(records) != 2 {
+		t.Fatalf("only the limited users should be exported: %v %s", err, buf.String())
+	}
+
+	dst := newLimiter()
+	if err := dst.ImportLimited(&buf); err != nil {
+		t.Fatalf("failed to import the limited users: %v", err)
+	}
+
+	for id := int64(1); id <= 2; id++ {
+		s := dst.GetStatusSnapshot(id)
+		if s == nil || !s.Limited || s.LimitedUntil == nil {
+			t.Fatalf("user %d should be limited: %+v", id, s)
+		}
+
+		if remaining := time.Until(*s.LimitedUntil); remaining < 59*time.Minute {
+			t.Errorf("the remaining punishment of user %d should be kept: %v", id, remaining)
+		}
+	}
+
+	if dst.GetStatusSnapshot(3) != nil {
+		t.Error("the users who are not limited should not be imported")
+	}
+
+	err := dst.ImportLimited(strings.NewReader(`[{"key": 4, "remaining": "2s"}]`))
+	if err != nil {
+		t.Fatalf("failed to import the record: %v", err)
+	}
+
+	if s := dst.GetStatusSnapshot(4); s == nil || time.Until(*s.LimitedUntil) > 2*time.Second {
+		t.Errorf("the imported duration should be used instead of the profile: %+v", s)
+	}
+}
+
+func TestStatsHistory(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		Standalone:   true,
+		ConsiderUser: true,
+		MessageCount: 2,
+		StatsHistory: 24 * time.Hour,
+		Storage:      store,
+	})
+	l.Start()
+	defer l.Stop()
+
+	msg := &gotgbot.Message{
+		Text: "hello",
+		Chat: gotgbot.Chat{Id: -100, Type: "supergroup"},
+		From: &gotgbot.User{Id: 1},
+	}
+	for i := 0; i < 4; i++ {
+		l.CheckMessage(msg)
+	}
+
+	if err := l.FlushStats(); err != nil {
+		t.Fatalf("failed to flush the statistics: %v", err)
+	}
+
+	keys, err := store.Keys("stats:-100:")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("the statistics should be persisted: %v (%v)", keys, err)
+	}
+
+	// the unflushed statistics should be merged with the persisted ones.
+	l.CheckMessage(msg)
+
+	now := time.Now()
+	buckets, err := l.StatsRange(-100, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to get the statistics: %v", err)
+	}
+
+	if len(buckets) != 1 || buckets[0].Messages != 5 || buckets[0].Limited != 1 ||
+		buckets[0].Blocked == 0 || buckets[0].Period != time.Hour {
+		t.Fatalf("unexpected statistics: %+v", buckets)
+	}
+
+	days := ratelimiter.DailyStats(buckets)
+	if len(days) != 1 || days[0].Messages != 5 || days[0].Period != 24*time.Hour {
+		t.Errorf("unexpected daily statistics: %+v", days)
+	}
+
+	buckets, err = l.StatsRange(-100, now.Add(time.Hour), now.Add(2*time.Hour))
+	if err != nil || len(buckets) != 0 {
+		t.Errorf("the range shouldn't contain any statistics: %+v (%v)", buckets, err)
+	}
+}
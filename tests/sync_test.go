@@ -0,0 +1,213 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+	"github.com/ALiwoto/ratelimiter/core"
+)
+
+// memoryBus is an in-memory event bus which records the published
+// events, and delivers them to the subscribers synchronously.
+type memoryBus struct {
+	mutex    sync.Mutex
+	delay    time.Duration
+	events   []*core.SyncEvent
+	handlers map[int]func(event *core.SyncEvent)
+	lastID   int
+
+	// subscribeErr is returned by Subscribe, if it's set.
+	subscribeErr error
+}
+
+func (b *memoryBus) Publish(event *core.SyncEvent) error {
+	if b.delay > 0 {
+		time.Sleep(b.delay)
+	}
+
+	b.mutex.Lock()
+	b.events = append(b.events, event)
+	handlers := make([]func(event *core.SyncEvent), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+
+	return nil
+}
+
+func (b *memoryBus) Subscribe(handler func(event *core.SyncEvent)) (func(), error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.subscribeErr != nil {
+		return nil, b.subscribeErr
+	}
+
+	if b.handlers == nil {
+		b.handlers = make(map[int]func(event *core.SyncEvent))
+	}
+
+	b.lastID++
+	id := b.lastID
+	b.handlers[id] = handler
+	return func() {
+		b.mutex.Lock()
+		delete(b.handlers, id)
+		b.mutex.Unlock()
+	}, nil
+}
+
+func (b *memoryBus) published() []*core.SyncEvent {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]*core.SyncEvent(nil), b.events...)
+}
+
+func newSyncedLimiter(t *testing.T, bus core.EventBus) *ratelimiter.Limiter {
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		Standalone:   true,
+	})
+	l.SetEventBus(bus)
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+
+	return l
+}
+
+func TestEventBusOrdering(t *testing.T) {
+	bus := &memoryBus{delay: time.Millisecond}
+	publisher := newSyncedLimiter(t, bus)
+	subscriber := newSyncedLimiter(t, bus)
+	defer subscriber.Stop()
+
+	for i := 0; i < 20; i++ {
+		publisher.Limit(1)
+		publisher.Unlimit(1)
+	}
+	publisher.Limit(1)
+
+	// stopping the limiter waits for the queued events to be published.
+	publisher.Stop()
+
+	events := bus.published()
+	if len(events) != 41 {
+		t.Fatalf("all of the events should be published, got %d", len(events))
+	}
+
+	for i, event := range events {
+		expected := core.SyncLimit
+		if i%2 == 1 {
+			expected = core.SyncUnlimit
+		}
+
+		if event.Type != expected || event.Key != 1 {
+			t.Fatalf("event %d is out of order: %+v", i, event)
+		}
+	}
+
+	if status := subscriber.GetStatus(1); status == nil || !status.IsLimited() {
+		t.Error("the last event should be applied to the other instance")
+	}
+
+	publisher.Limit(2)
+	if len(bus.published()) != len(events) {
+		t.Error("the events should not be published while the limiter is stopped")
+	}
+}
+
+func TestEventBusSubscribeError(t *testing.T) {
+	bus := &memoryBus{subscribeErr: errors.New("connection refused")}
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		Standalone:   true,
+	})
+	l.SetEventBus(bus)
+
+	err := l.Start()
+	if !errors.Is(err, ratelimiter.ErrSubscribeFailed) {
+		t.Fatalf("expected ErrSubscribeFailed, got: %v", err)
+	}
+
+	if l.IsEnabled() {
+		t.Error("the limiter should not be started without its subscription")
+	}
+
+	bus.subscribeErr = nil
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	l.Stop()
+}
+
+func TestApplySyncEvent(t *testing.T) {
+	bus := &memoryBus{}
+	l := newSyncedLimiter(t, bus)
+	defer l.Stop()
+
+	other := func(event *core.SyncEvent) {
+		event.Origin = "other"
+		_ = bus.Publish(event)
+	}
+
+	other(&core.SyncEvent{Type: core.SyncLimit, Key: 1})
+	if status := l.GetStatus(1); status == nil || !status.IsLimited() {
+		t.Fatal("the limit events of the other instances should be applied")
+	}
+
+	other(&core.SyncEvent{Type: core.SyncUnlimit, Key: 1})
+	if status := l.GetStatus(1); status != nil && status.IsLimited() {
+		t.Error("the unlimit events of the other instances should be applied")
+	}
+
+	other(&core.SyncEvent{
+		Type:             core.SyncCustomIgnore,
+		Key:              2,
+		Duration:         time.Minute,
+		IgnoreExceptions: true,
+	})
+	if status := l.GetStatus(2); status == nil || !status.IsCustomLimited() {
+		t.Fatal("the custom ignore events of the other instances should be applied")
+	}
+
+	other(&core.SyncEvent{Type: core.SyncRemoveCustomIgnore, Key: 2})
+	if status := l.GetStatus(2); status != nil && status.IsCustomLimited() {
+		t.Error("the removal of the custom ignores should be applied")
+	}
+
+	// the events of the instance itself come back from the bus as well,
+	// but they must not be applied twice.
+	l.Limit(3)
+	l.Unlimit(3)
+	l.Stop()
+
+	events := bus.published()
+	if len(events) < 2 || events[len(events)-2].Type != core.SyncLimit {
+		t.Fatalf("the own events should be published: %+v", events)
+	}
+
+	own := events[len(events)-2]
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to restart the limiter: %v", err)
+	}
+
+	_ = bus.Publish(own)
+	if status := l.GetStatus(3); status != nil && status.IsLimited() {
+		t.Error("the own events of the instance should be ignored")
+	}
+}
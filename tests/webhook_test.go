@@ -0,0 +1,276 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter"
+)
+
+func TestWebhookOrder(t *testing.T) {
+	received := make(chan *ratelimiter.LimitEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "secret" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+
+		event := new(ratelimiter.LimitEvent)
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			t.Errorf("failed to decode the event: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	w := &ratelimiter.WebhookNotifier{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "secret"},
+	}
+	defer w.Stop()
+
+	for i := int64(1); i <= 5; i++ {
+		if err := w.Notify(&ratelimiter.LimitEvent{Type: ratelimiter.EventLimited, Key: i}); err != nil {
+			t.Fatalf("failed to notify: %v", err)
+		}
+	}
+
+	for i := int64(1); i <= 5; i++ {
+		select {
+		case event := <-received:
+			if event.Key != i {
+				t.Errorf("the events should be sent in order, got %d instead of %d", event.Key, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d hasn't been sent", i)
+		}
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	failed := make(chan error, 1)
+	w := &ratelimiter.WebhookNotifier{
+		URL:        server.URL,
+		MaxRetries: 2,
+		Backoff:    time.Millisecond,
+		OnError:    func(err error) { failed <- err },
+	}
+	defer w.Stop()
+
+	_ = w.Notify(&ratelimiter.LimitEvent{Type: ratelimiter.EventLimited})
+	deadline := time.Now().Add(time.Second)
+	for attempts.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if attempts.Load() != 3 {
+		t.Fatalf("the failed requests should be retried, got %d attempts", attempts.Load())
+	}
+
+	// the retries are exhausted for the next event.
+	attempts.Store(0)
+	w.MaxRetries = 0
+	_ = w.Notify(&ratelimiter.LimitEvent{Type: ratelimiter.EventLimited})
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("the error of the request should be reported")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the failed event should be reported")
+	}
+}
+
+func TestWebhookNoRetry(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	failed := make(chan error, 1)
+	w := &ratelimiter.WebhookNotifier{
+		URL:        server.URL,
+		MaxRetries: 3,
+		Backoff:    time.Millisecond,
+		OnError:    func(err error) { failed <- err },
+	}
+	defer w.Stop()
+
+	_ = w.Notify(&ratelimiter.LimitEvent{Type: ratelimiter.EventLimited})
+	select {
+	case <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("the failed event should be reported")
+	}
+
+	if attempts.Load() != 1 {
+		t.Errorf("the client errors should not be retried, got %d attempts", attempts.Load())
+	}
+}
+
+func TestWebhookStop(t *testing.T) {
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	failed := make(chan error, 1)
+	w := &ratelimiter.WebhookNotifier{
+		URL:       server.URL,
+		QueueSize: 1,
+		OnError:   func(err error) { failed <- err },
+	}
+
+	_ = w.Notify(&ratelimiter.LimitEvent{Type: ratelimiter.EventLimited, Key: 1})
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("the first event hasn't been sent")
+	}
+
+	// the worker is stuck on the first event, so only one event fits in
+	// the queue.
+	if err := w.Notify(&ratelimiter.LimitEvent{Type: ratelimiter.EventLimited, Key: 2}); err != nil {
+		t.Errorf("the second event should be queued: %v", err)
+	}
+
+	err := w.Notify(&ratelimiter.LimitEvent{Type: ratelimiter.EventLimited, Key: 3})
+	if !errors.Is(err, ratelimiter.ErrWebhookQueueFull) {
+		t.Errorf("expected ErrWebhookQueueFull, got: %v", err)
+	}
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, ratelimiter.ErrWebhookQueueFull) {
+			t.Errorf("the dropped event should be reported, got: %v", err)
+		}
+	default:
+		t.Error("the dropped event should be reported")
+	}
+
+	// stopping the webhook aborts its current request.
+	stopped := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the webhook should be stopped without waiting for the request")
+	}
+
+	if len(started) != 0 {
+		t.Error("the queued events should be dropped by stop")
+	}
+}
+
+func TestLimiterWebhookOrder(t *testing.T) {
+	received := make(chan *ratelimiter.LimitEvent, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := new(ratelimiter.LimitEvent)
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			t.Errorf("failed to decode the event: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		Standalone:   true,
+		WebhookURL:   server.URL,
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	for i := 0; i < 10; i++ {
+		l.Limit(1)
+		l.Unlimit(1)
+	}
+
+	// an unlimit must never reach the webhook before its limit.
+	for i := 0; i < 20; i++ {
+		expected := ratelimiter.EventLimited
+		if i%2 == 1 {
+			expected = ratelimiter.EventUnlimited
+		}
+
+		select {
+		case event := <-received:
+			if event.Type != expected {
+				t.Fatalf("event %d is out of order: %+v", i, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d hasn't been sent", i)
+		}
+	}
+}
+
+func TestLimiterStopsWebhook(t *testing.T) {
+	received := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer server.Close()
+
+	l := ratelimiter.NewLimiter(nil, &ratelimiter.LimiterConfig{
+		ConsiderUser: true,
+		Standalone:   true,
+		WebhookURL:   server.URL,
+	})
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to start the limiter: %v", err)
+	}
+
+	l.Limit(1)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("the event hasn't been sent to the webhook")
+	}
+
+	// the webhook is stopped with the limiter, and started again by the
+	// events after the restart.
+	l.Stop()
+	if err := l.Start(); err != nil {
+		t.Fatalf("failed to restart the limiter: %v", err)
+	}
+	defer l.Stop()
+
+	l.Limit(2)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("the event hasn't been sent after the restart")
+	}
+}
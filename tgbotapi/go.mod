@@ -0,0 +1,10 @@
+module github.com/ALiwoto/ratelimiter/tgbotapi
+
+go 1.19
+
+require (
+	github.com/ALiwoto/ratelimiter v0.0.0-20261015214017-28dfd57d3b70
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
+)
+
+replace github.com/ALiwoto/ratelimiter => ../
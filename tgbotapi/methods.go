@@ -0,0 +1,234 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tgbotapi
+
+import (
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+//---------------------------------------------------------
+
+// Start will start the limiter; the statuses of the users will be
+// cleared from the memory periodically after this method is called.
+func (l *Limiter) Start() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.isEnabled {
+		return
+	}
+
+	l.isEnabled = true
+	l.stop = make(chan struct{})
+	go l.core.RunSweeper(l.maxTimeout, l.stop)
+}
+
+// Stop will stop the limiter and will free the statuses of the users.
+// `ShouldHandle` returns true for all of the updates while the limiter
+// is stopped.
+func (l *Limiter) Stop() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.isEnabled {
+		return
+	}
+
+	l.isEnabled = false
+	close(l.stop)
+	l.core.Clear()
+}
+
+// IsEnabled returns true if and only if this limiter is started.
+func (l *Limiter) IsEnabled() bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return l.isEnabled
+}
+
+// ShouldHandle will check the update and returns false if it should
+// be dropped because its sender (or chat) is limited.
+func (l *Limiter) ShouldHandle(update tg.Update) bool {
+	if !l.IsEnabled() || !l.isChecked(&update) {
+		return true
+	}
+
+	key, ok := l.getKey(&update)
+	if !ok {
+		return true
+	}
+
+	d := l.core.AllowRequest(key, &core.Request{
+		Cost:   1,
+		Exempt: l.isException(&update),
+		Strict: l.IsStrict,
+	})
+
+	l.mutex.RLock()
+	onLimited, onReleased := l.onLimited, l.onReleased
+	l.mutex.RUnlock()
+
+	if d.NewlyLimited && len(onLimited) != 0 {
+		go runHooks(onLimited, update, d)
+	}
+
+	if d.Released && len(onReleased) != 0 {
+		go runHooks(onReleased, update, d)
+	}
+
+	return d.IsAllowed()
+}
+
+// OnLimited will add a hook which is called when a user (or chat) gets
+// limited by this limiter; the update passed to it is the update which
+// caused the limitation.
+func (l *Limiter) OnLimited(hook EventHook) {
+	l.mutex.Lock()
+	l.onLimited = append(l.onLimited, hook)
+	l.mutex.Unlock()
+}
+
+// OnReleased will add a hook which is called when the punishment of
+// a user (or chat) ends; the update passed to it is the first update
+// received after the punishment.
+func (l *Limiter) OnReleased(hook EventHook) {
+	l.mutex.Lock()
+	l.onReleased = append(l.onReleased, hook)
+	l.mutex.Unlock()
+}
+
+// AddException will add an exception filter to this limiter.
+func (l *Limiter) AddException(ex Filter) {
+	l.mutex.Lock()
+	l.exceptions = append(l.exceptions, ex)
+	l.mutex.Unlock()
+}
+
+// AddExceptionID will add a group/user/channel ID to the exception
+// list of the limiter.
+func (l *Limiter) AddExceptionID(id ...int64) {
+	l.mutex.Lock()
+	l.exceptionIDs = append(l.exceptionIDs, id...)
+	l.mutex.Unlock()
+}
+
+// ClearAllExceptions will clear all exception filters and IDs of
+// this limiter.
+func (l *Limiter) ClearAllExceptions() {
+	l.mutex.Lock()
+	l.exceptions = nil
+	l.exceptionIDs = nil
+	l.mutex.Unlock()
+}
+
+// AddCondition will add a condition to be checked by this limiter,
+// if this condition doesn't return true, the limiter won't check
+// the update for anti-flood-wait.
+func (l *Limiter) AddCondition(condition Filter) {
+	l.mutex.Lock()
+	l.conditions = append(l.conditions, condition)
+	l.mutex.Unlock()
+}
+
+// AddCustomIgnore will make the limiter ignore the updates of the
+// given user (or chat) for `d` amount of time; pass zero to ignore them
+// until `RemoveCustomIgnore` is called.
+func (l *Limiter) AddCustomIgnore(id int64, d time.Duration, ignoreExceptions bool) {
+	l.core.AddCustomIgnore(id, d, ignoreExceptions)
+}
+
+// RemoveCustomIgnore will remove the custom ignore of the given user
+// (or chat).
+func (l *Limiter) RemoveCustomIgnore(id int64) {
+	l.core.RemoveCustomIgnore(id)
+}
+
+// Unlimit will free the user (or chat) from its limitation.
+func (l *Limiter) Unlimit(id int64) {
+	l.core.Unlimit(id)
+}
+
+// GetStatus returns the status of the user (or chat).
+func (l *Limiter) GetStatus(id int64) *core.Status {
+	return l.core.GetStatus(id)
+}
+
+// GetStatusSnapshot returns a snapshot of the status of the user (or
+// chat), which is safe to keep while the limiter is running.
+func (l *Limiter) GetStatusSnapshot(id int64) *core.StatusSnapshot {
+	return l.core.GetSnapshot(id)
+}
+
+// GetCore returns the framework-agnostic limiter used by this limiter.
+func (l *Limiter) GetCore() *core.Limiter {
+	return l.core
+}
+
+// isChecked returns true if the update should be checked by the
+// limiter at all.
+func (l *Limiter) isChecked(update *tg.Update) bool {
+	if update.CallbackQuery != nil && !l.ConsiderCallbacks {
+		return false
+	}
+
+	if l.IgnoreMediaGroup && update.Message != nil && update.Message.MediaGroupID != "" {
+		return false
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	for _, con := range l.conditions {
+		if !con(update) {
+			return false
+		}
+	}
+
+	for _, ex := range l.exceptions {
+		if ex(update) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isException returns true if the sender or the chat of the update is
+// in the exception list of the limiter.
+func (l *Limiter) isException(update *tg.Update) bool {
+	sender := update.SentFrom()
+	chat := update.FromChat()
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	for _, ex := range l.exceptionIDs {
+		if (sender != nil && sender.ID == ex) || (chat != nil && chat.ID == ex) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getKey returns the key of the update in the core limiter.
+func (l *Limiter) getKey(update *tg.Update) (int64, bool) {
+	if sender := update.SentFrom(); l.ConsiderUser && sender != nil {
+		return sender.ID, true
+	}
+
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID, true
+	}
+
+	return 0, false
+}
+
+//---------------------------------------------------------
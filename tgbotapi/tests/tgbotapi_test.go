@@ -0,0 +1,154 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/ALiwoto/ratelimiter/tgbotapi"
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func textUpdate(userID, chatID int64) tg.Update {
+	return tg.Update{
+		Message: &tg.Message{
+			Text: "hello",
+			From: &tg.User{ID: userID},
+			Chat: &tg.Chat{ID: chatID, Type: "supergroup"},
+		},
+	}
+}
+
+// handle feeds the updates to the limiter, and returns the number of
+// the updates which should be handled.
+func handle(l *tgbotapi.Limiter, updates ...tg.Update) int {
+	handled := 0
+	for _, update := range updates {
+		if l.ShouldHandle(update) {
+			handled++
+		}
+	}
+
+	return handled
+}
+
+func repeat(update tg.Update, n int) []tg.Update {
+	updates := make([]tg.Update, n)
+	for i := range updates {
+		updates[i] = update
+	}
+
+	return updates
+}
+
+func TestShouldHandle(t *testing.T) {
+	l := tgbotapi.NewLimiter(&tgbotapi.Config{
+		ConsiderUser:   true,
+		MessageCount:   3,
+		Timeout:        100 * time.Millisecond,
+		PunishmentTime: 50 * time.Millisecond,
+	})
+	l.Start()
+	defer l.Stop()
+
+	limited := make(chan core.Decision, 1)
+	released := make(chan int64, 1)
+	l.OnLimited(func(update tg.Update, d core.Decision) {
+		limited <- d
+	})
+	l.OnReleased(func(update tg.Update, d core.Decision) {
+		released <- update.SentFrom().ID
+	})
+
+	if n := handle(l, repeat(textUpdate(1, -100), 5)...); n != 3 {
+		t.Errorf("only the allowed updates should be handled, got %d", n)
+	}
+
+	select {
+	case d := <-limited:
+		if !d.NewlyLimited {
+			t.Errorf("unexpected decision: %+v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the limited hook hasn't been called")
+	}
+
+	// the other users of the same chat are not affected.
+	if handle(l, textUpdate(2, -100)) != 1 {
+		t.Error("the updates of the other users should be handled")
+	}
+
+	// the first update after the punishment releases the user.
+	time.Sleep(200 * time.Millisecond)
+	if handle(l, textUpdate(1, -100)) != 1 {
+		t.Error("the updates of the released user should be handled")
+	}
+
+	select {
+	case id := <-released:
+		if id != 1 {
+			t.Errorf("the released hook should be called for the user, got %d", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the released hook hasn't been called")
+	}
+
+	// the stopped limiter lets all of the updates pass.
+	l.Stop()
+	if n := handle(l, repeat(textUpdate(1, -100), 5)...); n != 5 {
+		t.Errorf("all of the updates should be handled while stopped, got %d", n)
+	}
+}
+
+func TestShouldHandleFilters(t *testing.T) {
+	l := tgbotapi.NewLimiter(&tgbotapi.Config{
+		MessageCount:     1,
+		IgnoreMediaGroup: true,
+		Timeout:          time.Minute,
+		PunishmentTime:   time.Minute,
+	})
+	l.Start()
+	defer l.Stop()
+
+	l.AddExceptionID(10)
+
+	// without ConsiderUser, the chat is limited as a whole.
+	if n := handle(l, textUpdate(1, -100), textUpdate(2, -100)); n != 1 {
+		t.Errorf("the chat should be limited, got %d handled updates", n)
+	}
+
+	// the exceptions and the albums are not checked.
+	album := textUpdate(3, -300)
+	album.Message.MediaGroupID = "album"
+	if n := handle(l, repeat(textUpdate(10, -200), 3)...) + handle(l, repeat(album, 3)...); n != 6 {
+		t.Errorf("the exceptions and the albums should be handled, got %d", n)
+	}
+
+	// the callback queries are not checked without ConsiderCallbacks.
+	query := tg.Update{
+		CallbackQuery: &tg.CallbackQuery{
+			From:    &tg.User{ID: 4},
+			Message: &tg.Message{Chat: &tg.Chat{ID: -400}},
+			Data:    "button",
+		},
+	}
+	if n := handle(l, repeat(query, 3)...); n != 3 {
+		t.Errorf("the callback queries should be handled, got %d", n)
+	}
+
+	// the conditions decide which updates are checked at all.
+	l.AddCondition(func(update *tg.Update) bool {
+		return update.Message == nil || update.Message.Text != "skip"
+	})
+
+	skipped := textUpdate(5, -500)
+	skipped.Message.Text = "skip"
+	if n := handle(l, repeat(skipped, 3)...); n != 3 {
+		t.Errorf("the updates which don't match the conditions should be handled, got %d", n)
+	}
+}
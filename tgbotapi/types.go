@@ -0,0 +1,76 @@
+// ratelimiter Project
+// Copyright (C) 2021~2022 ALiwoto and other Contributors
+// This file is subject to the terms and conditions defined in
+// file 'LICENSE', which is part of the source code.
+
+package tgbotapi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ALiwoto/ratelimiter/core"
+	tg "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Filter is a function which checks an update.
+type Filter func(update *tg.Update) bool
+
+// EventHook is a function which is called when an event happens in the
+// limiter, such as a user being limited. hooks are called in a separate
+// goroutine.
+type EventHook func(update tg.Update, d core.Decision)
+
+// Limiter is a rate limiter for the bots using go-telegram-bot-api.
+// Pass each update received from the updates channel to `ShouldHandle`
+// and skip the update if it returns false.
+type Limiter struct {
+	mutex *sync.RWMutex
+
+	// core is the framework-agnostic limiter which keeps track of
+	// the status of each user (or chat).
+	core *core.Limiter
+
+	// stop is closed when the limiter is stopped.
+	stop chan struct{}
+
+	isEnabled bool
+
+	onLimited    []EventHook
+	onReleased   []EventHook
+	exceptions   []Filter
+	conditions   []Filter
+	exceptionIDs []int64
+
+	// maxTimeout is the interval of clearing the old statuses from
+	// the memory.
+	maxTimeout time.Duration
+
+	// IgnoreMediaGroup should be set to true when we have to ignore
+	// album messages and don't check them at all.
+	IgnoreMediaGroup bool
+
+	// IsStrict will tell the limiter whether it should act more strict
+	// or not; see the gotgbot limiter for more information.
+	IsStrict bool
+
+	// ConsiderUser will be true when the limiter needs to use the
+	// sender's ID as the key; otherwise the chat's ID will be used.
+	ConsiderUser bool
+
+	// ConsiderCallbacks should be set to true if the callback queries
+	// have to be checked by the limiter as well.
+	ConsiderCallbacks bool
+}
+
+// Config is the config type of the go-telegram-bot-api limiter.
+type Config struct {
+	ConsiderUser      bool
+	ConsiderCallbacks bool
+	IgnoreMediaGroup  bool
+	IsStrict          bool
+	Timeout           time.Duration
+	PunishmentTime    time.Duration
+	MaxTimeout        time.Duration
+	MessageCount      int
+}
@@ -6,56 +6,1634 @@
 package ratelimiter
 
 import (
+	"context"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	"github.com/ALiwoto/ratelimiter/core"
+	"github.com/ALiwoto/ratelimiter/outbound"
+	"github.com/ALiwoto/ratelimiter/storage"
+	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers/filters"
 )
 
 // UserStatus is the status of a user in the map.
-type UserStatus struct {
-	// Last field is the last time that we received a message
-	// from the user.
-	Last time.Time
+type UserStatus = core.Status
 
-	// limited will be true if and only if the current user is
-	// banned in the limiter.
-	limited bool
+// LimitProfile is a set of limiting thresholds which can be applied
+// to a specific group of users instead of the default values of the
+// limiter.
+type LimitProfile = core.Profile
 
-	// count is the counts of the messages of the user received
-	// by limiter.
+// Decision is the decision made by the limiter about an update; see
+// `Limiter.Check` for more information.
+type Decision = core.Decision
+
+// Tier is the priority category of a user (or a chat) in the limiter.
+// each tier can have its own limit profile.
+type Tier int
+
+// TierResolver is a function which determines the tier of the sender
+// of an update. It should return `TierNormal` if it has nothing to say.
+type TierResolver func(ctx *ext.Context) Tier
+
+// ScoreFunc is a function which scores an update, for example by using
+// an external spam classifier; see `Limiter.SetScoreFunc`.
+type ScoreFunc func(ctx *ext.Context) float64
+
+// CostFunc is a function which determines the amount of quota units
+// consumed by an update; see `Limiter.SetCostFunc`.
+type CostFunc func(ctx *ext.Context) int
+
+// DecisionHook is a function which can override the decision of the
+// limiter about an update; see `Limiter.SetDecisionHook`.
+type DecisionHook func(ctx *ext.Context, proposed Decision) Decision
+
+// UserStatusSnapshot is a copy of the status of a user (or chat) at a
+// single moment, which is safe to keep and marshals cleanly to JSON.
+type UserStatusSnapshot = core.StatusSnapshot
+
+// CustomIgnoreInfo is the information of a custom ignore.
+type CustomIgnoreInfo = core.CustomIgnoreInfo
+
+// RateInfo is the quota information of a user (or chat), mirroring the
+// semantics of the X-RateLimit headers; see `Limiter.Info`.
+type RateInfo = core.RateInfo
+
+// CustomIgnoreCallback is a function called when a custom ignore
+// expires; see `Limiter.OnCustomIgnoreExpire`.
+type CustomIgnoreCallback func(info CustomIgnoreInfo)
+
+// LimitedRecord is the record of a punished user (or chat) written by
+// `Limiter.ExportLimited`.
+type LimitedRecord struct {
+	Key int64 `json:"key"`
+
+	// Remaining is the remaining duration of the punishment of the key
+	// at the time of the export.
+	Remaining Duration `json:"remaining"`
+}
+
+// UnlimitCallback is a function called when the punishment of a key
+// ends; action is `ActionExpire` or `ActionManual`.
+// see `Limiter.OnUnlimit`.
+type UnlimitCallback func(key int64, action string)
+
+// ContextCondition is a condition matched against the context of any
+// update checked by the limiter; see `Limiter.AddContextCondition`.
+type ContextCondition func(ctx *ext.Context) bool
+
+// ExpireCallback is a function called when the status of a key is
+// evicted from the memory; see `Limiter.OnExpire`.
+type ExpireCallback func(key int64, status *UserStatus)
+
+// MessageType is a mask of the kinds of the messages, such as
+// `TypeText` or `TypePhoto`; see `Limiter.SetCountedTypes`.
+type MessageType uint32
+
+// TriggerFilter is a mask of the conditions of a trigger, such as
+// `TriggerGroupsOnly` or `TriggerFirstOffense`; see `Trigger`.
+type TriggerFilter uint32
+
+// Trigger is a trigger function of the limiter with its own filter and
+// priority; see `Limiter.AddTrigger`.
+type Trigger struct {
+	// Name is the optional name of the trigger, which can be used for
+	// removing it by `Limiter.RemoveTrigger`.
+	Name string
+
+	// Response is the function run by the trigger.
+	Response handlers.Response
+
+	// Priority determines the order of the triggers; the triggers with
+	// a higher priority are run first, and the ones with the same
+	// priority are run in the order they are added.
+	Priority int
+
+	// Filter is the mask of the conditions the update has to meet for
+	// running the trigger; zero means the trigger is run whenever a
+	// user is limited.
+	Filter TriggerFilter
+
+	// Stop should be set to true if the triggers after this one should
+	// not be run when this trigger matches the update.
+	Stop bool
+
+	// Delay is the time the trigger is run after the user gets limited
+	// (or after their punishment ends, see `TriggerRelease`); zero means
+	// the trigger is run immediately. the pending triggers of a user are
+	// cancelled if the user is unlimited manually.
+	Delay time.Duration
+}
+
+// pendingTrigger is a trigger of a user waiting for its delay, or for
+// the end of the punishment of the user.
+type pendingTrigger struct {
+	bot     *gotgbot.Bot
+	ctx     *ext.Context
+	trigger *Trigger
+
+	// timer is the timer of the delay of the trigger; it's nil if the
+	// trigger is waiting for the end of the punishment.
+	timer *time.Timer
+}
+
+// offenseRecord is the record of the recent offenses of a key, used by
+// the `TriggerFirstOffense` filter.
+type offenseRecord struct {
+	count int
+	last  time.Time
+}
+
+// ChatScope is a mask of the kinds of the chats, such as `ScopePrivate`
+// or `ScopeGroups`; see `Limiter.SetChatScopes`.
+type ChatScope uint32
+
+// ServicePolicy determines how the service messages (such as joins,
+// leaves, pins and title changes) are handled by the limiter.
+type ServicePolicy int
+
+// AdaptiveConfig is the configuration of the adaptive limits; in this
+// mode the max message count of the users scales with the recent overall
+// message rate of their chat, so the users of the busy chats get more
+// headroom, and the users of the quiet chats get less.
+type AdaptiveConfig struct {
+	// BaseRate is the message rate of a chat (messages per minute) in
+	// which the limits are applied as they are, without any scaling.
+	BaseRate float64 `json:"base_rate" yaml:"base_rate"`
+
+	// MinFactor and MaxFactor are the bounds of the scaling factor of
+	// the max message count.
+	MinFactor float64 `json:"min_factor" yaml:"min_factor"`
+	MaxFactor float64 `json:"max_factor" yaml:"max_factor"`
+}
+
+// RiskConfig is the configuration of the account heuristics of a
+// limiter; the weights of the risk signals of a user are summed up into
+// their risk, which raises the cost of their updates (or lowers their max
+// message count). negative weights lower the risk of the users instead.
+type RiskConfig struct {
+	// NewAccountID is the user id above which the accounts are
+	// considered new; defaults to `DefaultRiskNewAccountID`.
+	// NOTICE: the user ids are given out (roughly) sequentially, so this
+	// value has to be raised once in a while.
+	NewAccountID int64 `json:"new_account_id" yaml:"new_account_id"`
+
+	// NewAccount is the weight of the new accounts.
+	NewAccount int `json:"new_account" yaml:"new_account"`
+
+	// NoUsername is the weight of the users without any username.
+	NoUsername int `json:"no_username" yaml:"no_username"`
+
+	// Premium is the weight of the premium users; it's usually negative,
+	// since the premium accounts are rarely used for spamming.
+	Premium int `json:"premium" yaml:"premium"`
+
+	// EmptyProfile is the weight of the users with an empty profile;
+	// that is, without a last name, a username and a language code.
+	EmptyProfile int `json:"empty_profile" yaml:"empty_profile"`
+
+	// LowerThreshold makes the risk lower the max message count of the
+	// users instead of raising the cost of their updates.
+	LowerThreshold bool `json:"lower_threshold" yaml:"lower_threshold"`
+}
+
+// chatActivity is the aggregate counter of the messages of a chat.
+type chatActivity struct {
+	count  int
+	factor float64
+}
+
+// Schedule is a cron-like schedule; see `ParseSchedule`.
+type Schedule interface {
+	// Next returns the first activation time of the schedule after t.
+	Next(t time.Time) time.Time
+}
+
+// cronSchedule is a standard 5-field cron schedule; each field is a
+// bit set of the accepted values.
+type cronSchedule struct {
+	minute  uint64
+	hour    uint64
+	day     uint64
+	month   uint64
+	weekday uint64
+
+	// anyDay and anyWeekday are true if the day of month or the day of
+	// week field is "*"; when both of them are restricted, matching
+	// either of them is enough (as in the standard cron).
+	anyDay     bool
+	anyWeekday bool
+}
+
+// everySchedule is a schedule which is activated at a fixed interval.
+type everySchedule time.Duration
+
+// ReportConfig is the configuration of the scheduled summary reports of
+// a limiter; see `Limiter.SetReport`.
+type ReportConfig struct {
+	// Bot is the bot used for sending the reports; the reports are sent
+	// through the send queue of the limiter, if there is any.
+	Bot *gotgbot.Bot
+
+	// ChatID is the id of the admin chat the reports are sent to.
+	ChatID int64
+
+	// Schedule is the cron-like spec of the reports, such as "@daily" or
+	// "0 9 * * 1"; see `ParseSchedule`.
+	Schedule string
+
+	// TopCount is the amount of the top offenders included in the
+	// reports; defaults to `DefaultReportTopCount`.
+	TopCount int
+
+	// Format will compose the text of the reports; defaults to
+	// `FormatReport`.
+	Format func(r *Report) string
+}
+
+// Report is a summary of the activity of a limiter in a period.
+type Report struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+
+	// Messages is the amount of the updates checked by the limiter, and
+	// Blocked is the amount of them which have been blocked.
+	Messages int64 `json:"messages"`
+	Blocked  int64 `json:"blocked"`
+
+	// Limits is the amount of the times the users have been limited,
+	// and Offenders is the amount of the unique limited users.
+	Limits    int `json:"limits"`
+	Offenders int `json:"offenders"`
+
+	// TopOffenders are the users limited the most in the period, sorted
+	// by their limits.
+	TopOffenders []Offender `json:"top_offenders"`
+}
+
+// Offender is a user (or a chat) limited in the period of a report.
+type Offender struct {
+	Key    int64 `json:"key"`
+	Limits int   `json:"limits"`
+}
+
+// reportState is the configuration and the collected activity of the
+// scheduled reports.
+type reportState struct {
+	config   ReportConfig
+	schedule Schedule
+	since    time.Time
+	messages int64
+	blocked  int64
+	limits   int
+
+	// offenders is a map of the amount of the limits of each key.
+	offenders map[int64]int
+
+	// stop is closed to stop the scheduler goroutine.
+	stop chan struct{}
+}
+
+// AlertConfig is the configuration of the repeat-offender alerts of a
+// limiter; see `Limiter.SetAlert`.
+type AlertConfig struct {
+	// Bot is the bot used for sending the alerts; the alerts are sent
+	// through the send queue of the limiter, if there is any.
+	Bot *gotgbot.Bot
+
+	// ChatID is the id of the admin chat the alerts are sent to; if it's
+	// zero, the alerts are sent to the admins of the chat of the last
+	// incident in their private chats (the admins have to start the bot
+	// for receiving them).
+	ChatID int64
+
+	// Threshold is the amount of the incidents of a user in `Window`
+	// amount of time which makes the limiter alert the admins; defaults
+	// to `DefaultAlertThreshold`.
+	Threshold int
+
+	// Window is the period in which the incidents are counted; defaults
+	// to `DefaultAlertWindow`.
+	Window time.Duration
+
+	// Format will compose the text of the alerts; defaults to
+	// `FormatAlert`.
+	Format func(a *Alert) string
+}
+
+// LockdownConfig is the configuration of the chat-wide lockdowns of a
+// limiter; see `Limiter.SetLockdown`.
+type LockdownConfig struct {
+	// Threshold is the amount of the messages of a chat (from all of its
+	// members together) in `Window` amount of time which locks the chat
+	// down; it's required.
+	Threshold int
+
+	// Window is the period in which the messages of the chat are counted;
+	// defaults to `DefaultLockdownWindow`.
+	Window time.Duration
+
+	// Cooldown is the duration of the lockdowns; defaults to
+	// `DefaultLockdownCooldown`.
+	Cooldown time.Duration
+
+	// Bot is the bot used for making the chat read-only during the
+	// lockdowns, which restores the previous permissions of the chat
+	// afterwards; the bot has to be an admin of the chat which can
+	// restrict the members. if it's nil, the permissions of the chat are
+	// not changed, and the limiter only drops the updates of the chat
+	// during the lockdowns.
+	Bot *gotgbot.Bot
+}
+
+// RaidConfig is the configuration of the raid detection of a limiter;
+// see `Limiter.SetRaid`.
+type RaidConfig struct {
+	// Joins is the amount of the new members of a chat in `Window` amount
+	// of time which is considered a raid; defaults to `DefaultRaidJoins`.
+	Joins int
+
+	// Window is the period in which the joins of the chat are counted;
+	// defaults to `DefaultRaidWindow`.
+	Window time.Duration
+
+	// Lockdown will lock the raided chats down as well; the lockdowns
+	// have to be enabled using `Limiter.SetLockdown` for this to work.
+	Lockdown bool
+}
+
+// chatRaid is the join tracker of a chat used by the raid detection.
+type chatRaid struct {
+	// joins are the join time of the recent members of the chat with
+	// their user id as key.
+	joins map[int64]time.Time
+
+	// active is true if a raid has been detected in the chat, and the
+	// join rate of the chat hasn't dropped below the threshold since.
+	active bool
+}
+
+// FlappingConfig is the configuration of the join/leave flapping
+// detection of a limiter; see `Limiter.SetFlapping`.
+type FlappingConfig struct {
+	// Changes is the amount of the joins and leaves of a user in a chat
+	// in `Window` amount of time which is considered flapping; defaults
+	// to `DefaultFlappingChanges`.
+	Changes int
+
+	// Window is the period in which the joins and leaves of the users
+	// are counted; defaults to `DefaultFlappingWindow`.
+	Window time.Duration
+
+	// IgnoreDuration is the duration of the custom ignore applied to the
+	// flapping users (see `Limiter.AddCustomIgnore`); zero means they
+	// are not ignored, and only the flapping triggers are run.
+	IgnoreDuration time.Duration
+}
+
+// flapKey is the key of the join/leave trackers of the users.
+type flapKey struct {
+	chatID int64
+	userID int64
+}
+
+// chatLockdown is an ongoing lockdown of a chat; its cooldown is kept
+// by the cooldown manager of the limiter (see `StateLockdown`).
+type chatLockdown struct {
+	// permissions are the permissions of the chat before the lockdown,
+	// which are restored when it's over; nil if the chat hasn't been
+	// made read-only.
+	permissions *gotgbot.ChatPermissions
+}
+
+// CooldownState is a temporary state of a chat, which is reversed
+// automatically when its cooldown is over; see `Limiter.ChatState`.
+type CooldownState string
+
+// ChatState is a snapshot of the temporary states of a chat; see
+// `Limiter.ChatState`.
+type ChatState struct {
+	ChatID int64 `json:"chat_id"`
+
+	// Until is the end of the cooldown of each active state of the chat.
+	Until map[CooldownState]time.Time `json:"until,omitempty"`
+
+	// SlowMode is the raised slow mode interval of the chat; zero means
+	// the slow mode of the chat is not raised (see `Limiter.RaiseSlowMode`).
+	SlowMode time.Duration `json:"slow_mode,omitempty"`
+}
+
+// cooldownKey is the key of the temporary states of the chats.
+type cooldownKey struct {
+	chatID int64
+	state  CooldownState
+}
+
+// chatCooldown is an active temporary state of a chat.
+type chatCooldown struct {
+	until time.Time
+	timer *time.Timer
+
+	// revert is called when the state is over; it can be nil.
+	revert func()
+}
+
+// Alert is a repeat-offender alert sent to the admins.
+type Alert struct {
+	// Key is the key of the offender in the limiter.
+	Key int64 `json:"key"`
+
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+
+	// ChatID is the id of the chat of the last incident, which the
+	// offender is banned from by the ban button.
+	ChatID int64 `json:"chat_id"`
+
+	// Incidents are the recent incidents of the offender, sorted by
+	// their time.
+	Incidents []Incident `json:"incidents"`
+}
+
+// Incident is a single time a user has been limited by the limiter.
+type Incident struct {
+	Time   time.Time `json:"time"`
+	ChatID int64     `json:"chat_id"`
+
+	// Count is the amount of the messages the user has sent in the window
+	// in which it has been limited.
+	Count int `json:"count"`
+}
+
+// pendingAlert is an alert whose buttons haven't been pressed yet.
+type pendingAlert struct {
+	key       int64
+	userID    int64
+	chatID    int64
+	expiresAt time.Time
+}
+
+// ChatStats contains the aggregate statistics of a chat in the current
+// retention window; see `Limiter.SetStatsRetention`.
+type ChatStats struct {
+	ChatID int64 `json:"chat_id"`
+
+	// Messages is the amount of the updates of the chat checked by the
+	// limiter, and Blocked is the amount of them which have been
+	// blocked because of flooding.
+	Messages int64 `json:"messages"`
+	Blocked  int64 `json:"blocked"`
+
+	// LimitedUsers is the amount of the unique users (or channels)
+	// limited in the chat.
+	LimitedUsers int `json:"limited_users"`
+
+	// Since is the start of the current retention window.
+	Since        time.Time `json:"since"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// StatsBucket contains the statistics of a chat in a period of time,
+// such as an hour or a day; see `Limiter.StatsRange`.
+type StatsBucket struct {
+	ChatID int64 `json:"chat_id"`
+
+	// Start is the start of the period, and Period is its length.
+	Start  time.Time     `json:"start"`
+	Period time.Duration `json:"period"`
+
+	// Messages is the amount of the updates of the chat checked by the
+	// limiter in the period, and Blocked is the amount of them which
+	// have been blocked; Limited is the amount of the times a user (or
+	// a channel) has been newly limited in the chat.
+	Messages int64 `json:"messages"`
+	Blocked  int64 `json:"blocked"`
+	Limited  int64 `json:"limited"`
+}
+
+// statsBucketKey is the key of an hourly bucket of the statistics of a
+// chat.
+type statsBucketKey struct {
+	chatID int64
+	hour   int64
+}
+
+// chatStats is the aggregate counters of a chat.
+type chatStats struct {
+	messages     int64
+	blocked      int64
+	limitedUsers map[int64]struct{}
+	since        time.Time
+	lastActivity time.Time
+}
+
+// Role is the chat member status of a user in a chat, such as
+// `RoleAdministrator` or `RoleRestricted`. each role can have its own
+// limit profile.
+type Role string
+
+// UpdateType is the type of an update checked by the limiter, such as
+// `UpdateMessage` or `UpdateCallback`. each update type can have its own
+// limit profile and triggers.
+type UpdateType string
+
+// Propagation determines what the limiter does with the dispatcher's
+// handler groups when an update is limited.
+type Propagation int
+
+// StopPolicy determines what happens to the state of the limiter when
+// it's stopped.
+type StopPolicy int
+
+// Punishment is the action taken against the limited users in the group
+// chats, in addition to ignoring their updates.
+type Punishment int
+
+// LimitInfo is the information about a limited update, which is stored
+// in `ext.Context.Data` when the propagation is `PropagationAnnotate`.
+type LimitInfo struct {
+	// Key is the id of the user (or chat) which has been limited.
+	Key int64
+
+	// ChatID, UserID and MessageID are the chat, the sender and the
+	// message of the limited update; they are zero if the update
+	// doesn't have them.
+	ChatID    int64
+	UserID    int64
+	MessageID int64
+
+	// Until is the time the punishment of the key ends at.
+	Until time.Time
+
+	// Count is the amount of messages sent by the key in the current
+	// window, and MaxCount is the maximum amount allowed.
+	Count    int
+	MaxCount int
+
+	// NewlyLimited is true if the key has been limited by this update.
+	NewlyLimited bool
+
+	// Capped is true if the punishment of the key has been shortened by
+	// the punishment cap of the limiter (see `Limiter.SetMaxPunishment`).
+	Capped bool
+
+	// Profile is the limit profile applied to the update.
+	Profile *LimitProfile
+}
+
+// ChallengeConfig is the configuration of the verification challenge
+// which is sent to the users when they get limited by the limiter.
+// Users can prove they are human by pressing the correct button, and
+// the limiter will unlimit them automatically.
+type ChallengeConfig struct {
+	// Text is the text of the challenge message. It should contain
+	// the `{choice}` placeholder, which will be replaced by the correct
+	// choice.
+	Text string
+
+	// SuccessText is the text shown to the user when they press the
+	// correct button.
+	SuccessText string
+
+	// FailureText is the text shown to the user when they press a
+	// wrong button.
+	FailureText string
+
+	// Choices are the labels of the buttons of the challenge; one of
+	// them will be chosen randomly as the correct answer.
+	Choices []string
+
+	// Timeout is the amount of time the challenge remains valid.
+	// if it's zero, the punishment time of the limiter will be used.
+	Timeout time.Duration
+
+	// DeleteOnSolve should be set to true if the challenge message
+	// has to be deleted once it's solved.
+	DeleteOnSolve bool
+}
+
+// ReplyConfig is the configuration of the built-in reply of a limiter,
+// which is sent to the chat of the messages that get their senders
+// limited (or warned); see `Limiter.SetReply`.
+// the texts are formatted as HTML, and can contain these placeholders:
+//
+//	{user}      the name of the sender.
+//	{mention}   a mention of the sender.
+//	{remaining} the remaining punishment time of the sender; in the
+//	            warning text, it's the amount of messages the sender
+//	            can still send.
+//	{until}     the time the punishment of the sender ends at.
+//	{chat}      the title of the chat.
+type ReplyConfig struct {
+	// Text is the text of the reply sent to the limited users.
+	Text string
+
+	// WarnText is the text of the reply sent to the users who reach
+	// the warning threshold (see `Limiter.SetWarnThreshold`); leave it
+	// empty to not reply to the warned users.
+	WarnText string
+
+	// UnlimitText is the text of the reply sent to the users whose
+	// punishment is over, on their first message after that; leave it
+	// empty to not reply to them.
+	UnlimitText string
+
+	// TimeFormat is the layout of the `{until}` placeholder.
+	TimeFormat string
+
+	// DeleteAfter is the amount of time after which the reply is
+	// deleted; zero means the replies are not deleted.
+	DeleteAfter time.Duration
+}
+
+// PunishmentConfig is the configuration of the punishments of a limiter;
+// see `Limiter.SetPunishment`.
+type PunishmentConfig struct {
+	// Bot is the bot used for punishing the users and reverting their
+	// punishments; it should be an admin of the chats with the right to
+	// restrict (or ban) the members.
+	Bot *gotgbot.Bot
+
+	// Type is the punishment taken against the limited users.
+	Type Punishment
+}
+
+// Action is an enforcement action taken against the newly limited users,
+// such as muting them; see `Limiter.AppendActions`. several actions can
+// be stacked in a limiter, and each of them is applied independently.
+type Action interface {
+	// Apply will take the action against the sender of the update which
+	// has just got limited.
+	Apply(b *gotgbot.Bot, ctx *ext.Context, info *LimitInfo) error
+
+	// Revert will revert the action when the punishment of the user is
+	// over (or when they are unlimited manually); the actions which
+	// can't be reverted (such as deleting the message) do nothing.
+	Revert(b *gotgbot.Bot, info *LimitInfo) error
+}
+
+// IgnoreAction only ignores the updates of the limited users, which the
+// limiter does anyway; it's the action of the limiters with no actions.
+type IgnoreAction struct{}
+
+// DeleteAction deletes the message which has got its sender limited.
+type DeleteAction struct{}
+
+// MuteAction restricts the limited users from sending messages to the
+// group until their punishment is over; the reversal is persisted the
+// same way as the punishments (see `Limiter.SetPunishment`).
+type MuteAction struct{}
+
+// KickAction removes the limited users from the group; they can join
+// the group again right away.
+type KickAction struct{}
+
+// BanAction bans the limited users from the group until their punishment
+// is over; the reversal is persisted the same way as the punishments
+// (see `Limiter.SetPunishment`).
+type BanAction struct{}
+
+// WarnAction replies to the message which has got its sender limited
+// with a warning; the text is formatted as HTML, and can contain the
+// placeholders of `ReplyConfig`.
+type WarnAction struct {
+	Text string
+}
+
+// Escalation is the punishment chosen by an escalation policy for
+// a violation of a user.
+type Escalation struct {
+	// Action is the enforcement action taken against the user, in
+	// addition to the actions of the limiter; nil means no extra action.
+	Action Action
+
+	// Duration is the punishment time of the user; zero means the
+	// punishment time of their limit profile is used.
+	Duration time.Duration
+}
+
+// EscalationPolicy maps the violation history of a user to their
+// punishment, so the repeat offenders get harsher punishments; see
+// `Limiter.SetEscalationPolicy`.
+type EscalationPolicy interface {
+	// Escalate returns the punishment of the user who has just been
+	// limited; history contains the violations of the user in the
+	// window of the policy, oldest first, including the current one.
+	Escalate(history []Incident) Escalation
+}
+
+// LinearEscalation is an escalation policy which increases the
+// punishment time of the users by `Step` for each of their violations.
+type LinearEscalation struct {
+	// Base is the punishment time of the first violation; defaults to
+	// `DefaultPunishmentTime`.
+	Base time.Duration
+
+	// Step is added to the punishment time for each violation after the
+	// first one.
+	Step time.Duration
+
+	// Max is the maximum punishment time; zero means no cap.
+	Max time.Duration
+
+	// Actions are the actions of the violations: the n-th violation
+	// takes the n-th action, and the violations after the last action
+	// take the last one.
+	Actions []Action
+}
+
+// ExponentialEscalation is an escalation policy which multiplies the
+// punishment time of the users by `Factor` for each of their violations.
+type ExponentialEscalation struct {
+	// Base is the punishment time of the first violation; defaults to
+	// `DefaultPunishmentTime`.
+	Base time.Duration
+
+	// Factor is the multiplier of the punishment time for each violation
+	// after the first one; defaults to `DefaultEscalationFactor`.
+	Factor float64
+
+	// Max is the maximum punishment time; zero means no cap.
+	Max time.Duration
+
+	// Actions are the actions of the violations, the same as the actions
+	// of `LinearEscalation`.
+	Actions []Action
+}
+
+// FederationConfig is the configuration of the federation of a limiter;
+// the bots of a federation share their limited and banned users through
+// a shared storage namespace (such as a redis storage); see
+// `Limiter.SetFederation`.
+type FederationConfig struct {
+	// Storage is the storage backend shared by the members of the
+	// federation.
+	Storage storage.Storage
+
+	// Name is the name of this bot in the federation; the verdicts of the
+	// limiter are exported under this name. leave it empty to only apply
+	// the verdicts of the other members.
+	Name string
+
+	// Trust is the trust policy of the remote verdicts; nil means the
+	// verdicts of all of the members are applied.
+	Trust *TrustPolicy
+
+	// SyncInterval is the interval of syncing the federation while the
+	// limiter is running; zero means the checker interval of the limiter.
+	SyncInterval time.Duration
+
+	// SyncTimeout is the max time a single sync may take before it's
+	// reported as failed; defaults to `DefaultFederationSyncTimeout`.
+	SyncTimeout time.Duration
+
+	// OnError is called when syncing the federation in the background
+	// fails; the federation is synced again in the next interval anyway.
+	OnError func(err error)
+}
+
+// TrustPolicy determines which of the verdicts of the other members of
+// a federation are applied locally.
+type TrustPolicy struct {
+	// Members are the names of the trusted members; empty means all of
+	// the members are trusted.
+	Members []string
+
+	// Quorum is the number of the trusted members which have to report
+	// a user before their verdict is applied; defaults to 1.
+	Quorum int
+
+	// BansOnly makes the limiter ignore the verdicts about the users
+	// who are only limited (and not banned).
+	BansOnly bool
+
+	// MaxDuration caps the duration of the remote verdicts applied
+	// locally; zero means no cap.
+	MaxDuration time.Duration
+}
+
+// FederationVerdict is the verdict of a member of a federation about
+// a user.
+type FederationVerdict struct {
+	Member string `json:"member"`
+	UserID int64  `json:"user_id"`
+	Banned bool   `json:"banned"`
+
+	// Until is the time the verdict ends at; zero means the verdict stays
+	// until it's revoked.
+	Until time.Time `json:"until"`
+}
+
+// builtinAction is implemented by the built-in actions, so the limiter
+// can audit them and persist their reversals.
+type builtinAction interface {
+	// auditAction returns the audit action of the action; empty means
+	// the action is not audited.
+	auditAction() string
+
+	// reversal returns the audit action of the reversal, which is
+	// persisted as a scheduled action; empty means the reversal is not
+	// persisted.
+	reversal() string
+}
+
+// appliedAction is an action applied to a key, waiting to be reverted.
+type appliedAction struct {
+	action Action
+	bot    *gotgbot.Bot
+	info   LimitInfo
+}
+
+// ScheduledAction is the reversal of a punishment, which is run when the
+// punishment of the user ends; the scheduled actions are persisted in
+// the storage backend (if any), so they survive the restarts.
+type ScheduledAction struct {
+	// Key is the key of the punished user (or chat) in the limiter.
+	Key int64 `json:"key"`
+
+	ChatID int64 `json:"chat_id"`
+	UserID int64 `json:"user_id"`
+
+	// Action is the reversal, which is either `AuditUnmute` or
+	// `AuditUnban`.
+	Action string `json:"action"`
+
+	// At is the time the action is run at.
+	At time.Time `json:"at"`
+
+	// Permissions are the permissions of the user before it was muted,
+	// if it was already restricted in the chat; they are restored by the
+	// reversal until `RestrictedUntil` (as unix time, zero meaning
+	// forever). nil means the restrictions of the user are lifted.
+	Permissions     *gotgbot.ChatPermissions `json:"permissions,omitempty"`
+	RestrictedUntil int64                    `json:"restricted_until,omitempty"`
+}
+
+// Bundle is the translation of the built-in messages of the limiter to
+// a language; see `Limiter.SetBundle`. empty texts fall back to the
+// texts configured in the limiter. the texts can contain the same
+// placeholders as the ones they translate.
+type Bundle struct {
+	// LimitText, WarnText and UnlimitText translate the texts of the
+	// built-in reply (see `ReplyConfig`).
+	LimitText   string `json:"limit_text,omitempty" yaml:"limit_text,omitempty"`
+	WarnText    string `json:"warn_text,omitempty" yaml:"warn_text,omitempty"`
+	UnlimitText string `json:"unlimit_text,omitempty" yaml:"unlimit_text,omitempty"`
+
+	// ChallengeText, ChallengeSuccessText and ChallengeFailureText
+	// translate the texts of the verification challenge.
+	ChallengeText        string `json:"challenge_text,omitempty" yaml:"challenge_text,omitempty"`
+	ChallengeSuccessText string `json:"challenge_success_text,omitempty" yaml:"challenge_success_text,omitempty"`
+	ChallengeFailureText string `json:"challenge_failure_text,omitempty" yaml:"challenge_failure_text,omitempty"`
+
+	DebounceToast      string `json:"debounce_toast,omitempty" yaml:"debounce_toast,omitempty"`
+	PaymentLimitedText string `json:"payment_limited_text,omitempty" yaml:"payment_limited_text,omitempty"`
+}
+
+// scheduledAction is a scheduled action waiting for its timer.
+type scheduledAction struct {
+	ScheduledAction
+
+	// bot is the bot which has taken the action; nil means the bot of
+	// the punishments is used.
+	bot   *gotgbot.Bot
+	timer *time.Timer
+}
+
+// pressKey is the key of the debounced button presses.
+type pressKey struct {
+	userID int64
+	data   string
+}
+
+// DelayConfig is the configuration of the delay mode of a limiter; see
+// `Limiter.SetDelay`.
+type DelayConfig struct {
+	// MaxDepth is the maximum amount of the queued updates of each
+	// user (or chat); the updates beyond it are dropped. defaults to
+	// `DefaultDelayMaxDepth`.
+	MaxDepth int
+
+	// MaxAge is the maximum amount of time an update can wait in the
+	// queue; the older updates are dropped. defaults to
+	// `DefaultDelayMaxAge`.
+	MaxAge time.Duration
+}
+
+// delayedUpdate is an update queued by the delay mode.
+type delayedUpdate struct {
+	bot      *gotgbot.Bot
+	update   *gotgbot.Update
+	queuedAt time.Time
+
+	// dispatcher is the dispatcher the update has been received from.
+	dispatcher *ext.Dispatcher
+}
+
+// delayQueue is the queue of the delayed updates of a key.
+type delayQueue struct {
+	updates []*delayedUpdate
+
+	// timer is the timer of the next re-injection of the queue.
+	timer *time.Timer
+}
+
+// roleKey is the key of the cached roles; it's used as the key of the
+// other states of the users in the chats too, such as the probations.
+type roleKey struct {
+	chatID int64
+	userID int64
+}
+
+// albumKey is the key of the media groups (albums) seen by the limiter.
+type albumKey struct {
+	chatID  int64
+	groupID string
+}
+
+// threadKey is the key of the discussion threads seen by the limiter.
+type threadKey struct {
+	chatID   int64
+	threadID int64
+}
+
+// cachedRole is a role resolved from the telegram api, cached until
+// its expiration time.
+type cachedRole struct {
+	role      Role
+	expiresAt time.Time
+}
+
+// Reputation is the reputation of a user reported by a reputation
+// provider.
+type Reputation struct {
+	// Spammer is true if the user is a known spammer.
+	Spammer bool
+
+	// Reason is the reason of the verdict of the provider, such as the
+	// name of the blocklist containing the user; it's optional.
+	Reason string
+}
+
+// ReputationProvider is an external source of the reputations of the
+// users, such as the CAS or Combot blocklists; see
+// `Limiter.SetReputationProvider`.
+type ReputationProvider interface {
+	// Lookup returns the reputation of the user.
+	Lookup(userID int64) (Reputation, error)
+}
+
+// ReputationFunc is an adapter to allow the use of ordinary functions
+// as reputation providers.
+type ReputationFunc func(userID int64) (Reputation, error)
+
+// cachedReputation is a reputation looked up from the reputation
+// provider, cached until its expiration time.
+type cachedReputation struct {
+	reputation Reputation
+	expiresAt  time.Time
+}
+
+// pendingChallenge is a challenge that has been sent to a user and is
+// waiting to be solved.
+// ChatSettings is the runtime settings of a chat, which override the
+// default configuration of the limiter for that specific chat.
+// zero values mean the default configuration of the limiter should
+// be used.
+type ChatSettings struct {
+	ChatID int64 `json:"chat_id"`
+
+	// Disabled should be set to true if the limiter shouldn't check
+	// the messages of this chat at all.
+	Disabled bool `json:"disabled,omitempty"`
+
+	Timeout        time.Duration `json:"timeout,omitempty"`
+	PunishmentTime time.Duration `json:"punishment_time,omitempty"`
+	MessageCount   int           `json:"message_count,omitempty"`
+
+	// Action is the action taken when a user is limited in this chat;
+	// it can be `ActionIgnore` or `ActionChallenge`.
+	Action string `json:"action,omitempty"`
+
+	// SlowMode is the minimum interval between the messages of each user
+	// in this chat, independent of the flood window; the messages sent
+	// sooner are silently ignored. zero disables the slow mode.
+	SlowMode time.Duration `json:"slow_mode,omitempty"`
+
+	// DeleteSlowed makes the limiter delete the messages ignored by the
+	// slow mode as well; the bot needs to be an admin of the chat.
+	DeleteSlowed bool `json:"delete_slowed,omitempty"`
+}
+
+// Duration is a `time.Duration` which can be unmarshaled from human
+// readable strings such as "4s" or "2m30s" in JSON and YAML files.
+// numbers are treated as nanoseconds, same as `time.Duration`.
+type Duration time.Duration
+
+// Config is the whole serializable configuration of the limiter; it
+// captures every tunable of the limiter which can be persisted, so it
+// can be exported using `Limiter.Config`, stored as JSON or YAML (see
+// `LoadConfig`), compared with another config, and be applied to the
+// limiter using `Limiter.ApplyConfig`.
+type Config struct {
+	ConsiderChannel  bool `json:"consider_channel" yaml:"consider_channel"`
+	ConsiderUser     bool `json:"consider_user" yaml:"consider_user"`
+	ConsiderEdits    bool `json:"consider_edits" yaml:"consider_edits"`
+	ConsiderBusiness bool `json:"consider_business" yaml:"consider_business"`
+	ConsiderInline   bool `json:"consider_inline" yaml:"consider_inline"`
+	IgnoreMediaGroup bool `json:"ignore_media_group" yaml:"ignore_media_group"`
+	CountAlbumsOnce  bool `json:"count_albums_once" yaml:"count_albums_once"`
+	TextOnly         bool `json:"text_only" yaml:"text_only"`
+	IsStrict         bool `json:"is_strict" yaml:"is_strict"`
+	PartialReset     bool `json:"partial_reset" yaml:"partial_reset"`
+	OptIn            bool `json:"opt_in" yaml:"opt_in"`
+
+	// CountedTypes are the names of the kinds of the messages which count
+	// toward the quota, such as "text" or "photo"; see `ParseMessageType`.
+	// it takes precedence over `TextOnly` if it's not empty.
+	CountedTypes  []string `json:"counted_types" yaml:"counted_types"`
+	CountCaptions bool     `json:"count_captions" yaml:"count_captions"`
+
+	// ChatScopes are the names of the kinds of the chats considered by the
+	// limiter, such as "private" or "groups"; see `ParseChatScope`.
+	// all of the chats are considered if it's empty.
+	ChatScopes []string `json:"chat_scopes" yaml:"chat_scopes"`
+
+	LimitChannelSenders bool `json:"limit_channel_senders" yaml:"limit_channel_senders"`
+	ExemptAutoForwards  bool `json:"exempt_auto_forwards" yaml:"exempt_auto_forwards"`
+	ConsiderPayments    bool `json:"consider_payments" yaml:"consider_payments"`
+	DeleteVoiceFlood    bool `json:"delete_voice_flood" yaml:"delete_voice_flood"`
+	ScopeByBot          bool `json:"scope_by_bot" yaml:"scope_by_bot"`
+
+	Propagation   Propagation   `json:"propagation" yaml:"propagation"`
+	StopPolicy    StopPolicy    `json:"stop_policy" yaml:"stop_policy"`
+	ServicePolicy ServicePolicy `json:"service_policy" yaml:"service_policy"`
+
+	Timeout        Duration `json:"timeout" yaml:"timeout"`
+	PunishmentTime Duration `json:"punishment_time" yaml:"punishment_time"`
+	MaxTimeout     Duration `json:"max_timeout" yaml:"max_timeout"`
+	MessageCount   int      `json:"message_count" yaml:"message_count"`
+
+	// Throttle is the throttle interval of the default profile; zero
+	// means the throttle mode is disabled.
+	Throttle Duration `json:"throttle,omitempty" yaml:"throttle,omitempty"`
+
+	// MaxPunishment is the maximum amount of time a key can remain
+	// limited; zero means no cap.
+	MaxPunishment Duration `json:"max_punishment,omitempty" yaml:"max_punishment,omitempty"`
+
+	// CheckerInterval is the interval of the cleanup loop of the limiter;
+	// zero means max_timeout is used.
+	CheckerInterval Duration `json:"checker_interval,omitempty" yaml:"checker_interval,omitempty"`
+
+	// WarnThreshold is the ratio of the quota in which the users are
+	// warned before getting limited; zero means no warning.
+	WarnThreshold float64 `json:"warn_threshold,omitempty" yaml:"warn_threshold,omitempty"`
+
+	ExceptionIDs []int64 `json:"exception_ids" yaml:"exception_ids"`
+
+	// Enrolled are the chats and users subject to limiting in the opt-in
+	// mode.
+	Enrolled []int64 `json:"enrolled,omitempty" yaml:"enrolled,omitempty"`
+
+	// AllowedCommands are the commands which are never limited, such as
+	// "start" or "/help".
+	AllowedCommands []string `json:"allowed_commands,omitempty" yaml:"allowed_commands,omitempty"`
+
+	// ScopedExceptions are the users exempted only in a single chat.
+	ScopedExceptions []ScopedException `json:"scoped_exceptions,omitempty" yaml:"scoped_exceptions,omitempty"`
+
+	// TopicExceptions are the forum topics exempted by the limiter.
+	TopicExceptions []TopicException `json:"topic_exceptions,omitempty" yaml:"topic_exceptions,omitempty"`
+
+	// BlockedSources are the channels and users whose forwarded messages
+	// are treated as over-limit.
+	BlockedSources []int64 `json:"blocked_sources,omitempty" yaml:"blocked_sources,omitempty"`
+
+	// ExceptionRules are the exceptions matching the attributes of the
+	// users.
+	ExceptionRules []ExceptionRule `json:"exception_rules,omitempty" yaml:"exception_rules,omitempty"`
+
+	// the limit profiles of the limiter; nil profiles are disabled (or
+	// use their default values, such as `DefaultPaymentProfile`).
+	ProbationProfile     *ProfileConfig `json:"probation_profile,omitempty" yaml:"probation_profile,omitempty"`
+	ProbationDuration    Duration       `json:"probation_duration" yaml:"probation_duration"`
+	ChannelSenderProfile *ProfileConfig `json:"channel_sender_profile,omitempty" yaml:"channel_sender_profile,omitempty"`
+	ChannelPostProfile   *ProfileConfig `json:"channel_post_profile,omitempty" yaml:"channel_post_profile,omitempty"`
+	CommentProfile       *ProfileConfig `json:"comment_profile,omitempty" yaml:"comment_profile,omitempty"`
+	JoinFloodProfile     *ProfileConfig `json:"join_flood_profile,omitempty" yaml:"join_flood_profile,omitempty"`
+	PaymentProfile       *ProfileConfig `json:"payment_profile,omitempty" yaml:"payment_profile,omitempty"`
+	GlobalProfile        *ProfileConfig `json:"global_profile,omitempty" yaml:"global_profile,omitempty"`
+	MentionProfile       *ProfileConfig `json:"mention_profile,omitempty" yaml:"mention_profile,omitempty"`
+	CommandProfile       *ProfileConfig `json:"command_profile,omitempty" yaml:"command_profile,omitempty"`
+	VoiceProfile         *ProfileConfig `json:"voice_profile,omitempty" yaml:"voice_profile,omitempty"`
+
+	TierProfiles  map[Tier]*ProfileConfig `json:"tier_profiles,omitempty" yaml:"tier_profiles,omitempty"`
+	RoleProfiles  map[Role]*ProfileConfig `json:"role_profiles,omitempty" yaml:"role_profiles,omitempty"`
+	RoleCacheTime Duration                `json:"role_cache_time" yaml:"role_cache_time"`
+
+	// UpdateProfiles are the limit profiles of the update types.
+	UpdateProfiles map[UpdateType]*ProfileConfig `json:"update_profiles,omitempty" yaml:"update_profiles,omitempty"`
+
+	// Delay is the configuration of the delay mode; nil means the delay
+	// mode is disabled.
+	Delay *DelayFileConfig `json:"delay,omitempty" yaml:"delay,omitempty"`
+
+	// Raid is the configuration of the raid detection; nil means the
+	// raid detection is disabled.
+	Raid *RaidFileConfig `json:"raid,omitempty" yaml:"raid,omitempty"`
+
+	// Flapping is the configuration of the join/leave flapping detection;
+	// nil means the flapping detection is disabled.
+	Flapping *FlappingFileConfig `json:"flapping,omitempty" yaml:"flapping,omitempty"`
+
+	// CallbackDebounce is the debounce interval of the button presses;
+	// zero means the debounce is disabled.
+	CallbackDebounce Duration `json:"callback_debounce" yaml:"callback_debounce"`
+	DebounceToast    string   `json:"debounce_toast,omitempty" yaml:"debounce_toast,omitempty"`
+
+	// Challenge is the verification challenge; nil means challenges
+	// are disabled.
+	Challenge *ChallengeFileConfig `json:"challenge,omitempty" yaml:"challenge,omitempty"`
+
+	// Reply is the built-in reply sent to the limited users; nil means
+	// no reply is sent.
+	Reply *ReplyFileConfig `json:"reply,omitempty" yaml:"reply,omitempty"`
+
+	// Bundles are the translations of the built-in messages, with the
+	// language codes as their keys.
+	Bundles map[string]*Bundle `json:"bundles,omitempty" yaml:"bundles,omitempty"`
+
+	// Adaptive is the configuration of the adaptive limits; nil means
+	// the adaptive limits are disabled.
+	Adaptive *AdaptiveConfig `json:"adaptive,omitempty" yaml:"adaptive,omitempty"`
+
+	// Risk is the configuration of the account heuristics; nil means
+	// the heuristics are disabled.
+	Risk *RiskConfig `json:"risk,omitempty" yaml:"risk,omitempty"`
+
+	// StatsRetention is the length of the retention window of the chat
+	// statistics; zero means the statistics are disabled.
+	StatsRetention Duration `json:"stats_retention" yaml:"stats_retention"`
+
+	// StatsHistory is how long the hourly statistics of the chats are
+	// kept in the storage backend; zero means they are not kept.
+	StatsHistory Duration `json:"stats_history" yaml:"stats_history"`
+
+	// MaxEntries is the maximum number of the tracked keys; zero means
+	// no cap.
+	MaxEntries int `json:"max_entries" yaml:"max_entries"`
+
+	// Chats are the per-chat overrides of the configuration.
+	Chats []ChatFileConfig `json:"chats" yaml:"chats"`
+}
+
+// FileConfig is the configuration of the limiter which can be loaded
+// from a JSON or YAML file using `LoadConfig`; it's the same as `Config`.
+type FileConfig = Config
+
+// ProfileConfig is the serializable form of a `LimitProfile`.
+type ProfileConfig struct {
+	Timeout        Duration `json:"timeout" yaml:"timeout"`
+	PunishmentTime Duration `json:"punishment_time" yaml:"punishment_time"`
+	MessageCount   int      `json:"message_count" yaml:"message_count"`
+	Throttle       Duration `json:"throttle,omitempty" yaml:"throttle,omitempty"`
+}
+
+// ChallengeFileConfig is the serializable form of a `ChallengeConfig`.
+type ChallengeFileConfig struct {
+	Text          string   `json:"text" yaml:"text"`
+	SuccessText   string   `json:"success_text" yaml:"success_text"`
+	FailureText   string   `json:"failure_text" yaml:"failure_text"`
+	Choices       []string `json:"choices" yaml:"choices"`
+	Timeout       Duration `json:"timeout" yaml:"timeout"`
+	DeleteOnSolve bool     `json:"delete_on_solve" yaml:"delete_on_solve"`
+}
+
+// ReplyFileConfig is the serializable form of a `ReplyConfig`.
+type ReplyFileConfig struct {
+	Text        string   `json:"text" yaml:"text"`
+	WarnText    string   `json:"warn_text" yaml:"warn_text"`
+	UnlimitText string   `json:"unlimit_text" yaml:"unlimit_text"`
+	TimeFormat  string   `json:"time_format" yaml:"time_format"`
+	DeleteAfter Duration `json:"delete_after" yaml:"delete_after"`
+}
+
+// DelayFileConfig is the serializable form of a `DelayConfig`.
+type DelayFileConfig struct {
+	MaxDepth int      `json:"max_depth" yaml:"max_depth"`
+	MaxAge   Duration `json:"max_age" yaml:"max_age"`
+}
+
+// RaidFileConfig is the serializable form of a `RaidConfig`.
+type RaidFileConfig struct {
+	Joins    int      `json:"joins" yaml:"joins"`
+	Window   Duration `json:"window" yaml:"window"`
+	Lockdown bool     `json:"lockdown" yaml:"lockdown"`
+}
+
+// FlappingFileConfig is the serializable form of a `FlappingConfig`.
+type FlappingFileConfig struct {
+	Changes        int      `json:"changes" yaml:"changes"`
+	Window         Duration `json:"window" yaml:"window"`
+	IgnoreDuration Duration `json:"ignore_duration" yaml:"ignore_duration"`
+}
+
+// ChatFileConfig is the per-chat override of the configuration in
+// a config file; see `ChatSettings` for more information.
+type ChatFileConfig struct {
+	ChatID         int64    `json:"chat_id" yaml:"chat_id"`
+	Disabled       bool     `json:"disabled" yaml:"disabled"`
+	Timeout        Duration `json:"timeout" yaml:"timeout"`
+	PunishmentTime Duration `json:"punishment_time" yaml:"punishment_time"`
+	MessageCount   int      `json:"message_count" yaml:"message_count"`
+	Action         string   `json:"action" yaml:"action"`
+	SlowMode       Duration `json:"slow_mode,omitempty" yaml:"slow_mode,omitempty"`
+	DeleteSlowed   bool     `json:"delete_slowed,omitempty" yaml:"delete_slowed,omitempty"`
+}
+
+// ConfigWatcher watches a config file (or a directory of templates) and
+// applies it to the limiter whenever it's modified.
+type ConfigWatcher struct {
+	// OnError is called when reloading the config file fails; the old
+	// configuration remains in effect in that case.
+	OnError func(err error)
+
+	interval time.Duration
+	modTime  time.Time
+	stop     chan struct{}
+	once     sync.Once
+
+	// modified returns the last modification time of the watched files,
+	// and load applies them to the limiter.
+	modified func() (time.Time, error)
+	load     func() error
+}
+
+// TemplateData is the data passed to the templates of the built-in
+// replies; see `Limiter.LoadTemplates`. the names are escaped for the
+// html parse mode.
+type TemplateData struct {
+	// User is the name of the sender of the update, and Mention is the
+	// link to the user (or just their name, if the sender is a chat).
+	User    string
+	Mention string
+	UserID  int64
+
+	// Chat is the title (or the name) of the chat of the update.
+	Chat   string
+	ChatID int64
+
+	// Remaining is the remaining punishment time of the limit reply,
+	// or the remaining quota of the warning; Until is the end of the
+	// punishment formatted by `ReplyConfig.TimeFormat`.
+	Remaining string
+	Until     string
+
+	// UntilTime is the end of the punishment.
+	UntilTime time.Time
+
+	// Language is the language code of the user.
+	Language string
+}
+
+// templateSet is a set of the templates of the built-in replies, with
+// their name (and their language code, such as "limit.es") as key.
+type templateSet map[string]*template.Template
+
+type pendingChallenge struct {
+	// key is the id of the status which should be unlimited when the
+	// challenge is solved.
+	key       int64
+	userID    int64
+	chatID    int64
+	messageID int64
+	answer    int
+	config    *ChallengeConfig
+	expiresAt time.Time
+}
+
+// LimitEvent is the information of an event happened in the limiter,
+// such as a user being limited or unlimited.
+type LimitEvent struct {
+	// Type is the type of the event, such as `EventLimited`.
+	Type string `json:"type"`
+
+	// Key is the id used by the limiter to track the status; it's the
+	// id of the user if `ConsiderUser` is set to true, otherwise it's
+	// the id of the chat.
+	Key int64 `json:"key"`
+
+	ChatID   int64 `json:"chat_id,omitempty"`
+	UserID   int64 `json:"user_id,omitempty"`
+	Count    int   `json:"count"`
+	MaxCount int   `json:"max_count"`
+
+	// Action is the action taken by the limiter, such as `ActionIgnore`.
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+
+	// Error is the error message of the failure events, such as
+	// `EventFederationError`.
+	Error string `json:"error,omitempty"`
+}
+
+// EventNotifier is the interface which should be implemented by the
+// types that want to be notified about the limiter's events.
+// Notify is called synchronously in the order of the events, so it
+// shouldn't block; slow notifiers should queue the events, like
+// `WebhookNotifier` does.
+type EventNotifier interface {
+	Notify(event *LimitEvent) error
+}
+
+// WebhookNotifier is an `EventNotifier` which sends the events as a
+// JSON payload to an http endpoint using POST method.
+type WebhookNotifier struct {
+	// URL is the address of the endpoint.
+	URL string
+
+	// Headers are the additional headers sent with each request, such as
+	// authorization headers.
+	Headers map[string]string
+
+	// Client is the http client used for sending the requests. if it's
+	// nil, a client with `DefaultWebhookTimeout` as timeout will be used.
+	Client *http.Client
+
+	// MaxRetries is the maximum number of retries after a failed request.
+	MaxRetries int
+
+	// Backoff is the amount of time waited before the first retry; it
+	// will get doubled after each failed retry.
+	Backoff time.Duration
+
+	// QueueSize is the maximum amount of the events waiting to be sent;
+	// zero means `DefaultWebhookQueueSize`.
+	QueueSize int
+
+	// OnError is called when an event can't be sent, even after all of
+	// the retries, or when it's dropped because the queue is full; it's
+	// called from the worker goroutine of the webhook for the failed
+	// requests. the 4xx responses (except 429) are not retried.
+	OnError func(err error)
+
+	// mutex is the mutex used for the queue; the events are sent in
+	// order by a single worker goroutine draining the queue, which is
+	// started by the first event and closes done when it returns.
+	mutex  sync.Mutex
+	queue  chan []byte
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// AuditRecord is an entry of the audit log of a limiter; see `AuditSink`.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+
+	// Action is the enforcement action, such as `AuditLimit`.
+	Action string `json:"action"`
+
+	// Key is the id the action has been taken on; it's the id of the
+	// user (or the chat) tracked by the limiter.
+	Key    int64 `json:"key"`
+	ChatID int64 `json:"chat_id,omitempty"`
+	UserID int64 `json:"user_id,omitempty"`
+
+	// Actor is who has taken the action, such as `ActorLimiter`; the
+	// bots can use their own actors (such as the id of an admin) for the
+	// records passed to `Limiter.Audit`.
+	Actor string `json:"actor"`
+
+	// Reason is the detail of the action, such as `ActionChallenge`.
+	Reason string `json:"reason,omitempty"`
+
+	// Duration is the duration of the action, if it has any.
+	Duration Duration `json:"duration,omitempty"`
+}
+
+// AuditSink is the interface which should be implemented by the types
+// that want to receive an append-only record of every enforcement
+// action of the limiter, such as limits, unlimits and exemption changes.
+// Audit is called synchronously in the order of the actions, so it
+// should return quickly.
+type AuditSink interface {
+	Audit(record *AuditRecord) error
+}
+
+// FileAuditSink is an audit sink which appends the records to a file as
+// JSON lines; see `NewFileAuditSink`.
+type FileAuditSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// IDSet is a set of ids (of users, chats or channels) which is safe for
+// concurrent use; it's copy-on-write, so checking the ids never blocks,
+// and the changes are a bit more expensive instead.
+// the zero value of IDSet is an empty set ready to use.
+type IDSet struct {
+	set cowSet[int64]
+}
+
+// ScopedException is an exception which exempts a user only in a
+// single chat; see `Limiter.AddScopedException`.
+type ScopedException struct {
+	ChatID int64 `json:"chat_id" yaml:"chat_id"`
+	UserID int64 `json:"user_id" yaml:"user_id"`
+}
+
+// TopicException is an exception which exempts a whole topic of a forum
+// supergroup; see `Limiter.AddTopicException`.
+type TopicException struct {
+	ChatID   int64 `json:"chat_id" yaml:"chat_id"`
+	ThreadID int64 `json:"thread_id" yaml:"thread_id"`
+}
+
+// ExceptionRule is an exception matching the attributes of the users,
+// resolved at check time; the empty fields of a rule are ignored, and a
+// rule matches the users matching all of its non-empty fields.
+type ExceptionRule struct {
+	// Username is the username of the users (without '@'); it can be a
+	// glob pattern (such as "*_bot") and is matched case-insensitively.
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+
+	// Premium will make the rule match only the premium users.
+	Premium bool `json:"premium,omitempty" yaml:"premium,omitempty"`
+
+	// LanguageCode is the language code of the users, such as "en".
+	LanguageCode string `json:"language_code,omitempty" yaml:"language_code,omitempty"`
+}
+
+// cowSet is a copy-on-write set; the readers load an immutable snapshot
+// of it without any locks, and the writers replace the whole snapshot
+// with a modified copy.
+type cowSet[K comparable] struct {
+	// mutex is only used to serialize the writers.
+	mutex    sync.Mutex
+	snapshot atomic.Pointer[map[K]struct{}]
+}
+
+// cowList is a copy-on-write list; the readers load an immutable
+// snapshot of it without any locks, and the writers replace the whole
+// snapshot with a modified copy.
+type cowList[T any] struct {
+	// mutex is only used to serialize the writers.
+	mutex    sync.Mutex
+	snapshot atomic.Pointer[[]T]
+}
+
+// checkSamples is a ring of the latest samples of the check path of a
+// limiter, used for the rolling latency percentiles and the decision
+// distribution; its zero value is ready to use.
+type checkSamples struct {
+	mutex     sync.Mutex
+	latencies [checkSamplesWindow]time.Duration
+	results   [checkSamplesWindow]core.Result
+
+	// next is the index of the next sample, and count is the amount of
+	// the samples in the ring.
+	next  int
 	count int
+}
+
+// LimiterProcessor is an `ext.Processor` which gates every update by the
+// limiter before any handler group of the dispatcher runs, instead of
+// running the handlers of the limiter in the handler groups; see
+// `Limiter.AttachProcessor`.
+type LimiterProcessor struct {
+	// Limiter is the limiter which gates the updates.
+	Limiter *Limiter
+
+	// Next is the wrapped processor, which processes the updates which
+	// have passed the limiter; nil means `ext.BaseProcessor`.
+	Next ext.Processor
+
+	// detached is true if the limiter has been detached from the
+	// dispatcher of this processor, so the updates are passed to the
+	// next processor directly.
+	detached atomic.Bool
+}
+
+// boundHandler is a handler of the limiter added to a dispatcher; it
+// stores the dispatcher in the context of the updates it handles (see
+// `ContextDispatcherKey`).
+type boundHandler struct {
+	ext.Handler
+	dispatcher *ext.Dispatcher
+}
+
+// namedHandler is a handler of the limiter with a name which is unique
+// to the limiter, so it can be removed from the dispatchers by `Detach`
+// without touching the handlers of the other limiters.
+type namedHandler struct {
+	ext.Handler
+	name string
+}
 
-	custom *customIgnore
+// updateHandler is a generic handler of the limiter for the update
+// types which have no handler in the `handlers` package (such as the
+// reaction counts and the boosts).
+type updateHandler struct {
+	name     string
+	filter   func(ctx *ext.Context) bool
+	response handlers.Response
 }
 
-type customIgnore struct {
-	startTime       time.Time
-	duration        time.Duration
-	ignoreException bool
+// limiterFlags is an immutable snapshot of the exported fields of the
+// limiter; the handlers only read the flags from the snapshot, so they
+// can be changed by the setters (and `ApplyConfig`) while the updates
+// are being checked.
+type limiterFlags struct {
+	ScopeByBot          bool
+	IgnoreMediaGroup    bool
+	CountAlbumsOnce     bool
+	ServicePolicy       ServicePolicy
+	ConsiderPayments    bool
+	DeleteVoiceFlood    bool
+	CountCaptions       bool
+	IsStrict            bool
+	PartialReset        bool
+	OptIn               bool
+	ConsiderUser        bool
+	ConsiderInline      bool
+	Propagation         Propagation
+	StopPolicy          StopPolicy
+	Standalone          bool
+	LimitChannelSenders bool
+	ExemptAutoForwards  bool
 }
 
 // Limiter is the main struct of this library.
+// NOTICE: the exported fields of the limiter are taken by `Start`, so
+// changing them directly while the limiter is running has no effect
+// until it's restarted; use the setters (or `ApplyConfig`) instead.
 type Limiter struct {
-	mutex *sync.RWMutex
+	// mutex is the mutex used for the joined users and the pending
+	// challenges.
+	mutex sync.RWMutex
+
 	// IsEnable will be true if and only if the limiter is enabled
 	// and should check for the incoming messages.
-	isEnabled bool
+	isEnabled atomic.Bool
+
+	// IsStopped will be true when the limiter is stopped.
+	isStopped atomic.Bool
+
+	// stateMutex serializes the `Start` and `Stop` methods.
+	stateMutex sync.Mutex
+
+	// flags is the snapshot of the exported fields, which is read by
+	// the handlers; see `loadFlags`.
+	flags atomic.Pointer[limiterFlags]
+
+	// flagsMutex serializes the changes of the flags.
+	flagsMutex sync.Mutex
 
-	// IsStopped will be false when the limiter is stopped.
-	isStopped bool
+	// checkerStop is closed to stop the checker goroutine, and
+	// checkerDone is closed by the checker goroutine when it returns.
+	checkerStop chan struct{}
+	checkerDone chan struct{}
 
-	// userMap is a map of user status with their user id
-	// as its key (int64).
-	userMap map[int64]*UserStatus
+	// core is the framework-agnostic limiter which keeps track of
+	// the status of each user (or chat).
+	core *core.Limiter
 
 	// trigger function will run when a user is limited
 	// by the limiter. It should be set by user, users can do everything
 	// they want in this function, such as logging the person's id who
 	// has been limited by the limiter, etc...
-	triggers []handlers.Response
+	// the triggers are sorted by their priority.
+	triggers cowList[*Trigger]
+
+	// offenseMutex is the mutex used for the offenses of the keys.
+	offenseMutex sync.Mutex
+
+	// offenses is a map of the recent offenses of the keys; it's only
+	// kept if there is a trigger with the `TriggerFirstOffense` filter.
+	offenses map[int64]offenseRecord
+
+	// triggerMutex is the mutex used for the pending triggers.
+	triggerMutex sync.Mutex
+
+	// pendingTriggers is a map of the delayed triggers of the keys, and
+	// the ones waiting for the end of their punishment.
+	pendingTriggers map[int64][]*pendingTrigger
+
+	// warnTriggers are run when a user reaches the warning threshold of
+	// its quota; see `SetWarnThreshold`.
+	warnTriggers cowList[handlers.Response]
+
+	// warnThreshold is the bits of the ratio of the quota in which the
+	// users are warned (see `math.Float64bits`); zero means no warning.
+	warnThreshold atomic.Uint64
 
 	filter filters.Message
 
@@ -67,29 +1645,107 @@ type Limiter struct {
 
 	allHandlers []ext.Handler
 
-	exceptions        []filters.Message
-	conditions        []filters.Message
-	exceptionIDs      []int64
-	ignoredExceptions []int64
+	// handlerGroups are the handler groups the handlers of the limiter
+	// are added to in the dispatchers.
+	handlerGroups []int
+
+	// attachMutex is the mutex used for attaching the limiter to the
+	// dispatchers and detaching it from them.
+	attachMutex sync.Mutex
+
+	// dispatchers are the dispatchers this limiter is attached to.
+	dispatchers cowList[*ext.Dispatcher]
+
+	// ScopeByBot should be set to true when the limiter is shared between
+	// several bots (for example when it's attached to several dispatchers,
+	// or several processes share the same event bus); the keys will be
+	// scoped by the id of the bot, so the state of each bot stays isolated.
+	// the chat settings persisted in the storage are not scoped, as they
+	// are the configuration of the chats, shared by all of the bots.
+	// NOTICE: the updates checked without any bot (such as using `Check`)
+	// are not scoped.
+	ScopeByBot bool
+
+	// exceptions and conditions are copy-on-write lists, so they can be
+	// changed safely while the limiter is running.
+	exceptions cowList[filters.Message]
+	conditions cowList[filters.Message]
+
+	// callbackExceptions, inlineExceptions and reactionExceptions are
+	// the exception filters of the other update types, as they can't be
+	// matched by the message filters.
+	callbackExceptions cowList[filters.CallbackQuery]
+	inlineExceptions   cowList[filters.InlineQuery]
+	reactionExceptions cowList[filters.Reaction]
+
+	// callbackConditions and inlineConditions are the conditions of the
+	// callback and inline queries, and contextConditions are the
+	// conditions of all of the update types.
+	callbackConditions cowList[filters.CallbackQuery]
+	inlineConditions   cowList[filters.InlineQuery]
+	contextConditions  cowList[ContextCondition]
+
+	// exceptionIDs is the set of the exempted ids, and ignoredExceptions
+	// is the set of the exempted ids which are custom ignored anyway.
+	exceptionIDs      IDSet
+	ignoredExceptions IDSet
+
+	// scopedExceptions is the set of the users exempted only in a
+	// single chat.
+	scopedExceptions cowSet[ScopedException]
+
+	// topicExceptions is the set of the exempted forum topics.
+	topicExceptions cowSet[TopicException]
+
+	// blockedSources is the set of the channels and users whose forwarded
+	// messages are treated as over-limit, and blockedActions are the
+	// actions taken against those messages.
+	blockedSources IDSet
+	blockedActions cowList[Action]
 
-	// timeout is the floodwait checking time. a user is allowed to
-	// send `maxCount` messages per `timeout`.
-	timeout time.Duration
+	// reputationMutex is the mutex used for the reputations.
+	reputationMutex sync.RWMutex
+
+	// reputationProvider is the external source of the reputations of
+	// the users; nil means the reputations are not looked up.
+	reputationProvider ReputationProvider
+
+	// reputationCacheTime is the amount of time a reputation is cached.
+	reputationCacheTime time.Duration
+
+	// reputations is the cache of the looked up reputations, with the
+	// user id as key.
+	reputations map[int64]*cachedReputation
+
+	// reputationActions are taken against every update of the known
+	// spammers.
+	reputationActions cowList[Action]
+
+	// exceptionRules are the exceptions matching the attributes of the
+	// users.
+	exceptionRules cowList[ExceptionRule]
+
+	// allowedCommands is the set of the lower-cased names of the commands
+	// which are never limited.
+	allowedCommands cowSet[string]
+
+	// commandProfile is the limit profile of the commands, which are
+	// counted in a separate budget (see `CommandKey`); nil means they are
+	// counted with the rest of the messages.
+	commandProfile atomic.Pointer[LimitProfile]
 
 	// maxTimeout is the maximum time out of clearing user status
-	// cache in the memory.
-	maxTimeout time.Duration
+	// cache in the memory (as a `time.Duration`).
+	maxTimeout atomic.Int64
 
-	// punishment is the necessary time a user needs to spend after
-	// being limiter as its punishment; the user will be freed after
-	// this time is passed.
-	punishment time.Duration
+	// checkerInterval is the interval of the cleanup loop of the limiter
+	// (as a `time.Duration`); zero means `maxTimeout` is used.
+	checkerInterval atomic.Int64
 
-	// maxCount is the maximum number of messages we can accept from the
-	// user in `timeout` amount of time; if the user sends more than
-	// this much message, it will be limited and so the bot will ignore
-	// their messages.
-	maxCount int
+	// maxPunishment is the maximum amount of time a key can remain
+	// limited, even in the strict mode (as a `time.Duration`); zero
+	// means no cap.
+	maxPunishment atomic.Int64
 
 	// IgnoreMediaGroup should be set to true when we have to ignore
 	// album messages (such as album musics, album photos, etc...) and
@@ -97,12 +1753,97 @@ type Limiter struct {
 	// default value for this field is true.
 	IgnoreMediaGroup bool
 
-	// TextOnly should be set to true when we have to ignore
-	// media messages (such as photos, videos, audios, etc...) and
-	// don't check them at all.
-	// If your bot has nothing to do with media messages, you can set
-	// this to true.
-	TextOnly bool
+	// CountAlbumsOnce should be set to true when a whole album (the
+	// messages with the same `media_group_id`) has to be counted as
+	// a single message; only the first message of the album consumes the
+	// quota, the rest of it shares the fate of its sender.
+	// it has no effect if `IgnoreMediaGroup` is true.
+	CountAlbumsOnce bool
+
+	// slowMutex is the mutex used for the slow mode.
+	slowMutex sync.Mutex
+
+	// slowed is the time each user can send its next message in each
+	// chat with the slow mode.
+	slowed map[roleKey]time.Time
+
+	// raisedSlowModes are the temporary slow mode intervals of the chats
+	// with the chat id as key; see `RaiseSlowMode`.
+	raisedSlowModes map[int64]time.Duration
+
+	// cooldownMutex is the mutex used for the temporary states of the
+	// chats.
+	cooldownMutex sync.Mutex
+
+	// cooldowns are the active temporary states of the chats, which are
+	// reversed by their timers.
+	cooldowns map[cooldownKey]*chatCooldown
+
+	// albumMutex is the mutex used for the albums.
+	albumMutex sync.Mutex
+
+	// albums are the media groups seen by the limiter, with the time
+	// they've been seen.
+	albums map[albumKey]time.Time
+
+	// countedTypes is the mask of the kinds of the messages which count
+	// toward the quota; other messages are ignored by the limiter and
+	// aren't checked at all. zero means all of the messages are counted.
+	countedTypes atomic.Uint32
+
+	// chatScopes is the mask of the kinds of the chats considered by the
+	// limiter; the updates of the other chats are ignored. zero means
+	// all of the chats are considered.
+	chatScopes atomic.Uint32
+
+	// ServicePolicy determines how the service messages are handled;
+	// default value is `ServiceCount`.
+	ServicePolicy ServicePolicy
+
+	// joinDetector counts the join and leave messages of the chats with
+	// the chat id as key, when the service policy is `ServiceDetect`.
+	joinDetector atomic.Pointer[core.Limiter]
+
+	// joinFloodTriggers are run when a join/leave flood is detected in
+	// a chat.
+	joinFloodTriggers cowList[handlers.Response]
+
+	// ConsiderPayments should be set to true when the payment-related
+	// updates (pre-checkout and shipping queries) have to be limited.
+	// they are counted separately from the messages, using the payment
+	// profile of the limiter (see `SetPaymentProfile`).
+	ConsiderPayments bool
+
+	// paymentLimiter counts the payment queries of the users.
+	paymentLimiter atomic.Pointer[core.Limiter]
+
+	// paymentTriggers are run when a user is limited for sending too
+	// many payment queries.
+	paymentTriggers cowList[handlers.Response]
+
+	// globalLimiter counts the updates of the users across all of the
+	// chats, with the user id as key; nil means the global tracking is
+	// disabled.
+	globalLimiter atomic.Pointer[core.Limiter]
+
+	// mentionLimiter counts the messages mentioning (or replying to) the
+	// bot; nil means they are only counted as usual.
+	mentionLimiter atomic.Pointer[core.Limiter]
+
+	// voiceLimiter counts the voice messages and the video notes; nil
+	// means they are only counted as usual.
+	voiceLimiter atomic.Pointer[core.Limiter]
+
+	// DeleteVoiceFlood should be set to true when the voice messages and
+	// the video notes sent over the voice profile of the limiter (see
+	// `SetVoiceProfile`) have to be deleted.
+	DeleteVoiceFlood bool
+
+	// CountCaptions should be set to true when the captions of the media
+	// messages have to be treated as text messages; so if the text
+	// messages are counted (such as in `TextOnly` mode), the media
+	// messages with a non-empty caption are counted as well.
+	CountCaptions bool
 
 	// IsStrict will tell the limiter whether it should act more strict
 	// or not. If this value is set to `true`, the user should NOT send
@@ -111,8 +1852,25 @@ type Limiter struct {
 	// sending any messages to the bot.
 	// (A truly bad way of handling anti-floodwait... we recommend not to
 	// set this value to `true`, unless it's very very necessary).
+	// see `SetMaxPunishment` and `PartialReset` for making it safer.
 	IsStrict bool
 
+	// PartialReset will make the messages sent during the punishment in
+	// the strict mode only restart the flood wait time of the user,
+	// instead of the whole of its punishment.
+	PartialReset bool
+
+	// OptIn will put the limiter in the opt-in mode; in this mode only
+	// the chats and users enrolled by `Enroll` are subject to limiting,
+	// the updates of everyone else are not checked at all. it's useful
+	// for the bots deployed in many groups, where only some of them have
+	// requested the protection.
+	OptIn bool
+
+	// enrolled is the set of the chats and users subject to limiting in
+	// the opt-in mode.
+	enrolled IDSet
+
 	// ConsiderUser will be true when the limiter needs to consider users
 	// for their checking the messages. so the user's ID will be used as key
 	// to access the map.
@@ -120,6 +1878,399 @@ type Limiter struct {
 
 	// ConsiderInline fields will determine whether we need to
 	ConsiderInline bool
+
+	// Propagation determines what happens to the other handlers when an
+	// update is limited; default value is `PropagationEndGroups`.
+	Propagation Propagation
+
+	// StopPolicy determines whether the statuses of the users are kept
+	// when the limiter is stopped; default value is `StopClear`.
+	StopPolicy StopPolicy
+
+	// Standalone should be set to true when the limiter is used without
+	// any dispatcher, only through the `Check` or `AllowID` methods;
+	// otherwise `Start` fails if the limiter is not attached to any
+	// dispatcher.
+	Standalone bool
+
+	// LimitChannelSenders should be set to true when the messages sent on
+	// behalf of other channels (the ones with `sender_chat`) have to be
+	// limited by the id of the sending channel, even if `ConsiderUser` is
+	// false; so a single channel spamming its posts into a group won't
+	// get the whole chat limited.
+	LimitChannelSenders bool
+
+	// channelProfile is the limit profile applied to the channel senders
+	// when `LimitChannelSenders` is true. nil means the default limits.
+	channelProfile atomic.Pointer[LimitProfile]
+
+	// channelPostProfile is the limit profile applied to the posts of the
+	// channels. nil means the default limits.
+	channelPostProfile atomic.Pointer[LimitProfile]
+
+	// ExemptAutoForwards should be set to true when the automatic forwards
+	// of the posts of a channel into its linked discussion group shouldn't
+	// be checked by the limiter; the comments of the users in their threads
+	// are still limited.
+	ExemptAutoForwards bool
+
+	// commentProfile is the limit profile applied to the comments in the
+	// discussion threads of the channel posts. nil means the default
+	// limits.
+	commentProfile atomic.Pointer[LimitProfile]
+
+	// threadMutex is the mutex used for the discussion threads.
+	threadMutex sync.Mutex
+
+	// threads are the discussion threads of the automatic forwards seen
+	// by the limiter, with the time they've been seen.
+	threads map[threadKey]time.Time
+
+	// probation is the limit profile applied to the users who have
+	// recently joined a chat. nil means probation mode is disabled.
+	probation atomic.Pointer[LimitProfile]
+
+	// probationDuration is the amount of time a user stays in
+	// probation mode after joining a chat (as a `time.Duration`).
+	probationDuration atomic.Int64
+
+	// joinedUsers is a map of the users' join time with the chat they
+	// have joined and their user id as its key.
+	joinedUsers map[roleKey]time.Time
+
+	// adaptive is the configuration of the adaptive limits; nil means
+	// the adaptive limits are disabled.
+	adaptive *AdaptiveConfig
+
+	// activityMutex is the mutex used for the activities of the chats.
+	activityMutex sync.Mutex
+
+	// activities is a map of the recent activities of the chats with
+	// their chat id as key.
+	activities map[int64]*chatActivity
+
+	// activitySince is the time the current activity counters have
+	// been started.
+	activitySince time.Time
+
+	// statsMutex is the mutex used for the statistics of the chats.
+	statsMutex sync.Mutex
+
+	// stats is a map of the statistics of the chats with their chat id
+	// as key.
+	stats map[int64]*chatStats
+
+	// statsRetention is the length of the retention window of the chat
+	// statistics; zero means the statistics are disabled.
+	statsRetention time.Duration
+
+	// statsHistory is how long the hourly statistics of the chats are
+	// kept; zero means the history is disabled.
+	statsHistory time.Duration
+
+	// statsBuckets is a map of the hourly statistics of the chats which
+	// haven't been flushed to the storage backend yet.
+	statsBuckets map[statsBucketKey]*StatsBucket
+
+	// reportMutex is the mutex used for the scheduled reports.
+	reportMutex sync.Mutex
+
+	// report is the state of the scheduled reports; nil means there
+	// are no scheduled reports.
+	report *reportState
+
+	// alertMutex is the mutex used for the repeat-offender alerts.
+	alertMutex sync.Mutex
+
+	// alert is the configuration of the repeat-offender alerts; nil
+	// means the alerts are disabled.
+	alert *AlertConfig
+
+	// incidents is a map of the recent incidents of each key.
+	incidents map[int64][]Incident
+
+	// alerts is a map of the sent alerts with their nonce as key.
+	alerts map[string]*pendingAlert
+
+	// lockdownMutex is the mutex used for the chat-wide lockdowns.
+	lockdownMutex sync.Mutex
+
+	// lockdown is the configuration of the chat-wide lockdowns; nil
+	// means the lockdowns are disabled.
+	lockdown *LockdownConfig
+
+	// lockdownDetector counts the messages of the chats with the chat id
+	// as key, when the lockdowns are enabled.
+	lockdownDetector *core.Limiter
+
+	// lockdowns is a map of the ongoing lockdowns with the chat id as key.
+	lockdowns map[int64]*chatLockdown
+
+	// lockdownTriggers are run when a chat is locked down.
+	lockdownTriggers cowList[handlers.Response]
+
+	// raidMutex is the mutex used for the raid detection.
+	raidMutex sync.Mutex
+
+	// raid is the configuration of the raid detection; nil means the
+	// raid detection is disabled.
+	raid *RaidConfig
+
+	// raids is a map of the join trackers of the chats with the chat id
+	// as key.
+	raids map[int64]*chatRaid
+
+	// raidTriggers are run when a raid is detected in a chat.
+	raidTriggers cowList[handlers.Response]
+
+	// flapMutex is the mutex used for the flapping detection.
+	flapMutex sync.Mutex
+
+	// flapping is the configuration of the flapping detection; nil means
+	// the flapping detection is disabled.
+	flapping *FlappingConfig
+
+	// flaps are the time of the recent joins and leaves of the users in
+	// the chats.
+	flaps map[flapKey][]time.Time
+
+	// flappingTriggers are run when a user is found flapping in a chat.
+	flappingTriggers cowList[handlers.Response]
+
+	// tierMutex is the mutex used for tier-related fields; it's separated
+	// from the main mutex as tiers are configuration and should remain
+	// usable even when the limiter is stopped.
+	tierMutex sync.RWMutex
+
+	// tiers is a map of manually assigned tiers with the user (or chat)
+	// id as its key.
+	tiers map[int64]Tier
+
+	// tierProfiles is a map of limit profiles with their tier as key.
+	tierProfiles map[Tier]*LimitProfile
+
+	// tierResolver is an optional function used to determine the tier
+	// of the users which don't have any manually assigned tier.
+	tierResolver TierResolver
+
+	// hookMutex is the mutex used for the user-defined hooks of the
+	// limiter.
+	hookMutex sync.RWMutex
+
+	// costFunc is an optional function used to determine the cost of
+	// the updates.
+	costFunc CostFunc
+
+	// decisionHook is an optional function used to override the final
+	// decisions of the limiter.
+	decisionHook DecisionHook
+
+	// sweepStats is the statistics of the sweeps; it's protected by
+	// hookMutex.
+	sweepStats SweepStats
+
+	// checkSamples is the latest samples of the check path, used for
+	// the latency and the decision statistics.
+	checkSamples checkSamples
+
+	// unlimitCallbacks are called when the punishment of a key ends.
+	unlimitCallbacks []UnlimitCallback
+
+	// expireCallbacks are called when the status of a key is evicted.
+	expireCallbacks []ExpireCallback
+
+	// customIgnoreCallbacks are called when a custom ignore expires.
+	customIgnoreCallbacks []CustomIgnoreCallback
+
+	// scoreFunc is an optional function used to score the updates.
+	scoreFunc ScoreFunc
+
+	// scoreThreshold is the score above which the updates are punished.
+	scoreThreshold float64
+
+	// scoreCost is the extra cost of the updates which have a score
+	// above the threshold; zero means they limit their sender at once.
+	scoreCost int
+
+	// risk is the configuration of the account heuristics; nil means
+	// the heuristics are disabled.
+	risk *RiskConfig
+
+	// delayMutex is the mutex used for the delay mode.
+	delayMutex sync.Mutex
+
+	// delay is the configuration of the delay mode; nil means the delay
+	// mode is disabled.
+	delay *DelayConfig
+
+	// delayed is a map of the queues of the delayed updates.
+	delayed map[int64]*delayQueue
+
+	// debounceMutex is the mutex used for the debounce of the callback
+	// queries.
+	debounceMutex sync.Mutex
+
+	// debounceInterval is the interval in which the repeated presses of
+	// the same button are dropped; zero means the debounce is disabled.
+	debounceInterval time.Duration
+
+	// debounceToast is the text the dropped presses are answered with;
+	// empty means they are not answered.
+	debounceToast string
+
+	// presses is a map of the last time of the button presses.
+	presses map[pressKey]time.Time
+
+	// punishMutex is the mutex used for the punishments and their
+	// scheduled reversals.
+	punishMutex sync.Mutex
+
+	// punishment is the configuration of the punishments; nil means the
+	// limited users are only ignored.
+	punishment *PunishmentConfig
+
+	// punisher is the bot of the last punishment config, which is used
+	// for the reversals even after the punishments are disabled.
+	punisher *gotgbot.Bot
+
+	// actions are the enforcement actions applied to the newly limited
+	// users.
+	actions cowList[Action]
+
+	// applied is a map of the actions applied to the keys which are
+	// waiting to be reverted, with the key as map key.
+	applied map[int64][]*appliedAction
+
+	// escalation is the escalation policy of the punishments; nil means
+	// the punishments are not escalated.
+	escalation EscalationPolicy
+
+	// escalationWindow is the period in which the violations of the
+	// users are remembered by the escalation policy.
+	escalationWindow time.Duration
+
+	// violations is a map of the recent violations of the keys, with the
+	// key as map key.
+	violations map[int64][]Incident
+
+	// scheduled is a map of the scheduled reversals of the punishments.
+	scheduled map[roleKey]*scheduledAction
+
+	// federationMutex is the mutex used for the federation.
+	federationMutex sync.Mutex
+
+	// federation is the configuration of the federation; nil means the
+	// limiter is not a member of any federation.
+	federation *FederationConfig
+
+	// exported are the verdicts of this limiter exported to the
+	// federation, with the user id as key.
+	exported map[int64]*FederationVerdict
+
+	// federated are the end times of the remote verdicts applied
+	// locally, with the user id as key; zero means the verdict stays
+	// until it's revoked.
+	federated map[int64]time.Time
+
+	// federationSyncMutex is the mutex used for the sync goroutine of
+	// the federation.
+	federationSyncMutex sync.Mutex
+
+	// federationCancel stops the sync goroutine of the federation, and
+	// federationDone is closed by it when it returns.
+	federationCancel context.CancelFunc
+	federationDone   chan struct{}
+
+	// federationSyncing is true while a background sync of the federation
+	// is running; a sync which has timed out may still be running, so
+	// the next one is skipped until it returns.
+	federationSyncing atomic.Bool
+
+	// bundleMutex is the mutex used for the translation bundles.
+	bundleMutex sync.RWMutex
+
+	// bundles is a map of the translations of the built-in messages,
+	// with the lower-cased language codes as their keys.
+	bundles map[string]*Bundle
+
+	// updateMutex is the mutex used for the profiles and the triggers
+	// of the update types.
+	updateMutex sync.RWMutex
+
+	// updateProfiles is a map of the limit profiles used for the update
+	// types; update types without any profile use the default limits.
+	updateProfiles map[UpdateType]*LimitProfile
+
+	// updateTriggers is a map of the triggers of the update types; they
+	// are run instead of the limiter's triggers.
+	updateTriggers map[UpdateType][]handlers.Response
+
+	// roleMutex is the mutex used for role-related fields.
+	roleMutex sync.RWMutex
+
+	// roleProfiles is a map of limit profiles with the chat member
+	// status of the users as key.
+	roleProfiles map[Role]*LimitProfile
+
+	// roles is the cache of the resolved chat member statuses.
+	roles map[roleKey]*cachedRole
+
+	// roleCacheTime is the amount of time a resolved role is cached.
+	roleCacheTime time.Duration
+
+	// challenge is the configuration of the verification challenge.
+	// nil means no challenge will be sent to the limited users.
+	challenge atomic.Pointer[ChallengeConfig]
+
+	// reply is the configuration of the built-in reply; nil means no
+	// reply will be sent to the limited users.
+	reply atomic.Pointer[ReplyConfig]
+
+	// templates are the templates of the built-in replies loaded from
+	// the template files; nil means the texts of the reply are used.
+	templates atomic.Pointer[templateSet]
+
+	// challenges is a map of pending challenges with their nonce
+	// as key. it's guarded by the main mutex.
+	challenges map[string]*pendingChallenge
+
+	// notifiers are notified whenever a user is limited or unlimited
+	// by this limiter.
+	notifiers cowList[EventNotifier]
+
+	// auditSinks receive the records of the enforcement actions of
+	// this limiter.
+	auditSinks cowList[AuditSink]
+
+	// storage is the backend used for persisting the data of the limiter;
+	// it's stored behind a pointer, so it can be changed while the updates
+	// are being checked.
+	storage atomic.Pointer[storage.Storage]
+
+	// settingsMutex is the mutex used for the chat settings.
+	settingsMutex sync.RWMutex
+
+	// chatSettings is a map of runtime settings of the chats with their
+	// chat id as key.
+	chatSettings map[int64]*ChatSettings
+
+	// sendQueue is used for sending the requests of the limiter itself,
+	// such as the challenges; it can be nil.
+	sendQueue atomic.Pointer[outbound.Queue]
+
+	// eventBus is used for sharing the limit events with the other
+	// instances of the limiter; it can be nil. instanceID is the origin
+	// of the events published by this limiter, which never changes.
+	eventBus    atomic.Pointer[core.EventBus]
+	instanceID  string
+	unsubscribe func()
+
+	// publishQueue is the queue of the events waiting to be published
+	// by the publisher goroutine, which closes publishDone when the
+	// queue is closed and drained; they're nil if it's not running.
+	publishMutex sync.Mutex
+	publishQueue chan *core.SyncEvent
+	publishDone  chan struct{}
 }
 
 // LimiterConfig is the config type of the limiter.
@@ -127,13 +2278,355 @@ type LimiterConfig struct {
 	ConsiderChannel  bool
 	ConsiderUser     bool
 	ConsiderEdits    bool
+	ConsiderBusiness bool
 	IgnoreMediaGroup bool
+	CountAlbumsOnce  bool
 	TextOnly         bool
 	IsStrict         bool
-	HandlerGroups    []int
 	ConsiderInline   bool
-	Timeout          time.Duration
-	PunishmentTime   time.Duration
-	MaxTimeout       time.Duration
-	MessageCount     int
+
+	// MaxPunishment and PartialReset make the strict mode safer; see
+	// `Limiter.SetMaxPunishment` and `Limiter.PartialReset`.
+	MaxPunishment time.Duration
+	PartialReset  bool
+
+	// WarnThreshold is the ratio of the quota in which the users are
+	// warned; see `Limiter.SetWarnThreshold`.
+	WarnThreshold float64
+
+	// OptIn makes the limiter only limit the enrolled chats and users;
+	// see `Limiter.OptIn`.
+	OptIn bool
+
+	// AllowedCommands are the commands which are never limited; see
+	// `Limiter.AddAllowedCommands`.
+	AllowedCommands []string
+
+	// CommandProfile is the limit profile of the separate budget of the
+	// commands; see `Limiter.SetCommandProfile`. leave it nil to count
+	// the commands with the rest of the messages.
+	CommandProfile *LimitProfile
+
+	// ScopeByBot makes the limiter scope its keys by the id of the bots;
+	// see `Limiter.ScopeByBot`.
+	ScopeByBot bool
+
+	// CountedTypes is the mask of the kinds of the messages which count
+	// toward the quota; it takes precedence over `TextOnly` if it's not
+	// zero.
+	CountedTypes MessageType
+
+	// ChatScopes is the mask of the kinds of the chats considered by the
+	// limiter; all of the chats are considered if it's zero.
+	ChatScopes ChatScope
+
+	// CountCaptions makes the limiter count the media messages with a
+	// caption as text messages.
+	CountCaptions bool
+
+	// ConsiderPayments makes the limiter limit the pre-checkout and
+	// shipping queries of the users, using `PaymentProfile` (or
+	// `DefaultPaymentProfile` if it's nil).
+	ConsiderPayments bool
+	PaymentProfile   *LimitProfile
+
+	// GlobalProfile enables the global tracking of the users, which
+	// counts their updates across all of the chats; see
+	// `Limiter.SetGlobalProfile`. leave it nil to disable it.
+	GlobalProfile *LimitProfile
+
+	// MentionProfile is the stricter quota of the messages mentioning
+	// (or replying to) the bot; see `Limiter.SetMentionProfile`. leave
+	// it nil to disable it.
+	MentionProfile *LimitProfile
+
+	// VoiceProfile is the quota of the voice messages and the video notes;
+	// see `Limiter.SetVoiceProfile`. leave it nil to disable it.
+	// DeleteVoiceFlood makes the limiter delete the voice messages and the
+	// video notes sent over this quota.
+	VoiceProfile     *LimitProfile
+	DeleteVoiceFlood bool
+
+	// ServicePolicy determines how the service messages are handled.
+	// JoinFloodProfile is the threshold of the join/leave spam detector
+	// used by `ServiceDetect`; `DefaultJoinFloodProfile` is used if it's
+	// nil.
+	ServicePolicy    ServicePolicy
+	JoinFloodProfile *LimitProfile
+
+	// HandlerGroups are the dispatcher's handler groups the limiter is
+	// registered in; the default group is used if it's empty. it's
+	// recommended to use a group before the ones of your own handlers.
+	HandlerGroups []int
+
+	Timeout        time.Duration
+	PunishmentTime time.Duration
+	MaxTimeout     time.Duration
+	MessageCount   int
+
+	// CheckerInterval is the interval of the cleanup loop of the limiter;
+	// see `Limiter.SetCheckerInterval`.
+	CheckerInterval time.Duration
+
+	// Throttle enables the throttle mode of the limiter when it's not
+	// zero; see `Limiter.SetThrottle`.
+	Throttle time.Duration
+
+	// ProbationProfile is the limit profile applied to newly joined
+	// members for `ProbationDuration` amount of time. leave it nil to
+	// disable probation mode.
+	ProbationProfile  *LimitProfile
+	ProbationDuration time.Duration
+
+	// TierProfiles is a map of the limit profiles used for each tier.
+	// tiers without any profile will use the default limits.
+	TierProfiles map[Tier]*LimitProfile
+
+	// Adaptive is the configuration of the adaptive limits; leave it nil
+	// to disable the adaptive limits.
+	Adaptive *AdaptiveConfig
+
+	// Risk is the configuration of the account heuristics; leave it nil
+	// to disable the heuristics.
+	Risk *RiskConfig
+
+	// StatsRetention is the length of the retention window of the chat
+	// statistics; leave it zero to disable the statistics.
+	StatsRetention time.Duration
+
+	// StatsHistory is how long the hourly statistics of the chats are
+	// kept; see `Limiter.SetStatsHistory`. leave it zero to disable the
+	// history.
+	StatsHistory time.Duration
+
+	// MaxEntries is the maximum number of the keys tracked by the
+	// limiter; see `Limiter.SetMaxEntries`. leave it zero for no cap.
+	MaxEntries int
+
+	// RoleProfiles is a map of the limit profiles used for the chat
+	// member statuses of the users; roles without any profile will use
+	// the default limits. the roles are resolved using the telegram api,
+	// and are cached for `RoleCacheTime` amount of time.
+	RoleProfiles  map[Role]*LimitProfile
+	RoleCacheTime time.Duration
+
+	// Challenge is the verification challenge sent to the limited
+	// users. leave it nil to disable challenges.
+	Challenge *ChallengeConfig
+
+	// Reply is the built-in reply sent to the limited users; see
+	// `Limiter.SetReply`.
+	Reply *ReplyConfig
+
+	// Bundles are the translations of the built-in messages, with the
+	// language codes as their keys; see `Limiter.SetBundle`.
+	Bundles map[string]*Bundle
+
+	// WebhookURL is the address of an http endpoint which will receive
+	// the limit events as JSON. leave it empty to disable the webhook.
+	WebhookURL string
+
+	// Storage is the backend used for persisting the data of the
+	// limiter, such as the runtime chat settings.
+	Storage storage.Storage
+
+	// LimitChannelSenders makes the limiter limit the channels sending
+	// messages into the groups by their own id, using
+	// `ChannelSenderProfile` (or the default limits if it's nil).
+	LimitChannelSenders  bool
+	ChannelSenderProfile *LimitProfile
+
+	// ChannelPostProfile is the limit profile applied to the posts of
+	// the channels (when `ConsiderChannel` is true); channel posts are
+	// always limited by the id of the channel. the default limits are
+	// used if it's nil.
+	ChannelPostProfile *LimitProfile
+
+	// ExemptAutoForwards and CommentProfile control the linked discussion
+	// groups of the channels; see `Limiter.ExemptAutoForwards` and
+	// `Limiter.SetCommentProfile`.
+	ExemptAutoForwards bool
+	CommentProfile     *LimitProfile
+
+	// CallbackProfile is the limit profile applied to the callback
+	// queries (when `ConsiderInline` is true), as the button presses
+	// usually need different tolerances than the messages. the default
+	// limits are used if it's nil. it's a shortcut for the callback
+	// entry of `UpdateProfiles`.
+	CallbackProfile *LimitProfile
+
+	// UpdateProfiles is a map of the limit profiles used for the update
+	// types; all of the update types of a user share the same status,
+	// so flooding through one of them limits the user in all of them.
+	UpdateProfiles map[UpdateType]*LimitProfile
+
+	// Delay enables the delay mode of the limiter, in which the limited
+	// updates are queued and handled later instead of being dropped; see
+	// `Limiter.SetDelay`. leave it nil to disable the delay mode.
+	Delay *DelayConfig
+
+	// Raid enables the raid detection of the limiter, which watches the
+	// join bursts of the chats; see `Limiter.SetRaid`. leave it nil to
+	// disable the raid detection.
+	Raid *RaidConfig
+
+	// Flapping enables the detection of the users who keep joining and
+	// leaving the chats; see `Limiter.SetFlapping`. leave it nil to
+	// disable it.
+	Flapping *FlappingConfig
+
+	// CallbackDebounce is the interval in which the repeated presses of
+	// the same button by the same user are dropped (and answered with
+	// `DebounceToast`, if it's not empty); leave it zero to disable the
+	// debounce.
+	CallbackDebounce time.Duration
+	DebounceToast    string
+
+	// Propagation determines what happens to the other handlers when an
+	// update is limited; default value is `PropagationEndGroups`.
+	Propagation Propagation
+
+	// StopPolicy determines whether the statuses of the users are kept
+	// when the limiter is stopped; see `Limiter.StopPolicy`.
+	StopPolicy StopPolicy
+
+	// Standalone allows the limiter to be started without a dispatcher;
+	// see `Limiter.Standalone`.
+	Standalone bool
+}
+
+// Registry is a set of named limiters; it's useful for the bots which
+// compose many purpose-specific limiters (such as one for commands, one
+// for callbacks and one for inline queries), so they can be looked up by
+// their name and be started, stopped and monitored together.
+type Registry struct {
+	mutex    sync.RWMutex
+	limiters map[string]*Limiter
+}
+
+// Metrics is a snapshot of the state of a limiter.
+type Metrics struct {
+	// Enabled is true if the limiter is started and not stopped.
+	Enabled bool `json:"enabled"`
+
+	// Tracked is the amount of keys being tracked by the limiter.
+	Tracked int `json:"tracked"`
+
+	// Limited is the amount of keys which are currently limited.
+	Limited int `json:"limited"`
+
+	// Evictions is the amount of keys evicted because of the cap of the
+	// tracked keys.
+	Evictions int `json:"evictions"`
+
+	// Sweep is the statistics of the sweeps done by the checker.
+	Sweep SweepStats `json:"sweep"`
+
+	// Latency is the rolling percentiles of the time spent by the
+	// limiter on checking the updates.
+	Latency LatencyStats `json:"latency"`
+
+	// Decisions is the rolling distribution of the decisions of the
+	// limiter about the updates.
+	Decisions DecisionStats `json:"decisions"`
+}
+
+// LatencyStats contains the percentiles of the time spent by a limiter
+// on checking the latest updates, so the operators can verify the
+// limiter isn't adding a meaningful overhead to each update.
+type LatencyStats struct {
+	// Samples is the amount of the latest checks the percentiles are
+	// calculated from.
+	Samples int `json:"samples"`
+
+	P50 time.Duration `json:"p50_ns"`
+	P90 time.Duration `json:"p90_ns"`
+	P99 time.Duration `json:"p99_ns"`
+	Max time.Duration `json:"max_ns"`
+}
+
+// DecisionStats contains the distribution of the decisions of a limiter
+// about the latest updates; the percents are in the range of 0 to 100.
+type DecisionStats struct {
+	// Allowed is the amount of the updates which have consumed the
+	// quota and have been allowed, Exempt is the amount of the ones
+	// allowed without consuming any quota, and Limited is the amount
+	// of the ones which have been blocked (including the ignored and
+	// the dropped ones).
+	Allowed int `json:"allowed"`
+	Exempt  int `json:"exempt"`
+	Limited int `json:"limited"`
+
+	AllowedPercent float64 `json:"allowed_percent"`
+	ExemptPercent  float64 `json:"exempt_percent"`
+	LimitedPercent float64 `json:"limited_percent"`
+}
+
+// SweepStats contains the statistics of the sweeps done by the checker
+// goroutine of a limiter, so the operators can verify the checker is
+// keeping up and tune the max cache duration.
+type SweepStats struct {
+	// Sweeps is the amount of sweeps done since the limiter has been
+	// created.
+	Sweeps int `json:"sweeps"`
+
+	// LastSweep is the time the last sweep has been started.
+	LastSweep time.Time `json:"last_sweep"`
+
+	// LastDuration is the time it took for the last sweep to finish.
+	LastDuration time.Duration `json:"last_duration_ns"`
+
+	// Evicted and Released are the amount of the statuses evicted and
+	// the punishments released by the last sweep.
+	Evicted  int `json:"evicted"`
+	Released int `json:"released"`
+}
+
+// HealthReport is the liveness report of a limiter; it's suitable for
+// the healthcheck endpoints of the bots (see `Limiter.Health`).
+type HealthReport struct {
+	// Healthy is true if the limiter is running, its checker goroutine
+	// is alive and keeping up, and its storage backend (if any) is
+	// reachable.
+	Healthy bool `json:"healthy"`
+
+	// Enabled is true if the limiter is started and not stopped.
+	Enabled bool `json:"enabled"`
+
+	// CheckerAlive is true if the checker goroutine is running.
+	CheckerAlive bool `json:"checker_alive"`
+
+	// SinceLastSweep is the time passed since the last sweep of the
+	// checker; it's zero if no sweep has been done yet.
+	SinceLastSweep time.Duration `json:"since_last_sweep_ns"`
+
+	// StorageReachable is true if the storage backend responds, or if
+	// no storage backend is set; StorageError is the error returned by
+	// the storage backend otherwise.
+	StorageReachable bool   `json:"storage_reachable"`
+	StorageError     string `json:"storage_error,omitempty"`
+
+	// SendQueueDepth is the amount of the jobs waiting in the send
+	// queue of the limiter (if any).
+	SendQueueDepth int64 `json:"send_queue_depth"`
+
+	// DelayQueueDepth is the amount of the updates queued by the delay
+	// mode.
+	DelayQueueDepth int `json:"delay_queue_depth"`
+
+	// ScheduledActions is the amount of the punishment reversals which
+	// are waiting to be run.
+	ScheduledActions int `json:"scheduled_actions"`
+}
+
+// RegistryMetrics is a snapshot of the state of all of the limiters of
+// a registry.
+type RegistryMetrics struct {
+	// Limiters are the metrics of each limiter with its name as key.
+	Limiters map[string]Metrics `json:"limiters"`
+
+	// Tracked and Limited are the sum of the metrics of all of the
+	// limiters.
+	Tracked int `json:"tracked"`
+	Limited int `json:"limited"`
 }
@@ -1,6 +1,215 @@
 package ratelimiter
 
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ALiwoto/ratelimiter/core"
+)
+
+var (
+	ErrInvalidMessageCount = core.ErrInvalidMessageCount
+	ErrInvalidTimeout      = core.ErrInvalidTimeout
+	ErrInvalidPunishment   = core.ErrInvalidPunishment
+	ErrInvalidThrottle     = core.ErrInvalidThrottle
+	ErrInvalidMaxTimeout   = errors.New("ratelimiter: max cache duration should be at least one second")
+	ErrInvalidChatSettings = errors.New("ratelimiter: invalid chat settings")
+	ErrInvalidMessageType  = errors.New("ratelimiter: invalid message type")
+	ErrInvalidChatScope    = errors.New("ratelimiter: invalid chat scope")
+	ErrLimiterExists       = errors.New("ratelimiter: a limiter with this name already exists")
+	ErrInvalidPropagation  = errors.New("ratelimiter: invalid propagation")
+	ErrInvalidPolicy       = errors.New("ratelimiter: invalid service policy")
+	ErrInvalidAdaptive     = errors.New("ratelimiter: invalid adaptive config")
+	ErrInvalidRisk         = errors.New("ratelimiter: invalid risk config")
+	ErrInvalidRule         = errors.New("ratelimiter: invalid exception rule")
+	ErrInvalidSchedule     = errors.New("ratelimiter: invalid schedule")
+	ErrInvalidReport       = errors.New("ratelimiter: invalid report config")
+	ErrInvalidUpdateType   = errors.New("ratelimiter: invalid update type")
+	ErrInvalidDelay        = errors.New("ratelimiter: invalid delay config")
+	ErrInvalidWarning      = errors.New("ratelimiter: warning threshold should be between 0 and 1")
+	ErrInvalidPunisher     = errors.New("ratelimiter: invalid punishment config")
+	ErrInvalidAlert        = errors.New("ratelimiter: invalid alert config")
+	ErrInvalidLockdown     = errors.New("ratelimiter: invalid lockdown config")
+	ErrInvalidRaid         = errors.New("ratelimiter: invalid raid config")
+	ErrInvalidFlapping     = errors.New("ratelimiter: invalid flapping config")
+	ErrInvalidTemplate     = errors.New("ratelimiter: invalid template file")
+	ErrInvalidEnv          = errors.New("ratelimiter: invalid environment variable")
+	ErrInvalidFederation   = errors.New("ratelimiter: invalid federation config")
+	ErrNoFederation        = errors.New("ratelimiter: the limiter is not exporting to any federation")
+	ErrFederationTimeout   = errors.New("ratelimiter: syncing the federation timed out")
+	ErrInvalidStopPolicy   = errors.New("ratelimiter: invalid stop policy")
+	ErrInvalidInterval     = errors.New("ratelimiter: checker interval should be at least one second")
+	ErrNoDispatcher        = errors.New("ratelimiter: the limiter is not attached to any dispatcher")
+	ErrAlreadyStarted      = errors.New("ratelimiter: the limiter is already started")
+	ErrWebhookQueueFull    = errors.New("ratelimiter: the queue of the webhook is full")
+	ErrSubscribeFailed     = errors.New("ratelimiter: failed to subscribe to the event bus")
+)
+
+var (
+	// messageTypeNames is a map of the message types with their name
+	// used in the config files as key.
+	messageTypeNames = map[string]MessageType{
+		"text":       TypeText,
+		"photo":      TypePhoto,
+		"video":      TypeVideo,
+		"animation":  TypeAnimation,
+		"audio":      TypeAudio,
+		"voice":      TypeVoice,
+		"video_note": TypeVideoNote,
+		"document":   TypeDocument,
+		"sticker":    TypeSticker,
+		"dice":       TypeDice,
+		"poll":       TypePoll,
+		"location":   TypeLocation,
+		"contact":    TypeContact,
+		"other":      TypeOther,
+		"media":      TypeMedia,
+		"all":        TypeAll,
+	}
+
+	// chatScopeNames is a map of the chat scopes with their name used in
+	// the config files as key.
+	chatScopeNames = map[string]ChatScope{
+		"private":  ScopePrivate,
+		"groups":   ScopeGroups,
+		"channels": ScopeChannels,
+		"all":      ScopeAll,
+	}
+)
+
+var (
+	// DefaultJoinFloodProfile is the default threshold of the join/leave
+	// spam detector; see `ServiceDetect`.
+	DefaultJoinFloodProfile = &LimitProfile{
+		Timeout:        DefaultJoinFloodTimeout,
+		PunishmentTime: DefaultJoinFloodTime,
+		MessageCount:   DefaultJoinFloodCount,
+	}
+)
+
+var (
+	// DefaultPaymentProfile is the default limit profile of the payment
+	// queries; it's conservative, as the real users rarely need to check
+	// out more than a few times in a minute.
+	DefaultPaymentProfile = &LimitProfile{
+		Timeout:        DefaultPaymentTimeout,
+		PunishmentTime: DefaultPaymentTime,
+		MessageCount:   DefaultPaymentCount,
+	}
+)
+
+var (
+	// DefaultVoiceProfile is a conservative limit profile for the voice
+	// messages and the video notes; see `Limiter.SetVoiceProfile`.
+	DefaultVoiceProfile = &LimitProfile{
+		Timeout:        DefaultVoiceTimeout,
+		PunishmentTime: DefaultVoiceTime,
+		MessageCount:   DefaultVoiceCount,
+	}
+)
+
 var (
+	// DefaultReactionCountProfile is a conservative limit profile for the
+	// reaction counts; it throttles each chat to a single update in
+	// `DefaultReactionCountThrottle`, as the counts of a busy chat may
+	// arrive much faster than the bot can process them.
+	DefaultReactionCountProfile = &LimitProfile{
+		Timeout:      DefaultReactionCountThrottle,
+		MessageCount: 1,
+		Throttle:     DefaultReactionCountThrottle,
+	}
+
+	// DefaultBoostProfile is a conservative limit profile for the chat
+	// boosts; it throttles each key to a single update in
+	// `DefaultBoostThrottle`.
+	DefaultBoostProfile = &LimitProfile{
+		Timeout:      DefaultBoostThrottle,
+		MessageCount: 1,
+		Throttle:     DefaultBoostThrottle,
+	}
+)
+
+var (
+	// DefaultRegistry is the package-level registry of the limiters.
+	DefaultRegistry = NewRegistry()
+)
+
+var (
+	// propagationNames are the names of the propagations used in the
+	// config files.
+	propagationNames = []string{
+		PropagationEndGroups: "end_groups",
+		PropagationEndGroup:  "end_group",
+		PropagationAnnotate:  "annotate",
+	}
+
+	// stopPolicyNames are the names of the stop policies used in the
+	// config files.
+	stopPolicyNames = []string{
+		StopClear:  "clear",
+		StopRetain: "retain",
+	}
+
+	// servicePolicyNames are the names of the service policies used in
+	// the config files.
+	servicePolicyNames = []string{
+		ServiceCount:  "count",
+		ServiceIgnore: "ignore",
+		ServiceDetect: "detect",
+	}
+)
+
+var (
+	DefaultChallengeChoices = []string{"🍎", "🍌", "🍇", "🍉", "🍒", "🍋"}
+
+	// DefaultBundles are the built-in translations of the default texts
+	// of the limiter; they are only used for the texts which are not
+	// changed, and are overridden by the bundles set in the limiter.
+	DefaultBundles = map[string]*Bundle{
+		"de": {
+			LimitText:            "{mention}, du sendest zu schnell Nachrichten; bitte warte {remaining}.",
+			ChallengeText:        "Du wurdest wegen Flooding eingeschränkt. Drücke {choice}, um zu beweisen, dass du ein Mensch bist.",
+			ChallengeSuccessText: "Danke! Du kannst wieder Nachrichten senden.",
+			ChallengeFailureText: "Falscher Knopf, bitte versuche es erneut.",
+			PaymentLimitedText:   "Zu viele Zahlungsversuche, bitte versuche es später erneut.",
+		},
+		"es": {
+			LimitText:            "{mention}, estás enviando mensajes demasiado rápido; por favor espera {remaining}.",
+			ChallengeText:        "Has sido limitado por flood. Pulsa {choice} para demostrar que eres humano.",
+			ChallengeSuccessText: "¡Gracias! Puedes volver a enviar mensajes.",
+			ChallengeFailureText: "Botón incorrecto, por favor inténtalo de nuevo.",
+			PaymentLimitedText:   "Demasiados intentos de pago, por favor inténtalo más tarde.",
+		},
+		"fa": {
+			LimitText:            "{mention}، شما پیام‌ها را خیلی سریع ارسال می‌کنید؛ لطفا {remaining} صبر کنید.",
+			ChallengeText:        "شما به دلیل فلود محدود شده‌اید. برای اثبات انسان بودن خود {choice} را بزنید.",
+			ChallengeSuccessText: "متشکریم! اکنون می‌توانید دوباره پیام ارسال کنید.",
+			ChallengeFailureText: "دکمه اشتباه است، لطفا دوباره تلاش کنید.",
+			PaymentLimitedText:   "تلاش‌های پرداخت بیش از حد، لطفا بعدا دوباره تلاش کنید.",
+		},
+		"ru": {
+			LimitText:            "{mention}, вы отправляете сообщения слишком быстро; пожалуйста, подождите {remaining}.",
+			ChallengeText:        "Вы были ограничены за флуд. Нажмите {choice}, чтобы доказать, что вы человек.",
+			ChallengeSuccessText: "Спасибо! Вы снова можете отправлять сообщения.",
+			ChallengeFailureText: "Неверная кнопка, пожалуйста, попробуйте ещё раз.",
+			PaymentLimitedText:   "Слишком много попыток оплаты, пожалуйста, попробуйте позже.",
+		},
+	}
+
+	// webhookClient is the http client of the webhooks without their
+	// own client.
+	webhookClient = &http.Client{Timeout: DefaultWebhookTimeout}
+
+	// defaultBundle contains the default texts of the limiter, which
+	// are translated by `DefaultBundles`.
+	defaultBundle = &Bundle{
+		LimitText:            DefaultReplyText,
+		ChallengeText:        DefaultChallengeText,
+		ChallengeSuccessText: DefaultChallengeSuccessText,
+		ChallengeFailureText: DefaultChallengeFailureText,
+		PaymentLimitedText:   DefaultPaymentLimitedText,
+	}
+
 	DefaultConfig *LimiterConfig = &LimiterConfig{
 		ConsiderChannel:  false,
 		ConsiderUser:     true,